@@ -0,0 +1,20 @@
+package limiter
+
+import (
+	"time"
+)
+
+// Clock is the source of the current time, used by windows and expirations.
+// It can be replaced in tests for a deterministic time (see limitertest.FakeClock).
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// SystemClock is the default clock, using the wall clock.
+type SystemClock struct{}
+
+// Now returns the current time of the wall clock.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
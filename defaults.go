@@ -12,4 +12,7 @@ const (
 
 	// DefaultCleanUpInterval is the default time duration for cleanup.
 	DefaultCleanUpInterval = 30 * time.Second
+
+	// DefaultShardCount is the default number of shards of the memory store.
+	DefaultShardCount = 256
 )
@@ -0,0 +1,51 @@
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ulule/limiter/v3"
+)
+
+const (
+	// OutcomeAllowed is the outcome label of allowed requests.
+	OutcomeAllowed = "allowed"
+	// OutcomeDenied is the outcome label of denied requests.
+	OutcomeDenied = "denied"
+)
+
+// Collector is a prometheus collector counting the decisions of a limiter, labeled by outcome.
+// Keys are not used as label, to keep a bounded cardinality.
+type Collector struct {
+	decisions *prometheus.CounterVec
+}
+
+// NewCollector returns a new collector, with given namespace for its metrics.
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "limiter",
+			Name:      "decisions_total",
+			Help:      "Number of decisions of the limiter, by outcome.",
+		}, []string{"outcome"}),
+	}
+}
+
+// OnDecision counts given decision. It's meant to be given to limiter.WithOnDecision.
+func (collector *Collector) OnDecision(key string, allowed bool, context limiter.Context) {
+	outcome := OutcomeAllowed
+	if !allowed {
+		outcome = OutcomeDenied
+	}
+	collector.decisions.WithLabelValues(outcome).Inc()
+}
+
+// Describe implements prometheus.Collector.
+func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
+	collector.decisions.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
+	collector.decisions.Collect(ch)
+}
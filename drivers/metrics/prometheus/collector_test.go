@@ -0,0 +1,44 @@
+package prometheus_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	libprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/metrics/prometheus"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+)
+
+func TestCollector(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	collector := prometheus.NewCollector("test")
+
+	registry := libprometheus.NewRegistry()
+	is.NoError(registry.Register(collector))
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: time.Minute,
+		Limit:  3,
+	}, limiter.WithOnDecision(collector.OnDecision))
+
+	for i := 0; i < 5; i++ {
+		_, err := instance.Get(ctx, "foo")
+		is.NoError(err)
+	}
+
+	expected := `
+# HELP test_limiter_decisions_total Number of decisions of the limiter, by outcome.
+# TYPE test_limiter_decisions_total counter
+test_limiter_decisions_total{outcome="allowed"} 3
+test_limiter_decisions_total{outcome="denied"} 2
+`
+	is.NoError(testutil.GatherAndCompare(registry, strings.NewReader(expected)))
+}
@@ -0,0 +1,78 @@
+package echo
+
+import (
+	"github.com/labstack/echo/v4"
+
+	"github.com/ulule/limiter/v3"
+)
+
+// Middleware is the middleware for echo.
+type Middleware struct {
+	Limiter        *limiter.Limiter
+	OnError        ErrorHandler
+	OnLimitReached LimitReachedHandler
+	KeyGetter      KeyGetter
+	ExcludedKey    func(string) bool
+	HeaderStyle    limiter.HeaderStyle
+}
+
+// NewMiddleware return a new instance of an echo middleware.
+func NewMiddleware(limiter *limiter.Limiter, options ...Option) echo.MiddlewareFunc {
+	middleware := &Middleware{
+		Limiter:        limiter,
+		OnError:        DefaultErrorHandler,
+		OnLimitReached: DefaultLimitReachedHandler,
+		KeyGetter:      DefaultKeyGetter(limiter),
+		ExcludedKey:    nil,
+	}
+
+	for _, option := range options {
+		option.apply(middleware)
+	}
+
+	return middleware.Handle
+}
+
+// Handle echo request.
+func (middleware *Middleware) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if middleware.Limiter.IsMethodSkipped(c.Request().Method) || middleware.Limiter.IsAllowlisted(c.Request()) ||
+			middleware.Limiter.IsBypassed(c.Request()) {
+			return next(c)
+		}
+		if middleware.Limiter.IsDenylisted(c.Request()) {
+			return middleware.OnLimitReached(c)
+		}
+
+		key, err := middleware.KeyGetter(c)
+		if err != nil {
+			return middleware.OnError(c, err)
+		}
+
+		if middleware.ExcludedKey != nil && middleware.ExcludedKey(key) {
+			return next(c)
+		}
+
+		context, err := middleware.Limiter.GetWithRequest(c.Request(), key)
+		if err != nil && !context.Reached {
+			return next(c)
+		}
+		if err != nil {
+			return middleware.OnError(c, err)
+		}
+
+		if middleware.Limiter.Options.DryRun {
+			return next(c)
+		}
+
+		for name, value := range middleware.Limiter.GetHeaders(context, middleware.HeaderStyle) {
+			c.Response().Header().Set(name, value)
+		}
+
+		if context.Reached {
+			return middleware.OnLimitReached(c)
+		}
+
+		return next(c)
+	}
+}
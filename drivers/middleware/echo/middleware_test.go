@@ -0,0 +1,116 @@
+package echo_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	libecho "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/middleware/echo"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	is := require.New(t)
+
+	rate, err := limiter.NewRateFromFormatted("10-M")
+	is.NoError(err)
+	is.NotZero(rate)
+
+	middleware := echo.NewMiddleware(limiter.New(memory.NewStore(), rate))
+	is.NotZero(middleware)
+
+	handler := middleware(func(c libecho.Context) error {
+		return c.String(http.StatusOK, "hello")
+	})
+
+	e := libecho.New()
+	success := int64(10)
+	clients := int64(100)
+
+	for i := int64(1); i <= clients; i++ {
+		request := httptest.NewRequest("GET", "/", nil)
+		resp := httptest.NewRecorder()
+
+		err := handler(e.NewContext(request, resp))
+		is.Equal("10", resp.Header().Get("X-RateLimit-Limit"))
+
+		if i <= success {
+			is.NoError(err)
+			is.Equal(http.StatusOK, resp.Code)
+			is.Equal(strconv.FormatInt(success-i, 10), resp.Header().Get("X-RateLimit-Remaining"))
+		} else {
+			herr := &libecho.HTTPError{}
+			is.True(errors.As(err, &herr))
+			is.Equal(http.StatusTooManyRequests, herr.Code)
+			is.NotEmpty(resp.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestJWTMiddlewareInvalidToken(t *testing.T) {
+	is := require.New(t)
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: time.Minute,
+		Limit:  10,
+	}, limiter.WithJWTSecret("javad"))
+
+	errorHandler := func(c libecho.Context, err error) error {
+		return libecho.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	middleware := echo.NewMiddleware(instance,
+		echo.WithKeyGetter(echo.JWTKeyGetter(instance)),
+		echo.WithErrorHandler(errorHandler))
+
+	called := false
+	handler := middleware(func(c libecho.Context) error {
+		called = true
+		return c.String(http.StatusOK, "hello")
+	})
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Authorization", "Bearer invalid")
+	resp := httptest.NewRecorder()
+
+	err := handler(libecho.New().NewContext(request, resp))
+	herr := &libecho.HTTPError{}
+	is.True(errors.As(err, &herr))
+	is.Equal(http.StatusUnauthorized, herr.Code)
+	is.False(called)
+}
+
+func TestHTTPMiddlewareWithExcludedKey(t *testing.T) {
+	is := require.New(t)
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: time.Minute,
+		Limit:  1,
+	})
+
+	middleware := echo.NewMiddleware(instance, echo.WithExcludedKey(func(key string) bool {
+		return key == "192.0.2.1"
+	}))
+
+	handler := middleware(func(c libecho.Context) error {
+		return c.String(http.StatusOK, "hello")
+	})
+
+	e := libecho.New()
+	for i := 0; i < 5; i++ {
+		request := httptest.NewRequest("GET", "/", nil)
+		resp := httptest.NewRecorder()
+
+		err := handler(e.NewContext(request, resp))
+		is.NoError(err)
+		is.Equal(http.StatusOK, resp.Code)
+		is.Empty(resp.Header().Get("X-RateLimit-Limit"))
+	}
+}
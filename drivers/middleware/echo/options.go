@@ -0,0 +1,92 @@
+package echo
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/ulule/limiter/v3"
+)
+
+// Option is used to define Middleware configuration.
+type Option interface {
+	apply(*Middleware)
+}
+
+type option func(*Middleware)
+
+func (o option) apply(middleware *Middleware) {
+	o(middleware)
+}
+
+// ErrorHandler is an handler used to inform when an error has occurred.
+// Its returned error is given to the echo HTTPErrorHandler.
+type ErrorHandler func(c echo.Context, err error) error
+
+// WithErrorHandler will configure the Middleware to use the given ErrorHandler.
+func WithErrorHandler(handler ErrorHandler) Option {
+	return option(func(middleware *Middleware) {
+		middleware.OnError = handler
+	})
+}
+
+// DefaultErrorHandler is the default ErrorHandler used by a new Middleware.
+func DefaultErrorHandler(c echo.Context, err error) error {
+	return err
+}
+
+// LimitReachedHandler is an handler used to inform when the limit has exceeded.
+// It is called once the rate limit headers are set, and may write its own status and body.
+type LimitReachedHandler func(c echo.Context) error
+
+// WithLimitReachedHandler will configure the Middleware to use the given LimitReachedHandler.
+func WithLimitReachedHandler(handler LimitReachedHandler) Option {
+	return option(func(middleware *Middleware) {
+		middleware.OnLimitReached = handler
+	})
+}
+
+// DefaultLimitReachedHandler is the default LimitReachedHandler used by a new Middleware.
+func DefaultLimitReachedHandler(c echo.Context) error {
+	return echo.NewHTTPError(http.StatusTooManyRequests, "Limit exceeded")
+}
+
+// KeyGetter will define the rate limiter key given the echo Context, or an error if it cannot be obtained.
+// The error is given to the Middleware ErrorHandler.
+type KeyGetter func(c echo.Context) (string, error)
+
+// WithKeyGetter will configure the Middleware to use the given KeyGetter.
+func WithKeyGetter(handler KeyGetter) Option {
+	return option(func(middleware *Middleware) {
+		middleware.KeyGetter = handler
+	})
+}
+
+// DefaultKeyGetter is the default KeyGetter used by a new Middleware.
+// It returns the limiter KeyFunc result if defined, or the Client IP address otherwise.
+func DefaultKeyGetter(limiter *limiter.Limiter) KeyGetter {
+	return func(c echo.Context) (string, error) {
+		return limiter.GetKey(c.Request()), nil
+	}
+}
+
+// JWTKeyGetter returns a KeyGetter using the Client JWT token sub as key, or an error if the JWT is invalid.
+func JWTKeyGetter(limiter *limiter.Limiter) KeyGetter {
+	return func(c echo.Context) (string, error) {
+		return limiter.GetJWTSub(c.Request())
+	}
+}
+
+// WithExcludedKey will configure the Middleware to ignore key(s) using the given function.
+func WithExcludedKey(handler func(string) bool) Option {
+	return option(func(middleware *Middleware) {
+		middleware.ExcludedKey = handler
+	})
+}
+
+// WithHeaderStyle will configure the Middleware to set the rate limit headers of the given style.
+func WithHeaderStyle(style limiter.HeaderStyle) Option {
+	return option(func(middleware *Middleware) {
+		middleware.HeaderStyle = style
+	})
+}
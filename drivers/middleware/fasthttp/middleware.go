@@ -3,7 +3,6 @@ package fasthttp
 import (
 	"github.com/ulule/limiter/v3"
 	"github.com/valyala/fasthttp"
-	"strconv"
 )
 
 // Middleware is the middleware for fasthttp.
@@ -13,6 +12,7 @@ type Middleware struct {
 	OnLimitReached LimitReachedHandler
 	KeyGetter      KeyGetter
 	ExcludedKey    func(string) bool
+	HeaderStyle    limiter.HeaderStyle
 }
 
 // NewMiddleware return a new instance of a fasthttp middleware.
@@ -35,6 +35,15 @@ func NewMiddleware(limiter *limiter.Limiter, options ...Option) *Middleware {
 // Handle fasthttp request.
 func (middleware *Middleware) Handle(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
+		if middleware.Limiter.IsMethodSkipped(string(ctx.Method())) || middleware.Limiter.IsIPAllowlisted(ctx.RemoteIP()) {
+			next(ctx)
+			return
+		}
+		if middleware.Limiter.IsIPDenylisted(ctx.RemoteIP()) {
+			middleware.OnLimitReached(ctx)
+			return
+		}
+
 		key := middleware.KeyGetter(ctx)
 		if middleware.ExcludedKey != nil && middleware.ExcludedKey(key) {
 			next(ctx)
@@ -42,14 +51,23 @@ func (middleware *Middleware) Handle(next fasthttp.RequestHandler) fasthttp.Requ
 		}
 
 		context, err := middleware.Limiter.Get(ctx, key)
+		if err != nil && !context.Reached {
+			next(ctx)
+			return
+		}
 		if err != nil {
 			middleware.OnError(ctx, err)
 			return
 		}
 
-		ctx.Response.Header.Set("X-RateLimit-Limit", strconv.FormatInt(context.Limit, 10))
-		ctx.Response.Header.Set("X-RateLimit-Remaining", strconv.FormatInt(context.Remaining, 10))
-		ctx.Response.Header.Set("X-RateLimit-Reset", strconv.FormatInt(context.Reset, 10))
+		if middleware.Limiter.Options.DryRun {
+			next(ctx)
+			return
+		}
+
+		for name, value := range middleware.Limiter.GetHeaders(context, middleware.HeaderStyle) {
+			ctx.Response.Header.Set(name, value)
+		}
 
 		if context.Reached {
 			middleware.OnLimitReached(ctx)
@@ -2,6 +2,8 @@ package fasthttp
 
 import (
 	"github.com/valyala/fasthttp"
+
+	"github.com/ulule/limiter/v3"
 )
 
 // Option is used to define Middleware configuration.
@@ -31,6 +33,7 @@ func DefaultErrorHandler(ctx *fasthttp.RequestCtx, err error) {
 }
 
 // LimitReachedHandler is an handler used to inform when the limit has exceeded.
+// It is called once the rate limit headers are set, and may write its own status and body.
 type LimitReachedHandler func(ctx *fasthttp.RequestCtx)
 
 // WithLimitReachedHandler will configure the Middleware to use the given LimitReachedHandler.
@@ -68,3 +71,10 @@ func WithExcludedKey(handler func(string) bool) Option {
 		middleware.ExcludedKey = handler
 	})
 }
+
+// WithHeaderStyle will configure the Middleware to set the rate limit headers of the given style.
+func WithHeaderStyle(style limiter.HeaderStyle) Option {
+	return option(func(middleware *Middleware) {
+		middleware.HeaderStyle = style
+	})
+}
@@ -1,13 +1,14 @@
 package gin
 
 import (
-	"strconv"
-
 	"github.com/gin-gonic/gin"
 
 	"github.com/ulule/limiter/v3"
 )
 
+// ContextKey is the key of the limit context of a request, stored in the gin Context.
+const ContextKey = "limiter_context"
+
 // Middleware is the middleware for gin.
 type Middleware struct {
 	Limiter        *limiter.Limiter
@@ -15,6 +16,7 @@ type Middleware struct {
 	OnLimitReached LimitReachedHandler
 	KeyGetter      KeyGetter
 	ExcludedKey    func(string) bool
+	HeaderStyle    limiter.HeaderStyle
 }
 
 // NewMiddleware return a new instance of a gin middleware.
@@ -38,22 +40,44 @@ func NewMiddleware(limiter *limiter.Limiter, options ...Option) gin.HandlerFunc
 
 // Handle gin request.
 func (middleware *Middleware) Handle(c *gin.Context) {
+	if middleware.Limiter.IsMethodSkipped(c.Request.Method) || middleware.Limiter.IsAllowlisted(c.Request) ||
+		middleware.Limiter.IsBypassed(c.Request) {
+		c.Next()
+		return
+	}
+	if middleware.Limiter.IsDenylisted(c.Request) {
+		middleware.OnLimitReached(c)
+		c.Abort()
+		return
+	}
+
 	key := middleware.KeyGetter(c)
 	if middleware.ExcludedKey != nil && middleware.ExcludedKey(key) {
 		c.Next()
 		return
 	}
 
-	context, err := middleware.Limiter.Get(c, key)
+	context, err := middleware.check(c, key)
+	if err != nil && !context.Reached {
+		middleware.next(c, key)
+		return
+	}
 	if err != nil {
 		middleware.OnError(c, err)
 		c.Abort()
 		return
 	}
 
-	c.Header("X-RateLimit-Limit", strconv.FormatInt(context.Limit, 10))
-	c.Header("X-RateLimit-Remaining", strconv.FormatInt(context.Remaining, 10))
-	c.Header("X-RateLimit-Reset", strconv.FormatInt(context.Reset, 10))
+	if middleware.Limiter.Options.DryRun {
+		c.Set(ContextKey, context)
+		middleware.next(c, key)
+		return
+	}
+
+	for name, value := range middleware.Limiter.GetHeaders(context, middleware.HeaderStyle) {
+		c.Header(name, value)
+	}
+	c.Set(ContextKey, context)
 
 	if context.Reached {
 		middleware.OnLimitReached(c)
@@ -61,5 +85,35 @@ func (middleware *Middleware) Handle(c *gin.Context) {
 		return
 	}
 
+	middleware.next(c, key)
+}
+
+// check returns the limit for given request and key before calling the handlers: it's incremented, unless responses
+// are counted once handled with the limiter CountStatusCodes, except for WebSocket handshakes.
+func (middleware *Middleware) check(c *gin.Context, key string) (limiter.Context, error) {
+	if len(middleware.Limiter.Options.CountStatusCodes) > 0 && !middleware.Limiter.IsWebSocketHandshake(c.Request) {
+		return middleware.Limiter.CheckWithRequest(c.Request, key)
+	}
+	return middleware.Limiter.GetWithRequest(c.Request, key)
+}
+
+// next calls the pending handlers, then counts their response against the limit of given key with the limiter
+// CountStatusCodes. Store errors are ignored since the response is already written.
+func (middleware *Middleware) next(c *gin.Context, key string) {
 	c.Next()
+
+	if len(middleware.Limiter.Options.CountStatusCodes) > 0 && !middleware.Limiter.IsWebSocketHandshake(c.Request) {
+		_, _ = middleware.Limiter.CountWithRequest(c.Request, key, c.Writer.Status())
+	}
+}
+
+// GetContext returns the limit context of the request, stored in the gin Context by the middleware.
+func GetContext(c *gin.Context) (limiter.Context, bool) {
+	value, ok := c.Get(ContextKey)
+	if !ok {
+		return limiter.Context{}, false
+	}
+
+	context, ok := value.(limiter.Context)
+	return context, ok
 }
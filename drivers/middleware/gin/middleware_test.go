@@ -7,6 +7,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	libgin "github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
@@ -159,3 +160,151 @@ func TestHTTPMiddleware(t *testing.T) {
 		}
 	}
 }
+
+func TestHTTPMiddlewareContext(t *testing.T) {
+	is := require.New(t)
+	libgin.SetMode(libgin.TestMode)
+
+	request, err := http.NewRequest("GET", "/", nil)
+	is.NoError(err)
+	is.NotNil(request)
+	request.RemoteAddr = "178.1.2.3:8080"
+
+	keys := []string{}
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: time.Minute,
+		Limit:  2,
+	}, limiter.WithKeyFunc(func(r *http.Request) string {
+		return "user:" + r.RemoteAddr
+	}))
+
+	contexts := []limiter.Context{}
+	middleware := gin.NewMiddleware(instance,
+		gin.WithKeyGetter(gin.LimiterKeyGetter(instance)),
+		gin.WithExcludedKey(func(key string) bool {
+			keys = append(keys, key)
+			return false
+		}),
+		gin.WithLimitReachedHandler(func(c *libgin.Context) {
+			context, ok := gin.GetContext(c)
+			is.True(ok)
+			contexts = append(contexts, context)
+			c.String(http.StatusTooManyRequests, "Limit exceeded")
+		}))
+
+	router := libgin.New()
+	router.Use(middleware)
+	router.GET("/", func(c *libgin.Context) {
+		context, ok := gin.GetContext(c)
+		is.True(ok)
+		contexts = append(contexts, context)
+		c.String(http.StatusOK, "hello")
+	})
+
+	for i := 0; i < 3; i++ {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, request)
+	}
+
+	is.Equal([]string{"user:178.1.2.3:8080", "user:178.1.2.3:8080", "user:178.1.2.3:8080"}, keys)
+	is.Len(contexts, 3)
+	for i, context := range contexts {
+		is.Equal(int64(2), context.Limit)
+		is.Equal(i == 2, context.Reached)
+	}
+	is.Equal(int64(1), contexts[0].Remaining)
+	is.Equal(int64(0), contexts[1].Remaining)
+}
+
+func TestHTTPMiddlewareDryRun(t *testing.T) {
+	is := require.New(t)
+	libgin.SetMode(libgin.TestMode)
+
+	request, err := http.NewRequest("GET", "/", nil)
+	is.NoError(err)
+	is.NotNil(request)
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: time.Minute,
+		Limit:  2,
+	}, limiter.WithDryRun(true))
+
+	reached := []bool{}
+	router := libgin.New()
+	router.Use(gin.NewMiddleware(instance))
+	router.GET("/", func(c *libgin.Context) {
+		context, ok := gin.GetContext(c)
+		is.True(ok)
+		reached = append(reached, context.Reached)
+		c.String(http.StatusOK, "hello")
+	})
+
+	for i := 0; i < 4; i++ {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, request)
+		is.Equal(http.StatusOK, resp.Code)
+	}
+
+	is.Equal([]bool{false, false, true, true}, reached)
+}
+
+func TestHTTPMiddlewareCountStatusCodes(t *testing.T) {
+	is := require.New(t)
+	libgin.SetMode(libgin.TestMode)
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: time.Minute,
+		Limit:  2,
+	}, limiter.WithCountStatusCodes(http.StatusUnauthorized))
+
+	router := libgin.New()
+	router.Use(gin.NewMiddleware(instance))
+	router.POST("/login", func(c *libgin.Context) {
+		if c.GetHeader("X-Password") != "secret" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.String(http.StatusOK, "hello")
+	})
+
+	scenarios := []struct {
+		password string
+		code     int
+	}{
+		{
+			// Scenario #1 : successful login isn't counted.
+			password: "secret",
+			code:     http.StatusOK,
+		},
+		{
+			// Scenario #2 : failed login is counted.
+			password: "foo",
+			code:     http.StatusUnauthorized,
+		},
+		{
+			// Scenario #3 : successful login isn't counted.
+			password: "secret",
+			code:     http.StatusOK,
+		},
+		{
+			// Scenario #4 : last failed login allowed.
+			password: "bar",
+			code:     http.StatusUnauthorized,
+		},
+		{
+			// Scenario #5 : limit is reached, even with the right password.
+			password: "secret",
+			code:     http.StatusTooManyRequests,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		request, err := http.NewRequest("POST", "/login", nil)
+		is.NoError(err)
+		request.Header.Set("X-Password", scenario.password)
+
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, request)
+		is.Equal(scenario.code, resp.Code, "scenario #%d", i+1)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/ulule/limiter/v3"
 )
 
 // Option is used to define Middleware configuration.
@@ -33,6 +35,7 @@ func DefaultErrorHandler(c *gin.Context, err error) {
 }
 
 // LimitReachedHandler is an handler used to inform when the limit has exceeded.
+// It is called once the rate limit headers are set, and may write its own status and body.
 type LimitReachedHandler func(c *gin.Context)
 
 // WithLimitReachedHandler will configure the Middleware to use the given LimitReachedHandler.
@@ -63,9 +66,24 @@ func DefaultKeyGetter(c *gin.Context) string {
 	return c.ClientIP()
 }
 
+// LimiterKeyGetter returns a KeyGetter using the given limiter to obtain the key of a request.
+// It returns the limiter KeyFunc result if defined, or the Client IP address with the limiter masks otherwise.
+func LimiterKeyGetter(limiter *limiter.Limiter) KeyGetter {
+	return func(c *gin.Context) string {
+		return limiter.GetKey(c.Request)
+	}
+}
+
 // WithExcludedKey will configure the Middleware to ignore key(s) using the given function.
 func WithExcludedKey(handler func(string) bool) Option {
 	return option(func(middleware *Middleware) {
 		middleware.ExcludedKey = handler
 	})
 }
+
+// WithHeaderStyle will configure the Middleware to set the rate limit headers of the given style.
+func WithHeaderStyle(style limiter.HeaderStyle) Option {
+	return option(func(middleware *Middleware) {
+		middleware.HeaderStyle = style
+	})
+}
@@ -2,18 +2,21 @@ package stdlib
 
 import (
 	"net/http"
-	"strconv"
+	"sort"
 
 	"github.com/ulule/limiter/v3"
 )
 
 // Middleware is the middleware for basic http.Handler.
 type Middleware struct {
-	Limiter        *limiter.Limiter
-	OnError        ErrorHandler
-	OnLimitReached LimitReachedHandler
-	KeyGetter      KeyGetter
-	ExcludedKey    func(string) bool
+	Limiter            *limiter.Limiter
+	OnError            ErrorHandler
+	OnLimitReached     LimitReachedHandler
+	KeyGetter          KeyGetter
+	KeyGetterWithError KeyGetterWithError
+	ExcludedKey        func(string) bool
+	HeaderStyle        limiter.HeaderStyle
+	Trailers           bool
 }
 
 // NewMiddleware return a new instance of a basic HTTP middleware.
@@ -36,11 +39,11 @@ func NewMiddleware(limiter *limiter.Limiter, options ...Option) *Middleware {
 // NewJWTMiddleware return a new instance of a JWT token middleware.
 func NewJWTMiddleware(limiter *limiter.Limiter, options ...Option) *Middleware {
 	middleware := &Middleware{
-		Limiter:        limiter,
-		OnError:        DefaultErrorHandler,
-		OnLimitReached: DefaultLimitReachedHandler,
-		KeyGetter:      JWTKeyGetter(limiter),
-		ExcludedKey:    nil,
+		Limiter:            limiter,
+		OnError:            DefaultErrorHandler,
+		OnLimitReached:     DefaultLimitReachedHandler,
+		KeyGetterWithError: JWTKeyGetter(limiter),
+		ExcludedKey:        nil,
 	}
 
 	for _, option := range options {
@@ -53,33 +56,171 @@ func NewJWTMiddleware(limiter *limiter.Limiter, options ...Option) *Middleware {
 // Handler handles a HTTP request.
 func (middleware *Middleware) Handler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := middleware.Limiter.ErrValidation
+		if middleware.Limiter.IsMethodSkipped(r.Method) || middleware.Limiter.IsAllowlisted(r) ||
+			middleware.Limiter.IsBypassed(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if middleware.Limiter.IsDenylisted(r) {
+			middleware.OnLimitReached(w, r)
+			return
+		}
+
+		key, err := middleware.getKey(r)
 		if err != nil {
 			middleware.OnError(w, r, err)
 			return
 		}
 
-		key := middleware.KeyGetter(r)
 		if middleware.ExcludedKey != nil && middleware.ExcludedKey(key) {
 			h.ServeHTTP(w, r)
 			return
 		}
 
-		context, err := middleware.Limiter.Get(r.Context(), key)
+		context, err := middleware.check(r, key)
+		if err != nil && !context.Reached {
+			middleware.serve(h, w, r, key)
+			return
+		}
 		if err != nil {
 			middleware.OnError(w, r, err)
 			return
 		}
 
-		w.Header().Add("X-RateLimit-Limit", strconv.FormatInt(context.Limit, 10))
-		w.Header().Add("X-RateLimit-Remaining", strconv.FormatInt(context.Remaining, 10))
-		w.Header().Add("X-RateLimit-Reset", strconv.FormatInt(context.Reset, 10))
+		if middleware.Limiter.Options.DryRun {
+			middleware.serve(h, w, r, key)
+			return
+		}
+
+		if middleware.Trailers && !context.Reached && r.ProtoAtLeast(1, 1) &&
+			!middleware.Limiter.IsWebSocketHandshake(r) {
+			middleware.serveWithTrailers(h, w, r, key, context)
+			return
+		}
+
+		for name, value := range middleware.Limiter.GetHeaders(context, middleware.HeaderStyle) {
+			w.Header().Add(name, value)
+		}
 
 		if context.Reached {
 			middleware.OnLimitReached(w, r)
 			return
 		}
 
-		h.ServeHTTP(w, r)
+		middleware.serve(h, w, r, key)
 	})
 }
+
+// check returns the limit for given request and key before calling the handler: it's incremented, unless responses
+// are counted once handled with the limiter CountStatusCodes, except for WebSocket handshakes.
+func (middleware *Middleware) check(r *http.Request, key string) (limiter.Context, error) {
+	if len(middleware.Limiter.Options.CountStatusCodes) > 0 && !middleware.Limiter.IsWebSocketHandshake(r) {
+		return middleware.Limiter.CheckWithRequest(r, key)
+	}
+	return middleware.Limiter.GetWithRequest(r, key)
+}
+
+// serve calls given handler, then counts its response against the limit of given key with the limiter
+// CountStatusCodes. It returns the limit once counted, if it has been. Store errors are ignored since the
+// response is already written.
+func (middleware *Middleware) serve(h http.Handler, w http.ResponseWriter, r *http.Request,
+	key string) (limiter.Context, bool) {
+
+	if len(middleware.Limiter.Options.CountStatusCodes) == 0 || middleware.Limiter.IsWebSocketHandshake(r) {
+		h.ServeHTTP(w, r)
+		return limiter.Context{}, false
+	}
+
+	writer := &statusWriter{ResponseWriter: w}
+	h.ServeHTTP(writer, r)
+	context, err := middleware.Limiter.CountWithRequest(r, key, writer.Status())
+	return context, err == nil
+}
+
+// serveWithTrailers calls given handler like serve, with the rate limit headers declared as trailers beforehand.
+// They are set once handled, from the limit of given key at that time, so a streamed response gets its final state.
+// If it can't be obtained, the given limit checked before the handler is used instead.
+func (middleware *Middleware) serveWithTrailers(h http.Handler, w http.ResponseWriter, r *http.Request,
+	key string, context limiter.Context) {
+
+	headers := middleware.Limiter.GetHeaders(context, middleware.HeaderStyle)
+	if middleware.Limiter.Options.WarnThreshold > 0 {
+		headers[limiter.HeaderWarning] = ""
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		w.Header().Add("Trailer", name)
+	}
+
+	final, ok := middleware.serve(h, w, r, key)
+	if !ok {
+		var err error
+		final, err = middleware.Limiter.PeekWithRequest(r, key)
+		if err != nil {
+			final = context
+		}
+	}
+
+	headers = middleware.Limiter.GetHeaders(final, middleware.HeaderStyle)
+	for _, name := range names {
+		if value, ok := headers[name]; ok {
+			w.Header().Set(name, value)
+		}
+	}
+}
+
+// getKey returns the rate limiter key for given request, using KeyGetterWithError if defined.
+func (middleware *Middleware) getKey(r *http.Request) (string, error) {
+	if middleware.KeyGetterWithError != nil {
+		return middleware.KeyGetterWithError(r)
+	}
+	return middleware.KeyGetter(r), nil
+}
+
+// statusWriter is a http.ResponseWriter recording the status of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records given status before sending it.
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 status if none was sent before writing given data.
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush sends buffered data to the client, if supported by the underlying http.ResponseWriter.
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the status of the response, which is 200 if the handler didn't send any.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
@@ -33,6 +33,7 @@ func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 }
 
 // LimitReachedHandler is an handler used to inform when the limit has exceeded.
+// It is called once the rate limit headers are set, and may write its own status and body.
 type LimitReachedHandler func(w http.ResponseWriter, r *http.Request)
 
 // WithLimitReachedHandler will configure the Middleware to use the given LimitReachedHandler.
@@ -54,14 +55,26 @@ type KeyGetter func(r *http.Request) string
 func WithKeyGetter(handler KeyGetter) Option {
 	return option(func(middleware *Middleware) {
 		middleware.KeyGetter = handler
+		middleware.KeyGetterWithError = nil
+	})
+}
+
+// KeyGetterWithError will define the rate limiter key given the request, or an error if it cannot be obtained.
+// The error is given to the Middleware ErrorHandler.
+type KeyGetterWithError func(r *http.Request) (string, error)
+
+// WithKeyGetterWithError will configure the Middleware to use the given KeyGetterWithError.
+func WithKeyGetterWithError(handler KeyGetterWithError) Option {
+	return option(func(middleware *Middleware) {
+		middleware.KeyGetterWithError = handler
 	})
 }
 
 // DefaultKeyGetter is the default KeyGetter used by a new Middleware.
-// It returns the Client IP address.
+// It returns the limiter KeyFunc result if defined, or the Client IP address otherwise.
 func DefaultKeyGetter(limiter *limiter.Limiter) func(r *http.Request) string {
 	return func(r *http.Request) string {
-		return limiter.GetIPKey(r)
+		return limiter.GetKey(r)
 	}
 }
 
@@ -72,10 +85,27 @@ func WithExcludedKey(handler func(string) bool) Option {
 	})
 }
 
-// JWTKeyGetter is the default KeyGetter used by a new Middleware.
-// It returns the Client JWT token.
-func JWTKeyGetter(limiter *limiter.Limiter) func(r *http.Request) string {
-	return func(r *http.Request) string {
+// JWTKeyGetter is the default KeyGetterWithError used by a new JWT Middleware.
+// It returns the Client JWT token sub, or an error if the JWT is invalid.
+func JWTKeyGetter(limiter *limiter.Limiter) func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
 		return limiter.GetJWTSub(r)
 	}
 }
+
+// WithHeaderStyle will configure the Middleware to set the rate limit headers of the given style.
+func WithHeaderStyle(style limiter.HeaderStyle) Option {
+	return option(func(middleware *Middleware) {
+		middleware.HeaderStyle = style
+	})
+}
+
+// WithTrailers will configure the Middleware to send the rate limit headers as HTTP trailers, once the request is
+// handled, such as for streaming responses whose headers are flushed early. They are declared with the Trailer
+// header beforehand. Rejected requests, and requests whose protocol doesn't support trailers (HTTP/1.0), still
+// get regular headers.
+func WithTrailers(enable bool) Option {
+	return option(func(middleware *Middleware) {
+		middleware.Trailers = enable
+	})
+}
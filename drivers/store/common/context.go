@@ -26,3 +26,15 @@ func GetContextFromState(now time.Time, rate limiter.Rate, expiration time.Time,
 		Reached:   reached,
 	}
 }
+
+// GetContextFromBucket generate a new limiter.Context from given token bucket state.
+// The reset is the time when the bucket will be full again, or when the requested tokens will be available
+// if they were not taken.
+func GetContextFromBucket(burst int64, tokens int64, reset time.Time, taken bool) limiter.Context {
+	return limiter.Context{
+		Limit:     burst,
+		Remaining: tokens,
+		Reset:     reset.Unix(),
+		Reached:   !taken,
+	}
+}
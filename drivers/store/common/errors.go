@@ -0,0 +1,52 @@
+package common
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/ulule/limiter/v3"
+)
+
+// WrapError wraps given error of a store driver with given message.
+// Network failures are wrapped in a limiter.StoreError, so they match limiter.ErrStoreTimeout or
+// limiter.ErrStoreUnavailable with errors.Is. Errors matching one of given unavailable errors, such as the
+// sentinel of a closed client, are classified as unavailable too.
+func WrapError(err error, message string, unavailable ...error) error {
+	kind := getErrorKind(err, unavailable)
+	if kind != nil {
+		err = &limiter.StoreError{Kind: kind, Err: err}
+	}
+	return pkgerrors.Wrap(err, message)
+}
+
+// getErrorKind returns the kind of given error, or nil if it isn't a network failure or is already classified.
+func getErrorKind(err error, unavailable []error) error {
+	var storeErr *limiter.StoreError
+	if errors.As(err, &storeErr) {
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return limiter.ErrStoreTimeout
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return limiter.ErrStoreUnavailable
+	}
+
+	for _, target := range unavailable {
+		if errors.Is(err, target) {
+			return limiter.ErrStoreUnavailable
+		}
+	}
+
+	return nil
+}
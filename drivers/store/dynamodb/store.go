@@ -0,0 +1,322 @@
+package dynamodb
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	libdynamodb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pkg/errors"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/common"
+)
+
+const (
+	// AttributeKey is the name of the partition key attribute of the table, a string.
+	AttributeKey = "key"
+	// AttributeCount is the name of the counter attribute, a number.
+	AttributeCount = "count"
+	// AttributeExpiration is the name of the expiration attribute, a number of seconds since the epoch.
+	// Enable DynamoDB TTL on this attribute so expired counters are eventually deleted.
+	AttributeExpiration = "expiration"
+)
+
+const (
+	// incrementExpression increments the counter of an item, if it's not expired.
+	incrementExpression = "ADD #count :count"
+	incrementCondition  = "#expiration > :now"
+	// createExpression creates the counter of an item, if it doesn't exist or is expired.
+	createExpression = "SET #count = :count, #expiration = :expiration"
+	createCondition  = "attribute_not_exists(#key) OR #expiration <= :now"
+)
+
+// ErrThrottled is returned (wrapped) when DynamoDB throttles a request, such as with a
+// ProvisionedThroughputExceededException, so callers can distinguish it from other failures (ie: to fail open).
+// It's wrapped in a limiter.StoreError of kind limiter.ErrStoreUnavailable, like other failures of the store.
+var ErrThrottled = errors.New("dynamodb request throttled")
+
+// throttledError is an error of DynamoDB throttling a request, matching ErrThrottled.
+type throttledError struct {
+	err error
+}
+
+// Error returns ErrThrottled and the error of DynamoDB.
+func (e *throttledError) Error() string {
+	return ErrThrottled.Error() + ": " + e.err.Error()
+}
+
+// Unwrap returns the error of DynamoDB.
+func (e *throttledError) Unwrap() error {
+	return e.err
+}
+
+// Is returns if given target is ErrThrottled.
+func (e *throttledError) Is(target error) bool {
+	return target == ErrThrottled
+}
+
+// Client is an interface thats allows to use a DynamoDB client, such as dynamodb.Client.
+type Client interface {
+	GetItem(ctx context.Context, params *libdynamodb.GetItemInput,
+		optFns ...func(*libdynamodb.Options)) (*libdynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *libdynamodb.UpdateItemInput,
+		optFns ...func(*libdynamodb.Options)) (*libdynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *libdynamodb.DeleteItemInput,
+		optFns ...func(*libdynamodb.Options)) (*libdynamodb.DeleteItemOutput, error)
+}
+
+// Store is the DynamoDB store.
+// The table must have a string partition key named "key". Expirations have a precision of one second.
+type Store struct {
+	// Prefix used for the key.
+	Prefix string
+	// Table is the name of the DynamoDB table.
+	Table string
+	// MaxRetry is the maximum number of retry when a counter is created concurrently.
+	MaxRetry int
+	// client used to communicate with DynamoDB.
+	client Client
+	// clock used to obtain the current time.
+	clock limiter.Clock
+}
+
+// NewStore returns an instance of DynamoDB store with defaults, using given table.
+func NewStore(client Client, table string) (limiter.Store, error) {
+	return NewStoreWithOptions(client, table, limiter.StoreOptions{
+		Prefix:   limiter.DefaultPrefix,
+		MaxRetry: limiter.DefaultMaxRetry,
+	})
+}
+
+// NewStoreWithOptions returns an instance of DynamoDB store with options, using given table.
+func NewStoreWithOptions(client Client, table string, options limiter.StoreOptions) (limiter.Store, error) {
+	if client == nil {
+		return nil, errors.New("dynamodb client is required")
+	}
+	if table == "" {
+		return nil, errors.New("dynamodb table is required")
+	}
+
+	maxRetry := options.MaxRetry
+	if maxRetry <= 0 {
+		maxRetry = limiter.DefaultMaxRetry
+	}
+
+	clock := options.Clock
+	if clock == nil {
+		clock = limiter.SystemClock{}
+	}
+
+	store := &Store{
+		Prefix:   options.Prefix,
+		Table:    table,
+		MaxRetry: maxRetry,
+		client:   client,
+		clock:    clock,
+	}
+
+	return store, nil
+}
+
+// Get returns the limit for given identifier.
+func (store *Store) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return store.Increment(ctx, key, 1, rate)
+}
+
+// Increment increments the limit by given count & gives back the new limit for given identifier.
+// The counter is atomically incremented if it's not expired, or created with a new expiration otherwise.
+func (store *Store) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
+	now := store.clock.Now()
+	expiration := getExpiration(now, rate)
+
+	for i := 0; i <= store.MaxRetry; i++ {
+		item, err := store.update(ctx, key, incrementExpression, incrementCondition, map[string]types.AttributeValue{
+			":count": numberValue(count),
+			":now":   numberValue(now.Unix()),
+		})
+		if err == nil {
+			return getContext(now, rate, item)
+		}
+		if !isConditionalCheckFailed(err) {
+			return limiter.Context{}, err
+		}
+
+		item, err = store.update(ctx, key, createExpression, createCondition, map[string]types.AttributeValue{
+			":count":      numberValue(count),
+			":now":        numberValue(now.Unix()),
+			":expiration": numberValue(expiration.Unix()),
+		})
+		if err == nil {
+			return getContext(now, rate, item)
+		}
+		if !isConditionalCheckFailed(err) {
+			return limiter.Context{}, err
+		}
+
+		// Another client has created the counter meanwhile: increment it again.
+	}
+
+	return limiter.Context{}, errors.Errorf("failed to increment key %s after %d retries", key, store.MaxRetry)
+}
+
+// Peek returns the limit for given identifier, without modification on current values.
+func (store *Store) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
+	now := store.clock.Now()
+
+	output, err := store.client.GetItem(ctx, &libdynamodb.GetItemInput{
+		TableName:      &store.Table,
+		Key:            store.getKey(key),
+		ConsistentRead: boolPtr(true),
+	})
+	if err != nil {
+		return limiter.Context{}, wrapError(ctx, err)
+	}
+
+	return getContext(now, rate, output.Item)
+}
+
+// Reset returns the limit for given identifier which is set to zero.
+func (store *Store) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
+	now := store.clock.Now()
+
+	_, err := store.client.DeleteItem(ctx, &libdynamodb.DeleteItemInput{
+		TableName: &store.Table,
+		Key:       store.getKey(key),
+	})
+	if err != nil {
+		return limiter.Context{}, wrapError(ctx, err)
+	}
+
+	return common.GetContextFromState(now, rate, getExpiration(now, rate), 0), nil
+}
+
+// update updates the item of given identifier with given expression, if given condition is satisfied.
+// It returns the updated item.
+func (store *Store) update(ctx context.Context, key string, expression string, condition string,
+	values map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+
+	output, err := store.client.UpdateItem(ctx, &libdynamodb.UpdateItemInput{
+		TableName:           &store.Table,
+		Key:                 store.getKey(key),
+		UpdateExpression:    &expression,
+		ConditionExpression: &condition,
+		ExpressionAttributeNames: map[string]string{
+			"#key":        AttributeKey,
+			"#count":      AttributeCount,
+			"#expiration": AttributeExpiration,
+		},
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return nil, wrapError(ctx, err)
+	}
+
+	return output.Attributes, nil
+}
+
+// getKey returns the DynamoDB key for given identifier.
+func (store *Store) getKey(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		AttributeKey: &types.AttributeValueMemberS{Value: store.Prefix + ":" + key},
+	}
+}
+
+// getContext returns the limit context of given item at given time.
+// A missing or expired item has a zero count.
+func getContext(now time.Time, rate limiter.Rate, item map[string]types.AttributeValue) (limiter.Context, error) {
+	expiration := getExpiration(now, rate)
+	if item == nil {
+		return common.GetContextFromState(now, rate, expiration, 0), nil
+	}
+
+	count, err := parseNumber(item, AttributeCount)
+	if err != nil {
+		return limiter.Context{}, err
+	}
+
+	seconds, err := parseNumber(item, AttributeExpiration)
+	if err != nil {
+		return limiter.Context{}, err
+	}
+
+	if seconds <= now.Unix() {
+		return common.GetContextFromState(now, rate, expiration, 0), nil
+	}
+
+	return common.GetContextFromState(now, rate, time.Unix(seconds, 0), count), nil
+}
+
+// getExpiration returns the expiration of a counter created at given time, truncated to the second.
+// It's at least one second after given time, so periods shorter than a second are extended.
+func getExpiration(now time.Time, rate limiter.Rate) time.Time {
+	expiration := now.Add(rate.Period).Truncate(time.Second)
+	if expiration.Unix() <= now.Unix() {
+		return now.Truncate(time.Second).Add(time.Second)
+	}
+	return expiration
+}
+
+// parseNumber returns the number attribute of given item with given name.
+func parseNumber(item map[string]types.AttributeValue, name string) (int64, error) {
+	attribute, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, errors.Errorf("attribute %s should be a number", name)
+	}
+
+	value, err := strconv.ParseInt(attribute.Value, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse attribute %s", name)
+	}
+
+	return value, nil
+}
+
+// numberValue returns a number attribute value.
+func numberValue(value int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(value, 10)}
+}
+
+// isConditionalCheckFailed returns if given error is a failed condition of an update.
+func isConditionalCheckFailed(err error) bool {
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	return errors.As(err, &conditionalCheckFailed)
+}
+
+// wrapError returns the context error if given context is done, or given error wrapped otherwise, classifying
+// throttling and network failures as store errors (see limiter.StoreError).
+// Throttling errors are unavailability errors matching ErrThrottled.
+func wrapError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var provisionedThroughputExceeded *types.ProvisionedThroughputExceededException
+	var requestLimitExceeded *types.RequestLimitExceeded
+	if errors.As(err, &provisionedThroughputExceeded) || errors.As(err, &requestLimitExceeded) {
+		return &limiter.StoreError{
+			Kind: limiter.ErrStoreUnavailable,
+			Err:  &throttledError{err: err},
+		}
+	}
+
+	return common.WrapError(err, "an error has occurred with dynamodb request")
+}
+
+func boolPtr(value bool) *bool {
+	return &value
+}
@@ -0,0 +1,329 @@
+package dynamodb_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	libdynamodb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/dynamodb"
+	"github.com/ulule/limiter/v3/drivers/store/tests"
+	"github.com/ulule/limiter/v3/limitertest"
+)
+
+// fakeItem is an item of fakeClient.
+type fakeItem struct {
+	count      int64
+	expiration int64
+}
+
+// fakeClient is an in-memory DynamoDB client, honoring the update expressions and conditions of the store.
+type fakeClient struct {
+	mutex sync.Mutex
+	items map[string]fakeItem
+	// err is returned by every request, if not nil.
+	err error
+	// onUpdate is called before updating an item, such as to simulate a concurrent client.
+	onUpdate func(params *libdynamodb.UpdateItemInput)
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{items: map[string]fakeItem{}}
+}
+
+func (client *fakeClient) GetItem(ctx context.Context, params *libdynamodb.GetItemInput,
+	optFns ...func(*libdynamodb.Options)) (*libdynamodb.GetItemOutput, error) {
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.err != nil {
+		return nil, client.err
+	}
+
+	item, ok := client.items[getKey(params.Key)]
+	if !ok {
+		return &libdynamodb.GetItemOutput{}, nil
+	}
+	return &libdynamodb.GetItemOutput{Item: item.attributes()}, nil
+}
+
+func (client *fakeClient) UpdateItem(ctx context.Context, params *libdynamodb.UpdateItemInput,
+	optFns ...func(*libdynamodb.Options)) (*libdynamodb.UpdateItemOutput, error) {
+
+	if client.onUpdate != nil {
+		client.onUpdate(params)
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.err != nil {
+		return nil, client.err
+	}
+
+	key := getKey(params.Key)
+	now := getNumber(params.ExpressionAttributeValues, ":now")
+	count := getNumber(params.ExpressionAttributeValues, ":count")
+	item, ok := client.items[key]
+	alive := ok && item.expiration > now
+
+	if strings.HasPrefix(*params.UpdateExpression, "ADD") {
+		if !alive {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		item.count += count
+	} else {
+		if alive {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		item = fakeItem{
+			count:      count,
+			expiration: getNumber(params.ExpressionAttributeValues, ":expiration"),
+		}
+	}
+
+	client.items[key] = item
+	return &libdynamodb.UpdateItemOutput{Attributes: item.attributes()}, nil
+}
+
+func (client *fakeClient) DeleteItem(ctx context.Context, params *libdynamodb.DeleteItemInput,
+	optFns ...func(*libdynamodb.Options)) (*libdynamodb.DeleteItemOutput, error) {
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.err != nil {
+		return nil, client.err
+	}
+
+	delete(client.items, getKey(params.Key))
+	return &libdynamodb.DeleteItemOutput{}, nil
+}
+
+func (item fakeItem) attributes() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		dynamodb.AttributeCount:      &types.AttributeValueMemberN{Value: strconv.FormatInt(item.count, 10)},
+		dynamodb.AttributeExpiration: &types.AttributeValueMemberN{Value: strconv.FormatInt(item.expiration, 10)},
+	}
+}
+
+func getKey(key map[string]types.AttributeValue) string {
+	return key[dynamodb.AttributeKey].(*types.AttributeValueMemberS).Value
+}
+
+func getNumber(values map[string]types.AttributeValue, name string) int64 {
+	value, ok := values[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	number, _ := strconv.ParseInt(value.Value, 10, 64)
+	return number
+}
+
+func TestDynamoDBStoreClient(t *testing.T) {
+	is := require.New(t)
+
+	var client dynamodb.Client = &libdynamodb.Client{}
+	is.NotNil(client)
+
+	_, err := dynamodb.NewStore(nil, "limiter")
+	is.Error(err)
+
+	_, err = dynamodb.NewStore(newFakeClient(), "")
+	is.Error(err)
+}
+
+func TestDynamoDBStoreSequentialAccess(t *testing.T) {
+	is := require.New(t)
+
+	store, err := dynamodb.NewStoreWithOptions(newFakeClient(), "limiter", limiter.StoreOptions{
+		Prefix:   "limiter:dynamodb:sequential-test",
+		MaxRetry: 3,
+	})
+	is.NoError(err)
+
+	tests.TestStoreSequentialAccess(t, store)
+}
+
+func TestDynamoDBStoreConcurrentAccess(t *testing.T) {
+	is := require.New(t)
+
+	store, err := dynamodb.NewStoreWithOptions(newFakeClient(), "limiter", limiter.StoreOptions{
+		Prefix:   "limiter:dynamodb:concurrent-test",
+		MaxRetry: 3,
+	})
+	is.NoError(err)
+
+	tests.TestStoreConcurrentAccess(t, store)
+}
+
+func TestDynamoDBStoreContextCancellation(t *testing.T) {
+	is := require.New(t)
+
+	store, err := dynamodb.NewStore(newFakeClient(), "limiter")
+	is.NoError(err)
+
+	tests.TestStoreContextCancellation(t, store)
+}
+
+func TestDynamoDBStoreExpiration(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	client := newFakeClient()
+	clock := limitertest.NewFakeClock(time.Unix(1600000000, 0))
+	store, err := dynamodb.NewStoreWithOptions(client, "limiter", limiter.StoreOptions{
+		Prefix:   "limiter:dynamodb:expiration-test",
+		MaxRetry: 3,
+		Clock:    clock,
+	})
+	is.NoError(err)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	lctx, err := store.Increment(ctx, "foo", 3, rate)
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Remaining)
+	is.Equal(int64(1600000060), lctx.Reset)
+
+	// The TTL attribute is the expiration of the counter.
+	item := client.items["limiter:dynamodb:expiration-test:foo"]
+	is.Equal(int64(3), item.count)
+	is.Equal(int64(1600000060), item.expiration)
+
+	clock.Advance(30 * time.Second)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(6), lctx.Remaining)
+	is.Equal(int64(1600000060), lctx.Reset)
+
+	// An expired item, not yet deleted by DynamoDB TTL, is ignored.
+	clock.Advance(30 * time.Second)
+
+	lctx, err = store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+	is.Equal(int64(1600000120), lctx.Reset)
+
+	// Another client creates the counter between the failed increment and the creation.
+	client.onUpdate = func(params *libdynamodb.UpdateItemInput) {
+		if strings.HasPrefix(*params.UpdateExpression, "SET") {
+			client.onUpdate = nil
+			client.mutex.Lock()
+			client.items["limiter:dynamodb:expiration-test:bar"] = fakeItem{count: 5, expiration: 1600000180}
+			client.mutex.Unlock()
+		}
+	}
+
+	lctx, err = store.Get(ctx, "bar", rate)
+	is.NoError(err)
+	is.Equal(int64(4), lctx.Remaining)
+	is.Equal(int64(1600000180), lctx.Reset)
+}
+
+func TestDynamoDBStoreThrottling(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	client := newFakeClient()
+	store, err := dynamodb.NewStore(client, "limiter")
+	is.NoError(err)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	// Scenario #1 : Provisioned throughput is exceeded.
+	client.err = &types.ProvisionedThroughputExceededException{}
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, dynamodb.ErrThrottled))
+
+	_, err = store.Peek(ctx, "foo", rate)
+	is.True(errors.Is(err, dynamodb.ErrThrottled))
+
+	_, err = store.Reset(ctx, "foo", rate)
+	is.True(errors.Is(err, dynamodb.ErrThrottled))
+
+	// Scenario #2 : Request limit is exceeded.
+	client.err = &types.RequestLimitExceeded{}
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, dynamodb.ErrThrottled))
+
+	// Scenario #3 : Other errors aren't throttling errors.
+	client.err = &types.ResourceNotFoundException{}
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.Error(err)
+	is.False(errors.Is(err, dynamodb.ErrThrottled))
+}
+
+func TestDynamoDBStoreErrors(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	client := newFakeClient()
+	store, err := dynamodb.NewStore(client, "limiter")
+	is.NoError(err)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	// Scenario #1 : Endpoint refuses connections.
+	client.err = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	_, err = store.Peek(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	_, err = store.Reset(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	var opErr *net.OpError
+	is.True(errors.As(err, &opErr))
+
+	// Scenario #2 : Endpoint doesn't reply in time.
+	client.err = &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreTimeout))
+
+	// Scenario #3 : Throttling is an unavailability of the store, still matching ErrThrottled.
+	client.err = &types.RequestLimitExceeded{}
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, dynamodb.ErrThrottled))
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	var storeErr *limiter.StoreError
+	is.True(errors.As(err, &storeErr))
+
+	var requestLimitExceeded *types.RequestLimitExceeded
+	is.True(errors.As(err, &requestLimitExceeded))
+}
@@ -0,0 +1,461 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/ulule/limiter/v3"
+)
+
+const (
+	// DefaultRetryInterval is the default interval between attempts to use the primary store again.
+	DefaultRetryInterval = 1 * time.Second
+	// DefaultMaxKeys is the default maximum number of keys counted by the local store, reconciled on recovery.
+	DefaultMaxKeys = 10000
+	// DefaultReconcileTimeout is the default timeout of an attempt to reconcile the local counts with the primary
+	// store.
+	DefaultReconcileTimeout = 10 * time.Second
+)
+
+// Options are options for fallback store.
+type Options struct {
+	// RetryInterval is the interval between attempts to use the primary store again, once it's unavailable.
+	// Meanwhile, the local store is used without trying the primary store, so requests don't pay its timeout.
+	// Default is DefaultRetryInterval.
+	RetryInterval time.Duration
+	// MaxKeys is the maximum number of keys counted by the local store during failover, so it's bounded whatever the
+	// number of clients, and whose counts are reconciled with the primary store on recovery. Once MaxKeys keys are
+	// counted in their window, requests of other keys fail with ErrLocalStoreFull, handled like an unavailable store
+	// without fallback (see the FailOpen option of the limiter). Resets are always applied.
+	// Default is DefaultMaxKeys.
+	MaxKeys int
+	// ReconcileTimeout is the timeout of an attempt to reconcile the local counts with the primary store, so a
+	// primary store hanging without its own timeout doesn't block recovery: the attempt fails once it has elapsed.
+	// Default is DefaultReconcileTimeout.
+	ReconcileTimeout time.Duration
+	// Clock is the source of the current time, such as a fake clock in tests. Default is SystemClock.
+	Clock limiter.Clock
+}
+
+// ErrLocalStoreFull is returned (wrapped in a limiter.StoreError of kind limiter.ErrStoreUnavailable) during
+// failover, for a key not counted by the local store once it counts MaxKeys keys.
+var ErrLocalStoreFull = errors.New("fallback local store is full")
+
+// pending is the count of a key on the local store, to reconcile with the primary store on recovery.
+type pending struct {
+	// count is the sum of increments during failover.
+	count int64
+	// rate of the increments.
+	rate limiter.Rate
+	// reset defines if the key has been reset during failover.
+	reset bool
+	// expiration is the end of the window of the first increment: expired counts are not reconciled.
+	expiration time.Time
+}
+
+// Store is a store degrading to a local store, such as a memory store, while the primary store (ie: Redis) is
+// unavailable: requests are still limited, approximately, instead of failing open or closed.
+//
+// Only errors matching limiter.ErrStoreUnavailable or limiter.ErrStoreTimeout trigger the failover: other errors
+// of the primary store are returned as is. Once RetryInterval has elapsed, the counts of the local store are added
+// to the primary store in the background, then the primary store is used again if it succeeds: the local store is
+// used meanwhile.
+//
+// Limiting is approximate during failover: each instance counts its own requests from zero, so a key can be allowed
+// up to its limit on every instance before recovery, and windows of the local store start with the failover.
+// On recovery, the counts still in their window are added to the primary store, so a key over its limit is denied,
+// but a request denied locally is counted too.
+// Only the fixed window and sliding window store modes are supported.
+type Store struct {
+	// Primary is the store used while available.
+	Primary limiter.Store
+	// Local is the store used while the primary store is unavailable.
+	Local limiter.Store
+	// RetryInterval is the interval between attempts to use the primary store again.
+	RetryInterval time.Duration
+	// MaxKeys is the maximum number of keys counted by the local store during failover.
+	MaxKeys int
+	// ReconcileTimeout is the timeout of an attempt to reconcile the local counts with the primary store.
+	ReconcileTimeout time.Duration
+	// clock used to obtain the current time.
+	clock limiter.Clock
+	// ctx is the context of reconciliations, canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// failed is 1 while the primary store is unavailable, so the healthy path doesn't acquire the mutex.
+	failed int32
+	// reconciles tracks the running reconciliation, if any.
+	reconciles sync.WaitGroup
+	// mutex guards the fields below.
+	mutex sync.Mutex
+	// retryAt is the time when the primary store is tried again.
+	retryAt time.Time
+	// reconciling defines if the pending counts are being reconciled with the primary store.
+	reconciling bool
+	// reconcilingKeys is the number of keys being reconciled, still counted by the local store.
+	reconcilingKeys int
+	// pruneAt is the time when the first pending count out of its window expires, to discard it once MaxKeys keys
+	// are counted.
+	pruneAt time.Time
+	// pending are the counts to reconcile with the primary store, by key.
+	pending map[string]*pending
+}
+
+// NewStore returns an instance of fallback store with defaults, using given primary and local stores.
+func NewStore(primary limiter.Store, local limiter.Store) (limiter.Store, error) {
+	return NewStoreWithOptions(primary, local, Options{
+		RetryInterval:    DefaultRetryInterval,
+		MaxKeys:          DefaultMaxKeys,
+		ReconcileTimeout: DefaultReconcileTimeout,
+	})
+}
+
+// NewStoreWithOptions returns an instance of fallback store with options, using given primary and local stores.
+func NewStoreWithOptions(primary limiter.Store, local limiter.Store, options Options) (limiter.Store, error) {
+	if primary == nil {
+		return nil, pkgerrors.New("fallback primary store is required")
+	}
+	if local == nil {
+		return nil, pkgerrors.New("fallback local store is required")
+	}
+
+	retryInterval := options.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = DefaultRetryInterval
+	}
+
+	maxKeys := options.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+
+	reconcileTimeout := options.ReconcileTimeout
+	if reconcileTimeout <= 0 {
+		reconcileTimeout = DefaultReconcileTimeout
+	}
+
+	clock := options.Clock
+	if clock == nil {
+		clock = limiter.SystemClock{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Store{
+		Primary:          primary,
+		Local:            local,
+		RetryInterval:    retryInterval,
+		MaxKeys:          maxKeys,
+		ReconcileTimeout: reconcileTimeout,
+		clock:            clock,
+		ctx:              ctx,
+		cancel:           cancel,
+		pending:          map[string]*pending{},
+	}, nil
+}
+
+// Get returns the limit for given identifier.
+func (store *Store) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if store.isPrimaryAvailable() {
+		lctx, err := store.Primary.Get(ctx, key, rate)
+		if !isUnavailable(err) {
+			return lctx, err
+		}
+		store.fail()
+	}
+
+	if !store.track(key, 1, rate, false) {
+		return limiter.Context{}, errLocalStoreFull()
+	}
+	return store.Local.Get(ctx, key, rate)
+}
+
+// Increment increments the limit by given count & gives back the new limit for given identifier.
+func (store *Store) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	if store.isPrimaryAvailable() {
+		lctx, err := store.Primary.Increment(ctx, key, count, rate)
+		if !isUnavailable(err) {
+			return lctx, err
+		}
+		store.fail()
+	}
+
+	if !store.track(key, count, rate, false) {
+		return limiter.Context{}, errLocalStoreFull()
+	}
+	return store.Local.Increment(ctx, key, count, rate)
+}
+
+// Peek returns the limit for given identifier, without modification on current values.
+func (store *Store) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if store.isPrimaryAvailable() {
+		lctx, err := store.Primary.Peek(ctx, key, rate)
+		if !isUnavailable(err) {
+			return lctx, err
+		}
+		store.fail()
+	}
+
+	return store.Local.Peek(ctx, key, rate)
+}
+
+// Reset returns the limit for given identifier which is set to zero.
+// During failover, the primary store is reset on recovery.
+func (store *Store) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if store.isPrimaryAvailable() {
+		lctx, err := store.Primary.Reset(ctx, key, rate)
+		if !isUnavailable(err) {
+			return lctx, err
+		}
+		store.fail()
+	}
+
+	store.track(key, 0, rate, true)
+	return store.Local.Reset(ctx, key, rate)
+}
+
+// Wait waits for the running reconciliation of the local counts with the primary store, if any, such as once
+// requests are drained.
+func (store *Store) Wait() {
+	store.reconciles.Wait()
+}
+
+// Close cancels the running reconciliation with the primary store, if any, and waits for it. Once closed, the
+// primary store isn't tried again after a failover. It doesn't close the primary and local stores.
+func (store *Store) Close() error {
+	store.mutex.Lock()
+	store.cancel()
+	store.mutex.Unlock()
+
+	store.reconciles.Wait()
+	return nil
+}
+
+// isPrimaryAvailable returns if the primary store should be used. Once the retry interval has elapsed after a
+// failure, the pending counts are reconciled with the primary store in the background, so requests don't wait for
+// it: the local store is used meanwhile, and the primary store is available again if they all succeed.
+func (store *Store) isPrimaryAvailable() bool {
+	if atomic.LoadInt32(&store.failed) == 0 {
+		return true
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if atomic.LoadInt32(&store.failed) == 0 {
+		return true
+	}
+
+	now := store.clock.Now()
+	if store.reconciling || now.Before(store.retryAt) || store.ctx.Err() != nil {
+		return false
+	}
+
+	store.reconciling = true
+	store.reconciles.Add(1)
+	go store.recover(now)
+
+	return false
+}
+
+// recover reconciles the pending counts with the primary store, then marks it as available if they all succeed.
+// Counts tracked meanwhile are reconciled once the primary store is available again, since requests are already
+// counted by the primary store from then on. On failure, the remaining counts are kept for the next attempt.
+func (store *Store) recover(now time.Time) {
+	defer store.reconciles.Done()
+
+	ctx, cancel := context.WithTimeout(store.ctx, store.ReconcileTimeout)
+	err := store.reconcile(ctx, store.takePending(now), now)
+	cancel()
+
+	store.mutex.Lock()
+	store.reconciling = false
+	store.reconcilingKeys = 0
+	if err != nil {
+		store.retryAt = store.clock.Now().Add(store.RetryInterval)
+		store.mutex.Unlock()
+		return
+	}
+	atomic.StoreInt32(&store.failed, 0)
+	store.mutex.Unlock()
+
+	ctx, cancel = context.WithTimeout(store.ctx, store.ReconcileTimeout)
+	defer cancel()
+
+	now = store.clock.Now()
+	_ = store.reconcile(ctx, store.takePending(now), now)
+
+	store.mutex.Lock()
+	store.reconcilingKeys = 0
+	store.mutex.Unlock()
+}
+
+// takePending returns the pending counts, replaced by an empty set for the counts tracked from now on.
+// The keys to reconcile are still counted by the local store until then, against MaxKeys.
+func (store *Store) takePending(now time.Time) map[string]*pending {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	batch := store.pending
+	store.pending = map[string]*pending{}
+	store.pruneAt = time.Time{}
+
+	for _, state := range batch {
+		if state.reset || now.Before(state.expiration) {
+			store.reconcilingKeys++
+		}
+	}
+
+	return batch
+}
+
+// reconcile adds given pending counts still in their window to the primary store, and resets them on the local
+// store. It stops on the first error, merging the remaining counts back with the pending ones for the next attempt.
+// The mutex must not be held, since store operations may wait for the timeout of the primary store.
+func (store *Store) reconcile(ctx context.Context, batch map[string]*pending, now time.Time) error {
+	for key, state := range batch {
+		if state.reset {
+			_, err := store.Primary.Reset(ctx, key, state.rate)
+			if err != nil {
+				store.restore(batch)
+				return err
+			}
+			state.reset = false
+		}
+
+		if state.count > 0 && now.Before(state.expiration) {
+			_, err := store.Primary.Increment(ctx, key, state.count, state.rate)
+			if err != nil {
+				store.restore(batch)
+				return err
+			}
+		}
+
+		delete(batch, key)
+		_, _ = store.Local.Reset(ctx, key, state.rate)
+	}
+
+	return nil
+}
+
+// restore merges given counts, tracked before the pending ones, back with them.
+func (store *Store) restore(batch map[string]*pending) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for key, state := range batch {
+		if state.count > 0 {
+			store.schedulePrune(state.expiration)
+		}
+
+		current, ok := store.pending[key]
+		if !ok {
+			store.pending[key] = state
+			continue
+		}
+
+		// A later reset discards the counts tracked before it.
+		if !current.reset {
+			current.reset = state.reset
+			current.count += state.count
+			current.expiration = state.expiration
+		}
+	}
+}
+
+// fail marks the primary store as unavailable until the retry interval has elapsed.
+func (store *Store) fail() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if atomic.LoadInt32(&store.failed) == 0 {
+		store.retryAt = store.clock.Now().Add(store.RetryInterval)
+		atomic.StoreInt32(&store.failed, 1)
+	}
+}
+
+// track records given count on the local store for given identifier, to reconcile it on recovery, and returns if
+// the local store can count it: a new key isn't once MaxKeys keys are counted.
+// A reset discards the counts recorded before it. Resets are recorded even beyond MaxKeys, so the primary store
+// never keeps a counter which has been reset.
+func (store *Store) track(key string, count int64, rate limiter.Rate, reset bool) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := store.clock.Now()
+
+	state, ok := store.pending[key]
+	if !ok {
+		if !reset && !store.hasRoom(now) {
+			return false
+		}
+		state = &pending{}
+		store.pending[key] = state
+	}
+
+	state.rate = rate
+	if reset {
+		state.count = 0
+		state.reset = true
+		return true
+	}
+
+	if state.count == 0 {
+		state.expiration = now.Add(rate.Period)
+		store.schedulePrune(state.expiration)
+	}
+	state.count += count
+	return true
+}
+
+// hasRoom returns if the local store can count a new key, discarding the pending counts out of their window once
+// MaxKeys keys are counted: they're not reconciled anyway, and their local counters have expired.
+// The mutex must be held.
+func (store *Store) hasRoom(now time.Time) bool {
+	if len(store.pending)+store.reconcilingKeys < store.MaxKeys {
+		return true
+	}
+	if store.pruneAt.IsZero() || now.Before(store.pruneAt) {
+		return false
+	}
+
+	store.pruneAt = time.Time{}
+	for key, state := range store.pending {
+		switch {
+		case state.reset:
+			// Resets are kept until reconciled.
+		case !now.Before(state.expiration):
+			delete(store.pending, key)
+		default:
+			store.schedulePrune(state.expiration)
+		}
+	}
+
+	return len(store.pending)+store.reconcilingKeys < store.MaxKeys
+}
+
+// schedulePrune records given expiration of a pending count for hasRoom, if it's the first one.
+// The mutex must be held.
+func (store *Store) schedulePrune(expiration time.Time) {
+	if store.pruneAt.IsZero() || expiration.Before(store.pruneAt) {
+		store.pruneAt = expiration
+	}
+}
+
+// errLocalStoreFull returns the error of a request not counted by the local store, since it counts MaxKeys keys.
+func errLocalStoreFull() error {
+	return &limiter.StoreError{
+		Kind: limiter.ErrStoreUnavailable,
+		Err:  ErrLocalStoreFull,
+	}
+}
+
+// isUnavailable returns if given error reports an unavailable primary store, triggering the failover.
+func isUnavailable(err error) bool {
+	return errors.Is(err, limiter.ErrStoreUnavailable) || errors.Is(err, limiter.ErrStoreTimeout)
+}
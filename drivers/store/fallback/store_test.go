@@ -0,0 +1,485 @@
+package fallback_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/fallback"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+	"github.com/ulule/limiter/v3/drivers/store/tests"
+	"github.com/ulule/limiter/v3/limitertest"
+)
+
+// flakyStore is a store failing with err, if not nil, like an unreachable Redis.
+// Its increments wait for wait to be closed, if not nil, or for their context to be done, like a slow Redis.
+type flakyStore struct {
+	limiter.Store
+	err  error
+	wait chan struct{}
+}
+
+func (store *flakyStore) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if store.err != nil {
+		return limiter.Context{}, store.err
+	}
+	return store.Store.Get(ctx, key, rate)
+}
+
+func (store *flakyStore) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if store.err != nil {
+		return limiter.Context{}, store.err
+	}
+	return store.Store.Peek(ctx, key, rate)
+}
+
+func (store *flakyStore) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if store.err != nil {
+		return limiter.Context{}, store.err
+	}
+	return store.Store.Reset(ctx, key, rate)
+}
+
+func (store *flakyStore) Increment(ctx context.Context, key string, count int64,
+	rate limiter.Rate) (limiter.Context, error) {
+
+	if store.wait != nil {
+		select {
+		case <-store.wait:
+		case <-ctx.Done():
+			return limiter.Context{}, ctx.Err()
+		}
+	}
+	if store.err != nil {
+		return limiter.Context{}, store.err
+	}
+	return store.Store.Increment(ctx, key, count, rate)
+}
+
+var errUnavailable = &limiter.StoreError{
+	Kind: limiter.ErrStoreUnavailable,
+	Err:  &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+}
+
+func newStore(t *testing.T, clock limiter.Clock) (limiter.Store, *flakyStore, limiter.Store) {
+	is := require.New(t)
+
+	primary := &flakyStore{Store: memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix: "limiter:primary",
+		Clock:  clock,
+	})}
+	local := memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix: "limiter:local",
+		Clock:  clock,
+	})
+
+	store, err := fallback.NewStoreWithOptions(primary, local, fallback.Options{
+		RetryInterval: 5 * time.Second,
+		Clock:         clock,
+	})
+	is.NoError(err)
+
+	return store, primary, local
+}
+
+func TestFallbackStoreClient(t *testing.T) {
+	is := require.New(t)
+
+	_, err := fallback.NewStore(nil, memory.NewStore())
+	is.Error(err)
+
+	_, err = fallback.NewStore(memory.NewStore(), nil)
+	is.Error(err)
+
+	store, err := fallback.NewStore(memory.NewStore(), memory.NewStore())
+	is.NoError(err)
+	is.Equal(fallback.DefaultRetryInterval, store.(*fallback.Store).RetryInterval)
+	is.Equal(fallback.DefaultMaxKeys, store.(*fallback.Store).MaxKeys)
+}
+
+func TestFallbackStoreSequentialAccess(t *testing.T) {
+	store, _, _ := newStore(t, limiter.SystemClock{})
+
+	tests.TestStoreSequentialAccess(t, store)
+}
+
+func TestFallbackStoreFailover(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Now())
+	store, primary, local := newStore(t, clock)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	// Scenario #1 : Primary store is healthy.
+	lctx, err := store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	// Scenario #2 : Primary store is down, requests are limited by the local store.
+	primary.err = errUnavailable
+
+	lctx, err = store.Increment(ctx, "foo", 3, rate)
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Remaining)
+
+	lctx, err = store.Get(ctx, "bar", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	lctx, err = store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Remaining)
+
+	for i := 1; i <= 8; i++ {
+		lctx, err = store.Get(ctx, "foo", rate)
+		is.NoError(err)
+	}
+	is.True(lctx.Reached)
+
+	// Scenario #3 : Primary store is back, but the retry interval hasn't elapsed.
+	primary.err = nil
+
+	lctx, err = store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(0), lctx.Remaining)
+
+	lctx, err = primary.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	// Scenario #4 : Primary store has recovered, local counts are reconciled.
+	clock.Advance(5 * time.Second)
+
+	lctx, err = store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(0), lctx.Remaining)
+
+	store.(*fallback.Store).Wait()
+
+	lctx, err = store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(0), lctx.Remaining)
+
+	lctx, err = store.Peek(ctx, "bar", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	lctx, err = local.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.True(lctx.Reached)
+}
+
+func TestFallbackStoreRecoveryFailure(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Now())
+	store, primary, _ := newStore(t, clock)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	primary.err = errUnavailable
+
+	lctx, err := store.Increment(ctx, "foo", 4, rate)
+	is.NoError(err)
+	is.Equal(int64(6), lctx.Remaining)
+
+	// Scenario #1 : Primary store is still down once the retry interval has elapsed.
+	clock.Advance(5 * time.Second)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(5), lctx.Remaining)
+
+	store.(*fallback.Store).Wait()
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(4), lctx.Remaining)
+
+	// Scenario #2 : Primary store has recovered, after the window of the local counts.
+	primary.err = nil
+	clock.Advance(1 * time.Minute)
+
+	_, err = store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+
+	store.(*fallback.Store).Wait()
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	// Scenario #3 : Other errors of the primary store don't trigger the failover.
+	primary.err = errors.New("invalid argument")
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.Equal(primary.err, err)
+}
+
+func TestFallbackStoreReset(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Now())
+	store, primary, _ := newStore(t, clock)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	_, err := store.Increment(ctx, "foo", 5, rate)
+	is.NoError(err)
+
+	// A reset during failover is applied to the primary store on recovery.
+	primary.err = errUnavailable
+
+	_, err = store.Increment(ctx, "foo", 2, rate)
+	is.NoError(err)
+
+	lctx, err := store.Reset(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+
+	primary.err = nil
+	clock.Advance(5 * time.Second)
+
+	_, err = store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+
+	store.(*fallback.Store).Wait()
+
+	lctx, err = primary.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+}
+
+func TestFallbackStoreResetMaxKeys(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Now())
+	primary := &flakyStore{Store: memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix: "limiter:primary",
+		Clock:  clock,
+	})}
+	store, err := fallback.NewStoreWithOptions(primary, memory.NewStore(), fallback.Options{
+		RetryInterval: 5 * time.Second,
+		MaxKeys:       1,
+		Clock:         clock,
+	})
+	is.NoError(err)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	_, err = store.Increment(ctx, "bar", 5, rate)
+	is.NoError(err)
+
+	// A reset is applied to the primary store on recovery, even once MaxKeys keys are counted.
+	primary.err = errUnavailable
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+
+	_, err = store.Reset(ctx, "bar", rate)
+	is.NoError(err)
+
+	primary.err = nil
+	clock.Advance(5 * time.Second)
+
+	_, err = store.Peek(ctx, "bar", rate)
+	is.NoError(err)
+
+	store.(*fallback.Store).Wait()
+
+	lctx, err := primary.Peek(ctx, "bar", rate)
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+
+	lctx, err = primary.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+}
+
+func TestFallbackStoreMaxKeys(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Now())
+	primary := &flakyStore{Store: memory.NewStore(), err: errUnavailable}
+	local := memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix: "limiter:local",
+		Clock:  clock,
+	})
+	store, err := fallback.NewStoreWithOptions(primary, local, fallback.Options{
+		RetryInterval: 1 * time.Hour,
+		MaxKeys:       2,
+		Clock:         clock,
+	})
+	is.NoError(err)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	// Scenario #1 : the local store counts up to MaxKeys keys.
+	_, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+
+	_, err = store.Increment(ctx, "bar", 2, rate)
+	is.NoError(err)
+
+	lctx, err := store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(8), lctx.Remaining)
+
+	// Scenario #2 : other keys fail like an unavailable store.
+	_, err = store.Get(ctx, "baz", rate)
+	is.True(errors.Is(err, fallback.ErrLocalStoreFull))
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	_, err = store.Increment(ctx, "baz", 1, rate)
+	is.True(errors.Is(err, fallback.ErrLocalStoreFull))
+
+	// Scenario #3 : keys are counted again once the counts of the local store expire.
+	clock.Advance(1*time.Minute + time.Second)
+
+	lctx, err = store.Get(ctx, "baz", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	_, err = store.Get(ctx, "bar", rate)
+	is.True(errors.Is(err, fallback.ErrLocalStoreFull))
+}
+
+func TestFallbackStoreReconcileInBackground(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Now())
+	store, primary, _ := newStore(t, clock)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	primary.err = errUnavailable
+
+	_, err := store.Increment(ctx, "foo", 4, rate)
+	is.NoError(err)
+
+	// Requests are limited by the local store, without waiting for a slow reconciliation.
+	primary.err = nil
+	primary.wait = make(chan struct{})
+	clock.Advance(5 * time.Second)
+
+	lctx, err := store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(5), lctx.Remaining)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(4), lctx.Remaining)
+
+	// Counts tracked during the reconciliation are reconciled once the primary store is used again.
+	close(primary.wait)
+	store.(*fallback.Store).Wait()
+
+	lctx, err = primary.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(4), lctx.Remaining)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(3), lctx.Remaining)
+}
+
+func TestFallbackStoreReconcileTimeout(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Now())
+	primary := &flakyStore{Store: memory.NewStore(), err: errUnavailable}
+	store, err := fallback.NewStoreWithOptions(primary, memory.NewStore(), fallback.Options{
+		RetryInterval:    5 * time.Second,
+		ReconcileTimeout: 10 * time.Millisecond,
+		Clock:            clock,
+	})
+	is.NoError(err)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	_, err = store.Increment(ctx, "foo", 4, rate)
+	is.NoError(err)
+
+	// Scenario #1 : a reconciliation with a hanging primary store fails once its timeout has elapsed.
+	primary.err = nil
+	primary.wait = make(chan struct{})
+	clock.Advance(5 * time.Second)
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+
+	store.(*fallback.Store).Wait()
+
+	lctx, err := store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(4), lctx.Remaining)
+
+	// Scenario #2 : Close cancels the running reconciliation.
+	store.(*fallback.Store).ReconcileTimeout = 1 * time.Hour
+	clock.Advance(5 * time.Second)
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+
+	is.NoError(store.(io.Closer).Close())
+
+	// Scenario #3 : the primary store isn't tried again once closed.
+	close(primary.wait)
+	clock.Advance(5 * time.Second)
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+
+	store.(*fallback.Store).Wait()
+
+	lctx, err = primary.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+}
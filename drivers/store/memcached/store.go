@@ -0,0 +1,216 @@
+package memcached
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/pkg/errors"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/common"
+)
+
+// maxRelativeExpiration is the longest expiration memcached accepts as a number of seconds from now.
+// Longer expirations must be given as an unix timestamp.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+// Client is an interface thats allows to use a memcached client, such as memcache.Client.
+type Client interface {
+	Get(key string) (*memcache.Item, error)
+	Add(item *memcache.Item) error
+	Increment(key string, delta uint64) (uint64, error)
+	Decrement(key string, delta uint64) (uint64, error)
+	Delete(key string) error
+}
+
+// Store is the memcached store.
+// Since memcached doesn't expose the expiration of a key, windows are aligned on a multiple of the rate period,
+// rather than starting on the first request of a key: counters of every key are reset at the same time.
+// Keys must comply with memcached restrictions (at most 250 bytes, without whitespace nor control characters),
+// including the prefix and the window suffix.
+type Store struct {
+	// Prefix used for the key.
+	Prefix string
+	// MaxRetry is the maximum number of retry when a counter is created concurrently.
+	MaxRetry int
+	// client used to communicate with memcached server.
+	client Client
+	// clock used to obtain the current time.
+	clock limiter.Clock
+}
+
+// NewStore returns an instance of memcached store with defaults.
+func NewStore(client Client) (limiter.Store, error) {
+	return NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix:   limiter.DefaultPrefix,
+		MaxRetry: limiter.DefaultMaxRetry,
+	})
+}
+
+// NewStoreWithOptions returns an instance of memcached store with options.
+func NewStoreWithOptions(client Client, options limiter.StoreOptions) (limiter.Store, error) {
+	if client == nil {
+		return nil, errors.New("memcached client is required")
+	}
+
+	maxRetry := options.MaxRetry
+	if maxRetry <= 0 {
+		maxRetry = limiter.DefaultMaxRetry
+	}
+
+	clock := options.Clock
+	if clock == nil {
+		clock = limiter.SystemClock{}
+	}
+
+	store := &Store{
+		Prefix:   options.Prefix,
+		MaxRetry: maxRetry,
+		client:   client,
+		clock:    clock,
+	}
+
+	return store, nil
+}
+
+// Get returns the limit for given identifier.
+func (store *Store) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return store.Increment(ctx, key, 1, rate)
+}
+
+// Increment increments the limit by given count & gives back the new limit for given identifier.
+// The counter is seeded with Add on the first request of a window, and incremented again if another client
+// has seeded it meanwhile.
+func (store *Store) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
+	now := store.clock.Now()
+	key, expiration := store.getKey(key, rate, now)
+
+	value, err := store.increment(key, count, now, expiration)
+	if err != nil {
+		return limiter.Context{}, err
+	}
+
+	return common.GetContextFromState(now, rate, expiration, value), nil
+}
+
+// Peek returns the limit for given identifier, without modification on current values.
+func (store *Store) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
+	now := store.clock.Now()
+	key, expiration := store.getKey(key, rate, now)
+
+	item, err := store.client.Get(key)
+	if err == memcache.ErrCacheMiss {
+		return common.GetContextFromState(now, rate, expiration, 0), nil
+	}
+	if err != nil {
+		return limiter.Context{}, wrapError(err, "failed to get key "+key)
+	}
+
+	value, err := strconv.ParseInt(string(item.Value), 10, 64)
+	if err != nil {
+		return limiter.Context{}, errors.Wrapf(err, "failed to parse value of key %s", key)
+	}
+
+	return common.GetContextFromState(now, rate, expiration, value), nil
+}
+
+// Reset returns the limit for given identifier which is set to zero.
+func (store *Store) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
+	now := store.clock.Now()
+	key, expiration := store.getKey(key, rate, now)
+
+	err := store.client.Delete(key)
+	if err != nil && err != memcache.ErrCacheMiss {
+		return limiter.Context{}, wrapError(err, "failed to delete key "+key)
+	}
+
+	return common.GetContextFromState(now, rate, expiration, 0), nil
+}
+
+// increment increments the counter of given key by given count, seeding it with Add if it doesn't exist.
+func (store *Store) increment(key string, count int64, now time.Time, expiration time.Time) (int64, error) {
+	if count < 0 {
+		value, err := store.client.Decrement(key, uint64(-count))
+		if err == memcache.ErrCacheMiss {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, wrapError(err, "failed to decrement key "+key)
+		}
+		return int64(value), nil
+	}
+
+	for i := 0; i <= store.MaxRetry; i++ {
+		value, err := store.client.Increment(key, uint64(count))
+		if err == nil {
+			return int64(value), nil
+		}
+		if err != memcache.ErrCacheMiss {
+			return 0, wrapError(err, "failed to increment key "+key)
+		}
+
+		err = store.client.Add(&memcache.Item{
+			Key:        key,
+			Value:      []byte(strconv.FormatInt(count, 10)),
+			Expiration: getExpiration(now, expiration),
+		})
+		if err == nil {
+			return count, nil
+		}
+		if err != memcache.ErrNotStored {
+			return 0, wrapError(err, "failed to add key "+key)
+		}
+
+		// Another client has seeded the counter meanwhile: increment it again.
+	}
+
+	return 0, errors.Errorf("failed to increment key %s after %d retries", key, store.MaxRetry)
+}
+
+// getKey returns the memcached key of the window of given identifier at given time, and the end of this window.
+func (store *Store) getKey(key string, rate limiter.Rate, now time.Time) (string, time.Time) {
+	index := now.UnixNano() / int64(rate.Period)
+	expiration := time.Unix(0, (index+1)*int64(rate.Period))
+
+	return store.Prefix + ":" + key + ":" + strconv.FormatInt(index, 10), expiration
+}
+
+// getExpiration returns the memcached expiration of an item expiring at given time.
+// It's a number of seconds from now, rounded up, or an unix timestamp if it's too far away.
+func getExpiration(now time.Time, expiration time.Time) int32 {
+	ttl := expiration.Sub(now)
+	if ttl > maxRelativeExpiration {
+		return int32(expiration.Unix() + 1)
+	}
+
+	seconds := int32((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// wrapError wraps given error of a memcached command with given message.
+// Network failures, connection timeouts and unavailable servers are classified as store errors
+// (see limiter.StoreError).
+func wrapError(err error, message string) error {
+	var timeoutErr *memcache.ConnectTimeoutError
+	if errors.As(err, &timeoutErr) {
+		err = &limiter.StoreError{Kind: limiter.ErrStoreTimeout, Err: err}
+	}
+	return common.WrapError(err, message, memcache.ErrNoServers)
+}
@@ -0,0 +1,363 @@
+package memcached_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/memcached"
+	"github.com/ulule/limiter/v3/drivers/store/tests"
+)
+
+// fakeClient is an in-memory memcached client, honoring the semantics of Add and Increment.
+type fakeClient struct {
+	mutex sync.Mutex
+	items map[string]uint64
+	// onAdd is called before adding an item, such as to simulate a concurrent client.
+	onAdd func(item *memcache.Item)
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{items: map[string]uint64{}}
+}
+
+func (client *fakeClient) Get(key string) (*memcache.Item, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	value, ok := client.items[key]
+	if !ok {
+		return nil, memcache.ErrCacheMiss
+	}
+	return &memcache.Item{Key: key, Value: []byte(strconv.FormatUint(value, 10))}, nil
+}
+
+func (client *fakeClient) Add(item *memcache.Item) error {
+	if client.onAdd != nil {
+		client.onAdd(item)
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if _, ok := client.items[item.Key]; ok {
+		return memcache.ErrNotStored
+	}
+
+	value, err := strconv.ParseUint(string(item.Value), 10, 64)
+	if err != nil {
+		return err
+	}
+	client.items[item.Key] = value
+	return nil
+}
+
+func (client *fakeClient) Increment(key string, delta uint64) (uint64, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	value, ok := client.items[key]
+	if !ok {
+		return 0, memcache.ErrCacheMiss
+	}
+	client.items[key] = value + delta
+	return value + delta, nil
+}
+
+func (client *fakeClient) Decrement(key string, delta uint64) (uint64, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	value, ok := client.items[key]
+	if !ok {
+		return 0, memcache.ErrCacheMiss
+	}
+	if delta > value {
+		delta = value
+	}
+	client.items[key] = value - delta
+	return value - delta, nil
+}
+
+func (client *fakeClient) Delete(key string) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if _, ok := client.items[key]; !ok {
+		return memcache.ErrCacheMiss
+	}
+	delete(client.items, key)
+	return nil
+}
+
+func TestMemcachedStoreClient(t *testing.T) {
+	is := require.New(t)
+
+	var client memcached.Client = memcache.New("127.0.0.1:11211")
+	is.NotNil(client)
+
+	_, err := memcached.NewStore(nil)
+	is.Error(err)
+}
+
+func TestMemcachedStoreSequentialAccess(t *testing.T) {
+	is := require.New(t)
+
+	store, err := memcached.NewStoreWithOptions(newFakeClient(), limiter.StoreOptions{
+		Prefix:   "limiter:memcached:sequential-test",
+		MaxRetry: 3,
+	})
+	is.NoError(err)
+
+	tests.TestStoreSequentialAccess(t, store)
+}
+
+func TestMemcachedStoreConcurrentAccess(t *testing.T) {
+	is := require.New(t)
+
+	store, err := memcached.NewStoreWithOptions(newFakeClient(), limiter.StoreOptions{
+		Prefix:   "limiter:memcached:concurrent-test",
+		MaxRetry: 3,
+	})
+	is.NoError(err)
+
+	tests.TestStoreConcurrentAccess(t, store)
+}
+
+func TestMemcachedStoreContextCancellation(t *testing.T) {
+	is := require.New(t)
+
+	store, err := memcached.NewStore(newFakeClient())
+	is.NoError(err)
+
+	tests.TestStoreContextCancellation(t, store)
+}
+
+func TestMemcachedStoreSeeding(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	client := newFakeClient()
+	store, err := memcached.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix:   "limiter:memcached:seeding-test",
+		MaxRetry: 3,
+	})
+	is.NoError(err)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	// First hit seeds the counter with its expiration.
+	client.onAdd = func(item *memcache.Item) {
+		is.True(item.Expiration >= 1 && item.Expiration <= 60)
+	}
+
+	lctx, err := store.Increment(ctx, "foo", 3, rate)
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Remaining)
+	is.True(lctx.Reset-time.Now().Unix() <= 60)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(6), lctx.Remaining)
+
+	// Another client seeds the counter between the missed increment and the add.
+	client.onAdd = func(item *memcache.Item) {
+		client.onAdd = nil
+		is.NoError(client.Add(&memcache.Item{Key: item.Key, Value: []byte("5")}))
+	}
+
+	lctx, err = store.Get(ctx, "bar", rate)
+	is.NoError(err)
+	is.Equal(int64(4), lctx.Remaining)
+
+	// A client always losing the race eventually gives up.
+	failing := &failingIncrementClient{fakeClient: newFakeClient()}
+	store, err = memcached.NewStoreWithOptions(failing, limiter.StoreOptions{
+		Prefix:   "limiter:memcached:seeding-test",
+		MaxRetry: 2,
+	})
+	is.NoError(err)
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.Error(err)
+	is.Equal(3, failing.increments)
+}
+
+func TestMemcachedStoreConcurrentSeeding(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	store, err := memcached.NewStoreWithOptions(newFakeClient(), limiter.StoreOptions{
+		Prefix:   "limiter:memcached:concurrent-seeding-test",
+		MaxRetry: 3,
+	})
+	is.NoError(err)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(1000),
+	}
+
+	goroutines := 100
+	wg := &sync.WaitGroup{}
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := store.Get(ctx, "foo", rate)
+			is.NoError(err)
+		}()
+	}
+	wg.Wait()
+
+	lctx, err := store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(1000-goroutines), lctx.Remaining)
+}
+
+// failingIncrementClient is a client whose counters are always missing, as if they were evicted right away.
+type failingIncrementClient struct {
+	*fakeClient
+	increments int
+}
+
+func (client *failingIncrementClient) Increment(key string, delta uint64) (uint64, error) {
+	client.increments++
+	return 0, memcache.ErrCacheMiss
+}
+
+func (client *failingIncrementClient) Add(item *memcache.Item) error {
+	return memcache.ErrNotStored
+}
+
+func TestMemcachedStoreErrors(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	newStore := func(client memcached.Client) limiter.Store {
+		store, err := memcached.NewStoreWithOptions(client, limiter.StoreOptions{
+			Prefix: "limiter:memcached:errors-test",
+		})
+		is.NoError(err)
+		return store
+	}
+
+	// Scenario #1 : Server refuses connections.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(err)
+	is.NoError(listener.Close())
+
+	store := newStore(memcache.New(listener.Addr().String()))
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	_, err = store.Peek(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	_, err = store.Reset(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	// Scenario #2 : No servers are available.
+	store = newStore(memcache.New())
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+	is.True(errors.Is(err, memcache.ErrNoServers))
+
+	// Scenario #3 : Connection times out.
+	store = newStore(&erroringClient{
+		fakeClient: newFakeClient(),
+		err:        &memcache.ConnectTimeoutError{Addr: listener.Addr()},
+	})
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreTimeout))
+
+	var timeoutErr *memcache.ConnectTimeoutError
+	is.True(errors.As(err, &timeoutErr))
+
+	// Scenario #4 : Other errors aren't classified.
+	store = newStore(&erroringClient{
+		fakeClient: newFakeClient(),
+		err:        memcache.ErrServerError,
+	})
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.Error(err)
+	is.False(errors.Is(err, limiter.ErrStoreUnavailable))
+	is.False(errors.Is(err, limiter.ErrStoreTimeout))
+}
+
+// erroringClient is a client whose increments always fail with given error.
+type erroringClient struct {
+	*fakeClient
+	err error
+}
+
+func (client *erroringClient) Increment(key string, delta uint64) (uint64, error) {
+	return 0, client.err
+}
+
+func TestMemcachedStoreDefaultKeys(t *testing.T) {
+	is := require.New(t)
+
+	// The client validates keys before connecting to the server: only valid keys fail to connect.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(err)
+	is.NoError(listener.Close())
+
+	store, err := memcached.NewStoreWithOptions(memcache.New(listener.Addr().String()), limiter.StoreOptions{
+		Prefix: "limiter:memcached:keys-test",
+	})
+	is.NoError(err)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	request := &http.Request{
+		Method:     "POST",
+		URL:        &url.URL{Path: "/users/foo bar"},
+		Header:     http.Header{},
+		RemoteAddr: "foo bar",
+	}
+
+	// Scenario #1 : a key with whitespace is rejected by the client.
+	_, err = store.Get(context.Background(), "foo bar", rate)
+	is.True(errors.Is(err, memcache.ErrMalformedKey))
+
+	// Scenario #2 : default keys with a method and a path are valid, even with whitespace in their components.
+	instances := []*limiter.Limiter{
+		limiter.New(store, rate, limiter.WithKeyIncludeMethod(true), limiter.WithKeyIncludePath(true)),
+		limiter.New(store, rate, limiter.WithKeyIncludeMethod(true),
+			limiter.WithPathTemplateFunc(func(r *http.Request) string {
+				return "/users/{user id}"
+			})),
+	}
+
+	for i, instance := range instances {
+		_, err = instance.GetWithRequest(request, instance.GetKey(request))
+		is.False(errors.Is(err, memcache.ErrMalformedKey), "scenario #%d", i+1)
+		is.True(errors.Is(err, limiter.ErrStoreUnavailable), "scenario #%d", i+1)
+	}
+}
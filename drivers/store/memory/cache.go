@@ -2,8 +2,12 @@ package memory
 
 import (
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/ulule/limiter/v3"
 )
 
 // Forked from https://github.com/patrickmn/go-cache
@@ -18,9 +22,10 @@ type CacheWrapper struct {
 type cleaner struct {
 	interval time.Duration
 	stop     chan bool
+	once     sync.Once
 }
 
-// Run will periodically delete expired keys from given cache until GC notify that it should stop.
+// Run will periodically delete expired keys from given cache until it is stopped, either by Close or by GC.
 func (cleaner *cleaner) Run(cache *Cache) {
 	ticker := time.NewTicker(cleaner.interval)
 	for {
@@ -34,10 +39,16 @@ func (cleaner *cleaner) Run(cache *Cache) {
 	}
 }
 
+// Stop stops the cleaner goroutine. It's safe to call it several times.
+func (cleaner *cleaner) Stop() {
+	cleaner.once.Do(func() {
+		close(cleaner.stop)
+	})
+}
+
 // stopCleaner is a callback from GC used to stop cleaner goroutine.
 func stopCleaner(wrapper *CacheWrapper) {
-	wrapper.cleaner.stop <- true
-	wrapper.cleaner = nil
+	wrapper.cleaner.Stop()
 }
 
 // startCleaner will start a cleaner goroutine for given cache.
@@ -56,6 +67,7 @@ type Counter struct {
 	mutex      sync.RWMutex
 	value      int64
 	expiration int64
+	label      string
 }
 
 // Value returns the counter current value.
@@ -72,24 +84,58 @@ func (counter *Counter) Expiration() int64 {
 	return counter.expiration
 }
 
+// Label returns the label attached to the counter, if any.
+func (counter *Counter) Label() string {
+	counter.mutex.RLock()
+	defer counter.mutex.RUnlock()
+	return counter.label
+}
+
 // Expired returns true if the counter has expired.
 func (counter *Counter) Expired() bool {
+	return counter.expired(time.Now().UnixNano())
+}
+
+// expired returns true if the counter has expired at given time.
+func (counter *Counter) expired(now int64) bool {
 	counter.mutex.RLock()
 	defer counter.mutex.RUnlock()
 
-	return counter.expiration == 0 || time.Now().UnixNano() > counter.expiration
+	return counter.expiration == 0 || now > counter.expiration
 }
 
 // Load returns the value and the expiration of this counter.
 // If the counter is expired, it will use the given expiration.
 func (counter *Counter) Load(expiration int64) (int64, int64) {
+	return counter.load(time.Now().UnixNano(), expiration)
+}
+
+// load returns the value and the expiration of this counter at given time.
+func (counter *Counter) load(now int64, expiration int64) (int64, int64) {
 	counter.mutex.RLock()
 	defer counter.mutex.RUnlock()
 
-	if counter.expiration == 0 || time.Now().UnixNano() > counter.expiration {
+	if counter.expiration == 0 || now > counter.expiration {
+		return 0, expiration
+	}
+
+	return counter.value, counter.expiration
+}
+
+// refund decrements given value on this counter at given time, without going below zero.
+// If the counter is expired, it's left untouched and given expiration is returned.
+func (counter *Counter) refund(now int64, value int64, expiration int64) (int64, int64) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	if counter.expiration == 0 || now > counter.expiration {
 		return 0, expiration
 	}
 
+	counter.value -= value
+	if counter.value < 0 {
+		counter.value = 0
+	}
 	return counter.value, counter.expiration
 }
 
@@ -97,29 +143,201 @@ func (counter *Counter) Load(expiration int64) (int64, int64) {
 // If the counter is expired, it will use the given expiration.
 // It returns its current value and expiration.
 func (counter *Counter) Increment(value int64, expiration int64) (int64, int64) {
+	return counter.increment(time.Now().UnixNano(), value, expiration, nil)
+}
+
+// increment increments given value on this counter at given time, and attaches given label if not nil.
+// The label is discarded once the counter expires.
+func (counter *Counter) increment(now int64, value int64, expiration int64, label *string) (int64, int64) {
 	counter.mutex.Lock()
 	defer counter.mutex.Unlock()
 
-	if counter.expiration == 0 || time.Now().UnixNano() > counter.expiration {
+	if counter.expiration == 0 || now > counter.expiration {
 		counter.value = value
 		counter.expiration = expiration
-		return counter.value, counter.expiration
+		counter.label = ""
+	} else {
+		counter.value += value
 	}
 
-	counter.value += value
+	if label != nil {
+		counter.label = *label
+	}
 	return counter.value, counter.expiration
 }
 
-// Cache contains a collection of counters.
+// Bucket is a token bucket, lazily refilled on each access.
+type Bucket struct {
+	mutex    sync.Mutex
+	tokens   float64
+	refilled int64
+	full     int64
+}
+
+// Expired returns true if the bucket is full, and can be deleted.
+func (bucket *Bucket) Expired() bool {
+	return bucket.expired(time.Now().UnixNano())
+}
+
+// expired returns true if the bucket is full at given time.
+func (bucket *Bucket) expired(now int64) bool {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	return now >= bucket.full
+}
+
+// Take refills this bucket according to the elapsed time since its last refill, then takes given count of
+// tokens if available. The bucket is refilled with limit tokens per period, and holds at most burst tokens.
+// It returns the remaining tokens, the time when the bucket will be full (or when given count of tokens will be
+// available if they were not taken) and if the tokens were taken.
+func (bucket *Bucket) Take(count int64, limit int64, period time.Duration, burst int64) (int64, int64, bool) {
+	return bucket.take(time.Now().UnixNano(), count, limit, period, burst)
+}
+
+// take refills this bucket and takes given count of tokens at given time.
+func (bucket *Bucket) take(now int64, count int64, limit int64, period time.Duration,
+	burst int64) (int64, int64, bool) {
+
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	if now > bucket.refilled {
+		bucket.tokens += float64(now-bucket.refilled) * float64(limit) / float64(period)
+		bucket.refilled = now
+	}
+	if bucket.tokens > float64(burst) {
+		bucket.tokens = float64(burst)
+	}
+
+	taken := false
+	if bucket.tokens >= float64(count) {
+		bucket.tokens -= float64(count)
+		taken = true
+	}
+
+	bucket.full = bucket.refilled + int64((float64(burst)-bucket.tokens)*float64(period)/float64(limit))
+	if !taken {
+		available := bucket.refilled + int64((float64(count)-bucket.tokens)*float64(period)/float64(limit))
+		return int64(bucket.tokens), available, false
+	}
+
+	return int64(bucket.tokens), bucket.full, taken
+}
+
+// Log is a sliding log, holding the time of the most recent requests of a key in ascending order.
+type Log struct {
+	mutex      sync.Mutex
+	entries    []int64
+	expiration int64
+}
+
+// expired returns true if every request of the log is older than its period at given time.
+func (log *Log) expired(now int64) bool {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	return now >= log.expiration
+}
+
+// add removes the requests older than given period at given time, then adds given count of requests.
+// Only the limit + 1 most recent requests are kept, since older ones don't change the result.
+// It returns the number of requests in the log, and the time when the next request will be allowed, if there are
+// at least limit requests, or when the oldest request leaves the log otherwise.
+func (log *Log) add(now int64, count int64, limit int64, period time.Duration) (int64, int64) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	start := sort.Search(len(log.entries), func(i int) bool {
+		return log.entries[i] > now-int64(period)
+	})
+	log.entries = log.entries[start:]
+
+	if count > limit+1 {
+		count = limit + 1
+	}
+	if count > 0 {
+		// Entries are inserted in order, in case the clock went backwards.
+		i := sort.Search(len(log.entries), func(j int) bool {
+			return log.entries[j] > now
+		})
+		log.entries = append(log.entries, make([]int64, count)...)
+		copy(log.entries[i+int(count):], log.entries[i:])
+		for j := i; j < i+int(count); j++ {
+			log.entries[j] = now
+		}
+	}
+
+	if excess := int64(len(log.entries)) - (limit + 1); excess > 0 {
+		log.entries = append(log.entries[:0], log.entries[excess:]...)
+	}
+
+	size := int64(len(log.entries))
+	if size == 0 {
+		log.expiration = now
+		return 0, now + int64(period)
+	}
+	log.expiration = log.entries[size-1] + int64(period)
+
+	index := int64(0)
+	if size >= limit && limit > 0 {
+		index = size - limit
+	}
+	return size, log.entries[index] + int64(period)
+}
+
+// Close stops the cleaner goroutine of this cache, if any.
+func (wrapper *CacheWrapper) Close() {
+	if wrapper.cleaner != nil {
+		wrapper.cleaner.Stop()
+	}
+	runtime.SetFinalizer(wrapper, nil)
+}
+
+// shard contains a subset of the counters and buckets of a cache, guarded by its own lock.
+type shard struct {
+	mutex    sync.RWMutex
+	counters map[string]*Counter
+	buckets  map[string]*Bucket
+	logs     map[string]*Log
+}
+
+// Cache contains a collection of counters, spread across shards to reduce lock contention.
 type Cache struct {
-	counters sync.Map
-	cleaner  *cleaner
+	shards  []*shard
+	cleaner *cleaner
+	clock   limiter.Clock
 }
 
-// NewCache returns a new cache.
+// NewCache returns a new cache, using the default number of shards.
 func NewCache(cleanInterval time.Duration) *CacheWrapper {
+	return NewCacheWithShards(cleanInterval, limiter.DefaultShardCount)
+}
+
+// NewCacheWithShards returns a new cache, using given number of shards.
+func NewCacheWithShards(cleanInterval time.Duration, shardCount int) *CacheWrapper {
+	return newCache(cleanInterval, shardCount, nil)
+}
+
+// newCache returns a new cache, using given number of shards and clock.
+// The clock is set before starting the cleaner, which reads it concurrently.
+func newCache(cleanInterval time.Duration, shardCount int, clock limiter.Clock) *CacheWrapper {
+	if shardCount <= 0 {
+		shardCount = limiter.DefaultShardCount
+	}
+
+	cache := &Cache{
+		shards: make([]*shard, shardCount),
+		clock:  clock,
+	}
+	for i := range cache.shards {
+		cache.shards[i] = &shard{
+			counters: map[string]*Counter{},
+			buckets:  map[string]*Bucket{},
+			logs:     map[string]*Log{},
+		}
+	}
 
-	cache := &Cache{}
 	wrapper := &CacheWrapper{Cache: cache}
 
 	if cleanInterval > 0 {
@@ -130,80 +348,248 @@ func NewCache(cleanInterval time.Duration) *CacheWrapper {
 	return wrapper
 }
 
+// now returns the current time of the cache clock, in nanoseconds.
+func (cache *Cache) now() int64 {
+	if cache.clock == nil {
+		return time.Now().UnixNano()
+	}
+	return cache.clock.Now().UnixNano()
+}
+
+// getShard returns the shard of given key, using its FNV-1a hash.
+func (cache *Cache) getShard(key string) *shard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return cache.shards[hash%uint32(len(cache.shards))]
+}
+
 // LoadOrStore returns the existing counter for the key if present.
 // Otherwise, it stores and returns the given counter.
 // The loaded result is true if the counter was loaded, false if stored.
 func (cache *Cache) LoadOrStore(key string, counter *Counter) (*Counter, bool) {
-	val, loaded := cache.counters.LoadOrStore(key, counter)
-	if val == nil {
-		return counter, false
+	shard := cache.getShard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	actual, ok := shard.counters[key]
+	if ok {
+		return actual, true
 	}
 
-	actual := val.(*Counter)
-	return actual, loaded
+	// The key is cloned since it may be backed by a buffer that will be recycled.
+	shard.counters[cloneKey(key)] = counter
+	return counter, false
 }
 
 // Load returns the counter stored in the map for a key, or nil if no counter is present.
 // The ok result indicates whether counter was found in the map.
 func (cache *Cache) Load(key string) (*Counter, bool) {
-	val, ok := cache.counters.Load(key)
-	if val == nil || !ok {
-		return nil, false
-	}
-	actual := val.(*Counter)
-	return actual, true
+	shard := cache.getShard(key)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	actual, ok := shard.counters[key]
+	return actual, ok
 }
 
 // Store sets the counter for a key.
 func (cache *Cache) Store(key string, counter *Counter) {
-	cache.counters.Store(key, counter)
+	shard := cache.getShard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	shard.counters[cloneKey(key)] = counter
 }
 
 // Delete deletes the value for a key.
 func (cache *Cache) Delete(key string) {
-	cache.counters.Delete(key)
+	shard := cache.getShard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	delete(shard.counters, key)
+	delete(shard.buckets, key)
+	delete(shard.logs, key)
+}
+
+// DeletePrefix deletes the values of every key starting with given prefix.
+// Each shard is locked while its keys are iterated.
+func (cache *Cache) DeletePrefix(prefix string) {
+	for _, shard := range cache.shards {
+		shard.mutex.Lock()
+		for key := range shard.counters {
+			if strings.HasPrefix(key, prefix) {
+				delete(shard.counters, key)
+			}
+		}
+		for key := range shard.buckets {
+			if strings.HasPrefix(key, prefix) {
+				delete(shard.buckets, key)
+			}
+		}
+		for key := range shard.logs {
+			if strings.HasPrefix(key, prefix) {
+				delete(shard.logs, key)
+			}
+		}
+		shard.mutex.Unlock()
+	}
 }
 
 // Range calls handler sequentially for each key and value present in the cache.
-// If handler returns false, range stops the iteration.
+// The handler must not modify the cache, since the shard of the given key is locked.
 func (cache *Cache) Range(handler func(key string, counter *Counter)) {
-	cache.counters.Range(func(k interface{}, v interface{}) bool {
-		if v == nil {
-			return true
+	for _, shard := range cache.shards {
+		shard.mutex.RLock()
+		for key, counter := range shard.counters {
+			handler(key, counter)
 		}
+		shard.mutex.RUnlock()
+	}
+}
+
+// TopEntry is a counter of a key, listed by ListTop.
+type TopEntry struct {
+	// Key is the key of the counter.
+	Key string
+	// Count is the value of the counter.
+	Count int64
+	// Label is the label attached to the counter, if any.
+	Label string
+	// Expiration is the time when the counter expires.
+	Expiration time.Time
+}
 
-		key := k.(string)
-		counter := v.(*Counter)
+// ListTop returns the n counters with the highest values, in descending order, among the unexpired keys starting
+// with given prefix. It iterates over every counter of the cache: it's an O(n) operation, intended for
+// administration.
+func (cache *Cache) ListTop(prefix string, n int) []TopEntry {
+	if n <= 0 {
+		return nil
+	}
 
-		handler(key, counter)
+	now := cache.now()
+	entries := []TopEntry{}
+
+	for _, shard := range cache.shards {
+		shard.mutex.RLock()
+		for key, counter := range shard.counters {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+
+			counter.mutex.RLock()
+			if counter.expiration != 0 && now <= counter.expiration {
+				entries = append(entries, TopEntry{
+					Key:        key,
+					Count:      counter.value,
+					Label:      counter.label,
+					Expiration: time.Unix(0, counter.expiration),
+				})
+			}
+			counter.mutex.RUnlock()
+		}
+		shard.mutex.RUnlock()
+	}
 
-		return true
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
 	})
+
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
 }
 
 // Increment increments given value on key.
 // If key is undefined or expired, it will create it.
+// The counter is read and updated under its lock, so concurrent increments are never lost.
 func (cache *Cache) Increment(key string, value int64, duration time.Duration) (int64, time.Time) {
-	expiration := time.Now().Add(duration).UnixNano()
+	return cache.increment(key, value, duration, nil)
+}
+
+// IncrementWithLabel increments given value on key like Increment, and attaches given label to its counter.
+// The label is discarded once the counter expires.
+func (cache *Cache) IncrementWithLabel(key string, value int64, duration time.Duration,
+	label string) (int64, time.Time) {
+
+	return cache.increment(key, value, duration, &label)
+}
+
+// Refund decrements given value on key, without going below zero.
+// If key is undefined or expired, it's left untouched.
+func (cache *Cache) Refund(key string, value int64, duration time.Duration) (int64, time.Time) {
+	now := cache.now()
+	expiration := now + int64(duration)
+	shard := cache.getShard(key)
+
+	// The shard stays locked while refunding, so the counter can't be cleaned meanwhile.
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	counter, ok := shard.counters[key]
+	if !ok {
+		return 0, time.Unix(0, expiration)
+	}
+
+	value, expiration = counter.refund(now, value, expiration)
+	return value, time.Unix(0, expiration)
+}
+
+// GetLabel returns the label attached to the counter of given key, if any and not expired.
+func (cache *Cache) GetLabel(key string) string {
+	counter, ok := cache.Load(key)
+	if !ok || counter.expired(cache.now()) {
+		return ""
+	}
+	return counter.Label()
+}
+
+// increment increments given value on key, and attaches given label to its counter if not nil.
+func (cache *Cache) increment(key string, value int64, duration time.Duration, label *string) (int64, time.Time) {
+	now := cache.now()
+	expiration := now + int64(duration)
+	shard := cache.getShard(key)
 
 	// If counter is in cache, try to load it first.
-	counter, loaded := cache.Load(key)
+	// The shard stays locked while incrementing, so the counter can't be cleaned meanwhile.
+	shard.mutex.RLock()
+	counter, loaded := shard.counters[key]
 	if loaded {
-		value, expiration = counter.Increment(value, expiration)
+		value, expiration = counter.increment(now, value, expiration, label)
+		shard.mutex.RUnlock()
 		return value, time.Unix(0, expiration)
 	}
+	shard.mutex.RUnlock()
 
 	// If it's not in cache, try to atomically create it.
-	// We do that in two step to reduce memory allocation.
-	counter, loaded = cache.LoadOrStore(key, &Counter{
+	// We do that in two step to reduce lock contention.
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	counter, loaded = shard.counters[key]
+	if loaded {
+		value, expiration = counter.increment(now, value, expiration, label)
+		return value, time.Unix(0, expiration)
+	}
+
+	// The key is cloned since it may be backed by a buffer that will be recycled.
+	counter = &Counter{
 		mutex:      sync.RWMutex{},
 		value:      value,
 		expiration: expiration,
-	})
-	if loaded {
-		value, expiration = counter.Increment(value, expiration)
-		return value, time.Unix(0, expiration)
 	}
+	if label != nil {
+		counter.label = *label
+	}
+	shard.counters[cloneKey(key)] = counter
 
 	// Otherwise, it has been created, return given value.
 	return value, time.Unix(0, expiration)
@@ -211,30 +597,151 @@ func (cache *Cache) Increment(key string, value int64, duration time.Duration) (
 
 // Get returns key's value and expiration.
 func (cache *Cache) Get(key string, duration time.Duration) (int64, time.Time) {
-	expiration := time.Now().Add(duration).UnixNano()
+	now := cache.now()
+	expiration := now + int64(duration)
 
 	counter, ok := cache.Load(key)
 	if !ok {
 		return 0, time.Unix(0, expiration)
 	}
 
-	value, expiration := counter.Load(expiration)
+	value, expiration := counter.load(now, expiration)
 	return value, time.Unix(0, expiration)
 }
 
+// TakeTokens takes given count of tokens from the bucket of given key.
+// If key is undefined or the bucket is full, it will create it with burst tokens.
+func (cache *Cache) TakeTokens(key string, count int64, limit int64, period time.Duration,
+	burst int64) (int64, time.Time, bool) {
+
+	now := cache.now()
+	shard := cache.getShard(key)
+
+	// The shard stays locked while taking tokens, so the bucket can't be cleaned meanwhile.
+	shard.mutex.RLock()
+	bucket, ok := shard.buckets[key]
+	if ok {
+		tokens, reset, taken := bucket.take(now, count, limit, period, burst)
+		shard.mutex.RUnlock()
+		return tokens, time.Unix(0, reset), taken
+	}
+	shard.mutex.RUnlock()
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	bucket, ok = shard.buckets[key]
+	if !ok {
+		// The key is cloned since it may be backed by a buffer that will be recycled.
+		bucket = &Bucket{
+			tokens:   float64(burst),
+			refilled: now,
+		}
+		shard.buckets[cloneKey(key)] = bucket
+	}
+
+	tokens, reset, taken := bucket.take(now, count, limit, period, burst)
+	return tokens, time.Unix(0, reset), taken
+}
+
+// LogRequests adds given count of requests to the sliding log of given key, after removing the requests older than
+// given period. If key is undefined, it will create it, unless count is zero.
+func (cache *Cache) LogRequests(key string, count int64, limit int64, period time.Duration) (int64, time.Time) {
+	now := cache.now()
+	shard := cache.getShard(key)
+
+	// The shard stays locked while logging requests, so the log can't be cleaned meanwhile.
+	shard.mutex.RLock()
+	log, ok := shard.logs[key]
+	if ok {
+		size, reset := log.add(now, count, limit, period)
+		shard.mutex.RUnlock()
+		return size, time.Unix(0, reset)
+	}
+	shard.mutex.RUnlock()
+
+	if count <= 0 {
+		return 0, time.Unix(0, now+int64(period))
+	}
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	log, ok = shard.logs[key]
+	if !ok {
+		// The key is cloned since it may be backed by a buffer that will be recycled.
+		log = &Log{}
+		shard.logs[cloneKey(key)] = log
+	}
+
+	size, reset := log.add(now, count, limit, period)
+	return size, time.Unix(0, reset)
+}
+
 // Clean will deleted any expired keys.
+// Expired keys of a shard are collected under a read lock, so the shard is only locked for writing
+// while deleting them.
 func (cache *Cache) Clean() {
-	cache.Range(func(key string, counter *Counter) {
-		if counter.Expired() {
-			cache.Delete(key)
+	var counters, buckets, logs []string
+	now := cache.now()
+
+	for _, shard := range cache.shards {
+		counters, buckets, logs = counters[:0], buckets[:0], logs[:0]
+
+		shard.mutex.RLock()
+		for key, counter := range shard.counters {
+			if counter.expired(now) {
+				counters = append(counters, key)
+			}
 		}
-	})
+		for key, bucket := range shard.buckets {
+			if bucket.expired(now) {
+				buckets = append(buckets, key)
+			}
+		}
+		for key, log := range shard.logs {
+			if log.expired(now) {
+				logs = append(logs, key)
+			}
+		}
+		shard.mutex.RUnlock()
+
+		if len(counters) == 0 && len(buckets) == 0 && len(logs) == 0 {
+			continue
+		}
+
+		shard.mutex.Lock()
+		for _, key := range counters {
+			// Check again since the counter may have been incremented meanwhile.
+			if counter, ok := shard.counters[key]; ok && counter.expired(now) {
+				delete(shard.counters, key)
+			}
+		}
+		for _, key := range buckets {
+			if bucket, ok := shard.buckets[key]; ok && bucket.expired(now) {
+				delete(shard.buckets, key)
+			}
+		}
+		for _, key := range logs {
+			if log, ok := shard.logs[key]; ok && log.expired(now) {
+				delete(shard.logs, key)
+			}
+		}
+		shard.mutex.Unlock()
+	}
 }
 
 // Reset changes the key's value and resets the expiration.
 func (cache *Cache) Reset(key string, duration time.Duration) (int64, time.Time) {
 	cache.Delete(key)
 
-	expiration := time.Now().Add(duration).UnixNano()
+	expiration := cache.now() + int64(duration)
 	return 0, time.Unix(0, expiration)
 }
+
+// cloneKey returns a copy of given key that doesn't share its underlying memory.
+func cloneKey(key string) string {
+	builder := strings.Builder{}
+	builder.WriteString(key)
+	return builder.String()
+}
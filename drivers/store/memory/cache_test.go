@@ -1,6 +1,7 @@
 package memory_test
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -127,3 +128,65 @@ func TestCacheReset(t *testing.T) {
 	is.Equal(int64(2), x)
 	is.InEpsilon(deleted, expire.UnixNano(), epsilon)
 }
+
+func TestCacheWithShards(t *testing.T) {
+	is := require.New(t)
+
+	duration := 50 * time.Millisecond
+
+	for _, shards := range []int{0, 1, 7, 256} {
+		cache := memory.NewCacheWithShards(10*time.Nanosecond, shards)
+
+		for i := 0; i < 100; i++ {
+			x, _ := cache.Increment(fmt.Sprintf("foo-%d", i), int64(i), duration)
+			is.Equal(int64(i), x, "shards %d", shards)
+		}
+
+		for i := 0; i < 100; i++ {
+			x, _ := cache.Get(fmt.Sprintf("foo-%d", i), duration)
+			is.Equal(int64(i), x, "shards %d", shards)
+		}
+	}
+}
+
+func TestCacheCleaner(t *testing.T) {
+	is := require.New(t)
+
+	cache := memory.NewCache(10 * time.Millisecond)
+	defer cache.Close()
+
+	duration := 20 * time.Millisecond
+
+	for i := 0; i < 1000; i++ {
+		cache.Increment(fmt.Sprintf("foo-%d", i), 1, duration)
+	}
+
+	is.Equal(1000, countCounters(cache))
+
+	// Expired keys must be reclaimed without further requests.
+	time.Sleep(100 * time.Millisecond)
+
+	is.Equal(0, countCounters(cache))
+}
+
+func TestCacheClose(t *testing.T) {
+	is := require.New(t)
+
+	cache := memory.NewCache(10 * time.Millisecond)
+	cache.Close()
+	cache.Close()
+
+	cache.Increment("foo", 1, time.Nanosecond)
+	time.Sleep(50 * time.Millisecond)
+
+	// The cleaner is stopped, so the expired key is not reclaimed.
+	is.Equal(1, countCounters(cache))
+}
+
+func countCounters(cache *memory.CacheWrapper) int {
+	count := 0
+	cache.Range(func(key string, counter *memory.Counter) {
+		count++
+	})
+	return count
+}
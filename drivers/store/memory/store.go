@@ -2,7 +2,7 @@ package memory
 
 import (
 	"context"
-	"time"
+	"strings"
 
 	"github.com/ulule/limiter/v3"
 	"github.com/ulule/limiter/v3/drivers/store/common"
@@ -15,6 +15,8 @@ type Store struct {
 	Prefix string
 	// cache used to store values in-memory.
 	cache *CacheWrapper
+	// clock used to obtain the current time.
+	clock limiter.Clock
 }
 
 // NewStore creates a new instance of memory store with defaults.
@@ -22,61 +24,201 @@ func NewStore() limiter.Store {
 	return NewStoreWithOptions(limiter.StoreOptions{
 		Prefix:          limiter.DefaultPrefix,
 		CleanUpInterval: limiter.DefaultCleanUpInterval,
+		ShardCount:      limiter.DefaultShardCount,
 	})
 }
 
 // NewStoreWithOptions creates a new instance of memory store with options.
 func NewStoreWithOptions(options limiter.StoreOptions) limiter.Store {
+	clock := options.Clock
+	if clock == nil {
+		clock = limiter.SystemClock{}
+	}
+
+	cache := newCache(options.CleanUpInterval, options.ShardCount, clock)
+
 	return &Store{
 		Prefix: options.Prefix,
-		cache:  NewCache(options.CleanUpInterval),
+		cache:  cache,
+		clock:  clock,
 	}
 }
 
 // Get returns the limit for given identifier.
 func (store *Store) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
 	buffer := bytebuffer.New()
 	defer buffer.Close()
 	buffer.Concat(store.Prefix, ":", key)
 
 	count, expiration := store.cache.Increment(buffer.String(), 1, rate.Period)
 
-	lctx := common.GetContextFromState(time.Now(), rate, expiration, count)
+	lctx := common.GetContextFromState(store.clock.Now(), rate, expiration, count)
 	return lctx, nil
 }
 
 // Increment increments the limit by given count & returns the new limit value for given identifier.
 func (store *Store) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
 	buffer := bytebuffer.New()
 	defer buffer.Close()
 	buffer.Concat(store.Prefix, ":", key)
 
 	newCount, expiration := store.cache.Increment(buffer.String(), count, rate.Period)
 
-	lctx := common.GetContextFromState(time.Now(), rate, expiration, newCount)
+	lctx := common.GetContextFromState(store.clock.Now(), rate, expiration, newCount)
+	return lctx, nil
+}
+
+// Refund decrements the limit by given count, without going below zero, & returns the new limit value for given
+// identifier.
+func (store *Store) Refund(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
+	buffer := bytebuffer.New()
+	defer buffer.Close()
+	buffer.Concat(store.Prefix, ":", key)
+
+	newCount, expiration := store.cache.Refund(buffer.String(), count, rate.Period)
+
+	lctx := common.GetContextFromState(store.clock.Now(), rate, expiration, newCount)
+	return lctx, nil
+}
+
+// TakeTokens takes given count of tokens from the bucket of given identifier.
+func (store *Store) TakeTokens(ctx context.Context, key string, count int64, rate limiter.Rate,
+	burst int64) (limiter.Context, error) {
+
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
+	buffer := bytebuffer.New()
+	defer buffer.Close()
+	buffer.Concat(store.Prefix, ":", key)
+
+	tokens, reset, taken := store.cache.TakeTokens(buffer.String(), count, rate.Limit, rate.Period, burst)
+
+	lctx := common.GetContextFromBucket(burst, tokens, reset, taken)
+	return lctx, nil
+}
+
+// LogRequests logs given count of requests in the sliding log of given identifier.
+func (store *Store) LogRequests(ctx context.Context, key string, count int64,
+	rate limiter.Rate) (limiter.Context, error) {
+
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
+	buffer := bytebuffer.New()
+	defer buffer.Close()
+	buffer.Concat(store.Prefix, ":", key)
+
+	size, reset := store.cache.LogRequests(buffer.String(), count, rate.Limit, rate.Period)
+
+	lctx := common.GetContextFromState(store.clock.Now(), rate, reset, size)
+	return lctx, nil
+}
+
+// GetWithLabel returns the limit for given identifier, and attaches given label to its counter.
+func (store *Store) GetWithLabel(ctx context.Context, key string, rate limiter.Rate,
+	label string) (limiter.Context, error) {
+
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
+	buffer := bytebuffer.New()
+	defer buffer.Close()
+	buffer.Concat(store.Prefix, ":", key)
+
+	count, expiration := store.cache.IncrementWithLabel(buffer.String(), 1, rate.Period, label)
+
+	lctx := common.GetContextFromState(store.clock.Now(), rate, expiration, count)
 	return lctx, nil
 }
 
+// GetLabel returns the label attached to the counter of given identifier, or an empty string if none.
+func (store *Store) GetLabel(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	buffer := bytebuffer.New()
+	defer buffer.Close()
+	buffer.Concat(store.Prefix, ":", key)
+
+	return store.cache.GetLabel(buffer.String()), nil
+}
+
+// ListTop returns the n counters with the highest counts, in descending order, with their label if any, such as
+// to list top offenders. Keys are the identifiers given to the store, including the limiter prefix.
+// Only counters of the fixed window are listed. It iterates over every counter of the store: it's an O(n)
+// operation, intended for administration.
+func (store *Store) ListTop(n int) []TopEntry {
+	prefix := store.Prefix + ":"
+
+	entries := store.cache.ListTop(prefix, n)
+	for i := range entries {
+		entries[i].Key = strings.TrimPrefix(entries[i].Key, prefix)
+	}
+	return entries
+}
+
 // Peek returns the limit for given identifier, without modification on current values.
 func (store *Store) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
 	buffer := bytebuffer.New()
 	defer buffer.Close()
 	buffer.Concat(store.Prefix, ":", key)
 
 	count, expiration := store.cache.Get(buffer.String(), rate.Period)
 
-	lctx := common.GetContextFromState(time.Now(), rate, expiration, count)
+	lctx := common.GetContextFromState(store.clock.Now(), rate, expiration, count)
 	return lctx, nil
 }
 
 // Reset returns the limit for given identifier.
 func (store *Store) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return limiter.Context{}, err
+	}
+
 	buffer := bytebuffer.New()
 	defer buffer.Close()
 	buffer.Concat(store.Prefix, ":", key)
 
 	count, expiration := store.cache.Reset(buffer.String(), rate.Period)
 
-	lctx := common.GetContextFromState(time.Now(), rate, expiration, count)
+	lctx := common.GetContextFromState(store.clock.Now(), rate, expiration, count)
 	return lctx, nil
 }
+
+// ResetNamespace deletes the counters of every identifier starting with given prefix.
+// It iterates over every key of the store: it's an O(n) operation, intended for administration.
+func (store *Store) ResetNamespace(ctx context.Context, prefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.cache.DeletePrefix(store.Prefix + ":" + prefix)
+	return nil
+}
+
+// Close stops the goroutine cleaning expired keys of this store.
+func (store *Store) Close() error {
+	store.cache.Close()
+	return nil
+}
@@ -1,12 +1,17 @@
 package memory_test
 
 import (
+	"context"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
+
 	"github.com/ulule/limiter/v3"
 	"github.com/ulule/limiter/v3/drivers/store/memory"
 	"github.com/ulule/limiter/v3/drivers/store/tests"
+	"github.com/ulule/limiter/v3/limitertest"
 )
 
 func TestMemoryStoreSequentialAccess(t *testing.T) {
@@ -23,6 +28,163 @@ func TestMemoryStoreConcurrentAccess(t *testing.T) {
 	}))
 }
 
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	tests.TestStoreTokenBucket(t, memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix:          "limiter:memory:token-bucket-test",
+		CleanUpInterval: 30 * time.Second,
+	}))
+}
+
+func TestMemoryStoreSlidingLog(t *testing.T) {
+	clock := limitertest.NewFakeClock(time.Now())
+
+	tests.TestStoreSlidingLog(t, memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix:          "limiter:memory:sliding-log-test",
+		CleanUpInterval: 30 * time.Second,
+		Clock:           clock,
+	}), clock)
+}
+
+func TestMemoryStoreLabels(t *testing.T) {
+	tests.TestStoreLabels(t, memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix:          "limiter:memory:labels-test",
+		CleanUpInterval: 30 * time.Second,
+	}))
+}
+
+func TestMemoryStoreListTop(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Now())
+	store := memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix:          "limiter:memory:list-top-test",
+		CleanUpInterval: 30 * time.Second,
+		Clock:           clock,
+	})
+
+	instance := limiter.New(store, limiter.Rate{
+		Period: time.Minute,
+		Limit:  10,
+	}, limiter.WithPrefix("user:"))
+
+	is.Empty(store.(*memory.Store).ListTop(3))
+
+	for i := 0; i < 3; i++ {
+		_, err := instance.GetWithLabel(ctx, "1", "account:1")
+		is.NoError(err)
+	}
+	for i := 0; i < 5; i++ {
+		_, err := instance.Get(ctx, "2")
+		is.NoError(err)
+	}
+	_, err := instance.GetWithLabel(ctx, "3", "account:3")
+	is.NoError(err)
+
+	clock.Advance(30 * time.Second)
+	for i := 0; i < 2; i++ {
+		_, err = instance.GetWithLabel(ctx, "4", "account:4")
+		is.NoError(err)
+	}
+
+	// Scenario #1 : counters are listed by descending count, with their label.
+	top := store.(*memory.Store).ListTop(3)
+	is.Len(top, 3)
+	is.Equal("user:2", top[0].Key)
+	is.Equal(int64(5), top[0].Count)
+	is.Empty(top[0].Label)
+	is.Equal("user:1", top[1].Key)
+	is.Equal(int64(3), top[1].Count)
+	is.Equal("account:1", top[1].Label)
+	is.Equal("user:4", top[2].Key)
+	is.Equal("account:4", top[2].Label)
+
+	// Scenario #2 : expired counters aren't listed.
+	clock.Advance(31 * time.Second)
+
+	top = store.(*memory.Store).ListTop(3)
+	is.Len(top, 1)
+	is.Equal("user:4", top[0].Key)
+	is.Equal(int64(2), top[0].Count)
+
+	label, err := instance.GetLabel(ctx, "1")
+	is.NoError(err)
+	is.Empty(label)
+}
+
+func TestMemoryStoreRefund(t *testing.T) {
+	tests.TestStoreRefund(t, memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix:          "limiter:memory:refund-test",
+		CleanUpInterval: 30 * time.Second,
+	}))
+}
+
+func TestMemoryStoreContextCancellation(t *testing.T) {
+	tests.TestStoreContextCancellation(t, memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix:          "limiter:memory:context-test",
+		CleanUpInterval: 30 * time.Second,
+	}))
+}
+
+func TestMemoryStoreResetNamespace(t *testing.T) {
+	tests.TestStoreResetNamespace(t, memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix:          "limiter:memory:namespace-test",
+		CleanUpInterval: 30 * time.Second,
+	}))
+}
+
+func TestMemoryStoreLostUpdate(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	// The cleaner runs continuously, to check it doesn't race with increments either.
+	store := memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix:          "limiter:memory:lost-update-test",
+		CleanUpInterval: 1 * time.Nanosecond,
+	})
+	defer store.(*memory.Store).Close()
+
+	goroutines := 100
+	ops := 100
+	total := goroutines * ops
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(total),
+	}
+
+	mutex := sync.Mutex{}
+	remainings := map[int64]bool{}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < ops; j++ {
+				lctx, err := store.Get(ctx, "foo", rate)
+				is.NoError(err)
+
+				mutex.Lock()
+				remainings[lctx.Remaining] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Each increment observed its own count: none of them were lost.
+	is.Len(remainings, total)
+
+	lctx, err := store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(0), lctx.Remaining)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.True(lctx.Reached)
+}
+
 func BenchmarkMemoryStoreSequentialAccess(b *testing.B) {
 	tests.BenchmarkStoreSequentialAccess(b, memory.NewStoreWithOptions(limiter.StoreOptions{
 		Prefix:          "limiter:memory:sequential-benchmark",
@@ -36,3 +198,21 @@ func BenchmarkMemoryStoreConcurrentAccess(b *testing.B) {
 		CleanUpInterval: 1 * time.Hour,
 	}))
 }
+
+func BenchmarkMemoryStoreDistinctKeysAccess(b *testing.B) {
+	b.Run("single-lock", func(b *testing.B) {
+		tests.BenchmarkStoreDistinctKeysAccess(b, memory.NewStoreWithOptions(limiter.StoreOptions{
+			Prefix:          "limiter:memory:distinct-keys-benchmark",
+			CleanUpInterval: 1 * time.Hour,
+			ShardCount:      1,
+		}), 10000, 64)
+	})
+
+	b.Run("sharded", func(b *testing.B) {
+		tests.BenchmarkStoreDistinctKeysAccess(b, memory.NewStoreWithOptions(limiter.StoreOptions{
+			Prefix:          "limiter:memory:distinct-keys-benchmark",
+			CleanUpInterval: 1 * time.Hour,
+			ShardCount:      limiter.DefaultShardCount,
+		}), 10000, 64)
+	})
+}
@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+	libredis "github.com/redis/go-redis/v9"
+
+	"github.com/ulule/limiter/v3"
+)
+
+const (
+	// NetworkTCP is the network of a redis server reachable over TCP, such as "127.0.0.1:6379".
+	NetworkTCP = "tcp"
+	// NetworkUnix is the network of a redis server reachable over a Unix socket, such as "/run/redis.sock".
+	NetworkUnix = "unix"
+)
+
+// ConnectionOptions are options to connect to a redis server, for NewClient.
+type ConnectionOptions struct {
+	// Network is either NetworkTCP or NetworkUnix. Default is NetworkTCP.
+	Network string
+	// Addr is the address of the server: "host:port" over TCP, or the path of the socket over Unix.
+	// Default is "localhost:6379" over TCP.
+	Addr string
+	// Username is used to authenticate with redis ACL, in addition to Password.
+	Username string
+	// Password is used to authenticate with the server, if defined.
+	Password string
+	// DB is the database selected after connecting.
+	DB int
+	// TLSConfig enables TLS with given configuration, if defined, such as to verify the server certificate.
+	TLSConfig *tls.Config
+}
+
+// NewClient returns a redis client connected with given options, for users who don't build their own client.
+func NewClient(options ConnectionOptions) (*libredis.Client, error) {
+	network := options.Network
+	if network == "" {
+		network = NetworkTCP
+	}
+
+	if network != NetworkTCP && network != NetworkUnix {
+		return nil, errors.Errorf("unsupported redis network %q", network)
+	}
+	if network == NetworkUnix && options.Addr == "" {
+		return nil, errors.New("redis unix socket path is required")
+	}
+
+	return libredis.NewClient(&libredis.Options{
+		Network:   network,
+		Addr:      options.Addr,
+		Username:  options.Username,
+		Password:  options.Password,
+		DB:        options.DB,
+		TLSConfig: options.TLSConfig,
+	}), nil
+}
+
+// NewStoreWithConnection returns an instance of redis store with options, using a client connected with given
+// connection options (see NewClient). The client is closed with the store.
+func NewStoreWithConnection(connection ConnectionOptions, options limiter.StoreOptions) (limiter.Store, error) {
+	client, err := NewClient(connection)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := NewStoreWithOptions(client, options)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	store.(*Store).closer = client
+	return store, nil
+}
@@ -2,7 +2,11 @@ package redis
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -18,17 +22,16 @@ import (
 const (
 	luaIncrScript = `
 local key = KEYS[1]
-local count = tonumber(ARGV[1])
 local ttl = tonumber(ARGV[2])
 local ret = redis.call("incrby", key, ARGV[1])
-if ret == count then
+local current = redis.call("pttl", key)
+if current == -1 then
 	if ttl > 0 then
 		redis.call("pexpire", key, ARGV[2])
 	end
 	return {ret, ttl}
 end
-ttl = redis.call("pttl", key)
-return {ret, ttl}
+return {ret, current}
 `
 	luaPeekScript = `
 local key = KEYS[1]
@@ -38,10 +41,95 @@ if v == false then
 end
 local ttl = redis.call("pttl", key)
 return {tonumber(v), ttl}
+`
+	luaTokenScript = `
+local key = KEYS[1]
+local count = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+local burst = tonumber(ARGV[4])
+local now = tonumber(ARGV[5])
+local state = redis.call("hmget", key, "tokens", "refilled")
+local tokens = tonumber(state[1])
+local refilled = tonumber(state[2])
+if tokens == nil or refilled == nil then
+	tokens = burst
+	refilled = now
+end
+if now > refilled then
+	tokens = tokens + (now - refilled) * limit / period
+	refilled = now
+end
+if tokens > burst then
+	tokens = burst
+end
+local taken = 0
+if tokens >= count then
+	tokens = tokens - count
+	taken = 1
+end
+local ttl = math.ceil((burst - tokens) * period / limit)
+if ttl > 0 then
+	redis.call("hmset", key, "tokens", tostring(tokens), "refilled", refilled)
+	redis.call("pexpire", key, ttl)
+else
+	redis.call("del", key)
+end
+if taken == 0 then
+	ttl = math.ceil((count - tokens) * period / limit)
+end
+return {taken, math.floor(tokens), ttl}
+`
+	luaRefundScript = `
+local key = KEYS[1]
+local count = tonumber(ARGV[1])
+local v = tonumber(redis.call("get", key))
+if v == nil then
+	return {0, 0}
+end
+if count > v then
+	count = v
+end
+local ret = redis.call("decrby", key, count)
+local ttl = redis.call("pttl", key)
+return {ret, ttl}
+`
+	luaLabelScript = `
+local key = KEYS[1]
+local ttl = tonumber(ARGV[1])
+local ret = redis.call("incr", key)
+local current = redis.call("pttl", key)
+if current == -1 then
+	if ttl > 0 then
+		redis.call("pexpire", key, ARGV[1])
+	end
+else
+	ttl = current
+end
+redis.call("hset", KEYS[2], "label", ARGV[2])
+if ttl > 0 then
+	redis.call("pexpire", KEYS[2], ttl)
+end
+return {ret, ttl}
+`
+	luaGetLabelScript = `
+local v = redis.call("hget", KEYS[1], "label")
+if v == false then
+	return ""
+end
+return v
 `
 )
 
+// labelKeySuffix is the suffix of the redis key of the hash holding the label of an identifier.
+const labelKeySuffix = "#label"
+
+// scanCount is the number of keys requested by SCAN iteration when resetting a namespace.
+const scanCount = 1000
+
 // Client is an interface thats allows to use a redis cluster or a redis single client seamlessly.
+// It's satisfied by libredis.Client, libredis.ClusterClient, libredis.Ring and libredis.UniversalClient,
+// hence sentinel connections created with libredis.NewFailoverClient.
 type Client interface {
 	Get(ctx context.Context, key string) *libredis.StringCmd
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *libredis.StatusCmd
@@ -52,6 +140,19 @@ type Client interface {
 	ScriptLoad(ctx context.Context, script string) *libredis.StringCmd
 }
 
+// scanner is implemented by redis clients supporting the SCAN command, required to reset a namespace.
+// It's satisfied by libredis.Client, libredis.ClusterClient, libredis.Ring and libredis.UniversalClient.
+type scanner interface {
+	Scan(ctx context.Context, cursor uint64, match string, count int64) *libredis.ScanCmd
+	Del(ctx context.Context, keys ...string) *libredis.IntCmd
+}
+
+// transactioner is implemented by redis clients supporting MULTI transactions, required by the sliding log.
+// It's satisfied by libredis.Client, libredis.ClusterClient, libredis.Ring and libredis.UniversalClient.
+type transactioner interface {
+	TxPipelined(ctx context.Context, fn func(libredis.Pipeliner) error) ([]libredis.Cmder, error)
+}
+
 // Store is the redis store.
 type Store struct {
 	// Prefix used for the key.
@@ -59,8 +160,15 @@ type Store struct {
 	// MaxRetry is the maximum number of retry under race conditions.
 	// Deprecated: this option is no longer required since all operations are atomic now.
 	MaxRetry int
+	// HashTag defines if keys are wrapped in a hash tag, so every key is hashed into a slot of a redis cluster
+	// using the limiter key only, regardless of the prefix.
+	HashTag bool
 	// client used to communicate with redis server.
 	client Client
+	// closer is the client created by the store, closed with it.
+	closer io.Closer
+	// clock used to obtain the current time.
+	clock limiter.Clock
 	// luaMutex is a mutex used to avoid concurrent access on luaIncrSHA and luaPeekSHA.
 	luaMutex sync.RWMutex
 	// luaLoaded is used for CAS and reduce pressure on luaMutex.
@@ -69,6 +177,14 @@ type Store struct {
 	luaIncrSHA string
 	// luaPeekSHA is the SHA of peek and expire key script.
 	luaPeekSHA string
+	// luaTokenSHA is the SHA of token bucket script.
+	luaTokenSHA string
+	// luaRefundSHA is the SHA of refund script.
+	luaRefundSHA string
+	// luaLabelSHA is the SHA of increase and label key script.
+	luaLabelSHA string
+	// luaGetLabelSHA is the SHA of get label script.
+	luaGetLabelSHA string
 }
 
 // NewStore returns an instance of redis store with defaults.
@@ -86,6 +202,43 @@ func NewStoreWithOptions(client Client, options limiter.StoreOptions) (limiter.S
 		client:   client,
 		Prefix:   options.Prefix,
 		MaxRetry: options.MaxRetry,
+		clock:    options.Clock,
+	}
+
+	err := store.preloadLuaScripts(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// NewClusterStore returns an instance of redis store with defaults, for a redis cluster.
+//
+// Every operation of this store is done with a single key, so the store works with any slot distribution.
+// Keys are hash-tagged with the limiter key, so keys sharing a limiter key but with a different prefix land
+// in the same slot.
+//
+// During a failover or a slot migration, the client follows MOVED and ASK redirections, and the lua scripts are
+// loaded again on the new master if they are missing. However, counters which were not yet replicated to
+// the promoted replica are lost, and will restart from zero.
+func NewClusterStore(client Client) (limiter.Store, error) {
+	return NewClusterStoreWithOptions(client, limiter.StoreOptions{
+		Prefix:          limiter.DefaultPrefix,
+		CleanUpInterval: limiter.DefaultCleanUpInterval,
+		MaxRetry:        limiter.DefaultMaxRetry,
+	})
+}
+
+// NewClusterStoreWithOptions returns an instance of redis store with options, for a redis cluster.
+// See NewClusterStore for its behavior.
+func NewClusterStoreWithOptions(client Client, options limiter.StoreOptions) (limiter.Store, error) {
+	store := &Store{
+		client:   client,
+		Prefix:   options.Prefix,
+		MaxRetry: options.MaxRetry,
+		HashTag:  true,
+		clock:    options.Clock,
 	}
 
 	err := store.preloadLuaScripts(context.Background())
@@ -98,28 +251,51 @@ func NewStoreWithOptions(client Client, options limiter.StoreOptions) (limiter.S
 
 // Increment increments the limit by given count & gives back the new limit for given identifier
 func (store *Store) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
-	key = fmt.Sprintf("%s:%s", store.Prefix, key)
+	key = store.getKey(key)
 	cmd := store.evalSHA(ctx, store.getLuaIncrSHA, []string{key}, count, rate.Period.Milliseconds())
-	return currentContext(cmd, rate)
+	return currentContext(ctx, cmd, rate, store.now())
 }
 
 // Get returns the limit for given identifier.
 func (store *Store) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
-	key = fmt.Sprintf("%s:%s", store.Prefix, key)
+	key = store.getKey(key)
 	cmd := store.evalSHA(ctx, store.getLuaIncrSHA, []string{key}, 1, rate.Period.Milliseconds())
-	return currentContext(cmd, rate)
+	return currentContext(ctx, cmd, rate, store.now())
+}
+
+// GetWithLabel returns the limit for given identifier, and attaches given label to its counter.
+// The label is stored in a hash field, whose key is the counter one suffixed with "#label", expiring with the
+// counter. Both keys are updated by a single script: on a redis cluster, use NewClusterStore so they share a slot.
+// Since redis has no index, listing labelled counters requires a SCAN of the keys, like ResetNamespace.
+func (store *Store) GetWithLabel(ctx context.Context, key string, rate limiter.Rate,
+	label string) (limiter.Context, error) {
+
+	key = store.getKey(key)
+	cmd := store.evalSHA(ctx, store.getLuaLabelSHA, []string{key, key + labelKeySuffix},
+		rate.Period.Milliseconds(), label)
+	return currentContext(ctx, cmd, rate, store.now())
+}
+
+// GetLabel returns the label attached to the counter of given identifier, or an empty string if none.
+func (store *Store) GetLabel(ctx context.Context, key string) (string, error) {
+	key = store.getKey(key)
+	label, err := store.evalSHA(ctx, store.getLuaGetLabelSHA, []string{key + labelKeySuffix}).Text()
+	if err != nil {
+		return "", wrapError(ctx, err)
+	}
+	return label, nil
 }
 
 // Peek returns the limit for given identifier, without modification on current values.
 func (store *Store) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
-	key = fmt.Sprintf("%s:%s", store.Prefix, key)
+	key = store.getKey(key)
 	cmd := store.evalSHA(ctx, store.getLuaPeekSHA, []string{key})
-	count, ttl, err := parseCountAndTTL(cmd)
+	count, ttl, err := parseCountAndTTL(ctx, cmd)
 	if err != nil {
 		return limiter.Context{}, err
 	}
 
-	now := time.Now()
+	now := store.now()
 	expiration := now.Add(rate.Period)
 	if ttl > 0 {
 		expiration = now.Add(time.Duration(ttl) * time.Millisecond)
@@ -128,21 +304,246 @@ func (store *Store) Peek(ctx context.Context, key string, rate limiter.Rate) (li
 	return common.GetContextFromState(now, rate, expiration, count), nil
 }
 
+// Refund decrements the limit by given count, without going below zero, & gives back the new limit for given
+// identifier. The floor is checked by a lua script, before a DECRBY keeping the expiration of the counter.
+func (store *Store) Refund(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	key = store.getKey(key)
+	cmd := store.evalSHA(ctx, store.getLuaRefundSHA, []string{key}, count)
+	return currentContext(ctx, cmd, rate, store.now())
+}
+
+// TakeTokens takes given count of tokens from the bucket of given identifier.
+// The bucket is refilled according to the clock of this client, rather than the redis server one.
+func (store *Store) TakeTokens(ctx context.Context, key string, count int64, rate limiter.Rate,
+	burst int64) (limiter.Context, error) {
+
+	key = store.getKey(key)
+	now := store.now()
+	cmd := store.evalSHA(ctx, store.getLuaTokenSHA, []string{key},
+		count, rate.Limit, rate.Period.Milliseconds(), burst, now.UnixNano()/int64(time.Millisecond))
+
+	result, err := cmd.Result()
+	if err != nil {
+		return limiter.Context{}, wrapError(ctx, err)
+	}
+
+	fields, ok := result.([]interface{})
+	if !ok || len(fields) != 3 {
+		return limiter.Context{}, errors.New("three elements in result were expected")
+	}
+
+	taken, ok1 := fields[0].(int64)
+	tokens, ok2 := fields[1].(int64)
+	ttl, ok3 := fields[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return limiter.Context{}, errors.New("type of the taken, tokens and/or ttl should be number")
+	}
+
+	reset := now.Add(time.Duration(ttl) * time.Millisecond)
+
+	return common.GetContextFromBucket(burst, tokens, reset, taken == 1), nil
+}
+
+// LogRequests logs given count of requests in the sliding log of given identifier: a sorted set of requests scored
+// by their time in milliseconds, trimmed and counted in a single MULTI transaction.
+// Requests are timed according to the clock of this client. If clocks are skewed between instances, the requests
+// of an instance leave the log earlier or later by the skew, but they are still counted, unlike with windows.
+// Each request is a sorted set entry of about 100 bytes, and a key holds up to rate.Limit + 1 entries.
+// It returns limiter.ErrStoreModeNotSupported if the client doesn't support transactions.
+func (store *Store) LogRequests(ctx context.Context, key string, count int64,
+	rate limiter.Rate) (limiter.Context, error) {
+
+	client, ok := store.client.(transactioner)
+	if !ok {
+		return limiter.Context{}, limiter.ErrStoreModeNotSupported
+	}
+
+	// Older requests would be trimmed anyway, since only the limit + 1 most recent ones are kept.
+	if count > rate.Limit+1 {
+		count = rate.Limit + 1
+	}
+
+	key = store.getKey(key)
+	now := store.now()
+	score := now.UnixNano() / int64(time.Millisecond)
+
+	entries, err := newLogEntries(score, count)
+	if err != nil {
+		return limiter.Context{}, err
+	}
+
+	var (
+		size   *libredis.IntCmd
+		oldest *libredis.ZSliceCmd
+		next   *libredis.ZSliceCmd
+	)
+
+	_, err = client.TxPipelined(ctx, func(pipe libredis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(score-rate.Period.Milliseconds(), 10))
+		if len(entries) > 0 {
+			pipe.ZAdd(ctx, key, entries...)
+			pipe.ZRemRangeByRank(ctx, key, 0, -(rate.Limit + 2))
+			pipe.PExpire(ctx, key, rate.Period)
+		}
+		size = pipe.ZCard(ctx, key)
+		oldest = pipe.ZRangeWithScores(ctx, key, 0, 0)
+		// With at least rate.Limit requests, the next one is allowed once this one leaves the log.
+		next = pipe.ZRangeWithScores(ctx, key, -rate.Limit, -rate.Limit)
+		return nil
+	})
+	if err != nil {
+		return limiter.Context{}, wrapError(ctx, err)
+	}
+
+	reset := now.Add(rate.Period)
+	first := oldest.Val()
+	if size.Val() >= rate.Limit && len(next.Val()) > 0 {
+		first = next.Val()
+	}
+	if len(first) > 0 {
+		reset = time.Unix(0, int64(first[0].Score)*int64(time.Millisecond)).Add(rate.Period)
+	}
+
+	return common.GetContextFromState(now, rate, reset, size.Val()), nil
+}
+
 // Reset returns the limit for given identifier which is set to zero.
 func (store *Store) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
-	key = fmt.Sprintf("%s:%s", store.Prefix, key)
+	key = store.getKey(key)
 	_, err := store.client.Del(ctx, key).Result()
 	if err != nil {
-		return limiter.Context{}, err
+		return limiter.Context{}, wrapError(ctx, err)
+	}
+
+	// The label is deleted separately, since both keys may not share a slot without NewClusterStore.
+	_, err = store.client.Del(ctx, key+labelKeySuffix).Result()
+	if err != nil {
+		return limiter.Context{}, wrapError(ctx, err)
 	}
 
 	count := int64(0)
-	now := time.Now()
+	now := store.now()
 	expiration := now.Add(rate.Period)
 
 	return common.GetContextFromState(now, rate, expiration, count), nil
 }
 
+// ResetNamespace deletes the counters of every identifier starting with given prefix.
+// Keys are iterated with SCAN, on every master of a cluster or shard of a ring, so redis isn't blocked like with
+// KEYS: it's still an O(n) operation on the keys of the database, intended for administration.
+// Keys created meanwhile may not be deleted.
+// It returns limiter.ErrNamespaceNotSupported if the client doesn't support SCAN.
+func (store *Store) ResetNamespace(ctx context.Context, prefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	pattern := store.getKeyPattern(prefix)
+
+	switch client := store.client.(type) {
+	case *libredis.ClusterClient:
+		// Keys of a node may belong to different slots, so they are deleted one by one.
+		return client.ForEachMaster(ctx, func(ctx context.Context, node *libredis.Client) error {
+			return deleteKeys(ctx, node, pattern, false)
+		})
+	case *libredis.Ring:
+		return client.ForEachShard(ctx, func(ctx context.Context, shard *libredis.Client) error {
+			return deleteKeys(ctx, shard, pattern, true)
+		})
+	case scanner:
+		return deleteKeys(ctx, client, pattern, true)
+	default:
+		return limiter.ErrNamespaceNotSupported
+	}
+}
+
+// deleteKeys deletes the keys matching given pattern, iterated with SCAN.
+// If batch is true, the keys of an iteration are deleted together.
+func deleteKeys(ctx context.Context, client scanner, pattern string, batch bool) error {
+	cursor := uint64(0)
+
+	for {
+		keys, next, err := client.Scan(ctx, cursor, pattern, scanCount).Result()
+		if err != nil {
+			return wrapError(ctx, err)
+		}
+
+		err = deleteBatch(ctx, client, keys, batch)
+		if err != nil {
+			return wrapError(ctx, err)
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Close closes the redis client created by NewStoreWithConnection.
+// It doesn't close a client given to the store, which can be shared with the rest of your application.
+func (store *Store) Close() error {
+	if store.closer == nil {
+		return nil
+	}
+	return store.closer.Close()
+}
+
+// now returns the current time of the store clock, or the wall clock otherwise.
+func (store *Store) now() time.Time {
+	if store.clock == nil {
+		return time.Now()
+	}
+	return store.clock.Now()
+}
+
+// getKey returns the redis key for given identifier.
+func (store *Store) getKey(key string) string {
+	if store.HashTag {
+		return fmt.Sprintf("%s:{%s}", store.Prefix, key)
+	}
+	return fmt.Sprintf("%s:%s", store.Prefix, key)
+}
+
+// deleteBatch deletes given keys, together if batch is true, or one by one otherwise.
+func deleteBatch(ctx context.Context, client scanner, keys []string, batch bool) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if batch {
+		return client.Del(ctx, keys...).Err()
+	}
+
+	for _, key := range keys {
+		err := client.Del(ctx, key).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getKeyPattern returns the SCAN pattern matching the redis keys of every identifier starting with given prefix.
+func (store *Store) getKeyPattern(prefix string) string {
+	if store.HashTag {
+		return fmt.Sprintf("%s:{%s*", escapePattern(store.Prefix), escapePattern(prefix))
+	}
+	return fmt.Sprintf("%s:%s*", escapePattern(store.Prefix), escapePattern(prefix))
+}
+
+// escapePattern escapes the special characters of a redis glob-style pattern in given value.
+func escapePattern(value string) string {
+	builder := strings.Builder{}
+	for _, c := range value {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			builder.WriteByte('\\')
+		}
+		builder.WriteRune(c)
+	}
+	return builder.String()
+}
+
 // preloadLuaScripts preloads the "incr" and "peek" lua scripts.
 func (store *Store) preloadLuaScripts(ctx context.Context) error {
 	// Verify if we need to load lua scripts.
@@ -182,8 +583,32 @@ func (store *Store) loadLuaScripts(ctx context.Context) error {
 		return errors.Wrap(err, `failed to load "peek" lua script`)
 	}
 
+	luaTokenSHA, err := store.client.ScriptLoad(ctx, luaTokenScript).Result()
+	if err != nil {
+		return errors.Wrap(err, `failed to load "token" lua script`)
+	}
+
+	luaRefundSHA, err := store.client.ScriptLoad(ctx, luaRefundScript).Result()
+	if err != nil {
+		return errors.Wrap(err, `failed to load "refund" lua script`)
+	}
+
+	luaLabelSHA, err := store.client.ScriptLoad(ctx, luaLabelScript).Result()
+	if err != nil {
+		return errors.Wrap(err, `failed to load "label" lua script`)
+	}
+
+	luaGetLabelSHA, err := store.client.ScriptLoad(ctx, luaGetLabelScript).Result()
+	if err != nil {
+		return errors.Wrap(err, `failed to load "get label" lua script`)
+	}
+
 	store.luaIncrSHA = luaIncrSHA
 	store.luaPeekSHA = luaPeekSHA
+	store.luaTokenSHA = luaTokenSHA
+	store.luaRefundSHA = luaRefundSHA
+	store.luaLabelSHA = luaLabelSHA
+	store.luaGetLabelSHA = luaGetLabelSHA
 
 	atomic.StoreUint32(&store.luaLoaded, 1)
 
@@ -204,6 +629,34 @@ func (store *Store) getLuaPeekSHA() string {
 	return store.luaPeekSHA
 }
 
+// getLuaTokenSHA returns a "thread-safe" value for luaTokenSHA.
+func (store *Store) getLuaTokenSHA() string {
+	store.luaMutex.RLock()
+	defer store.luaMutex.RUnlock()
+	return store.luaTokenSHA
+}
+
+// getLuaRefundSHA returns a "thread-safe" value for luaRefundSHA.
+func (store *Store) getLuaRefundSHA() string {
+	store.luaMutex.RLock()
+	defer store.luaMutex.RUnlock()
+	return store.luaRefundSHA
+}
+
+// getLuaLabelSHA returns a "thread-safe" value for luaLabelSHA.
+func (store *Store) getLuaLabelSHA() string {
+	store.luaMutex.RLock()
+	defer store.luaMutex.RUnlock()
+	return store.luaLabelSHA
+}
+
+// getLuaGetLabelSHA returns a "thread-safe" value for luaGetLabelSHA.
+func (store *Store) getLuaGetLabelSHA() string {
+	store.luaMutex.RLock()
+	defer store.luaMutex.RUnlock()
+	return store.luaGetLabelSHA
+}
+
 // evalSHA eval the redis lua sha and load the scripts if missing.
 func (store *Store) evalSHA(ctx context.Context, getSha func() string,
 	keys []string, args ...interface{}) *libredis.Cmd {
@@ -224,16 +677,51 @@ func (store *Store) evalSHA(ctx context.Context, getSha func() string,
 	return store.client.EvalSha(ctx, getSha(), keys, args...)
 }
 
+// wrapError wraps given error of a redis command.
+// If the context is done, its error is returned instead, so it can be told apart from a store error.
+// Network failures and closed clients are classified as store errors (see limiter.StoreError).
+func wrapError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return common.WrapError(err, "an error has occurred with redis command", libredis.ErrClosed)
+}
+
 // isLuaScriptGone returns if the error is a missing lua script from redis server.
 func isLuaScriptGone(err error) bool {
 	return strings.HasPrefix(err.Error(), "NOSCRIPT")
 }
 
+// newLogEntries returns given count of sorted set entries for requests of a sliding log at given score.
+// Members are made unique with a random identifier, since instances may log requests at the same time.
+func newLogEntries(score int64, count int64) ([]libredis.Z, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate sliding log entry")
+	}
+
+	prefix := strconv.FormatInt(score, 10) + ":" + hex.EncodeToString(id) + ":"
+	entries := make([]libredis.Z, count)
+	for i := range entries {
+		entries[i] = libredis.Z{
+			Score:  float64(score),
+			Member: prefix + strconv.Itoa(i),
+		}
+	}
+
+	return entries, nil
+}
+
 // parseCountAndTTL parse count and ttl from lua script output.
-func parseCountAndTTL(cmd *libredis.Cmd) (int64, int64, error) {
+func parseCountAndTTL(ctx context.Context, cmd *libredis.Cmd) (int64, int64, error) {
 	result, err := cmd.Result()
 	if err != nil {
-		return 0, 0, errors.Wrap(err, "an error has occurred with redis command")
+		return 0, 0, wrapError(ctx, err)
 	}
 
 	fields, ok := result.([]interface{})
@@ -250,13 +738,14 @@ func parseCountAndTTL(cmd *libredis.Cmd) (int64, int64, error) {
 	return count, ttl, nil
 }
 
-func currentContext(cmd *libredis.Cmd, rate limiter.Rate) (limiter.Context, error) {
-	count, ttl, err := parseCountAndTTL(cmd)
+func currentContext(ctx context.Context, cmd *libredis.Cmd, rate limiter.Rate,
+	now time.Time) (limiter.Context, error) {
+
+	count, ttl, err := parseCountAndTTL(ctx, cmd)
 	if err != nil {
 		return limiter.Context{}, err
 	}
 
-	now := time.Now()
 	expiration := now.Add(rate.Period)
 	if ttl > 0 {
 		expiration = now.Add(time.Duration(ttl) * time.Millisecond)
@@ -2,6 +2,10 @@ package redis_test
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
 	"os"
 	"testing"
 	"time"
@@ -12,6 +16,7 @@ import (
 	"github.com/ulule/limiter/v3"
 	"github.com/ulule/limiter/v3/drivers/store/redis"
 	"github.com/ulule/limiter/v3/drivers/store/tests"
+	"github.com/ulule/limiter/v3/limitertest"
 )
 
 func TestRedisStoreSequentialAccess(t *testing.T) {
@@ -46,6 +51,155 @@ func TestRedisStoreConcurrentAccess(t *testing.T) {
 	tests.TestStoreConcurrentAccess(t, store)
 }
 
+func TestRedisStoreTokenBucket(t *testing.T) {
+	is := require.New(t)
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	store, err := redis.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: "limiter:redis:token-bucket-test",
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	tests.TestStoreTokenBucket(t, store)
+}
+
+func TestRedisStoreSlidingLog(t *testing.T) {
+	is := require.New(t)
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	clock := limitertest.NewFakeClock(time.Now())
+	store, err := redis.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: "limiter:redis:sliding-log-test",
+		Clock:  clock,
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	tests.TestStoreSlidingLog(t, store, clock)
+}
+
+func TestRedisStoreLabels(t *testing.T) {
+	is := require.New(t)
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	store, err := redis.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: "limiter:redis:labels-test",
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	tests.TestStoreLabels(t, store)
+
+	// The label expires with the counter.
+	ttl, err := client.PTTL(context.Background(), "limiter:redis:labels-test:foo#label").Result()
+	is.NoError(err)
+	is.Equal(time.Duration(-2), ttl)
+
+	_, err = limiter.New(store, limiter.Rate{Period: time.Minute, Limit: 3}).GetWithLabel(context.Background(),
+		"foo", "account:1")
+	is.NoError(err)
+
+	ttl, err = client.PTTL(context.Background(), "limiter:redis:labels-test:foo#label").Result()
+	is.NoError(err)
+	is.True(ttl > 59*time.Second && ttl <= time.Minute)
+}
+
+func TestRedisStoreRefund(t *testing.T) {
+	is := require.New(t)
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	store, err := redis.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: "limiter:redis:refund-test",
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	tests.TestStoreRefund(t, store)
+}
+
+func TestRedisStoreRefundExpiration(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	clock := limitertest.NewFakeClock(time.Now())
+	store, err := redis.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: "limiter:redis:refund-expiration-test",
+		Clock:  clock,
+	})
+	is.NoError(err)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(5),
+	}
+
+	_, err = store.Reset(ctx, "foo", rate)
+	is.NoError(err)
+
+	_, err = store.Increment(ctx, "foo", 5, rate)
+	is.NoError(err)
+
+	// Half of the window has elapsed.
+	is.NoError(client.PExpire(ctx, "limiter:redis:refund-expiration-test:foo", 30*time.Second).Err())
+
+	// An increment after a full refund keeps the window of the counter.
+	lctx, err := store.(limiter.RefundStore).Refund(ctx, "foo", 5, rate)
+	is.NoError(err)
+	is.Equal(int64(5), lctx.Remaining)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(4), lctx.Remaining)
+	is.True(lctx.Reset <= clock.Now().Add(30*time.Second).Unix())
+	is.True(lctx.Reset >= clock.Now().Add(29*time.Second).Unix())
+
+	ttl, err := client.PTTL(ctx, "limiter:redis:refund-expiration-test:foo").Result()
+	is.NoError(err)
+	is.True(ttl > 0 && ttl <= 30*time.Second)
+}
+
+func TestRedisStoreResetNamespace(t *testing.T) {
+	is := require.New(t)
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	store, err := redis.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: "limiter:redis:namespace-test",
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	tests.TestStoreResetNamespace(t, store)
+
+	// Hash-tagged keys are matched too.
+	store, err = redis.NewClusterStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: "limiter:redis:cluster-namespace-test",
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	tests.TestStoreResetNamespace(t, store)
+}
+
 func TestRedisClientExpiration(t *testing.T) {
 	is := require.New(t)
 
@@ -92,6 +246,357 @@ func TestRedisClientExpiration(t *testing.T) {
 	is.Greater(actual, expected)
 }
 
+func TestRedisClusterStoreSequentialAccess(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	store, err := redis.NewClusterStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: "limiter:redis:cluster-sequential-test",
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	tests.TestStoreSequentialAccess(t, store)
+
+	// Keys must be hash-tagged with the limiter key.
+	_, err = store.Get(ctx, "bar", limiter.Rate{Limit: 10, Period: time.Minute})
+	is.NoError(err)
+
+	exists, err := client.Exists(ctx, "limiter:redis:cluster-sequential-test:{bar}").Result()
+	is.NoError(err)
+	is.Equal(int64(1), exists)
+}
+
+func TestRedisStoreClients(t *testing.T) {
+	is := require.New(t)
+
+	// Every redis connection mode must be supported.
+	var client libredis.UniversalClient = libredis.NewUniversalClient(&libredis.UniversalOptions{
+		Addrs: []string{"localhost:6379"},
+	})
+	is.Implements((*redis.Client)(nil), client)
+	is.Implements((*redis.Client)(nil), &libredis.Client{})
+	is.Implements((*redis.Client)(nil), &libredis.ClusterClient{})
+	is.Implements((*redis.Client)(nil), &libredis.Ring{})
+}
+
+func TestRedisStoreContextCancellation(t *testing.T) {
+	is := require.New(t)
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	store, err := redis.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: "limiter:redis:context-test",
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	tests.TestStoreContextCancellation(t, store)
+}
+
+func TestRedisStoreContextDeadline(t *testing.T) {
+	is := require.New(t)
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	// A server accepting connections but never replying, to hang the increment.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	unresponsive := libredis.NewClient(&libredis.Options{
+		Addr:                  listener.Addr().String(),
+		ContextTimeoutEnabled: true,
+		MaxRetries:            -1,
+	})
+	defer unresponsive.Close()
+
+	store, err := redis.NewStoreWithOptions(&unresponsiveClient{Client: client, unresponsive: unresponsive},
+		limiter.StoreOptions{
+			Prefix: "limiter:redis:context-deadline-test",
+		})
+	is.NoError(err)
+	is.NotNil(store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = store.Get(ctx, "foo", limiter.Rate{Limit: 10, Period: time.Minute})
+	is.Equal(context.DeadlineExceeded, err)
+	is.Less(int64(time.Since(start)), int64(time.Second))
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	rate := limiter.Rate{Limit: 10, Period: time.Minute}
+
+	// A server accepting connections but never replying, to time out reads.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	// An address refusing connections.
+	refusing, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoError(err)
+	is.NoError(refusing.Close())
+
+	newStore := func(options *libredis.Options) limiter.Store {
+		options.MaxRetries = -1
+		unresponsive := libredis.NewClient(options)
+		t.Cleanup(func() {
+			_ = unresponsive.Close()
+		})
+
+		store, err := redis.NewStoreWithOptions(&unresponsiveClient{Client: client, unresponsive: unresponsive},
+			limiter.StoreOptions{
+				Prefix: "limiter:redis:errors-test",
+			})
+		is.NoError(err)
+		return store
+	}
+
+	// Scenario #1 : Server doesn't reply in time.
+	store := newStore(&libredis.Options{
+		Addr:        listener.Addr().String(),
+		ReadTimeout: 50 * time.Millisecond,
+	})
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreTimeout))
+	is.False(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	var storeErr *limiter.StoreError
+	is.True(errors.As(err, &storeErr))
+	is.Equal(limiter.ErrStoreTimeout, storeErr.Kind)
+
+	var netErr net.Error
+	is.True(errors.As(err, &netErr))
+	is.True(netErr.Timeout())
+
+	// Scenario #2 : Server refuses connections.
+	store = newStore(&libredis.Options{
+		Addr: refusing.Addr().String(),
+	})
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+	is.False(errors.Is(err, limiter.ErrStoreTimeout))
+
+	// Scenario #3 : Client is closed.
+	unresponsive := libredis.NewClient(&libredis.Options{
+		Addr: listener.Addr().String(),
+	})
+	is.NoError(unresponsive.Close())
+
+	store, err = redis.NewStoreWithOptions(&unresponsiveClient{Client: client, unresponsive: unresponsive},
+		limiter.StoreOptions{
+			Prefix: "limiter:redis:errors-test",
+		})
+	is.NoError(err)
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+	is.True(errors.Is(err, libredis.ErrClosed))
+}
+
+// unresponsiveClient is a redis client sending lua scripts evaluations to an unresponsive server.
+type unresponsiveClient struct {
+	*libredis.Client
+	unresponsive *libredis.Client
+}
+
+func (client *unresponsiveClient) EvalSha(ctx context.Context, sha string, keys []string,
+	args ...interface{}) *libredis.Cmd {
+
+	return client.unresponsive.EvalSha(ctx, sha, keys, args...)
+}
+
+func TestRedisStoreIncrementTTL(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	prefix := "limiter:redis:ttl-test"
+	store, err := redis.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: prefix,
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	rate := limiter.Rate{
+		Limit:  10,
+		Period: time.Minute,
+	}
+
+	scenarios := []struct {
+		key       string
+		increment func(key string) (limiter.Context, error)
+	}{
+		{
+			// Scenario #1 : first increment with Get.
+			key: "get",
+			increment: func(key string) (limiter.Context, error) {
+				return store.Get(ctx, key, rate)
+			},
+		},
+		{
+			// Scenario #2 : first increment with Increment.
+			key: "increment",
+			increment: func(key string) (limiter.Context, error) {
+				return store.Increment(ctx, key, 5, rate)
+			},
+		},
+	}
+
+	for i, scenario := range scenarios {
+		key := prefix + ":" + scenario.key
+
+		_, err = client.Del(ctx, key).Result()
+		is.NoError(err)
+
+		_, err = scenario.increment(scenario.key)
+		is.NoError(err, "scenario #%d", i+1)
+
+		ttl, err := client.PTTL(ctx, key).Result()
+		is.NoError(err, "scenario #%d", i+1)
+		is.Greater(int64(ttl), int64(0), "scenario #%d", i+1)
+		is.LessOrEqual(int64(ttl), int64(rate.Period), "scenario #%d", i+1)
+	}
+}
+
+func TestRedisStoreGetWithTTL(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	prefix := "limiter:redis:get-with-ttl-test"
+	store, err := redis.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: prefix,
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	instance := limiter.New(store, limiter.Rate{
+		Limit:  10,
+		Period: time.Hour,
+	})
+
+	scenarios := []struct {
+		key string
+		ttl time.Duration
+	}{
+		{
+			// Scenario #1 : short TTL.
+			key: "login",
+			ttl: 15 * time.Minute,
+		},
+		{
+			// Scenario #2 : long TTL.
+			key: "api",
+			ttl: time.Hour,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		key := prefix + ":" + scenario.key
+
+		_, err = client.Del(ctx, key).Result()
+		is.NoError(err)
+
+		lctx, err := instance.GetWithTTL(ctx, scenario.key, scenario.ttl)
+		is.NoError(err, "scenario #%d", i+1)
+		is.Equal(int64(9), lctx.Remaining, "scenario #%d", i+1)
+
+		ttl, err := client.PTTL(ctx, key).Result()
+		is.NoError(err, "scenario #%d", i+1)
+		is.Greater(int64(ttl), int64(scenario.ttl-time.Minute), "scenario #%d", i+1)
+		is.LessOrEqual(int64(ttl), int64(scenario.ttl), "scenario #%d", i+1)
+	}
+}
+
+func TestRedisStoreScriptFlush(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	client, err := newRedisClient()
+	is.NoError(err)
+	is.NotNil(client)
+
+	store, err := redis.NewStoreWithOptions(client, limiter.StoreOptions{
+		Prefix: "limiter:redis:script-flush-test",
+	})
+	is.NoError(err)
+	is.NotNil(store)
+
+	rate := limiter.Rate{
+		Limit:  10,
+		Period: time.Minute,
+	}
+
+	_, err = store.Reset(ctx, "foo", rate)
+	is.NoError(err)
+
+	lctx, err := store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	// Scripts must be loaded again on NOSCRIPT error.
+	_, err = client.ScriptFlush(ctx).Result()
+	is.NoError(err)
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(8), lctx.Remaining)
+
+	_, err = client.ScriptFlush(ctx).Result()
+	is.NoError(err)
+
+	lctx, err = store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(8), lctx.Remaining)
+}
+
 func BenchmarkRedisStoreSequentialAccess(b *testing.B) {
 	is := require.New(b)
 
@@ -124,6 +629,88 @@ func BenchmarkRedisStoreConcurrentAccess(b *testing.B) {
 	tests.BenchmarkStoreConcurrentAccess(b, store)
 }
 
+func TestRedisStoreConnection(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	// Scenario #1 : Unix socket, with ACL authentication.
+	client, err := redis.NewClient(redis.ConnectionOptions{
+		Network:  redis.NetworkUnix,
+		Addr:     "/run/redis/redis.sock",
+		Username: "limiter",
+		Password: "secret",
+		DB:       2,
+	})
+	is.NoError(err)
+	is.Equal("unix", client.Options().Network)
+	is.Equal("/run/redis/redis.sock", client.Options().Addr)
+	is.Equal("limiter", client.Options().Username)
+	is.Equal("secret", client.Options().Password)
+	is.Equal(2, client.Options().DB)
+	is.Nil(client.Options().TLSConfig)
+	is.NoError(client.Close())
+
+	// Scenario #2 : TLS, over TCP by default.
+	config := &tls.Config{ServerName: "redis.example.com", MinVersion: tls.VersionTLS12}
+	client, err = redis.NewClient(redis.ConnectionOptions{
+		Addr:      "redis.example.com:6380",
+		TLSConfig: config,
+	})
+	is.NoError(err)
+	is.Equal("tcp", client.Options().Network)
+	is.Equal("redis.example.com:6380", client.Options().Addr)
+	is.Equal(config, client.Options().TLSConfig)
+	is.NoError(client.Close())
+
+	// Scenario #3 : Invalid options.
+	_, err = redis.NewClient(redis.ConnectionOptions{Network: "udp", Addr: "127.0.0.1:6379"})
+	is.Error(err)
+
+	_, err = redis.NewClient(redis.ConnectionOptions{Network: redis.NetworkUnix})
+	is.Error(err)
+
+	// Scenario #4 : Store connected with options, closing its client.
+	uri := "redis://localhost:6379/0"
+	if os.Getenv("REDIS_URI") != "" {
+		uri = os.Getenv("REDIS_URI")
+	}
+	opt, err := libredis.ParseURL(uri)
+	is.NoError(err)
+
+	store, err := redis.NewStoreWithConnection(redis.ConnectionOptions{
+		Addr:     opt.Addr,
+		Username: opt.Username,
+		Password: opt.Password,
+		DB:       opt.DB,
+	}, limiter.StoreOptions{
+		Prefix: "limiter:redis:connection-test",
+	})
+	is.NoError(err)
+
+	rate := limiter.Rate{Period: time.Minute, Limit: 10}
+	_, err = store.Reset(ctx, "foo", rate)
+	is.NoError(err)
+
+	lctx, err := store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	is.NoError(store.(io.Closer).Close())
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	// Stores with a given client don't close it.
+	client, err = newRedisClient()
+	is.NoError(err)
+	defer client.Close()
+
+	store, err = redis.NewStore(client)
+	is.NoError(err)
+	is.NoError(store.(io.Closer).Close())
+	is.NoError(client.Ping(ctx).Err())
+}
+
 func newRedisClient() (*libredis.Client, error) {
 	uri := "redis://localhost:6379/0"
 	if os.Getenv("REDIS_URI") != "" {
@@ -0,0 +1,209 @@
+package sql
+
+import (
+	"context"
+	libsql "database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/common"
+)
+
+const (
+	// incrementQuery creates the counter of a key, or increments it if it's not expired.
+	// Expressions of the SET clause refer to the existing row, so both columns are reset together once expired.
+	incrementQuery = `INSERT INTO %[1]s (key, count, expiration) VALUES ($1, $2, $3)
+ON CONFLICT (key) DO UPDATE SET
+count = CASE WHEN %[1]s.expiration <= $4 THEN EXCLUDED.count ELSE %[1]s.count + EXCLUDED.count END,
+expiration = CASE WHEN %[1]s.expiration <= $4 THEN EXCLUDED.expiration ELSE %[1]s.expiration END
+RETURNING count, expiration`
+	// peekQuery returns the counter of a key.
+	peekQuery = `SELECT count, expiration FROM %s WHERE key = $1`
+	// resetQuery deletes the counter of a key.
+	resetQuery = `DELETE FROM %s WHERE key = $1`
+	// cleanQuery deletes expired counters.
+	cleanQuery = `DELETE FROM %s WHERE expiration <= $1`
+)
+
+// Store is the SQL store, such as for PostgreSQL (or any database supporting "INSERT ... ON CONFLICT DO UPDATE"
+// and "RETURNING" with "$n" placeholders, like CockroachDB or SQLite 3.35+).
+//
+// The table must be created beforehand, such as:
+//
+//	CREATE TABLE limiter (
+//	    key        TEXT PRIMARY KEY,
+//	    count      BIGINT NOT NULL,
+//	    expiration TIMESTAMPTZ NOT NULL
+//	);
+//
+// Counters are created or incremented with a single upsert statement, which locks the row of the key: the default
+// READ COMMITTED isolation level is required and sufficient to avoid lost updates. Don't use this store with a
+// connection defaulting to REPEATABLE READ or SERIALIZABLE, since concurrent upserts of a key would then fail with
+// serialization errors instead of waiting for each other.
+type Store struct {
+	// Prefix used for the key.
+	Prefix string
+	// Table is the name of the table, used verbatim in queries: it must be a trusted identifier.
+	Table string
+	// db used to communicate with the database.
+	db *libsql.DB
+	// clock used to obtain the current time.
+	clock limiter.Clock
+	// queries with the table name.
+	increment string
+	peek      string
+	reset     string
+	clean     string
+	// stop is closed to stop the cleaner goroutine.
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewStore returns an instance of SQL store with defaults, using given table.
+func NewStore(db *libsql.DB, table string) (limiter.Store, error) {
+	return NewStoreWithOptions(db, table, limiter.StoreOptions{
+		Prefix:          limiter.DefaultPrefix,
+		CleanUpInterval: limiter.DefaultCleanUpInterval,
+	})
+}
+
+// NewStoreWithOptions returns an instance of SQL store with options, using given table.
+// If CleanUpInterval is positive, expired counters are periodically deleted until the store is closed.
+func NewStoreWithOptions(db *libsql.DB, table string, options limiter.StoreOptions) (limiter.Store, error) {
+	if db == nil {
+		return nil, errors.New("sql database is required")
+	}
+	if table == "" {
+		return nil, errors.New("sql table is required")
+	}
+
+	clock := options.Clock
+	if clock == nil {
+		clock = limiter.SystemClock{}
+	}
+
+	store := &Store{
+		Prefix:    options.Prefix,
+		Table:     table,
+		db:        db,
+		clock:     clock,
+		increment: fmt.Sprintf(incrementQuery, table),
+		peek:      fmt.Sprintf(peekQuery, table),
+		reset:     fmt.Sprintf(resetQuery, table),
+		clean:     fmt.Sprintf(cleanQuery, table),
+		stop:      make(chan struct{}),
+	}
+
+	if options.CleanUpInterval > 0 {
+		go store.runCleaner(options.CleanUpInterval)
+	}
+
+	return store, nil
+}
+
+// Get returns the limit for given identifier.
+func (store *Store) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return store.Increment(ctx, key, 1, rate)
+}
+
+// Increment increments the limit by given count & gives back the new limit for given identifier.
+func (store *Store) Increment(ctx context.Context, key string, count int64, rate limiter.Rate) (limiter.Context, error) {
+	now := store.clock.Now()
+
+	var (
+		value      int64
+		expiration time.Time
+	)
+
+	row := store.db.QueryRowContext(ctx, store.increment, store.getKey(key), count, now.Add(rate.Period), now)
+	err := row.Scan(&value, &expiration)
+	if err != nil {
+		return limiter.Context{}, wrapError(ctx, err, "failed to increment counter")
+	}
+
+	return common.GetContextFromState(now, rate, expiration, value), nil
+}
+
+// Peek returns the limit for given identifier, without modification on current values.
+func (store *Store) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	now := store.clock.Now()
+
+	var (
+		value      int64
+		expiration time.Time
+	)
+
+	err := store.db.QueryRowContext(ctx, store.peek, store.getKey(key)).Scan(&value, &expiration)
+	if err == libsql.ErrNoRows || (err == nil && !expiration.After(now)) {
+		return common.GetContextFromState(now, rate, now.Add(rate.Period), 0), nil
+	}
+	if err != nil {
+		return limiter.Context{}, wrapError(ctx, err, "failed to peek counter")
+	}
+
+	return common.GetContextFromState(now, rate, expiration, value), nil
+}
+
+// Reset returns the limit for given identifier which is set to zero.
+func (store *Store) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	now := store.clock.Now()
+
+	_, err := store.db.ExecContext(ctx, store.reset, store.getKey(key))
+	if err != nil {
+		return limiter.Context{}, wrapError(ctx, err, "failed to reset counter")
+	}
+
+	return common.GetContextFromState(now, rate, now.Add(rate.Period), 0), nil
+}
+
+// Clean deletes expired counters.
+func (store *Store) Clean(ctx context.Context) error {
+	_, err := store.db.ExecContext(ctx, store.clean, store.clock.Now())
+	if err != nil {
+		return wrapError(ctx, err, "failed to clean expired counters")
+	}
+	return nil
+}
+
+// Close stops the goroutine cleaning expired counters of this store. It doesn't close the database.
+func (store *Store) Close() error {
+	store.once.Do(func() {
+		close(store.stop)
+	})
+	return nil
+}
+
+// runCleaner periodically deletes expired counters until the store is closed.
+// Cleanup errors are ignored: expired counters are kept until the next successful cleanup.
+func (store *Store) runCleaner(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = store.Clean(context.Background())
+		case <-store.stop:
+			return
+		}
+	}
+}
+
+// getKey returns the stored key of given identifier.
+func (store *Store) getKey(key string) string {
+	return store.Prefix + ":" + key
+}
+
+// wrapError returns the context error if given context is done, or given error wrapped with given message otherwise.
+// Network failures and broken or closed connections are classified as store errors (see limiter.StoreError).
+func wrapError(ctx context.Context, err error, message string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return common.WrapError(err, message, driver.ErrBadConn, libsql.ErrConnDone)
+}
@@ -0,0 +1,251 @@
+package sql_test
+
+import (
+	"context"
+	libsql "database/sql"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/sql"
+	"github.com/ulule/limiter/v3/drivers/store/tests"
+	"github.com/ulule/limiter/v3/limitertest"
+)
+
+const (
+	incrementQuery = `INSERT INTO limiter \(key, count, expiration\) VALUES \(\$1, \$2, \$3\) ` +
+		`ON CONFLICT \(key\) DO UPDATE SET ` +
+		`count = CASE WHEN limiter.expiration <= \$4 THEN EXCLUDED.count ELSE limiter.count \+ EXCLUDED.count END, ` +
+		`expiration = CASE WHEN limiter.expiration <= \$4 THEN EXCLUDED.expiration ELSE limiter.expiration END ` +
+		`RETURNING count, expiration`
+	peekQuery  = `SELECT count, expiration FROM limiter WHERE key = \$1`
+	resetQuery = `DELETE FROM limiter WHERE key = \$1`
+	cleanQuery = `DELETE FROM limiter WHERE expiration <= \$1`
+)
+
+var now = time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+var rate = limiter.Rate{
+	Period: 1 * time.Minute,
+	Limit:  int64(10),
+}
+
+func newStore(t *testing.T) (limiter.Store, sqlmock.Sqlmock) {
+	is := require.New(t)
+
+	db, mock, err := sqlmock.New()
+	is.NoError(err)
+	t.Cleanup(func() {
+		is.NoError(mock.ExpectationsWereMet())
+		_ = db.Close()
+	})
+
+	store, err := sql.NewStoreWithOptions(db, "limiter", limiter.StoreOptions{
+		Prefix: "limiter:sql",
+		Clock:  limitertest.NewFakeClock(now),
+	})
+	is.NoError(err)
+
+	return store, mock
+}
+
+func TestSQLStoreClient(t *testing.T) {
+	is := require.New(t)
+
+	db, _, err := sqlmock.New()
+	is.NoError(err)
+	defer db.Close()
+
+	_, err = sql.NewStore(nil, "limiter")
+	is.Error(err)
+
+	_, err = sql.NewStore(db, "")
+	is.Error(err)
+
+	store, err := sql.NewStore(db, "limiter")
+	is.NoError(err)
+	is.NoError(store.(io.Closer).Close())
+	is.NoError(store.(io.Closer).Close())
+}
+
+func TestSQLStoreIncrement(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	store, mock := newStore(t)
+
+	// Scenario #1 : Counter created or incremented.
+	mock.ExpectQuery(incrementQuery).
+		WithArgs("limiter:sql:foo", int64(3), now.Add(rate.Period), now).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "expiration"}).AddRow(int64(3), now.Add(rate.Period)))
+
+	lctx, err := store.Increment(ctx, "foo", 3, rate)
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Limit)
+	is.Equal(int64(7), lctx.Remaining)
+	is.Equal(now.Add(rate.Period).Unix(), lctx.Reset)
+	is.False(lctx.Reached)
+
+	// Scenario #2 : Existing counter keeps its expiration.
+	mock.ExpectQuery(incrementQuery).
+		WithArgs("limiter:sql:foo", int64(1), now.Add(rate.Period), now).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "expiration"}).AddRow(int64(11), now.Add(30*time.Second)))
+
+	lctx, err = store.Get(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(0), lctx.Remaining)
+	is.Equal(now.Add(30*time.Second).Unix(), lctx.Reset)
+	is.True(lctx.Reached)
+
+	// Scenario #3 : Database error.
+	mock.ExpectQuery(incrementQuery).WillReturnError(errors.New("connection refused"))
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.Error(err)
+	is.Contains(err.Error(), "connection refused")
+}
+
+func TestSQLStorePeek(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	store, mock := newStore(t)
+
+	// Scenario #1 : Missing counter.
+	mock.ExpectQuery(peekQuery).
+		WithArgs("limiter:sql:foo").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "expiration"}))
+
+	lctx, err := store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+	is.Equal(now.Add(rate.Period).Unix(), lctx.Reset)
+
+	// Scenario #2 : Expired counter, not yet cleaned.
+	mock.ExpectQuery(peekQuery).
+		WithArgs("limiter:sql:foo").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "expiration"}).AddRow(int64(5), now.Add(-time.Second)))
+
+	lctx, err = store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+	is.Equal(now.Add(rate.Period).Unix(), lctx.Reset)
+
+	// Scenario #3 : Live counter.
+	mock.ExpectQuery(peekQuery).
+		WithArgs("limiter:sql:foo").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "expiration"}).AddRow(int64(4), now.Add(20*time.Second)))
+
+	lctx, err = store.Peek(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(6), lctx.Remaining)
+	is.Equal(now.Add(20*time.Second).Unix(), lctx.Reset)
+
+	// Scenario #4 : Database error.
+	mock.ExpectQuery(peekQuery).WillReturnError(errors.New("connection refused"))
+
+	_, err = store.Peek(ctx, "foo", rate)
+	is.Error(err)
+}
+
+func TestSQLStoreReset(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	store, mock := newStore(t)
+
+	mock.ExpectExec(resetQuery).
+		WithArgs("limiter:sql:foo").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	lctx, err := store.Reset(ctx, "foo", rate)
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+	is.Equal(now.Add(rate.Period).Unix(), lctx.Reset)
+}
+
+func TestSQLStoreClean(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	store, mock := newStore(t)
+
+	mock.ExpectExec(cleanQuery).
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 42))
+
+	is.NoError(store.(*sql.Store).Clean(ctx))
+
+	// Expired counters are periodically cleaned.
+	db, mock, err := sqlmock.New()
+	is.NoError(err)
+	defer db.Close()
+
+	mock.ExpectExec(cleanQuery).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	store, err = sql.NewStoreWithOptions(db, "limiter", limiter.StoreOptions{
+		Prefix:          "limiter:sql",
+		CleanUpInterval: 10 * time.Millisecond,
+	})
+	is.NoError(err)
+
+	is.Eventually(func() bool {
+		return mock.ExpectationsWereMet() == nil
+	}, time.Second, 10*time.Millisecond)
+	is.NoError(store.(io.Closer).Close())
+}
+
+func TestSQLStoreContextCancellation(t *testing.T) {
+	store, _ := newStore(t)
+
+	tests.TestStoreContextCancellation(t, store)
+}
+
+func TestSQLStoreErrors(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	store, mock := newStore(t)
+
+	// Scenario #1 : Connection is reset.
+	mock.ExpectQuery(incrementQuery).
+		WillReturnError(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET})
+
+	_, err := store.Get(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+	is.True(errors.Is(err, syscall.ECONNRESET))
+
+	// Scenario #2 : Connection is already closed.
+	mock.ExpectExec(resetQuery).WillReturnError(libsql.ErrConnDone)
+
+	_, err = store.Reset(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+
+	// Scenario #3 : Read deadline is exceeded.
+	mock.ExpectQuery(peekQuery).
+		WillReturnError(&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded})
+
+	_, err = store.Peek(ctx, "foo", rate)
+	is.True(errors.Is(err, limiter.ErrStoreTimeout))
+
+	var storeErr *limiter.StoreError
+	is.True(errors.As(err, &storeErr))
+	is.Equal(limiter.ErrStoreTimeout, storeErr.Kind)
+
+	// Scenario #4 : Query errors aren't classified.
+	mock.ExpectQuery(incrementQuery).WillReturnError(errors.New(`relation "limiter" does not exist`))
+
+	_, err = store.Get(ctx, "foo", rate)
+	is.Error(err)
+	is.False(errors.As(err, &storeErr))
+}
@@ -2,13 +2,17 @@ package tests
 
 import (
 	"context"
+	"fmt"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/limitertest"
 )
 
 // TestStoreSequentialAccess verify that store works as expected with a sequential access.
@@ -115,6 +119,370 @@ func TestStoreSequentialAccess(t *testing.T, store limiter.Store) {
 	}
 }
 
+// TestStoreTokenBucket verify that store works as expected with the token bucket algorithm.
+func TestStoreTokenBucket(t *testing.T, store limiter.Store) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	limiter := limiter.New(store, limiter.Rate{
+		Limit:  10,
+		Period: time.Second,
+	}, limiter.WithStoreMode(limiter.StoreModeTokenBucket), limiter.WithBurst(3))
+
+	// Check burst.
+	{
+		for i := 1; i <= 4; i++ {
+			lctx, err := limiter.Get(ctx, "foo")
+			is.NoError(err)
+			is.Equal(int64(3), lctx.Limit)
+			is.True((lctx.Reset - time.Now().Unix()) <= 1)
+
+			if i <= 3 {
+				is.Equal(int64(3-i), lctx.Remaining)
+				is.False(lctx.Reached)
+			} else {
+				is.Equal(int64(0), lctx.Remaining)
+				is.True(lctx.Reached)
+			}
+		}
+
+		lctx, err := limiter.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(0), lctx.Remaining)
+	}
+
+	// Check refill.
+	{
+		time.Sleep(250 * time.Millisecond)
+
+		lctx, err := limiter.Peek(ctx, "foo")
+		is.NoError(err)
+		is.True(lctx.Remaining >= 2)
+
+		lctx, err = limiter.Get(ctx, "foo")
+		is.NoError(err)
+		is.False(lctx.Reached)
+	}
+
+	// Check reset.
+	{
+		lctx, err := limiter.Reset(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(3), lctx.Remaining)
+		is.False(lctx.Reached)
+
+		lctx, err = limiter.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(3), lctx.Remaining)
+	}
+}
+
+// TestStoreSlidingLog verify that store counts requests exactly with the sliding log algorithm.
+// The store must use given clock.
+func TestStoreSlidingLog(t *testing.T, store limiter.Store, clock *limitertest.FakeClock) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	start := time.Unix(clock.Now().Unix(), 0)
+	clock.Set(start)
+
+	limiter := limiter.New(store, limiter.Rate{
+		Limit:  3,
+		Period: time.Minute,
+	}, limiter.WithStoreMode(limiter.StoreModeSlidingLog), limiter.WithClock(clock))
+
+	// Start from a clean log, since persistent stores may keep requests of previous runs.
+	_, err := limiter.Reset(ctx, "foo")
+	is.NoError(err)
+
+	// Check requests within the window.
+	{
+		lctx, err := limiter.Get(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(3), lctx.Limit)
+		is.Equal(int64(2), lctx.Remaining)
+		is.False(lctx.Reached)
+
+		clock.Advance(30 * time.Second)
+
+		lctx, err = limiter.Get(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(1), lctx.Remaining)
+
+		lctx, err = limiter.Get(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(0), lctx.Remaining)
+		is.False(lctx.Reached)
+		is.Equal(start.Add(time.Minute).Unix(), lctx.Reset)
+	}
+
+	// Check window edges: a request leaves the log exactly one period after it has been logged.
+	{
+		clock.Set(start.Add(time.Minute - time.Millisecond))
+
+		lctx, err := limiter.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(0), lctx.Remaining)
+
+		clock.Set(start.Add(time.Minute))
+
+		lctx, err = limiter.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(1), lctx.Remaining)
+
+		// A fixed window would allow 3 requests again, but only the first one has left the log.
+		lctx, err = limiter.Get(ctx, "foo")
+		is.NoError(err)
+		is.False(lctx.Reached)
+
+		lctx, err = limiter.Get(ctx, "foo")
+		is.NoError(err)
+		is.True(lctx.Reached)
+		is.Equal(start.Add(90*time.Second).Unix(), lctx.Reset)
+
+		clock.Set(start.Add(90*time.Second - time.Millisecond))
+
+		lctx, err = limiter.Peek(ctx, "foo")
+		is.NoError(err)
+		is.True(lctx.Reached)
+
+		clock.Set(start.Add(90 * time.Second))
+
+		lctx, err = limiter.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(1), lctx.Remaining)
+		is.False(lctx.Reached)
+	}
+
+	// Check that only the most recent requests are kept.
+	{
+		lctx, err := limiter.Increment(ctx, "foo", 100)
+		is.NoError(err)
+		is.True(lctx.Reached)
+
+		clock.Advance(time.Minute)
+
+		lctx, err = limiter.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(3), lctx.Remaining)
+	}
+
+	// Check reset.
+	{
+		_, err := limiter.Get(ctx, "foo")
+		is.NoError(err)
+
+		lctx, err := limiter.Reset(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(3), lctx.Remaining)
+
+		lctx, err = limiter.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(3), lctx.Remaining)
+	}
+}
+
+// TestStoreLabels verify that store attaches labels to counters, implementing limiter.LabelStore.
+func TestStoreLabels(t *testing.T, store limiter.Store) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	limiter := limiter.New(store, limiter.Rate{
+		Limit:  3,
+		Period: time.Minute,
+	})
+
+	// Start from clean counters, since persistent stores may keep counters of previous runs.
+	for _, key := range []string{"foo", "bar"} {
+		_, err := limiter.Reset(ctx, key)
+		is.NoError(err)
+	}
+
+	// Check label round-tripping.
+	{
+		label, err := limiter.GetLabel(ctx, "foo")
+		is.NoError(err)
+		is.Empty(label)
+
+		lctx, err := limiter.GetWithLabel(ctx, "foo", "account:42")
+		is.NoError(err)
+		is.Equal(int64(2), lctx.Remaining)
+
+		label, err = limiter.GetLabel(ctx, "foo")
+		is.NoError(err)
+		is.Equal("account:42", label)
+
+		label, err = limiter.GetLabel(ctx, "bar")
+		is.NoError(err)
+		is.Empty(label)
+	}
+
+	// Check that labelled and unlabelled requests share the counter.
+	{
+		lctx, err := limiter.Get(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(1), lctx.Remaining)
+
+		label, err := limiter.GetLabel(ctx, "foo")
+		is.NoError(err)
+		is.Equal("account:42", label)
+
+		lctx, err = limiter.GetWithLabel(ctx, "foo", "account:43")
+		is.NoError(err)
+		is.Equal(int64(0), lctx.Remaining)
+
+		label, err = limiter.GetLabel(ctx, "foo")
+		is.NoError(err)
+		is.Equal("account:43", label)
+	}
+
+	// Check reset.
+	{
+		_, err := limiter.Reset(ctx, "foo")
+		is.NoError(err)
+
+		label, err := limiter.GetLabel(ctx, "foo")
+		is.NoError(err)
+		is.Empty(label)
+	}
+}
+
+// TestStoreRefund verify that store refunds counters without going below zero, implementing limiter.RefundStore.
+func TestStoreRefund(t *testing.T, store limiter.Store) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	rate := limiter.Rate{
+		Limit:  5,
+		Period: time.Minute,
+	}
+
+	limiters := map[string]*limiter.Limiter{
+		"fixed:":   limiter.New(store, rate),
+		"sliding:": limiter.New(store, rate, limiter.WithStoreMode(limiter.StoreModeSliding)),
+	}
+
+	for namespace, instance := range limiters {
+		key := namespace + "foo"
+
+		// Start from a clean counter, since persistent stores may keep counters of previous runs.
+		_, err := instance.Reset(ctx, key)
+		is.NoError(err)
+
+		// Check that a missing counter is left untouched.
+		is.NoError(instance.Refund(ctx, key, 1))
+
+		lctx, err := instance.Peek(ctx, key)
+		is.NoError(err)
+		is.Equal(int64(5), lctx.Remaining, namespace)
+
+		// Check that a refund restores remaining.
+		_, err = instance.Increment(ctx, key, 5)
+		is.NoError(err)
+
+		lctx, err = instance.Get(ctx, key)
+		is.NoError(err)
+		is.True(lctx.Reached, namespace)
+
+		is.NoError(instance.Refund(ctx, key, 3))
+
+		lctx, err = instance.Get(ctx, key)
+		is.NoError(err)
+		is.False(lctx.Reached, namespace)
+		is.Equal(int64(1), lctx.Remaining, namespace)
+
+		// Check that a refund never underflows.
+		is.NoError(instance.Refund(ctx, key, 100))
+
+		lctx, err = instance.Peek(ctx, key)
+		is.NoError(err)
+		is.Equal(int64(5), lctx.Remaining, namespace)
+
+		lctx, err = instance.Get(ctx, key)
+		is.NoError(err)
+		is.Equal(int64(4), lctx.Remaining, namespace)
+	}
+}
+
+// TestStoreResetNamespace verify that store only resets the counters of identifiers starting with given prefix.
+func TestStoreResetNamespace(t *testing.T, store limiter.Store) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	rate := limiter.Rate{
+		Limit:  10,
+		Period: time.Minute,
+	}
+
+	// Counters of every store mode are reset, using a distinct namespace for each one.
+	limiters := map[string]*limiter.Limiter{
+		"fixed:":   limiter.New(store, rate),
+		"sliding:": limiter.New(store, rate, limiter.WithStoreMode(limiter.StoreModeSliding)),
+	}
+	if _, ok := store.(limiter.TokenBucketStore); ok {
+		limiters["tokens:"] = limiter.New(store, rate, limiter.WithStoreMode(limiter.StoreModeTokenBucket))
+	}
+	if _, ok := store.(limiter.SlidingLogStore); ok {
+		limiters["log:"] = limiter.New(store, rate, limiter.WithStoreMode(limiter.StoreModeSlidingLog))
+	}
+
+	keys := []string{"user:1", "user:2", "use", "admin:1", "a*1", "ab"}
+	for namespace, instance := range limiters {
+		// Start from a clean namespace, since persistent stores may keep counters of previous runs.
+		is.NoError(instance.ResetNamespace(ctx, namespace))
+
+		for _, key := range keys {
+			_, err := instance.Get(ctx, namespace+key)
+			is.NoError(err)
+		}
+	}
+
+	for namespace, instance := range limiters {
+		is.NoError(instance.ResetNamespace(ctx, namespace+"user:"))
+		// Special characters of patterns are matched literally.
+		is.NoError(instance.ResetNamespace(ctx, namespace+"a*"))
+	}
+
+	reset := map[string]bool{"user:1": true, "user:2": true, "a*1": true}
+	for namespace, instance := range limiters {
+		for _, key := range keys {
+			expected := int64(9)
+			if reset[key] {
+				expected = 10
+			}
+
+			lctx, err := instance.Peek(ctx, namespace+key)
+			is.NoError(err)
+			is.Equal(expected, lctx.Remaining, namespace+key)
+		}
+	}
+}
+
+// TestStoreContextCancellation verify that store returns the context error once it's cancelled.
+func TestStoreContextCancellation(t *testing.T, store limiter.Store) {
+	is := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	limiter := limiter.New(store, limiter.Rate{
+		Limit:  3,
+		Period: time.Minute,
+	})
+
+	_, err := limiter.Get(ctx, "foo")
+	is.Equal(context.Canceled, err)
+
+	_, err = limiter.Increment(ctx, "foo", 2)
+	is.Equal(context.Canceled, err)
+
+	_, err = limiter.Peek(ctx, "foo")
+	is.Equal(context.Canceled, err)
+
+	_, err = limiter.Reset(ctx, "foo")
+	is.Equal(context.Canceled, err)
+}
+
 // TestStoreConcurrentAccess verify that store works as expected with a concurrent access.
 func TestStoreConcurrentAccess(t *testing.T, store limiter.Store) {
 	is := require.New(t)
@@ -174,3 +542,36 @@ func BenchmarkStoreConcurrentAccess(b *testing.B, store limiter.Store) {
 		}
 	})
 }
+
+// BenchmarkStoreDistinctKeysAccess executes a benchmark against a store with given number of distinct keys
+// and goroutines.
+func BenchmarkStoreDistinctKeysAccess(b *testing.B, store limiter.Store, keys int, goroutines int) {
+	ctx := context.Background()
+
+	instance := limiter.New(store, limiter.Rate{
+		Limit:  100000,
+		Period: 10 * time.Second,
+	})
+
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = fmt.Sprintf("foo-%d", i)
+	}
+
+	parallelism := goroutines / runtime.GOMAXPROCS(0)
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var counter uint64
+
+	b.SetParallelism(parallelism)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := atomic.AddUint64(&counter, 1) * 7919
+		for pb.Next() {
+			_, _ = instance.Get(ctx, names[i%uint64(keys)])
+			i++
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package limiter
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DefaultSkipMethods defines the default HTTP methods which are not limited by middlewares: CORS preflight requests
+// shouldn't consume the quota of browser clients.
+var DefaultSkipMethods = []string{http.MethodOptions}
+
+// Handler returns a net/http handler limiting requests before calling given handler.
+// The key of a request is obtained with GetKey, and its rate with GetRate.
+// Requests whose method is in SkipMethods, whose path is in SkipPaths, whose IP is allowlisted, or bypassed by
+// BypassFunc, are not limited.
+// Rejected requests receive a 429 status code, with rate limit headers.
+// With CountStatusCodes, requests are only counted once handled, if their response status is one of them.
+// With WebSocketRate, WebSocket handshakes are counted before calling the handler, which may hijack the connection.
+func (limiter *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter.IsMethodSkipped(r.Method) || limiter.IsPathSkipped(r) || limiter.IsAllowlisted(r) ||
+			limiter.IsBypassed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if limiter.IsDenylisted(r) {
+			http.Error(w, "Limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		key := limiter.GetKey(r)
+		context, err := limiter.check(r, key)
+		if err != nil && !context.Reached {
+			limiter.serve(next, w, r, key)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if limiter.Options.DryRun {
+			limiter.serve(next, w, r, key)
+			return
+		}
+
+		for name, value := range limiter.GetHeaders(context, HeaderStyleLegacy) {
+			w.Header().Add(name, value)
+		}
+
+		if context.Reached {
+			http.Error(w, "Limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		limiter.serve(next, w, r, key)
+	})
+}
+
+// check returns the limit for given request and key before calling the handler: it's incremented, unless responses
+// are counted once handled with CountStatusCodes, except for WebSocket handshakes.
+func (limiter *Limiter) check(r *http.Request, key string) (Context, error) {
+	if len(limiter.Options.CountStatusCodes) > 0 && !limiter.IsWebSocketHandshake(r) {
+		return limiter.CheckWithRequest(r, key)
+	}
+	return limiter.GetWithRequest(r, key)
+}
+
+// serve calls given handler, then counts its response against the limit of given key with CountStatusCodes.
+// Store errors are ignored since the response is already written.
+func (limiter *Limiter) serve(next http.Handler, w http.ResponseWriter, r *http.Request, key string) {
+	if len(limiter.Options.CountStatusCodes) == 0 || limiter.IsWebSocketHandshake(r) {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	writer := &statusWriter{ResponseWriter: w}
+	next.ServeHTTP(writer, r)
+	_, _ = limiter.CountWithRequest(r, key, writer.Status())
+}
+
+// IsWebSocketUpgrade returns if given request is a WebSocket upgrade handshake: its Connection header has the
+// "Upgrade" token, and its Upgrade header the "websocket" protocol, matched case-insensitively.
+func IsWebSocketUpgrade(r *http.Request) bool {
+	return hasHeaderToken(r.Header, "Connection", "upgrade") && hasHeaderToken(r.Header, "Upgrade", "websocket")
+}
+
+// IsWebSocketHandshake returns if given request is a WebSocket upgrade handshake limited with WebSocketRate.
+func (limiter *Limiter) IsWebSocketHandshake(r *http.Request) bool {
+	rate := limiter.Options.WebSocketRate
+	return (rate.Limit != 0 || rate.Period != 0) && IsWebSocketUpgrade(r)
+}
+
+// hasHeaderToken returns if one of the comma-separated values of given header is given token.
+func hasHeaderToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// statusWriter is a http.ResponseWriter recording the status of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records given status before sending it.
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 status if none was sent before writing given data.
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush sends buffered data to the client, if supported by the underlying http.ResponseWriter.
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the status of the response, which is 200 if the handler didn't send any.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// IsMethodSkipped returns if given HTTP method is in SkipMethods, matched case-insensitively.
+func (limiter *Limiter) IsMethodSkipped(method string) bool {
+	for _, skipped := range limiter.Options.SkipMethods {
+		if strings.EqualFold(method, skipped) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPathSkipped returns if the path of given request is in SkipPaths.
+func (limiter *Limiter) IsPathSkipped(r *http.Request) bool {
+	for _, path := range limiter.Options.SkipPaths {
+		if r.URL.Path == path {
+			return true
+		}
+	}
+	return false
+}
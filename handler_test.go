@@ -0,0 +1,216 @@
+package limiter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+)
+
+func TestLimiterHandler(t *testing.T) {
+	is := require.New(t)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, thr := w.Write([]byte("hello"))
+		if thr != nil {
+			panic(thr)
+		}
+	})
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: time.Minute,
+		Limit:  2,
+	}, limiter.WithSkipPaths("/healthz"), limiter.WithBypassFunc(func(r *http.Request) bool {
+		return r.Header.Get("X-API-Key") == "premium"
+	}))
+
+	server := httptest.NewServer(instance.Handler(handler))
+	defer server.Close()
+
+	for i := 1; i <= 3; i++ {
+		resp, err := http.Get(server.URL + "/foo")
+		is.NoError(err)
+		is.NoError(resp.Body.Close())
+
+		if i <= 2 {
+			is.Equal(http.StatusOK, resp.StatusCode)
+			is.Empty(resp.Header.Get("Retry-After"))
+		} else {
+			is.Equal(http.StatusTooManyRequests, resp.StatusCode)
+			is.NotEmpty(resp.Header.Get("Retry-After"))
+		}
+		is.Equal("2", resp.Header.Get("X-RateLimit-Limit"))
+	}
+
+	// Bypassed requests are not limited.
+	for i := 1; i <= 3; i++ {
+		request, err := http.NewRequest("GET", server.URL+"/foo", nil)
+		is.NoError(err)
+		request.Header.Set("X-API-Key", "premium")
+
+		resp, err := http.DefaultClient.Do(request)
+		is.NoError(err)
+		is.NoError(resp.Body.Close())
+		is.Equal(http.StatusOK, resp.StatusCode)
+		is.Empty(resp.Header.Get("X-RateLimit-Limit"))
+	}
+
+	// Skipped methods are not limited.
+	for i := 1; i <= 3; i++ {
+		request, err := http.NewRequest("OPTIONS", server.URL+"/foo", nil)
+		is.NoError(err)
+
+		resp, err := http.DefaultClient.Do(request)
+		is.NoError(err)
+		is.NoError(resp.Body.Close())
+		is.Equal(http.StatusOK, resp.StatusCode)
+		is.Empty(resp.Header.Get("X-RateLimit-Limit"))
+	}
+
+	// Skipped paths are not limited.
+	for i := 1; i <= 3; i++ {
+		resp, err := http.Get(server.URL + "/healthz")
+		is.NoError(err)
+		is.NoError(resp.Body.Close())
+		is.Equal(http.StatusOK, resp.StatusCode)
+		is.Empty(resp.Header.Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestLimiterHandlerCountStatusCodes(t *testing.T) {
+	is := require.New(t)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		_, thr := w.Write([]byte("hello"))
+		if thr != nil {
+			panic(thr)
+		}
+	})
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: time.Minute,
+		Limit:  1,
+	}, limiter.WithCountStatusCodes(http.StatusUnauthorized))
+
+	server := httptest.NewServer(instance.Handler(handler))
+	defer server.Close()
+
+	scenarios := []struct {
+		path string
+		code int
+	}{
+		{
+			// Scenario #1 : successful response isn't counted.
+			path: "/foo",
+			code: http.StatusOK,
+		},
+		{
+			// Scenario #2 : failed response is counted.
+			path: "/fail",
+			code: http.StatusUnauthorized,
+		},
+		{
+			// Scenario #3 : limit is reached.
+			path: "/foo",
+			code: http.StatusTooManyRequests,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		resp, err := http.Get(server.URL + scenario.path)
+		is.NoError(err)
+		is.NoError(resp.Body.Close())
+		is.Equal(scenario.code, resp.StatusCode, "scenario #%d", i+1)
+	}
+}
+
+func TestLimiterHandlerWebSocketRate(t *testing.T) {
+	is := require.New(t)
+
+	// A WebSocket handler, hijacking the connection to answer the handshake.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.IsWebSocketUpgrade(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		_, err = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		if err == nil {
+			err = buf.Flush()
+		}
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: time.Minute,
+		Limit:  1,
+	}, limiter.WithCountStatusCodes(http.StatusUnauthorized), limiter.WithWebSocketRate(limiter.Rate{
+		Period: time.Minute,
+		Limit:  2,
+	}))
+
+	server := httptest.NewServer(instance.Handler(handler))
+	defer server.Close()
+
+	scenarios := []struct {
+		upgrade bool
+		code    int
+	}{
+		{
+			// Scenario #1 : handshake is counted before the handler hijacks the connection.
+			upgrade: true,
+			code:    http.StatusSwitchingProtocols,
+		},
+		{
+			// Scenario #2 : failed request without upgrade headers is counted with the limiter rate.
+			upgrade: false,
+			code:    http.StatusUnauthorized,
+		},
+		{
+			// Scenario #3 : last handshake allowed, regardless of the limiter rate.
+			upgrade: true,
+			code:    http.StatusSwitchingProtocols,
+		},
+		{
+			// Scenario #4 : handshake limit is reached.
+			upgrade: true,
+			code:    http.StatusTooManyRequests,
+		},
+		{
+			// Scenario #5 : limit of other requests is reached.
+			upgrade: false,
+			code:    http.StatusTooManyRequests,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		request, err := http.NewRequest("GET", server.URL+"/ws", nil)
+		is.NoError(err)
+		if scenario.upgrade {
+			request.Header.Set("Connection", "Upgrade")
+			request.Header.Set("Upgrade", "WebSocket")
+		}
+
+		resp, err := http.DefaultTransport.RoundTrip(request)
+		is.NoError(err)
+		is.NoError(resp.Body.Close())
+		is.Equal(scenario.code, resp.StatusCode, "scenario #%d", i+1)
+	}
+}
@@ -0,0 +1,88 @@
+package limiter
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// HeaderStyle defines the rate limit headers set by middlewares.
+type HeaderStyle int
+
+const (
+	// HeaderStyleLegacy sets X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers,
+	// the reset being a unix timestamp.
+	HeaderStyleLegacy HeaderStyle = iota
+	// HeaderStyleDraft sets RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers from the IETF draft,
+	// the reset being a number of seconds until the reset.
+	HeaderStyleDraft
+	// HeaderStyleBoth sets both legacy and IETF draft headers.
+	HeaderStyleBoth
+)
+
+// HeaderWarning is the header set by middlewares once the consumption of the limit reaches the WarnThreshold,
+// with the consumed fraction of the limit.
+const HeaderWarning = "RateLimit-Warning"
+
+// GetHeaders returns the rate limit headers of given style for given context, at the time of the limiter clock.
+// If the consumption of the limit reaches the WarnThreshold, without being rejected, a RateLimit-Warning header
+// is also returned.
+func (limiter *Limiter) GetHeaders(context Context, style HeaderStyle) map[string]string {
+	headers := context.Headers(style, limiter.Now())
+	if context.IsWarned(limiter.Options.WarnThreshold) {
+		headers[HeaderWarning] = strconv.FormatFloat(context.Consumed(), 'f', 2, 64)
+	}
+	return headers
+}
+
+// RetryAfter returns the duration until the limit of this context can be retried, or zero if it's not reached.
+// See RetryAfterAt.
+func (context Context) RetryAfter() time.Duration {
+	return context.RetryAfterAt(time.Now())
+}
+
+// RetryAfterAt returns the duration from given time until the limit of this context can be retried, or zero if
+// it's not reached: until the reset of the window, or until the next tokens are available with a token bucket.
+// Like the Retry-After header, it's rounded up to whole seconds and at least one second, since the reset has a
+// precision of one second.
+func (context Context) RetryAfterAt(now time.Time) time.Duration {
+	if !context.Reached {
+		return 0
+	}
+
+	retry := int64(math.Ceil(time.Unix(context.Reset, 0).Sub(now).Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	return time.Duration(retry) * time.Second
+}
+
+// Headers returns the rate limit headers of given style for this context, at given time.
+// If the limit is reached, a Retry-After header is also returned with the number of seconds until the reset.
+func (context Context) Headers(style HeaderStyle, now time.Time) map[string]string {
+	headers := map[string]string{}
+
+	if context.Reached {
+		retry := int64(context.RetryAfterAt(now) / time.Second)
+		headers["Retry-After"] = strconv.FormatInt(retry, 10)
+	}
+
+	if style == HeaderStyleLegacy || style == HeaderStyleBoth {
+		headers["X-RateLimit-Limit"] = strconv.FormatInt(context.Limit, 10)
+		headers["X-RateLimit-Remaining"] = strconv.FormatInt(context.Remaining, 10)
+		headers["X-RateLimit-Reset"] = strconv.FormatInt(context.Reset, 10)
+	}
+
+	if style == HeaderStyleDraft || style == HeaderStyleBoth {
+		reset := context.Reset - now.Unix()
+		if reset < 0 {
+			reset = 0
+		}
+
+		headers["RateLimit-Limit"] = strconv.FormatInt(context.Limit, 10)
+		headers["RateLimit-Remaining"] = strconv.FormatInt(context.Remaining, 10)
+		headers["RateLimit-Reset"] = strconv.FormatInt(reset, 10)
+	}
+
+	return headers
+}
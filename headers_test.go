@@ -0,0 +1,220 @@
+package limiter_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+	"github.com/ulule/limiter/v3/limitertest"
+)
+
+func TestContextHeaders(t *testing.T) {
+	is := require.New(t)
+
+	now := time.Unix(1600000000, 0)
+	lctx := limiter.Context{
+		Limit:     10,
+		Remaining: 3,
+		Reset:     now.Add(42 * time.Second).Unix(),
+	}
+
+	legacy := map[string]string{
+		"X-RateLimit-Limit":     "10",
+		"X-RateLimit-Remaining": "3",
+		"X-RateLimit-Reset":     "1600000042",
+	}
+	draft := map[string]string{
+		"RateLimit-Limit":     "10",
+		"RateLimit-Remaining": "3",
+		"RateLimit-Reset":     "42",
+	}
+	both := map[string]string{}
+	for name, value := range legacy {
+		both[name] = value
+	}
+	for name, value := range draft {
+		both[name] = value
+	}
+
+	scenarios := []struct {
+		style    limiter.HeaderStyle
+		expected map[string]string
+	}{
+		{
+			// Scenario #1 : legacy headers.
+			style:    limiter.HeaderStyleLegacy,
+			expected: legacy,
+		},
+		{
+			// Scenario #2 : IETF draft headers.
+			style:    limiter.HeaderStyleDraft,
+			expected: draft,
+		},
+		{
+			// Scenario #3 : both headers.
+			style:    limiter.HeaderStyleBoth,
+			expected: both,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		is.Equal(scenario.expected, lctx.Headers(scenario.style, now), "scenario #%d", i+1)
+	}
+
+	// The draft reset must not be negative.
+	headers := lctx.Headers(limiter.HeaderStyleDraft, now.Add(time.Minute))
+	is.Equal("0", headers["RateLimit-Reset"])
+}
+
+func TestContextHeadersRetryAfter(t *testing.T) {
+	is := require.New(t)
+
+	now := time.Unix(1600000000, 0)
+
+	scenarios := []struct {
+		lctx     limiter.Context
+		expected string
+	}{
+		{
+			// Scenario #1 : allowed request, without Retry-After.
+			lctx: limiter.Context{
+				Limit:     10,
+				Remaining: 3,
+				Reset:     now.Add(42 * time.Second).Unix(),
+			},
+			expected: "",
+		},
+		{
+			// Scenario #2 : rejected request, until the reset.
+			lctx: limiter.Context{
+				Limit:   10,
+				Reset:   now.Add(42 * time.Second).Unix(),
+				Reached: true,
+			},
+			expected: "42",
+		},
+		{
+			// Scenario #3 : rejected request, at least one second.
+			lctx: limiter.Context{
+				Limit:   10,
+				Reset:   now.Unix(),
+				Reached: true,
+			},
+			expected: "1",
+		},
+	}
+
+	for i, scenario := range scenarios {
+		headers := scenario.lctx.Headers(limiter.HeaderStyleLegacy, now)
+		is.Equal(scenario.expected, headers["Retry-After"], "scenario #%d", i+1)
+	}
+
+	// The remaining time is rounded up.
+	headers := scenarios[1].lctx.Headers(limiter.HeaderStyleLegacy, now.Add(500*time.Millisecond))
+	is.Equal("42", headers["Retry-After"])
+}
+
+func TestContextRetryAfter(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Unix(1600000000, 0))
+	store := memory.NewStoreWithOptions(limiter.StoreOptions{Prefix: "limiter", Clock: clock})
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(2),
+	}
+
+	// Scenario #1 : fixed window, not yet reached.
+	instance := limiter.New(store, rate, limiter.WithClock(clock))
+
+	lctx, err := instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.Equal(time.Duration(0), lctx.RetryAfterAt(clock.Now()))
+
+	// Scenario #2 : fixed window, reached until the reset of the window.
+	clock.Advance(20 * time.Second)
+
+	_, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.True(lctx.Reached)
+	is.Equal(40*time.Second, lctx.RetryAfterAt(clock.Now()))
+
+	// Scenario #3 : token bucket, reached until the next token.
+	instance = limiter.New(store, rate, limiter.WithClock(clock), limiter.WithStoreMode(limiter.StoreModeTokenBucket))
+
+	for i := 0; i < 2; i++ {
+		_, err = instance.Get(ctx, "bar")
+		is.NoError(err)
+	}
+	lctx, err = instance.Get(ctx, "bar")
+	is.NoError(err)
+	is.True(lctx.Reached)
+	is.Equal(30*time.Second, lctx.RetryAfterAt(clock.Now()))
+
+	// It's consistent with the Retry-After header.
+	headers := lctx.Headers(limiter.HeaderStyleLegacy, clock.Now())
+	is.Equal("30", headers["Retry-After"])
+
+	// Scenario #4 : not reached, at the current time.
+	is.Equal(time.Duration(0), limiter.Context{Reset: time.Now().Add(time.Minute).Unix()}.RetryAfter())
+}
+
+func TestLimiterGetHeadersWarning(t *testing.T) {
+	is := require.New(t)
+
+	instance := New(limiter.WithWarnThreshold(0.8))
+
+	scenarios := []struct {
+		remaining int64
+		reached   bool
+		expected  string
+	}{
+		{
+			// Scenario #1 : below the threshold.
+			remaining: 3,
+			expected:  "",
+		},
+		{
+			// Scenario #2 : reaching the threshold.
+			remaining: 2,
+			expected:  "0.80",
+		},
+		{
+			// Scenario #3 : above the threshold.
+			remaining: 0,
+			expected:  "1.00",
+		},
+		{
+			// Scenario #4 : rejected.
+			remaining: 0,
+			reached:   true,
+			expected:  "",
+		},
+	}
+
+	for i, scenario := range scenarios {
+		lctx := limiter.Context{
+			Limit:     10,
+			Remaining: scenario.remaining,
+			Reset:     time.Now().Add(time.Minute).Unix(),
+			Reached:   scenario.reached,
+		}
+
+		headers := instance.GetHeaders(lctx, limiter.HeaderStyleLegacy)
+		is.Equal(scenario.expected, headers[limiter.HeaderWarning], "scenario #%d", i+1)
+		is.Equal(strconv.FormatInt(scenario.remaining, 10), headers["X-RateLimit-Remaining"], "scenario #%d", i+1)
+	}
+
+	// A zero threshold disables the warning.
+	lctx := limiter.Context{Limit: 10, Remaining: 0}
+	is.Equal(1.0, lctx.Consumed())
+	is.NotContains(New().GetHeaders(lctx, limiter.HeaderStyleLegacy), limiter.HeaderWarning)
+}
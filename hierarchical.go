@@ -0,0 +1,91 @@
+package limiter
+
+import (
+	"net/http"
+)
+
+// Level is a level of a HierarchicalLimiter, such as a global or a per-user limit.
+type Level struct {
+	// Name identifies the level, such as "global" or "user". It's reported when the limit of the level is reached,
+	// and prefixes its keys so levels sharing a store don't share their counters: it must be unique.
+	Name string
+	// KeyFunc returns the key of a request for this level, such as a constant for a global limit,
+	// or the user IP or JWT sub for a per-user limit.
+	KeyFunc func(r *http.Request) string
+	// Rate is the rate of this level.
+	Rate Rate
+}
+
+// HierarchicalLimiter applies several levels of limits on a request, such as a global limit of an endpoint and a
+// per-user limit, each with its own key and rate. A request is denied if any of the levels is exceeded.
+// Unlike MultiLimiter, which applies several rates on the same key, each level obtains its own key from the request.
+type HierarchicalLimiter struct {
+	Levels   []Level
+	Limiters []*Limiter
+	// OnDecision is called once per decision on every levels, instead of the one of each limiter, with the key of
+	// the level exceeded, if any, or else of the most restrictive one.
+	OnDecision func(key string, allowed bool, context Context)
+}
+
+// NewHierarchicalLimiter returns an instance of HierarchicalLimiter, using a limiter with given store and options
+// for each level.
+func NewHierarchicalLimiter(store Store, levels []Level, options ...Option) *HierarchicalLimiter {
+	hierarchical := &HierarchicalLimiter{
+		Levels:   levels,
+		Limiters: make([]*Limiter, 0, len(levels)),
+	}
+
+	for _, level := range levels {
+		limiter := New(store, level.Rate, options...)
+		hierarchical.OnDecision = limiter.Options.OnDecision
+		limiter.Options.OnDecision = nil
+		hierarchical.Limiters = append(hierarchical.Limiters, limiter)
+	}
+
+	return hierarchical
+}
+
+// Get increments the limit of every level for given request, in order, and returns the most restrictive one along
+// with the name of the level exceeded, if any.
+// Levels are evaluated in order until one is exceeded: the following levels aren't incremented for this request,
+// whereas the previous ones are.
+// If the store fails, the error is returned along with the context of the failing level, reflecting the FailOpen
+// option, and its name.
+func (hierarchical *HierarchicalLimiter) Get(r *http.Request) (Context, string, error) {
+	var (
+		result    Context
+		resultKey string
+		found     bool
+	)
+
+	for i, level := range hierarchical.Levels {
+		limiter := hierarchical.Limiters[i]
+		key := level.Name + ":" + level.KeyFunc(r)
+
+		lctx, err := limiter.GetWithRequest(r, key)
+		if err != nil {
+			hierarchical.decide(key, lctx)
+			return lctx, level.Name, err
+		}
+		if lctx.Reached {
+			hierarchical.decide(key, lctx)
+			return lctx, level.Name, nil
+		}
+
+		if !found || isMoreRestrictive(lctx, result) {
+			result = lctx
+			resultKey = key
+			found = true
+		}
+	}
+
+	hierarchical.decide(resultKey, result)
+	return result, "", nil
+}
+
+// decide notifies OnDecision, if defined, of the decision on given key.
+func (hierarchical *HierarchicalLimiter) decide(key string, lctx Context) {
+	if hierarchical.OnDecision != nil {
+		hierarchical.OnDecision(key, !lctx.Reached, lctx)
+	}
+}
@@ -0,0 +1,156 @@
+package limiter_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+)
+
+func TestHierarchicalLimiter(t *testing.T) {
+	is := require.New(t)
+
+	instance := limiter.NewHierarchicalLimiter(memory.NewStore(), []limiter.Level{
+		{
+			Name: "global",
+			KeyFunc: func(r *http.Request) string {
+				return r.URL.Path
+			},
+			Rate: limiter.Rate{Period: 1 * time.Minute, Limit: int64(5)},
+		},
+		{
+			Name: "user",
+			KeyFunc: func(r *http.Request) string {
+				return r.Header.Get("X-User")
+			},
+			Rate: limiter.Rate{Period: 1 * time.Minute, Limit: int64(2)},
+		},
+	})
+
+	scenarios := []struct {
+		user      string
+		reached   bool
+		level     string
+		limit     int64
+		remaining int64
+	}{
+		{
+			// Scenario #1 : both levels pass, the per-user one is the most restrictive.
+			user:      "foo",
+			limit:     2,
+			remaining: 1,
+		},
+		{
+			// Scenario #2 : both levels pass.
+			user:      "foo",
+			limit:     2,
+			remaining: 0,
+		},
+		{
+			// Scenario #3 : global level passes, but per-user level trips.
+			user:      "foo",
+			reached:   true,
+			level:     "user",
+			limit:     2,
+			remaining: 0,
+		},
+		{
+			// Scenario #4 : another user has its own limit, the global one is the most restrictive.
+			user:      "bar",
+			limit:     5,
+			remaining: 1,
+		},
+		{
+			// Scenario #5 : both levels pass.
+			user:      "bar",
+			limit:     5,
+			remaining: 0,
+		},
+		{
+			// Scenario #6 : per-user level would pass, but global level trips.
+			user:      "baz",
+			reached:   true,
+			level:     "global",
+			limit:     5,
+			remaining: 0,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		request, err := http.NewRequest("GET", "/search", nil)
+		is.NoError(err)
+		request.Header.Set("X-User", scenario.user)
+
+		lctx, level, err := instance.Get(request)
+		is.NoError(err, "scenario #%d", i+1)
+		is.Equal(scenario.reached, lctx.Reached, "scenario #%d", i+1)
+		is.Equal(scenario.level, level, "scenario #%d", i+1)
+		is.Equal(scenario.limit, lctx.Limit, "scenario #%d", i+1)
+		is.Equal(scenario.remaining, lctx.Remaining, "scenario #%d", i+1)
+	}
+
+	// The per-user level isn't incremented once the global level trips.
+	lctx, err := instance.Limiters[1].Peek(context.Background(), "user:baz")
+	is.NoError(err)
+	is.Equal(int64(2), lctx.Remaining)
+
+	// Levels don't share their counters with other endpoints.
+	request, err := http.NewRequest("GET", "/other", nil)
+	is.NoError(err)
+	request.Header.Set("X-User", "baz")
+
+	lctx, level, err := instance.Get(request)
+	is.NoError(err)
+	is.False(lctx.Reached)
+	is.Empty(level)
+}
+
+func TestHierarchicalLimiterOnDecision(t *testing.T) {
+	is := require.New(t)
+
+	type decision struct {
+		key     string
+		allowed bool
+	}
+	decisions := []decision{}
+
+	instance := limiter.NewHierarchicalLimiter(memory.NewStore(), []limiter.Level{
+		{
+			Name: "global",
+			KeyFunc: func(r *http.Request) string {
+				return r.URL.Path
+			},
+			Rate: limiter.Rate{Period: 1 * time.Minute, Limit: int64(5)},
+		},
+		{
+			Name: "user",
+			KeyFunc: func(r *http.Request) string {
+				return r.Header.Get("X-User")
+			},
+			Rate: limiter.Rate{Period: 1 * time.Minute, Limit: int64(1)},
+		},
+	}, limiter.WithOnDecision(func(key string, allowed bool, context limiter.Context) {
+		decisions = append(decisions, decision{key: key, allowed: allowed})
+	}))
+
+	request, err := http.NewRequest("GET", "/search", nil)
+	is.NoError(err)
+	request.Header.Set("X-User", "foo")
+
+	// Scenario #1 : a request allowed by every level is decided once, on the most restrictive level.
+	_, _, err = instance.Get(request)
+	is.NoError(err)
+	is.Equal([]decision{{key: "user:foo", allowed: true}}, decisions)
+
+	// Scenario #2 : a request denied by a level is decided once, on that level, even if previous levels passed.
+	decisions = decisions[:0]
+
+	_, _, err = instance.Get(request)
+	is.NoError(err)
+	is.Equal([]decision{{key: "user:foo", allowed: false}}, decisions)
+}
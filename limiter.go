@@ -2,6 +2,37 @@ package limiter
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// ErrInvalidCost is returned when a request cost is lower than one.
+	ErrInvalidCost = fmt.Errorf("cost must be positive")
+	// ErrInvalidTTL is returned when a TTL override is not positive.
+	ErrInvalidTTL = fmt.Errorf("ttl must be positive")
+	// ErrInvalidRate is returned when an ad-hoc rate has no positive period.
+	ErrInvalidRate = fmt.Errorf("rate period must be positive")
+)
+
+const (
+	// autoSubPrefix is the key prefix used by GetAuto for authenticated requests.
+	autoSubPrefix = "sub:"
+	// autoIPPrefix is the key prefix used by GetAuto for anonymous requests.
+	autoIPPrefix = "ip:"
+	// keyHashPrefix is the prefix of identifiers hashed because of MaxKeyLength.
+	keyHashPrefix = "sha256:"
+	// keyHashSize is the number of bytes of the SHA-256 hash kept for identifiers longer than MaxKeyLength.
+	keyHashSize = 16
+	// webSocketPrefix is the key prefix of WebSocket upgrade handshakes, limited with WebSocketRate.
+	webSocketPrefix = "ws:"
 )
 
 // -----------------------------------------------------------------
@@ -14,6 +45,48 @@ type Context struct {
 	Remaining int64
 	Reset     int64
 	Reached   bool
+	Meta      Meta
+}
+
+// Meta is the metadata of a limit context, populated by the limiter for debugging purposes only, such as in
+// mixed deployments: it doesn't change the decision.
+type Meta struct {
+	// Algorithm is the store mode used to obtain the context.
+	Algorithm StoreMode
+	// FailOpen indicates if the context was served without the store, because it failed and FailOpen is enabled.
+	FailOpen bool
+}
+
+// Consumed returns the consumed fraction of the limit, between 0 and 1.
+func (context Context) Consumed() float64 {
+	if context.Limit <= 0 {
+		return 1
+	}
+	return float64(context.Limit-context.Remaining) / float64(context.Limit)
+}
+
+// IsWarned returns if the consumed fraction of the limit reached given threshold, without the limit being reached.
+// A zero threshold is never reached.
+func (context Context) IsWarned(threshold float64) bool {
+	return threshold > 0 && !context.Reached && context.Consumed() >= threshold
+}
+
+// ResetTime returns the reset of the limit as a time, in UTC.
+func (context Context) ResetTime() time.Time {
+	return time.Unix(context.Reset, 0).UTC()
+}
+
+// -----------------------------------------------------------------
+// LimitEvent
+// -----------------------------------------------------------------
+
+// LimitEvent describes a request rejected by the limiter.
+type LimitEvent struct {
+	Key       string
+	IP        net.IP
+	Rate      Rate
+	Remaining int64
+	Request   *http.Request
 }
 
 // -----------------------------------------------------------------
@@ -22,10 +95,17 @@ type Context struct {
 
 // Limiter is the limiter instance.
 type Limiter struct {
-	Store         Store
-	Rate          Rate
-	Options       Options
+	Store Store
+	// Rate is the rate the limiter was built with, such as to report the limit to clients.
+	// Use GetRate to obtain the rate of a given request, if RateResolver, RateByClaim or RateByCountry are defined.
+	Rate    Rate
+	Options Options
+	// ErrValidation was the error of the last JWT validation.
+	// Deprecated: this field is no longer populated since it was shared across concurrent requests,
+	// please use the error returned by GetJWTSub instead.
 	ErrValidation error
+	// peeks coalesces concurrent peeks, with CoalescePeeks.
+	peeks singleflight.Group
 }
 
 // New returns an instance of Limiter.
@@ -34,6 +114,12 @@ func New(store Store, rate Rate, options ...Option) *Limiter {
 		IPv4Mask:           DefaultIPv4Mask,
 		IPv6Mask:           DefaultIPv6Mask,
 		TrustForwardHeader: false,
+		TrustRFC7239:       true,
+		JWTAlgorithms:      DefaultJWTAlgorithms,
+		AuthScheme:         DefaultAuthScheme,
+		SkipMethods:        DefaultSkipMethods,
+		FailOpen:           true,
+		Clock:              SystemClock{},
 	}
 	for _, o := range options {
 		o(&opt)
@@ -45,22 +131,670 @@ func New(store Store, rate Rate, options ...Option) *Limiter {
 	}
 }
 
+// NewWithValidation returns an instance of Limiter like New, or an error if its options are inconsistent,
+// such as swapped IPv4 and IPv6 masks (see Options.Validate), to catch configuration mistakes early.
+func NewWithValidation(store Store, rate Rate, options ...Option) (*Limiter, error) {
+	limiter := New(store, rate, options...)
+
+	err := limiter.Options.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return limiter, nil
+}
+
 // Get returns the limit for given identifier.
+// It only relies on the store, so it can be used without HTTP, such as by gRPC interceptors or queue consumers:
+// GetWithRequest and the middlewares are built on top of it.
+// If the store fails, the error is returned along with a context reflecting the FailOpen option.
 func (limiter *Limiter) Get(ctx context.Context, key string) (Context, error) {
-	return limiter.Store.Get(ctx, key, limiter.Rate)
+	return limiter.get(ctx, key, limiter.Rate)
+}
+
+// Allow returns if a request of given identifier is allowed, incrementing its limit like Get, for callers which
+// don't need the whole context.
+// If the store fails, the error is returned along with a decision reflecting the FailOpen option.
+func (limiter *Limiter) Allow(ctx context.Context, key string) (bool, error) {
+	lctx, err := limiter.Get(ctx, key)
+	return !lctx.Reached, err
+}
+
+// GetWithCost returns the limit for given identifier, incrementing it by given cost instead of one,
+// such as for a bulk request. The request is denied if the accumulated cost exceeds the limit.
+// If the store fails, the error is returned along with a context reflecting the FailOpen option.
+func (limiter *Limiter) GetWithCost(ctx context.Context, key string, cost int64) (Context, error) {
+	if cost < 1 {
+		return Context{}, ErrInvalidCost
+	}
+	return limiter.increment(ctx, key, cost, limiter.Rate)
+}
+
+// GetWithTTL returns the limit for given identifier, using given TTL instead of the rate period for its expiry,
+// such as login attempts expiring faster than API calls. The limit count of the rate is still enforced.
+// Since the TTL is the period of the counter, an identifier should always be used with the same TTL.
+// It returns ErrInvalidTTL if the TTL is not positive.
+func (limiter *Limiter) GetWithTTL(ctx context.Context, key string, ttl time.Duration) (Context, error) {
+	if ttl <= 0 {
+		return Context{}, ErrInvalidTTL
+	}
+
+	rate := limiter.Rate
+	rate.Period = ttl
+	return limiter.get(ctx, key, rate)
+}
+
+// GetWithRate returns the limit for given identifier, using given rate instead of the limiter rate, such as for
+// a one-off limit of a password reset endpoint, without a dedicated limiter. The counter expires after the period
+// of given rate. Since the rate is the one of the counter, an identifier should always be used with the same rate.
+// It returns ErrInvalidRate if the period of the rate is not positive.
+func (limiter *Limiter) GetWithRate(ctx context.Context, key string, rate Rate) (Context, error) {
+	if rate.Period <= 0 {
+		return Context{}, ErrInvalidRate
+	}
+	return limiter.get(ctx, key, rate)
+}
+
+// GetWithLabel returns the limit for given identifier like Get, and attaches given label to its counter, such as
+// an account id, so administration tooling can list top offenders with context (see memory.Store.ListTop).
+// The label expires with the counter. It's only supported with StoreModeFixed, by stores implementing LabelStore:
+// it returns ErrLabelNotSupported otherwise.
+// If the store fails, the error is returned along with a context reflecting the FailOpen option.
+func (limiter *Limiter) GetWithLabel(ctx context.Context, key string, label string) (Context, error) {
+	store, ok := limiter.Store.(LabelStore)
+	if !ok || limiter.Options.StoreMode != StoreModeFixed {
+		return Context{}, ErrLabelNotSupported
+	}
+
+	rate := limiter.adjustRate(limiter.Rate)
+
+	lctx, err := store.GetWithLabel(ctx, limiter.getStoreKey(key), limiter.jitterRate(key, rate), label)
+	if err != nil {
+		lctx = limiter.failureContext(rate)
+	}
+	lctx.Meta.Algorithm = limiter.Options.StoreMode
+
+	limiter.decide(key, lctx)
+	return lctx, err
+}
+
+// GetLabel returns the label attached to the counter of given identifier by GetWithLabel, or an empty string if
+// none. It returns ErrLabelNotSupported if the store doesn't implement LabelStore.
+func (limiter *Limiter) GetLabel(ctx context.Context, key string) (string, error) {
+	store, ok := limiter.Store.(LabelStore)
+	if !ok {
+		return "", ErrLabelNotSupported
+	}
+	return store.GetLabel(ctx, limiter.getStoreKey(key))
+}
+
+// GetBatch returns the limit for given identifier, charging it for a batch of n events at once, such as queued
+// requests processed by a worker. It's a single atomic increment of the store (ie: one INCRBY on redis).
+// The context Reached flag indicates whether the batch pushed the identifier over its limit, even partially.
+// It returns ErrInvalidCost if n is lower than one.
+func (limiter *Limiter) GetBatch(ctx context.Context, key string, n int64) (Context, error) {
+	return limiter.GetWithCost(ctx, key, n)
+}
+
+// Now returns the current time of the limiter clock.
+func (limiter *Limiter) Now() time.Time {
+	return limiter.Options.now()
+}
+
+// GetRate returns the rate for given request: WebSocketRate for a WebSocket handshake if defined, then using
+// RateResolver, RateByClaim then RateByCountry if defined, or the limiter rate otherwise.
+func (limiter *Limiter) GetRate(r *http.Request) Rate {
+	if limiter.IsWebSocketHandshake(r) {
+		return limiter.Options.WebSocketRate
+	}
+
+	if limiter.Options.RateResolver != nil {
+		rate := limiter.Options.RateResolver(r)
+		if rate.Limit != 0 || rate.Period != 0 {
+			return rate
+		}
+	}
+
+	if len(limiter.Options.RateByClaim) > 0 {
+		value, err := getJWTClaim(r, limiter.Options, limiter.Options.RateClaim)
+		if err == nil {
+			rate, ok := limiter.Options.RateByClaim[value]
+			if ok {
+				return rate
+			}
+		}
+	}
+
+	if len(limiter.Options.RateByCountry) > 0 && limiter.Options.CountryResolver != nil {
+		country, ok := limiter.Options.CountryResolver.Country(limiter.GetIP(r))
+		if ok {
+			rate, ok := limiter.Options.RateByCountry[country]
+			if ok {
+				return rate
+			}
+		}
+	}
+
+	return limiter.Rate
+}
+
+// GetWithRequest returns the limit for given identifier, using the rate resolved for given request.
+// With ByteQuota, the limit is incremented by the request size instead of one.
+// When RateResolver, RateByClaim or RateByCountry is defined, the identifier is suffixed with the resolved rate, so
+// requests resolving to different rates don't share their counters, whereas requests resolving to the same rate do.
+// If the limit is reached, OnLimitReached is notified of the rejected request.
+func (limiter *Limiter) GetWithRequest(r *http.Request, key string) (Context, error) {
+	key, rate, lctx, err := limiter.countRequest(r, key)
+	limiter.decide(key, lctx)
+	limiter.notifyLimitReached(r, key, rate, lctx)
+
+	return lctx, err
+}
+
+// countRequest increments the limit for given identifier like GetWithRequest, without notifying OnDecision and
+// OnLimitReached. It returns the identifier and the rate resolved for given request along with the limit.
+func (limiter *Limiter) countRequest(r *http.Request, key string) (string, Rate, Context, error) {
+	key, rate := limiter.getRequestRate(r, key)
+
+	var (
+		lctx Context
+		err  error
+	)
+
+	if limiter.Options.ByteQuota {
+		lctx, err = limiter.incrementStore(r.Context(), key, limiter.getRequestCost(r), rate)
+	} else {
+		lctx, err = limiter.getStore(r.Context(), key, rate)
+	}
+
+	return key, rate, lctx, err
+}
+
+// CheckWithRequest returns the limit for given identifier, using the rate resolved for given request like
+// GetWithRequest, without modification on current values. The context is Reached if no request remains, that is
+// if counting one more request would exceed the limit. It's used with CountWithRequest to only count requests
+// once handled, such as with CountStatusCodes.
+// It's the decision of the request: OnDecision is notified of it, and not of the following count.
+// If the limit is reached, OnLimitReached is notified of the rejected request.
+// If the store fails, the error is returned along with a context reflecting the FailOpen option.
+func (limiter *Limiter) CheckWithRequest(r *http.Request, key string) (Context, error) {
+	key, rate := limiter.getRequestRate(r, key)
+
+	lctx, err := limiter.peek(r.Context(), key, rate)
+	if err != nil {
+		lctx = limiter.failureContext(rate)
+	} else if lctx.Remaining <= 0 {
+		lctx.Reached = true
+	}
+	limiter.decide(key, lctx)
+	limiter.notifyLimitReached(r, key, rate, lctx)
+
+	return lctx, err
+}
+
+// CountWithRequest increments the limit for given identifier by one, or by the request size with ByteQuota,
+// using the rate resolved for given request like GetWithRequest, if given response status is counted
+// (see IsStatusCounted). Otherwise, the limit is returned without modification on current values.
+// Since the request has already been decided by CheckWithRequest, OnDecision isn't notified.
+func (limiter *Limiter) CountWithRequest(r *http.Request, key string, status int) (Context, error) {
+	key, rate := limiter.getRequestRate(r, key)
+
+	if !limiter.IsStatusCounted(status) {
+		return limiter.peek(r.Context(), key, rate)
+	}
+
+	cost := int64(1)
+	if limiter.Options.ByteQuota {
+		cost = limiter.getRequestCost(r)
+	}
+	return limiter.incrementStore(r.Context(), key, cost, rate)
+}
+
+// PeekWithRequest returns the limit for given identifier, using the rate resolved for given request like
+// GetWithRequest, without modification on current values.
+func (limiter *Limiter) PeekWithRequest(r *http.Request, key string) (Context, error) {
+	key, rate := limiter.getRequestRate(r, key)
+	return limiter.peek(r.Context(), key, rate)
+}
+
+// IsStatusCounted returns if a response with given status is counted against the limit, that is if it's
+// in CountStatusCodes, or if CountStatusCodes is empty.
+func (limiter *Limiter) IsStatusCounted(status int) bool {
+	if len(limiter.Options.CountStatusCodes) == 0 {
+		return true
+	}
+	for _, code := range limiter.Options.CountStatusCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// getRequestRate returns the rate resolved for given request, and given identifier suffixed with this rate
+// when RateResolver, RateByClaim or RateByCountry is defined. A WebSocket handshake limited with WebSocketRate has its identifier
+// prefixed instead, so it doesn't share the counter of other requests.
+func (limiter *Limiter) getRequestRate(r *http.Request, key string) (string, Rate) {
+	if limiter.IsWebSocketHandshake(r) {
+		return webSocketPrefix + key, limiter.Options.WebSocketRate
+	}
+
+	rate := limiter.GetRate(r)
+	if limiter.Options.RateResolver != nil || len(limiter.Options.RateByClaim) > 0 ||
+		len(limiter.Options.RateByCountry) > 0 {
+		key = getRateKey(key, rate)
+	}
+	return key, rate
+}
+
+// getRequestCost returns the cost of given request with ByteQuota: its body size, or UnknownContentLengthCost
+// if unknown. A request costs at least one byte, so it's rejected once the quota is exhausted.
+func (limiter *Limiter) getRequestCost(r *http.Request) int64 {
+	cost := r.ContentLength
+	if cost < 0 {
+		cost = limiter.Options.UnknownContentLengthCost
+	}
+	if cost < 1 {
+		return 1
+	}
+	return cost
+}
+
+// notifyLimitReached notifies OnLimitReached, if defined, of given request if its limit is reached.
+func (limiter *Limiter) notifyLimitReached(r *http.Request, key string, rate Rate, lctx Context) {
+	if lctx.Reached && limiter.Options.OnLimitReached != nil {
+		limiter.Options.OnLimitReached(LimitEvent{
+			Key:       key,
+			IP:        limiter.GetIP(r),
+			Rate:      rate,
+			Remaining: lctx.Remaining,
+			Request:   r,
+		})
+	}
+}
+
+// GetAuto returns the limit for given request, keyed by the JWT sub for authenticated requests, such as "sub:foo",
+// or by the user IP key (see GetIPKey) otherwise, such as "ip:8.8.8.8". Requests with a missing, malformed or invalid
+// JWT fallback on the user IP key: the prefixes ensure a sub and an IP never share their counters.
+// The limit is obtained with GetWithRequest, so it honors the rate resolved for given request.
+func (limiter *Limiter) GetAuto(r *http.Request) (Context, error) {
+	return limiter.GetWithRequest(r, limiter.getAutoKey(r))
+}
+
+// getAutoKey returns the store key of given request used by GetAuto.
+func (limiter *Limiter) getAutoKey(r *http.Request) string {
+	sub, err := limiter.GetJWTSub(r)
+	if err == nil {
+		return autoSubPrefix + sub
+	}
+	return autoIPPrefix + limiter.GetIPKey(r)
+}
+
+// GetWithAllIPs returns the limit for every IP of given request (see GetAllIPs), so a malicious proxy of the chain
+// is limited too: the counter of each IP is incremented like GetWithRequest, keyed like GetIPKey, and the most
+// restrictive context is returned. It's reached if any of the IPs is over its limit.
+// It's a single decision: OnDecision and OnLimitReached are notified once, with the key of the most restrictive IP.
+// If the store fails for an IP, the other IPs are still incremented: the error is returned along with the context
+// of the failing IP, reflecting the FailOpen option, unless another IP is over its limit.
+func (limiter *Limiter) GetWithAllIPs(r *http.Request) (Context, error) {
+	ips := limiter.GetAllIPs(r)
+	if len(ips) == 0 {
+		return limiter.GetWithRequest(r, getUnknownIPKey(limiter.Options.remoteAddr(r)))
+	}
+
+	var (
+		result      Context
+		resultKey   string
+		resultRate  Rate
+		found       bool
+		failure     Context
+		failureKey  string
+		failureRate Rate
+		failureErr  error
+	)
+
+	for _, ip := range ips {
+		key, rate, lctx, err := limiter.countRequest(r, limiter.getIPKey(ip))
+		if err != nil {
+			if failureErr == nil {
+				failure, failureKey, failureRate, failureErr = lctx, key, rate, err
+			}
+			continue
+		}
+		if !found || isMoreRestrictive(lctx, result) {
+			result, resultKey, resultRate = lctx, key, rate
+			found = true
+		}
+	}
+
+	if failureErr != nil && !result.Reached {
+		limiter.decide(failureKey, failure)
+		limiter.notifyLimitReached(r, failureKey, failureRate, failure)
+		return failure, failureErr
+	}
+
+	limiter.decide(resultKey, result)
+	limiter.notifyLimitReached(r, resultKey, resultRate, result)
+	return result, nil
 }
 
 // Peek returns the limit for given identifier, without modification on current values.
 func (limiter *Limiter) Peek(ctx context.Context, key string) (Context, error) {
-	return limiter.Store.Peek(ctx, key, limiter.Rate)
+	return limiter.peek(ctx, key, limiter.Rate)
 }
 
 // Reset sets the limit for given identifier to zero.
 func (limiter *Limiter) Reset(ctx context.Context, key string) (Context, error) {
-	return limiter.Store.Reset(ctx, key, limiter.Rate)
+	return limiter.reset(ctx, key, limiter.Rate)
+}
+
+// ResetNamespace deletes the counters of every identifier starting with given prefix, such as "user:" to reset
+// every user once their rate is changed, including the counters of every store mode.
+// It iterates over the keys of the store: it's an O(n) operation, intended for administration rather than requests.
+// The Prefix option is taken into account, so only the counters of this limiter are deleted.
+// Identifiers hashed because of MaxKeyLength don't keep their prefix, so they can't be reset by namespace.
+// It returns ErrNamespaceNotSupported if the store doesn't implement NamespaceStore.
+func (limiter *Limiter) ResetNamespace(ctx context.Context, prefix string) error {
+	store, ok := limiter.Store.(NamespaceStore)
+	if !ok {
+		return ErrNamespaceNotSupported
+	}
+	return store.ResetNamespace(ctx, limiter.Options.Prefix+prefix)
 }
 
 // Increment increments the limit by given count & gives back the new limit for given identifier
+// If the store fails, the error is returned along with a context reflecting the FailOpen option.
 func (limiter *Limiter) Increment(ctx context.Context, key string, count int64) (Context, error) {
-	return limiter.Store.Increment(ctx, key, count, limiter.Rate)
+	return limiter.increment(ctx, key, count, limiter.Rate)
+}
+
+// Refund decrements the limit for given identifier by given amount, without going below zero, such as when a request
+// failed before doing any real work (ie: a downstream outage), so the client isn't penalized for it.
+// A counter which has expired meanwhile is left untouched.
+// It's supported with StoreModeFixed, and StoreModeSliding (refunding the current window), by stores implementing
+// RefundStore: it returns ErrRefundNotSupported otherwise. It returns ErrInvalidCost if amount is lower than one.
+func (limiter *Limiter) Refund(ctx context.Context, key string, amount int64) error {
+	if amount < 1 {
+		return ErrInvalidCost
+	}
+
+	store, ok := limiter.Store.(RefundStore)
+	if !ok {
+		return ErrRefundNotSupported
+	}
+
+	rate := limiter.adjustRate(limiter.Rate)
+	storeKey := limiter.getStoreKey(key)
+
+	var err error
+
+	switch limiter.Options.StoreMode {
+	case StoreModeFixed:
+		_, err = store.Refund(ctx, storeKey, amount, limiter.jitterRate(key, rate))
+	case StoreModeSliding:
+		err = limiter.refundSliding(ctx, store, storeKey, amount, rate)
+	default:
+		err = ErrRefundNotSupported
+	}
+
+	return err
+}
+
+// get returns the limit for given identifier and rate, using the configured store mode, and notifies OnDecision of
+// the decision.
+func (limiter *Limiter) get(ctx context.Context, key string, rate Rate) (Context, error) {
+	lctx, err := limiter.getStore(ctx, key, rate)
+	limiter.decide(key, lctx)
+	return lctx, err
+}
+
+// getStore returns the limit for given identifier and rate, using the configured store mode, without notifying
+// OnDecision.
+func (limiter *Limiter) getStore(ctx context.Context, key string, rate Rate) (Context, error) {
+	rate = limiter.adjustRate(rate)
+
+	var (
+		lctx Context
+		err  error
+	)
+
+	storeKey := limiter.getStoreKey(key)
+	rate = limiter.warmupRate(ctx, storeKey, rate)
+
+	switch limiter.Options.StoreMode {
+	case StoreModeSliding:
+		lctx, err = limiter.incrementSliding(ctx, storeKey, 1, rate)
+	case StoreModeTokenBucket, StoreModeLeakyBucket:
+		lctx, err = limiter.takeTokens(ctx, storeKey, 1, rate)
+	case StoreModeSlidingLog:
+		lctx, err = limiter.logRequests(ctx, storeKey, 1, rate)
+	default:
+		lctx, err = limiter.Store.Get(ctx, storeKey, limiter.jitterRate(key, rate))
+	}
+
+	if err != nil {
+		lctx = limiter.failureContext(rate)
+	}
+	lctx.Meta.Algorithm = limiter.Options.StoreMode
+
+	return lctx, err
+}
+
+// peek returns the limit for given identifier and rate, using the configured store mode.
+// With CoalescePeeks, concurrent peeks of the same identifier and rate share a single store operation. It's detached
+// from the cancellation of the first caller, since its result is shared: each caller waits for it until its own
+// context is done.
+func (limiter *Limiter) peek(ctx context.Context, key string, rate Rate) (Context, error) {
+	rate = limiter.adjustRate(rate)
+	if !limiter.Options.CoalescePeeks {
+		return limiter.peekStore(ctx, key, rate)
+	}
+
+	results := limiter.peeks.DoChan(getRateKey(key, rate), func() (interface{}, error) {
+		return limiter.peekStore(detachedContext{parent: ctx}, key, rate)
+	})
+
+	select {
+	case result := <-results:
+		return result.Val.(Context), result.Err
+	case <-ctx.Done():
+		return Context{}, ctx.Err()
+	}
+}
+
+// detachedContext is a context with the values of its parent, but never canceled, for an operation shared by
+// several callers.
+type detachedContext struct {
+	parent context.Context
+}
+
+// Deadline returns no deadline.
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done returns nil, since the context is never canceled.
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err returns nil, since the context is never canceled.
+func (detachedContext) Err() error {
+	return nil
+}
+
+// Value returns the value of its parent for given key.
+func (ctx detachedContext) Value(key interface{}) interface{} {
+	return ctx.parent.Value(key)
+}
+
+// peekStore returns the limit for given identifier and rate from the store, using the configured store mode.
+func (limiter *Limiter) peekStore(ctx context.Context, key string, rate Rate) (Context, error) {
+	storeKey := limiter.getStoreKey(key)
+	rate = limiter.peekWarmupRate(ctx, storeKey, rate)
+
+	var (
+		lctx Context
+		err  error
+	)
+
+	switch limiter.Options.StoreMode {
+	case StoreModeSliding:
+		lctx, err = limiter.peekSliding(ctx, storeKey, rate)
+	case StoreModeTokenBucket, StoreModeLeakyBucket:
+		lctx, err = limiter.takeTokens(ctx, storeKey, 0, rate)
+	case StoreModeSlidingLog:
+		lctx, err = limiter.logRequests(ctx, storeKey, 0, rate)
+	default:
+		lctx, err = limiter.Store.Peek(ctx, storeKey, limiter.jitterRate(key, rate))
+	}
+
+	lctx.Meta.Algorithm = limiter.Options.StoreMode
+	return lctx, err
+}
+
+// reset sets the limit for given identifier and rate to zero, using the configured store mode.
+func (limiter *Limiter) reset(ctx context.Context, key string, rate Rate) (Context, error) {
+	rate = limiter.adjustRate(rate)
+	storeKey := limiter.getStoreKey(key)
+
+	var (
+		lctx Context
+		err  error
+	)
+
+	switch limiter.Options.StoreMode {
+	case StoreModeSliding:
+		lctx, err = limiter.resetSliding(ctx, storeKey, rate)
+	case StoreModeTokenBucket, StoreModeLeakyBucket:
+		lctx, err = limiter.resetTokens(ctx, storeKey, rate)
+	case StoreModeSlidingLog:
+		lctx, err = limiter.resetLog(ctx, storeKey, rate)
+	default:
+		lctx, err = limiter.Store.Reset(ctx, storeKey, limiter.jitterRate(key, rate))
+	}
+
+	lctx.Meta.Algorithm = limiter.Options.StoreMode
+	return lctx, err
+}
+
+// increment increments the limit by given count for given identifier and rate, using the configured store mode,
+// and notifies OnDecision of the decision.
+func (limiter *Limiter) increment(ctx context.Context, key string, count int64, rate Rate) (Context, error) {
+	lctx, err := limiter.incrementStore(ctx, key, count, rate)
+	limiter.decide(key, lctx)
+	return lctx, err
+}
+
+// incrementStore increments the limit by given count for given identifier and rate, using the configured store
+// mode, without notifying OnDecision.
+func (limiter *Limiter) incrementStore(ctx context.Context, key string, count int64, rate Rate) (Context, error) {
+	rate = limiter.adjustRate(rate)
+
+	var (
+		lctx Context
+		err  error
+	)
+
+	storeKey := limiter.getStoreKey(key)
+	rate = limiter.warmupRate(ctx, storeKey, rate)
+
+	switch limiter.Options.StoreMode {
+	case StoreModeSliding:
+		lctx, err = limiter.incrementSliding(ctx, storeKey, count, rate)
+	case StoreModeTokenBucket, StoreModeLeakyBucket:
+		lctx, err = limiter.takeTokens(ctx, storeKey, count, rate)
+	case StoreModeSlidingLog:
+		lctx, err = limiter.logRequests(ctx, storeKey, count, rate)
+	default:
+		lctx, err = limiter.Store.Increment(ctx, storeKey, count, limiter.jitterRate(key, rate))
+	}
+
+	if err != nil {
+		lctx = limiter.failureContext(rate)
+	}
+	lctx.Meta.Algorithm = limiter.Options.StoreMode
+
+	return lctx, err
+}
+
+// adjustRate returns the effective rate of given base rate, using RateAdjuster if defined.
+func (limiter *Limiter) adjustRate(rate Rate) Rate {
+	if limiter.Options.RateAdjuster == nil {
+		return rate
+	}
+
+	adjusted := limiter.Options.RateAdjuster.Adjust(rate)
+	if adjusted.Limit == 0 && adjusted.Period == 0 {
+		return rate
+	}
+	return adjusted
+}
+
+// getStoreKey returns the store key of given identifier, prefixed with the Prefix option.
+// With MaxKeyLength, an identifier longer than it is replaced by its hash (see getKeyHash).
+func (limiter *Limiter) getStoreKey(key string) string {
+	if limiter.Options.MaxKeyLength > 0 && len(key) > limiter.Options.MaxKeyLength {
+		key = getKeyHash(key)
+	}
+	return limiter.Options.Prefix + key
+}
+
+// getKeyHash returns the truncated SHA-256 hash of given identifier, such as "sha256:9f86d081884c7d659a2feaa0c55ad015".
+func getKeyHash(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return keyHashPrefix + hex.EncodeToString(hash[:keyHashSize])
+}
+
+// jitterRate returns given rate with its period extended by a duration within [0, ResetJitter),
+// derived from a hash of given identifier so it's stable across requests.
+func (limiter *Limiter) jitterRate(key string, rate Rate) Rate {
+	jitter := limiter.Options.ResetJitter
+	if jitter <= 0 {
+		return rate
+	}
+
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(key))
+	rate.Period += time.Duration(hash.Sum64() % uint64(jitter))
+	return rate
+}
+
+// decide notifies OnDecision, if defined, of the decision on given key.
+func (limiter *Limiter) decide(key string, lctx Context) {
+	if limiter.Options.OnDecision != nil {
+		limiter.Options.OnDecision(key, !lctx.Reached, lctx)
+	}
+}
+
+// failureContext returns the limit context used when the store fails.
+// The request is allowed with FailOpen, and denied otherwise.
+func (limiter *Limiter) failureContext(rate Rate) Context {
+	limit := rate.Limit
+	if limiter.Options.StoreMode == StoreModeTokenBucket || limiter.Options.StoreMode == StoreModeLeakyBucket {
+		limit = limiter.burst(rate)
+	}
+
+	meta := Meta{
+		Algorithm: limiter.Options.StoreMode,
+		FailOpen:  limiter.Options.FailOpen,
+	}
+
+	if limiter.Options.FailOpen {
+		return Context{
+			Limit:     limit,
+			Remaining: limit,
+			Reset:     limiter.Now().Add(rate.Period).Unix(),
+			Reached:   false,
+			Meta:      meta,
+		}
+	}
+
+	return Context{
+		Limit:     limit,
+		Remaining: 0,
+		Reset:     limiter.Now().Add(rate.Period).Unix(),
+		Reached:   true,
+		Meta:      meta,
+	}
 }
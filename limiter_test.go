@@ -1,10 +1,25 @@
 package limiter_test
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+
 	"github.com/ulule/limiter/v3"
 	"github.com/ulule/limiter/v3/drivers/store/memory"
+	"github.com/ulule/limiter/v3/limitertest"
 )
 
 func New(options ...limiter.Option) *limiter.Limiter {
@@ -15,3 +30,1851 @@ func New(options ...limiter.Option) *limiter.Limiter {
 	}
 	return limiter.New(store, rate, options...)
 }
+
+func TestNewWithValidation(t *testing.T) {
+	is := require.New(t)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Second,
+		Limit:  int64(10),
+	}
+
+	// Scenario #1 : default and valid masks.
+	instance, err := limiter.NewWithValidation(memory.NewStore(), rate)
+	is.NoError(err)
+	is.NotNil(instance)
+
+	_, err = limiter.NewWithValidation(memory.NewStore(), rate,
+		limiter.WithIPv4Mask(net.CIDRMask(24, 32)),
+		limiter.WithIPv6Mask(net.CIDRMask(64, 128)))
+	is.NoError(err)
+
+	_, err = limiter.NewWithValidation(memory.NewStore(), rate,
+		limiter.WithIPv4Mask(nil),
+		limiter.WithIPv6Mask(net.IPMask{}))
+	is.NoError(err)
+
+	// Scenario #2 : IPv4 mask in its IPv4-mapped form.
+	_, err = limiter.NewWithValidation(memory.NewStore(), rate,
+		limiter.WithIPv4Mask(net.CIDRMask(120, 128)))
+	is.NoError(err)
+
+	// Scenario #3 : swapped masks.
+	_, err = limiter.NewWithValidation(memory.NewStore(), rate,
+		limiter.WithIPv4Mask(net.CIDRMask(64, 128)),
+		limiter.WithIPv6Mask(net.CIDRMask(24, 32)))
+	is.Equal(limiter.ErrInvalidIPv4Mask, err)
+
+	_, err = limiter.NewWithValidation(memory.NewStore(), rate,
+		limiter.WithIPv6Mask(net.CIDRMask(24, 32)))
+	is.Equal(limiter.ErrInvalidIPv6Mask, err)
+
+	// Scenario #4 : non-canonical masks.
+	_, err = limiter.NewWithValidation(memory.NewStore(), rate,
+		limiter.WithIPv4Mask(net.IPv4Mask(255, 0, 255, 0)))
+	is.Equal(limiter.ErrInvalidIPv4Mask, err)
+
+	_, err = limiter.NewWithValidation(memory.NewStore(), rate,
+		limiter.WithIPv6Mask(net.IPMask{0xff, 0xff}))
+	is.Equal(limiter.ErrInvalidIPv6Mask, err)
+}
+
+func TestLimiterPeek(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(3),
+	})
+
+	for i := 0; i < 10; i++ {
+		lctx, err := instance.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(3), lctx.Limit)
+		is.Equal(int64(3), lctx.Remaining)
+		is.False(lctx.Reached)
+	}
+
+	lctx, err := instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(2), lctx.Remaining)
+
+	for i := 0; i < 10; i++ {
+		lctx, err = instance.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(2), lctx.Remaining)
+		is.False(lctx.Reached)
+	}
+}
+
+func TestLimiterGetWithoutRequest(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(2),
+	})
+
+	// Keys are arbitrary strings, such as a gRPC method with a client ID or a queue name.
+	keys := []string{
+		"/helloworld.Greeter/SayHello:client-42",
+		"queue:emails",
+		"",
+	}
+
+	for _, key := range keys {
+		for i := 1; i <= 3; i++ {
+			lctx, err := instance.Get(ctx, key)
+			is.NoError(err, key)
+			is.Equal(int64(2), lctx.Limit, key)
+			is.Equal(i > 2, lctx.Reached, key)
+		}
+	}
+
+	// Keys don't share their counters.
+	lctx, err := instance.Get(ctx, "queue:sms")
+	is.NoError(err)
+	is.Equal(int64(1), lctx.Remaining)
+}
+
+func TestLimiterAllow(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(3),
+	})
+
+	// Scenario #1 : requests are allowed up to the limit.
+	for i := 1; i <= 3; i++ {
+		allowed, err := instance.Allow(ctx, "foo")
+		is.NoError(err)
+		is.True(allowed, "request #%d", i)
+	}
+
+	// Scenario #2 : requests are denied once the limit is exceeded.
+	allowed, err := instance.Allow(ctx, "foo")
+	is.NoError(err)
+	is.False(allowed)
+
+	// Scenario #3 : keys don't share their counters.
+	allowed, err = instance.Allow(ctx, "bar")
+	is.NoError(err)
+	is.True(allowed)
+}
+func TestLimiterRate(t *testing.T) {
+	is := require.New(t)
+
+	rate := limiter.Rate{
+		Formatted: "3-M",
+		Period:    1 * time.Minute,
+		Limit:     int64(3),
+	}
+	instance := limiter.New(memory.NewStore(), rate)
+
+	is.Equal(rate, instance.Rate)
+	is.Equal(int64(3), instance.Rate.Limit)
+	is.Equal(1*time.Minute, instance.Rate.Period)
+	is.Equal(rate, instance.GetRate(&http.Request{}))
+}
+
+func TestLimiterReset(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(3),
+	})
+
+	for i := 0; i < 5; i++ {
+		_, err := instance.Get(ctx, "foo")
+		is.NoError(err)
+	}
+
+	lctx, err := instance.Peek(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(0), lctx.Remaining)
+	is.True(lctx.Reached)
+
+	lctx, err = instance.Reset(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(3), lctx.Remaining)
+	is.False(lctx.Reached)
+
+	lctx, err = instance.Peek(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(3), lctx.Remaining)
+	is.False(lctx.Reached)
+
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(2), lctx.Remaining)
+}
+
+func TestLimiterStoreMode(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	period := 500 * time.Millisecond
+
+	scenarios := []struct {
+		mode    limiter.StoreMode
+		allowed int
+	}{
+		{
+			// Scenario #1 : the fixed window is over, the second burst is allowed.
+			mode:    limiter.StoreModeFixed,
+			allowed: 10,
+		},
+		{
+			// Scenario #2 : the sliding window still counts the first burst, the second one is throttled.
+			mode:    limiter.StoreModeSliding,
+			allowed: 6,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		instance := limiter.New(memory.NewStore(), limiter.Rate{
+			Period: period,
+			Limit:  int64(10),
+		}, limiter.WithStoreMode(scenario.mode))
+
+		// Start the first burst at the middle of a window.
+		elapsed := time.Duration(time.Now().UnixNano() % int64(period))
+		time.Sleep(period - elapsed + period/2)
+
+		for j := 0; j < 10; j++ {
+			lctx, err := instance.Get(ctx, "foo")
+			is.NoError(err)
+			is.False(lctx.Reached, "scenario #%d", i+1)
+		}
+
+		// Straddle the window boundary.
+		time.Sleep(period + period/10)
+
+		allowed := 0
+		for j := 0; j < 10; j++ {
+			lctx, err := instance.Get(ctx, "foo")
+			is.NoError(err)
+			if !lctx.Reached {
+				allowed++
+			}
+		}
+
+		if scenario.mode == limiter.StoreModeSliding {
+			is.Less(allowed, 10, "scenario #%d", i+1)
+			is.InDelta(scenario.allowed, allowed, 1, "scenario #%d", i+1)
+		} else {
+			is.Equal(scenario.allowed, allowed, "scenario #%d", i+1)
+		}
+	}
+}
+
+func TestLimiterStoreModeNotSupported(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	// Only expose the methods of limiter.Store.
+	store := struct{ limiter.Store }{memory.NewStore()}
+
+	instance := limiter.New(store, limiter.Rate{
+		Period: 1 * time.Second,
+		Limit:  int64(10),
+	}, limiter.WithStoreMode(limiter.StoreModeTokenBucket))
+
+	_, err := instance.Get(ctx, "foo")
+	is.Equal(limiter.ErrStoreModeNotSupported, err)
+
+	instance.Options.StoreMode = limiter.StoreModeLeakyBucket
+	_, err = instance.Get(ctx, "foo")
+	is.Equal(limiter.ErrStoreModeNotSupported, err)
+
+	err = instance.ResetNamespace(ctx, "foo")
+	is.Equal(limiter.ErrNamespaceNotSupported, err)
+}
+
+func TestLimiterPrefix(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	store := memory.NewStore()
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	app1 := limiter.New(store, rate, limiter.WithPrefix("app1:"))
+	app2 := limiter.New(store, rate, limiter.WithPrefix("app2:"))
+	app3 := limiter.New(store, rate, limiter.WithPrefix("app3:"), limiter.WithStoreMode(limiter.StoreModeSliding))
+	raw := limiter.New(store, rate)
+
+	for i := 1; i <= 3; i++ {
+		_, err := app1.Get(ctx, "8.8.8.8")
+		is.NoError(err)
+	}
+	_, err := app2.Increment(ctx, "8.8.8.8", 2)
+	is.NoError(err)
+	_, err = app3.Get(ctx, "8.8.8.8")
+	is.NoError(err)
+
+	// Scenario #1 : limiters with different prefixes don't share their counters.
+	lctx, err := app1.Peek(ctx, "8.8.8.8")
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Remaining)
+
+	lctx, err = app2.Peek(ctx, "8.8.8.8")
+	is.NoError(err)
+	is.Equal(int64(8), lctx.Remaining)
+
+	lctx, err = app3.Peek(ctx, "8.8.8.8")
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	// Scenario #2 : identifiers are stored with their prefix, and as is without prefix.
+	lctx, err = raw.Peek(ctx, "app1:8.8.8.8")
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Remaining)
+
+	lctx, err = raw.Peek(ctx, "8.8.8.8")
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+
+	// Scenario #3 : reset only applies to the counter of the limiter.
+	_, err = app1.Reset(ctx, "8.8.8.8")
+	is.NoError(err)
+
+	lctx, err = app1.Peek(ctx, "8.8.8.8")
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+
+	lctx, err = app2.Peek(ctx, "8.8.8.8")
+	is.NoError(err)
+	is.Equal(int64(8), lctx.Remaining)
+
+	// Scenario #4 : namespace reset only applies to the counters of the limiter.
+	is.NoError(app2.ResetNamespace(ctx, ""))
+
+	lctx, err = app2.Peek(ctx, "8.8.8.8")
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+
+	lctx, err = app3.Peek(ctx, "8.8.8.8")
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+}
+
+func TestLimiterStoreError(t *testing.T) {
+	is := require.New(t)
+
+	cause := errors.New("dial tcp 127.0.0.1:6379: connect: connection refused")
+	err := fmt.Errorf("an error has occurred: %w", &limiter.StoreError{
+		Kind: limiter.ErrStoreUnavailable,
+		Err:  cause,
+	})
+
+	is.True(errors.Is(err, limiter.ErrStoreUnavailable))
+	is.True(errors.Is(err, cause))
+	is.False(errors.Is(err, limiter.ErrStoreTimeout))
+	is.Equal("an error has occurred: store is unavailable: dial tcp 127.0.0.1:6379: connect: connection refused",
+		err.Error())
+
+	var storeErr *limiter.StoreError
+	is.True(errors.As(err, &storeErr))
+	is.Equal(limiter.ErrStoreUnavailable, storeErr.Kind)
+	is.Equal(cause, storeErr.Err)
+}
+
+func TestLimiterFailOpen(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	scenarios := []struct {
+		options []limiter.Option
+		reached bool
+	}{
+		{
+			// Scenario #1 : fail open by default.
+			options: nil,
+			reached: false,
+		},
+		{
+			// Scenario #2 : fail open.
+			options: []limiter.Option{limiter.WithFailOpen(true)},
+			reached: false,
+		},
+		{
+			// Scenario #3 : fail closed.
+			options: []limiter.Option{limiter.WithFailOpen(false)},
+			reached: true,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		instance := limiter.New(failingStore{}, limiter.Rate{
+			Period: 1 * time.Second,
+			Limit:  int64(10),
+		}, scenario.options...)
+
+		lctx, err := instance.Get(ctx, "foo")
+		is.Equal(errStoreFailure, err, "scenario #%d", i+1)
+		is.Equal(scenario.reached, lctx.Reached, "scenario #%d", i+1)
+		is.Equal(int64(10), lctx.Limit, "scenario #%d", i+1)
+		is.Equal(!scenario.reached, lctx.Meta.FailOpen, "scenario #%d", i+1)
+
+		lctx, err = instance.Increment(ctx, "foo", 2)
+		is.Equal(errStoreFailure, err, "scenario #%d", i+1)
+		is.Equal(scenario.reached, lctx.Reached, "scenario #%d", i+1)
+		is.Equal(!scenario.reached, lctx.Meta.FailOpen, "scenario #%d", i+1)
+	}
+}
+
+func TestLimiterContextMeta(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	modes := []limiter.StoreMode{
+		limiter.StoreModeFixed,
+		limiter.StoreModeSliding,
+		limiter.StoreModeTokenBucket,
+		limiter.StoreModeLeakyBucket,
+	}
+
+	for _, mode := range modes {
+		message := fmt.Sprintf("mode %s", mode)
+		instance := limiter.New(memory.NewStore(), rate, limiter.WithStoreMode(mode))
+
+		lctx, err := instance.Get(ctx, "foo")
+		is.NoError(err, message)
+		is.Equal(limiter.Meta{Algorithm: mode}, lctx.Meta, message)
+		is.False(lctx.Reached, message)
+
+		lctx, err = instance.Peek(ctx, "foo")
+		is.NoError(err, message)
+		is.Equal(mode, lctx.Meta.Algorithm, message)
+
+		lctx, err = instance.Reset(ctx, "foo")
+		is.NoError(err, message)
+		is.Equal(mode, lctx.Meta.Algorithm, message)
+	}
+
+	// The fail-open flag is only set when the store fails.
+	instance := limiter.New(failingStore{}, rate, limiter.WithStoreMode(limiter.StoreModeSliding))
+
+	lctx, err := instance.Get(ctx, "foo")
+	is.Error(err)
+	is.Equal(limiter.Meta{Algorithm: limiter.StoreModeSliding, FailOpen: true}, lctx.Meta)
+	is.False(lctx.Reached)
+
+	is.Equal("fixed", limiter.StoreModeFixed.String())
+	is.Equal("token-bucket", limiter.StoreModeTokenBucket.String())
+}
+
+var errStoreFailure = errors.New("store failure")
+
+// failingStore is a store failing on every operation.
+type failingStore struct{}
+
+func (failingStore) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return limiter.Context{}, errStoreFailure
+}
+
+func (failingStore) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return limiter.Context{}, errStoreFailure
+}
+
+func (failingStore) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return limiter.Context{}, errStoreFailure
+}
+
+func (failingStore) Increment(ctx context.Context, key string, count int64,
+	rate limiter.Rate) (limiter.Context, error) {
+
+	return limiter.Context{}, errStoreFailure
+}
+
+func TestLimiterRateResolver(t *testing.T) {
+	is := require.New(t)
+
+	search := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(2),
+	}
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(5),
+	}, limiter.WithRateResolver(func(r *http.Request) limiter.Rate {
+		if r.URL.Path == "/search" {
+			return search
+		}
+		return limiter.Rate{}
+	}))
+
+	scenarios := []struct {
+		path  string
+		limit int64
+	}{
+		{
+			// Scenario #1 : stricter rate.
+			path:  "/search",
+			limit: 2,
+		},
+		{
+			// Scenario #2 : limiter rate, with its own counter.
+			path:  "/status",
+			limit: 5,
+		},
+		{
+			// Scenario #3 : limiter rate, sharing the counter of the same rate.
+			path:  "/health",
+			limit: 5,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		request, err := http.NewRequest("GET", scenario.path, nil)
+		is.NoError(err)
+
+		rate := instance.GetRate(request)
+		is.Equal(scenario.limit, rate.Limit, "scenario #%d", i+1)
+
+		lctx, err := instance.GetWithRequest(request, "foo")
+		is.NoError(err, "scenario #%d", i+1)
+		is.Equal(scenario.limit, lctx.Limit, "scenario #%d", i+1)
+
+		if scenario.path == "/health" {
+			is.Equal(scenario.limit-2, lctx.Remaining, "scenario #%d", i+1)
+		} else {
+			is.Equal(scenario.limit-1, lctx.Remaining, "scenario #%d", i+1)
+		}
+	}
+}
+
+func TestLimiterRateByClaim(t *testing.T) {
+	is := require.New(t)
+
+	secret := "javad"
+	rates := map[string]limiter.Rate{
+		"pro":        {Period: 1 * time.Minute, Limit: int64(100)},
+		"enterprise": {Period: 1 * time.Minute, Limit: int64(1000)},
+	}
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}, limiter.WithJWTSecret(secret), limiter.WithRateByClaim("plan", rates))
+
+	sign := func(claims jwt.MapClaims, secret string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		is.NoError(err)
+		return token
+	}
+
+	scenarios := []struct {
+		token string
+		limit int64
+	}{
+		{
+			// Scenario #1 : free tier, unmapped.
+			token: sign(jwt.MapClaims{"sub": "foo", "plan": "free"}, secret),
+			limit: 10,
+		},
+		{
+			// Scenario #2 : pro tier.
+			token: sign(jwt.MapClaims{"sub": "foo", "plan": "pro"}, secret),
+			limit: 100,
+		},
+		{
+			// Scenario #3 : enterprise tier.
+			token: sign(jwt.MapClaims{"sub": "foo", "plan": "enterprise"}, secret),
+			limit: 1000,
+		},
+		{
+			// Scenario #4 : absent claim.
+			token: sign(jwt.MapClaims{"sub": "foo"}, secret),
+			limit: 10,
+		},
+		{
+			// Scenario #5 : invalid JWT.
+			token: sign(jwt.MapClaims{"sub": "foo", "plan": "enterprise"}, "foo"),
+			limit: 10,
+		},
+		{
+			// Scenario #6 : no JWT.
+			limit: 10,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		request, err := http.NewRequest("GET", "/", nil)
+		is.NoError(err)
+		if scenario.token != "" {
+			request.Header.Set("Authorization", "Bearer "+scenario.token)
+		}
+
+		rate := instance.GetRate(request)
+		is.Equal(scenario.limit, rate.Limit, "scenario #%d", i+1)
+
+		lctx, err := instance.GetWithRequest(request, "foo")
+		is.NoError(err, "scenario #%d", i+1)
+		is.Equal(scenario.limit, lctx.Limit, "scenario #%d", i+1)
+	}
+
+	// Tiers don't share their counters, but the default rate is shared by unmapped requests.
+	lctx, err := instance.Peek(context.Background(), "foo:100-1m0s")
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	lctx, err = instance.Peek(context.Background(), "foo:10-1m0s")
+	is.NoError(err)
+	is.Equal(int64(6), lctx.Remaining)
+}
+
+// fakeCountryResolver is a CountryResolver mapping IPs to countries.
+type fakeCountryResolver map[string]string
+
+func (resolver fakeCountryResolver) Country(ip net.IP) (string, bool) {
+	country, ok := resolver[ip.String()]
+	return country, ok
+}
+
+func TestLimiterRateByCountry(t *testing.T) {
+	is := require.New(t)
+
+	resolver := fakeCountryResolver{
+		"192.0.2.1":    "FR",
+		"192.0.2.2":    "FR",
+		"198.51.100.1": "XX",
+		"203.0.113.1":  "US",
+	}
+	rates := map[string]limiter.Rate{
+		"XX": {Period: 1 * time.Minute, Limit: int64(2)},
+		"FR": {Period: 1 * time.Minute, Limit: int64(100)},
+	}
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}, limiter.WithRateByCountry(resolver, rates))
+
+	scenarios := []struct {
+		ip    string
+		limit int64
+	}{
+		{
+			// Scenario #1 : mapped country.
+			ip:    "192.0.2.1",
+			limit: 100,
+		},
+		{
+			// Scenario #2 : stricter rate of an unserved country.
+			ip:    "198.51.100.1",
+			limit: 2,
+		},
+		{
+			// Scenario #3 : unmapped country.
+			ip:    "203.0.113.1",
+			limit: 10,
+		},
+		{
+			// Scenario #4 : country not found.
+			ip:    "192.0.2.3",
+			limit: 10,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		request, err := http.NewRequest("GET", "/", nil)
+		is.NoError(err)
+		request.RemoteAddr = scenario.ip + ":1234"
+
+		rate := instance.GetRate(request)
+		is.Equal(scenario.limit, rate.Limit, "scenario #%d", i+1)
+
+		lctx, err := instance.GetWithRequest(request, instance.GetIPKey(request))
+		is.NoError(err, "scenario #%d", i+1)
+		is.Equal(scenario.limit, lctx.Limit, "scenario #%d", i+1)
+		is.Equal(scenario.limit-1, lctx.Remaining, "scenario #%d", i+1)
+	}
+
+	// Countries don't share their counters, but the default rate is shared by unmapped requests.
+	request, err := http.NewRequest("GET", "/", nil)
+	is.NoError(err)
+	request.RemoteAddr = "192.0.2.2:1234"
+
+	lctx, err := instance.GetWithRequest(request, "foo")
+	is.NoError(err)
+	is.Equal(int64(99), lctx.Remaining)
+
+	lctx, err = instance.Peek(context.Background(), "foo:10-1m0s")
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+}
+
+func TestLimiterGetAuto(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	secret := "javad"
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}, limiter.WithJWTSecret(secret))
+
+	sign := func(claims jwt.MapClaims, secret string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		is.NoError(err)
+		return token
+	}
+
+	valid := sign(jwt.MapClaims{"sub": "foo"}, secret)
+
+	scenarios := []struct {
+		token string
+		sub   bool
+	}{
+		{
+			// Scenario #1 : valid JWT.
+			token: valid,
+			sub:   true,
+		},
+		{
+			// Scenario #2 : missing JWT.
+			token: "",
+			sub:   false,
+		},
+		{
+			// Scenario #3 : malformed JWT.
+			token: "foo.bar",
+			sub:   false,
+		},
+		{
+			// Scenario #4 : JWT signed with another secret.
+			token: sign(jwt.MapClaims{"sub": "foo"}, "foo"),
+			sub:   false,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		request, err := http.NewRequest("GET", "/", nil)
+		is.NoError(err)
+		request.RemoteAddr = "8.8.8.8:1234"
+		if scenario.token != "" {
+			request.Header.Set("Authorization", "Bearer "+scenario.token)
+		}
+
+		lctx, err := instance.GetAuto(request)
+		is.NoError(err, "scenario #%d", i+1)
+		is.Equal(int64(10), lctx.Limit, "scenario #%d", i+1)
+	}
+
+	// An authenticated request is keyed by its sub, whereas the others share the key of their IP.
+	request, err := http.NewRequest("GET", "/", nil)
+	is.NoError(err)
+	request.Header.Set("Authorization", "Bearer "+valid)
+
+	sub, err := instance.GetJWTSub(request)
+	is.NoError(err)
+
+	lctx, err := instance.Peek(ctx, "sub:"+sub)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	lctx, err = instance.Peek(ctx, "ip:8.8.8.8")
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Remaining)
+}
+
+func TestLimiterCountWithRequest(t *testing.T) {
+	is := require.New(t)
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(2),
+	}, limiter.WithCountStatusCodes(http.StatusUnauthorized, http.StatusForbidden))
+
+	is.True(instance.IsStatusCounted(http.StatusUnauthorized))
+	is.True(instance.IsStatusCounted(http.StatusForbidden))
+	is.False(instance.IsStatusCounted(http.StatusOK))
+
+	request, err := http.NewRequest("POST", "/login", nil)
+	is.NoError(err)
+
+	// Scenario #1 : status isn't counted.
+	lctx, err := instance.CountWithRequest(request, "foo", http.StatusOK)
+	is.NoError(err)
+	is.Equal(int64(2), lctx.Remaining)
+
+	// Scenario #2 : status is counted.
+	lctx, err = instance.CountWithRequest(request, "foo", http.StatusUnauthorized)
+	is.NoError(err)
+	is.Equal(int64(1), lctx.Remaining)
+
+	lctx, err = instance.CheckWithRequest(request, "foo")
+	is.NoError(err)
+	is.Equal(int64(1), lctx.Remaining)
+	is.False(lctx.Reached)
+
+	// Scenario #3 : no request remains.
+	_, err = instance.CountWithRequest(request, "foo", http.StatusForbidden)
+	is.NoError(err)
+
+	lctx, err = instance.CheckWithRequest(request, "foo")
+	is.NoError(err)
+	is.Equal(int64(0), lctx.Remaining)
+	is.True(lctx.Reached)
+
+	// Every status is counted by default.
+	instance.Options.CountStatusCodes = nil
+	is.True(instance.IsStatusCounted(http.StatusOK))
+}
+
+func TestLimiterByteQuota(t *testing.T) {
+	is := require.New(t)
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(1000),
+	}, limiter.WithByteQuota(150))
+
+	newRequest := func(size int64) *http.Request {
+		request, err := http.NewRequest("POST", "/upload", nil)
+		is.NoError(err)
+		request.ContentLength = size
+		return request
+	}
+
+	// Scenario #1 : requests are charged their body size.
+	lctx, err := instance.GetWithRequest(newRequest(300), "foo")
+	is.NoError(err)
+	is.Equal(int64(700), lctx.Remaining)
+
+	lctx, err = instance.GetWithRequest(newRequest(500), "foo")
+	is.NoError(err)
+	is.Equal(int64(200), lctx.Remaining)
+
+	// Scenario #2 : request of unknown size is charged the default cost.
+	lctx, err = instance.GetWithRequest(newRequest(-1), "foo")
+	is.NoError(err)
+	is.Equal(int64(50), lctx.Remaining)
+	is.False(lctx.Reached)
+
+	// Scenario #3 : empty request is charged one byte.
+	lctx, err = instance.GetWithRequest(newRequest(0), "foo")
+	is.NoError(err)
+	is.Equal(int64(49), lctx.Remaining)
+
+	// Scenario #4 : quota is exceeded.
+	lctx, err = instance.GetWithRequest(newRequest(100), "foo")
+	is.NoError(err)
+	is.Equal(int64(0), lctx.Remaining)
+	is.True(lctx.Reached)
+
+	// Scenario #5 : counted requests are charged their body size too.
+	lctx, err = instance.CountWithRequest(newRequest(400), "bar", http.StatusOK)
+	is.NoError(err)
+	is.Equal(int64(600), lctx.Remaining)
+}
+
+func TestLimiterRateAdjuster(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	healthy := true
+	adjuster := limiter.RateAdjusterFunc(func(base limiter.Rate) limiter.Rate {
+		if healthy {
+			return limiter.Rate{}
+		}
+		base.Limit /= 2
+		return base
+	})
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}, limiter.WithRateAdjuster(adjuster))
+
+	// Scenario #1 : base rate is used while healthy.
+	lctx, err := instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Limit)
+	is.Equal(int64(9), lctx.Remaining)
+
+	// Scenario #2 : rate is halved while unhealthy, sharing the same counter.
+	healthy = false
+
+	lctx, err = instance.Peek(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(5), lctx.Limit)
+	is.Equal(int64(4), lctx.Remaining)
+
+	for i := 1; i <= 5; i++ {
+		lctx, err = instance.Get(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(5), lctx.Limit)
+		is.Equal(i == 5, lctx.Reached)
+	}
+
+	// Scenario #3 : adjuster sees the rate resolved for the request.
+	instance.Options.RateResolver = func(r *http.Request) limiter.Rate {
+		return limiter.Rate{Period: 1 * time.Minute, Limit: int64(4)}
+	}
+
+	request, err := http.NewRequest("GET", "/", nil)
+	is.NoError(err)
+
+	lctx, err = instance.GetWithRequest(request, "bar")
+	is.NoError(err)
+	is.Equal(int64(2), lctx.Limit)
+	is.Equal(int64(1), lctx.Remaining)
+
+	// Scenario #4 : base rate is restored once healthy.
+	healthy = true
+
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Limit)
+	is.Equal(int64(3), lctx.Remaining)
+	is.False(lctx.Reached)
+}
+
+func TestLimiterGetWithLabel(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	// Scenario #1 : label is attached to the counter, with the limiter prefix.
+	instance := New(limiter.WithPrefix("api:"))
+
+	lctx, err := instance.GetWithLabel(ctx, "foo", "account:42")
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+	is.Equal(limiter.StoreModeFixed, lctx.Meta.Algorithm)
+
+	label, err := instance.GetLabel(ctx, "foo")
+	is.NoError(err)
+	is.Equal("account:42", label)
+
+	label, err = instance.Store.(limiter.LabelStore).GetLabel(ctx, "api:foo")
+	is.NoError(err)
+	is.Equal("account:42", label)
+
+	// Scenario #2 : labels are only supported with a fixed window.
+	instance = New(limiter.WithStoreMode(limiter.StoreModeSliding))
+
+	_, err = instance.GetWithLabel(ctx, "foo", "account:42")
+	is.Equal(limiter.ErrLabelNotSupported, err)
+}
+
+func TestLimiterRefund(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	// Scenario #1 : refund restores remaining, with the limiter prefix.
+	instance := New(limiter.WithPrefix("api:"))
+
+	_, err := instance.Increment(ctx, "foo", 4)
+	is.NoError(err)
+
+	is.NoError(instance.Refund(ctx, "foo", 1))
+
+	lctx, err := instance.Peek(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Remaining)
+
+	// Scenario #2 : amount must be positive.
+	is.Equal(limiter.ErrInvalidCost, instance.Refund(ctx, "foo", 0))
+	is.Equal(limiter.ErrInvalidCost, instance.Refund(ctx, "foo", -1))
+
+	// Scenario #3 : refunds aren't supported by token buckets.
+	instance = New(limiter.WithStoreMode(limiter.StoreModeTokenBucket))
+	is.Equal(limiter.ErrRefundNotSupported, instance.Refund(ctx, "foo", 1))
+}
+
+func TestLimiterGetWithRate(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Now())
+	store := memory.NewStoreWithOptions(limiter.StoreOptions{Prefix: "limiter", Clock: clock})
+	instance := limiter.New(store, limiter.Rate{
+		Period: 1 * time.Hour,
+		Limit:  int64(100),
+	}, limiter.WithClock(clock))
+
+	reset := limiter.Rate{Period: 1 * time.Minute, Limit: int64(2)}
+	signup := limiter.Rate{Period: 10 * time.Minute, Limit: int64(5)}
+
+	// Scenario #1 : each key is limited by its own rate, with a TTL derived from its period.
+	for i := 1; i <= 3; i++ {
+		lctx, err := instance.GetWithRate(ctx, "reset:foo", reset)
+		is.NoError(err)
+		is.Equal(int64(2), lctx.Limit)
+		is.Equal(i > 2, lctx.Reached)
+		is.Equal(clock.Now().Add(1*time.Minute).Unix(), lctx.Reset)
+	}
+
+	lctx, err := instance.GetWithRate(ctx, "signup:foo", signup)
+	is.NoError(err)
+	is.Equal(int64(5), lctx.Limit)
+	is.Equal(int64(4), lctx.Remaining)
+	is.Equal(clock.Now().Add(10*time.Minute).Unix(), lctx.Reset)
+
+	// Scenario #2 : the limiter rate is untouched.
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(99), lctx.Remaining)
+
+	// Scenario #3 : counters expire after the period of their rate.
+	clock.Advance(1*time.Minute + 1*time.Second)
+
+	lctx, err = instance.GetWithRate(ctx, "reset:foo", reset)
+	is.NoError(err)
+	is.Equal(int64(1), lctx.Remaining)
+
+	lctx, err = instance.GetWithRate(ctx, "signup:foo", signup)
+	is.NoError(err)
+	is.Equal(int64(3), lctx.Remaining)
+
+	// Scenario #4 : invalid rate.
+	_, err = instance.GetWithRate(ctx, "foo", limiter.Rate{Limit: int64(5)})
+	is.Equal(limiter.ErrInvalidRate, err)
+}
+
+func TestLimiterGetWithAllIPs(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(3),
+	}, limiter.WithTrustForwardHeader(true))
+
+	newRequest := func(chain string) *http.Request {
+		request, err := http.NewRequest("GET", "/", nil)
+		is.NoError(err)
+		request.RemoteAddr = "5.5.5.5:8888"
+		request.Header.Set("X-Forwarded-For", chain)
+		return request
+	}
+
+	// Scenario #1 : every IP of the chain is counted.
+	lctx, err := instance.GetWithAllIPs(newRequest("9.9.9.9, 7.7.7.7"))
+	is.NoError(err)
+	is.Equal(int64(2), lctx.Remaining)
+
+	for _, key := range []string{"9.9.9.9", "7.7.7.7", "5.5.5.5"} {
+		lctx, err = instance.Peek(ctx, key)
+		is.NoError(err)
+		is.Equal(int64(2), lctx.Remaining, key)
+	}
+
+	// Scenario #2 : the most restrictive IP is reported.
+	lctx, err = instance.GetWithAllIPs(newRequest("8.8.8.8, 7.7.7.7"))
+	is.NoError(err)
+	is.Equal(int64(1), lctx.Remaining)
+	is.False(lctx.Reached)
+
+	// Scenario #3 : a new client is rejected once a proxy of its chain is over its limit.
+	_, err = instance.GetWithAllIPs(newRequest("6.6.6.6, 7.7.7.7"))
+	is.NoError(err)
+
+	lctx, err = instance.GetWithAllIPs(newRequest("4.4.4.4"))
+	is.NoError(err)
+	is.True(lctx.Reached)
+
+	lctx, err = instance.Peek(ctx, "4.4.4.4")
+	is.NoError(err)
+	is.Equal(int64(2), lctx.Remaining)
+}
+
+// keyFailingStore is a store failing on the operations of a key.
+type keyFailingStore struct {
+	limiter.Store
+	key string
+}
+
+func (store keyFailingStore) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	if key == store.key {
+		return limiter.Context{}, errStoreFailure
+	}
+	return store.Store.Get(ctx, key, rate)
+}
+
+func TestLimiterGetWithAllIPsDecision(t *testing.T) {
+	is := require.New(t)
+
+	decisions := map[string]bool{}
+	calls := 0
+	reached := []string{}
+
+	instance := limiter.New(keyFailingStore{Store: memory.NewStore(), key: "6.6.6.6"}, limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(1),
+	}, limiter.WithTrustForwardHeader(true), limiter.WithFailOpen(true),
+		limiter.WithOnDecision(func(key string, allowed bool, context limiter.Context) {
+			decisions[key] = allowed
+			calls++
+		}), limiter.WithOnLimitReached(func(event limiter.LimitEvent) {
+			reached = append(reached, event.Key)
+		}))
+
+	newRequest := func(chain string) *http.Request {
+		request, err := http.NewRequest("GET", "/", nil)
+		is.NoError(err)
+		request.RemoteAddr = "5.5.5.5:8888"
+		request.Header.Set("X-Forwarded-For", chain)
+		return request
+	}
+
+	// Scenario #1 : a request is decided once, whatever the number of IPs of its chain.
+	lctx, err := instance.GetWithAllIPs(newRequest("9.9.9.9, 8.8.8.8, 7.7.7.7"))
+	is.NoError(err)
+	is.False(lctx.Reached)
+	is.Equal(1, calls)
+	is.Empty(reached)
+
+	// Scenario #2 : a rejected request is reported once, with the key of the IP over its limit.
+	calls = 0
+	decisions = map[string]bool{}
+
+	lctx, err = instance.GetWithAllIPs(newRequest("4.4.4.4, 7.7.7.7"))
+	is.NoError(err)
+	is.True(lctx.Reached)
+	is.Equal(1, calls)
+	is.Equal(map[string]bool{"7.7.7.7": false}, decisions)
+	is.Equal([]string{"7.7.7.7"}, reached)
+
+	// Scenario #3 : an IP over its limit wins over a failing store, even with FailOpen.
+	calls = 0
+	reached = reached[:0]
+
+	lctx, err = instance.GetWithAllIPs(newRequest("6.6.6.6, 7.7.7.7"))
+	is.NoError(err)
+	is.True(lctx.Reached)
+	is.Equal(1, calls)
+	is.Equal([]string{"7.7.7.7"}, reached)
+
+	// Scenario #4 : otherwise, the store error is returned with the context of the failing IP.
+	calls = 0
+	decisions = map[string]bool{}
+
+	request := newRequest("6.6.6.6")
+	request.RemoteAddr = "3.3.3.3:8888"
+
+	lctx, err = instance.GetWithAllIPs(request)
+	is.Equal(errStoreFailure, err)
+	is.False(lctx.Reached)
+	is.True(lctx.Meta.FailOpen)
+	is.Equal(1, calls)
+	is.Equal(map[string]bool{"6.6.6.6": true}, decisions)
+}
+
+// slowStore is a store with a latency, counting its round trips.
+type slowStore struct {
+	limiter.Store
+	latency time.Duration
+	peeks   int64
+}
+
+func (store *slowStore) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	atomic.AddInt64(&store.peeks, 1)
+	time.Sleep(store.latency)
+	return store.Store.Peek(ctx, key, rate)
+}
+
+func TestLimiterCoalescePeeks(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	store := &slowStore{Store: memory.NewStore(), latency: 50 * time.Millisecond}
+	instance := limiter.New(store, limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(100),
+	}, limiter.WithCoalescePeeks(true))
+
+	goroutines := 10
+
+	// Scenario #1 : concurrent peeks share their store operation.
+	wg := &sync.WaitGroup{}
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			lctx, err := instance.Peek(ctx, "foo")
+			is.NoError(err)
+			is.Equal(int64(100), lctx.Remaining)
+		}()
+	}
+	wg.Wait()
+
+	is.Less(atomic.LoadInt64(&store.peeks), int64(goroutines))
+
+	// Scenario #2 : increments are never coalesced.
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := instance.Get(ctx, "foo")
+			is.NoError(err)
+		}()
+	}
+	wg.Wait()
+
+	lctx, err := instance.Peek(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(90), lctx.Remaining)
+}
+
+func BenchmarkLimiterPeek(b *testing.B) {
+	for _, coalesce := range []bool{false, true} {
+		b.Run(fmt.Sprintf("coalesce=%t", coalesce), func(b *testing.B) {
+			ctx := context.Background()
+			store := &slowStore{Store: memory.NewStore(), latency: 100 * time.Microsecond}
+			instance := limiter.New(store, limiter.Rate{
+				Period: 1 * time.Minute,
+				Limit:  int64(100),
+			}, limiter.WithCoalescePeeks(coalesce))
+
+			// Requests mostly wait for the store, so a burst is simulated with more goroutines than CPUs.
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, err := instance.Peek(ctx, "foo")
+					if err != nil {
+						b.Error(err)
+					}
+				}
+			})
+
+			b.ReportMetric(float64(atomic.LoadInt64(&store.peeks))/float64(b.N), "roundtrips/op")
+		})
+	}
+}
+
+func TestLimiterCoalescePeeksCancellation(t *testing.T) {
+	is := require.New(t)
+
+	store := &slowStore{Store: memory.NewStore(), latency: 50 * time.Millisecond}
+	instance := limiter.New(store, limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(100),
+	}, limiter.WithCoalescePeeks(true))
+
+	// The first caller is canceled while the shared peek is running, whereas a follower still obtains its result.
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := instance.Peek(leaderCtx, "foo")
+		leaderErr <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	lctx, err := instance.Peek(context.Background(), "foo")
+	is.NoError(err)
+	is.Equal(int64(100), lctx.Remaining)
+
+	is.Equal(context.Canceled, <-leaderErr)
+	is.Equal(int64(1), atomic.LoadInt64(&store.peeks))
+}
+
+func TestContextResetTime(t *testing.T) {
+	is := require.New(t)
+
+	lctx := limiter.Context{
+		Reset: 1600000042,
+	}
+
+	reset := lctx.ResetTime()
+	is.Equal(int64(1600000042), reset.Unix())
+	is.Equal(time.UTC, reset.Location())
+	is.Equal("2020-09-13T12:27:22Z", reset.Format(time.RFC3339))
+}
+
+func TestLimiterOnDecision(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	type decision struct {
+		key     string
+		allowed bool
+	}
+
+	decisions := []decision{}
+	onDecision := func(key string, allowed bool, context limiter.Context) {
+		is.Equal(!context.Reached, allowed)
+		decisions = append(decisions, decision{key: key, allowed: allowed})
+	}
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(2),
+	}, limiter.WithOnDecision(onDecision))
+
+	_, err := instance.Get(ctx, "foo")
+	is.NoError(err)
+	_, err = instance.Peek(ctx, "foo")
+	is.NoError(err)
+	_, err = instance.Increment(ctx, "foo", 1)
+	is.NoError(err)
+	_, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	_, err = instance.Reset(ctx, "foo")
+	is.NoError(err)
+
+	is.Equal([]decision{
+		{key: "foo", allowed: true},
+		{key: "foo", allowed: true},
+		{key: "foo", allowed: false},
+	}, decisions)
+
+	// A multi limiter notifies once per decision, for every rate.
+	decisions = []decision{}
+	multi := limiter.NewMultiLimiter(memory.NewStore(), []limiter.Rate{
+		{Period: 1 * time.Second, Limit: int64(10)},
+		{Period: 1 * time.Hour, Limit: int64(1)},
+	}, limiter.WithOnDecision(onDecision))
+
+	_, err = multi.Get(ctx, "bar")
+	is.NoError(err)
+	_, err = multi.Get(ctx, "bar")
+	is.NoError(err)
+
+	is.Equal([]decision{
+		{key: "bar", allowed: true},
+		{key: "bar", allowed: false},
+	}, decisions)
+}
+
+func TestLimiterOnLimitReached(t *testing.T) {
+	is := require.New(t)
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(2),
+	}
+
+	events := []limiter.LimitEvent{}
+	instance := limiter.New(memory.NewStore(), rate, limiter.WithOnLimitReached(func(event limiter.LimitEvent) {
+		events = append(events, event)
+	}))
+
+	request, err := http.NewRequest("GET", "/", nil)
+	is.NoError(err)
+	request.RemoteAddr = "178.1.2.3:8080"
+
+	for i := 0; i < 2; i++ {
+		_, err = instance.GetWithRequest(request, "foo")
+		is.NoError(err)
+	}
+	is.Empty(events)
+
+	for i := 0; i < 2; i++ {
+		lctx, err := instance.GetWithRequest(request, "foo")
+		is.NoError(err)
+		is.True(lctx.Reached)
+	}
+
+	is.Len(events, 2)
+	for _, event := range events {
+		is.Equal("foo", event.Key)
+		is.Equal("178.1.2.3", event.IP.String())
+		is.Equal(rate, event.Rate)
+		is.Equal(int64(0), event.Remaining)
+		is.Equal(request, event.Request)
+	}
+}
+
+func TestLimiterGetWithCost(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	})
+
+	for _, cost := range []int64{0, -1} {
+		_, err := instance.GetWithCost(ctx, "foo", cost)
+		is.Equal(limiter.ErrInvalidCost, err)
+	}
+
+	lctx, err := instance.GetWithCost(ctx, "foo", 1)
+	is.NoError(err)
+	is.False(lctx.Reached)
+	is.Equal(int64(9), lctx.Remaining)
+
+	lctx, err = instance.GetWithCost(ctx, "foo", 9)
+	is.NoError(err)
+	is.False(lctx.Reached)
+	is.Equal(int64(0), lctx.Remaining)
+
+	lctx, err = instance.GetWithCost(ctx, "foo", 1)
+	is.NoError(err)
+	is.True(lctx.Reached)
+
+	// A single costly request exhausts the window.
+	lctx, err = instance.GetWithCost(ctx, "bar", 11)
+	is.NoError(err)
+	is.True(lctx.Reached)
+	is.Equal(int64(0), lctx.Remaining)
+
+	lctx, err = instance.Get(ctx, "bar")
+	is.NoError(err)
+	is.True(lctx.Reached)
+
+	for _, mode := range []limiter.StoreMode{limiter.StoreModeSliding, limiter.StoreModeTokenBucket} {
+		instance := limiter.New(memory.NewStore(), limiter.Rate{
+			Period: 1 * time.Minute,
+			Limit:  int64(10),
+		}, limiter.WithStoreMode(mode))
+
+		lctx, err = instance.GetWithCost(ctx, "foo", 10)
+		is.NoError(err)
+		is.False(lctx.Reached)
+		is.Equal(int64(0), lctx.Remaining)
+
+		lctx, err = instance.GetWithCost(ctx, "foo", 1)
+		is.NoError(err)
+		is.True(lctx.Reached)
+	}
+}
+
+func TestLimiterClock(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+
+	{
+		// Fixed window.
+		clock := limitertest.NewFakeClock(now)
+		store := memory.NewStoreWithOptions(limiter.StoreOptions{
+			Prefix: limiter.DefaultPrefix,
+			Clock:  clock,
+		})
+		instance := limiter.New(store, rate, limiter.WithClock(clock))
+
+		lctx, err := instance.GetWithCost(ctx, "foo", 10)
+		is.NoError(err)
+		is.Equal(int64(0), lctx.Remaining)
+		is.Equal(now.Add(rate.Period).Unix(), lctx.Reset)
+
+		clock.Advance(59 * time.Second)
+		lctx, err = instance.Get(ctx, "foo")
+		is.NoError(err)
+		is.True(lctx.Reached)
+
+		clock.Advance(2 * time.Second)
+		lctx, err = instance.Get(ctx, "foo")
+		is.NoError(err)
+		is.False(lctx.Reached)
+		is.Equal(int64(9), lctx.Remaining)
+		is.Equal(now.Add(61*time.Second+rate.Period).Unix(), lctx.Reset)
+		is.Equal(clock.Now(), instance.Now())
+	}
+
+	{
+		// Sliding window, starting at the beginning of a window.
+		clock := limitertest.NewFakeClock(now)
+		store := memory.NewStoreWithOptions(limiter.StoreOptions{
+			Prefix: limiter.DefaultPrefix,
+			Clock:  clock,
+		})
+		instance := limiter.New(store, rate, limiter.WithClock(clock), limiter.WithStoreMode(limiter.StoreModeSliding))
+
+		_, err := instance.GetWithCost(ctx, "foo", 10)
+		is.NoError(err)
+
+		// Half of the previous window overlaps with the sliding window.
+		clock.Advance(90 * time.Second)
+		lctx, err := instance.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(5), lctx.Remaining)
+
+		// The previous window no longer overlaps with the sliding window.
+		clock.Advance(30 * time.Second)
+		lctx, err = instance.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(10), lctx.Remaining)
+	}
+
+	{
+		// Token bucket.
+		clock := limitertest.NewFakeClock(now)
+		store := memory.NewStoreWithOptions(limiter.StoreOptions{
+			Prefix: limiter.DefaultPrefix,
+			Clock:  clock,
+		})
+		instance := limiter.New(store, rate, limiter.WithClock(clock), limiter.WithStoreMode(limiter.StoreModeTokenBucket))
+
+		_, err := instance.GetWithCost(ctx, "foo", 10)
+		is.NoError(err)
+
+		clock.Advance(30 * time.Second)
+		lctx, err := instance.Peek(ctx, "foo")
+		is.NoError(err)
+		is.Equal(int64(5), lctx.Remaining)
+		is.Equal(now.Add(rate.Period).Unix(), lctx.Reset)
+	}
+}
+
+func TestLimiterGetBatch(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	instance := limiter.New(memory.NewStore(), limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	})
+
+	_, err := instance.GetBatch(ctx, "foo", 0)
+	is.Equal(limiter.ErrInvalidCost, err)
+
+	scenarios := []struct {
+		n         int64
+		remaining int64
+		reached   bool
+	}{
+		{
+			// Scenario #1 : batch within the limit.
+			n:         7,
+			remaining: 3,
+			reached:   false,
+		},
+		{
+			// Scenario #2 : batch partially over the limit.
+			n:         5,
+			remaining: 0,
+			reached:   true,
+		},
+		{
+			// Scenario #3 : batch entirely over the limit.
+			n:         1,
+			remaining: 0,
+			reached:   true,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		lctx, err := instance.GetBatch(ctx, "foo", scenario.n)
+		is.NoError(err, "scenario #%d", i+1)
+		is.Equal(int64(10), lctx.Limit, "scenario #%d", i+1)
+		is.Equal(scenario.remaining, lctx.Remaining, "scenario #%d", i+1)
+		is.Equal(scenario.reached, lctx.Reached, "scenario #%d", i+1)
+	}
+
+	// A batch exactly reaching the limit is allowed.
+	lctx, err := instance.GetBatch(ctx, "bar", 10)
+	is.NoError(err)
+	is.Equal(int64(0), lctx.Remaining)
+	is.False(lctx.Reached)
+}
+
+func TestLimiterGetWithTTL(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := limitertest.NewFakeClock(now)
+	store := memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix: limiter.DefaultPrefix,
+		Clock:  clock,
+	})
+	instance := limiter.New(store, limiter.Rate{
+		Period: 1 * time.Hour,
+		Limit:  int64(2),
+	}, limiter.WithClock(clock))
+
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		_, err := instance.GetWithTTL(ctx, "foo", ttl)
+		is.Equal(limiter.ErrInvalidTTL, err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		login, err := instance.GetWithTTL(ctx, "login", 15*time.Minute)
+		is.NoError(err)
+		is.Equal(i > 2, login.Reached)
+		is.Equal(int64(2), login.Limit)
+		is.Equal(now.Add(15*time.Minute).Unix(), login.Reset)
+
+		api, err := instance.GetWithTTL(ctx, "api", time.Hour)
+		is.NoError(err)
+		is.Equal(i > 2, api.Reached)
+		is.Equal(now.Add(time.Hour).Unix(), api.Reset)
+	}
+
+	// The login key expires first.
+	clock.Advance(16 * time.Minute)
+
+	login, err := instance.GetWithTTL(ctx, "login", 15*time.Minute)
+	is.NoError(err)
+	is.False(login.Reached)
+	is.Equal(int64(1), login.Remaining)
+
+	api, err := instance.GetWithTTL(ctx, "api", time.Hour)
+	is.NoError(err)
+	is.True(api.Reached)
+}
+
+func TestLimiterResetJitter(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := limitertest.NewFakeClock(now)
+	store := memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix: limiter.DefaultPrefix,
+		Clock:  clock,
+	})
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+	instance := limiter.New(store, rate, limiter.WithClock(clock), limiter.WithResetJitter(30*time.Second))
+
+	resets := map[string]int64{}
+	for _, key := range []string{"foo", "bar"} {
+		lctx, err := instance.Get(ctx, key)
+		is.NoError(err)
+		is.Equal(int64(9), lctx.Remaining)
+		is.True(lctx.Reset >= now.Add(rate.Period).Unix())
+		is.True(lctx.Reset < now.Add(rate.Period+30*time.Second).Unix())
+		resets[key] = lctx.Reset
+	}
+	is.NotEqual(resets["foo"], resets["bar"])
+
+	// Repeated reads agree on the reset of a key.
+	clock.Advance(10 * time.Second)
+	for key, reset := range resets {
+		lctx, err := instance.Peek(ctx, key)
+		is.NoError(err)
+		is.Equal(int64(9), lctx.Remaining)
+		is.Equal(reset, lctx.Reset)
+
+		lctx, err = instance.Get(ctx, key)
+		is.NoError(err)
+		is.Equal(int64(8), lctx.Remaining)
+		is.Equal(reset, lctx.Reset)
+	}
+
+	// Each key expires at its own reset.
+	clock.Set(time.Unix(resets["foo"], 0).Add(time.Second))
+	lctx, err := instance.Peek(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+
+	// Without jitter, keys created at the same time share their reset.
+	instance = limiter.New(store, rate, limiter.WithClock(clock))
+	foo, err := instance.Get(ctx, "jitter-foo")
+	is.NoError(err)
+	bar, err := instance.Get(ctx, "jitter-bar")
+	is.NoError(err)
+	is.Equal(foo.Reset, bar.Reset)
+}
+
+func TestLimiterLeakyBucket(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := limitertest.NewFakeClock(now)
+	store := memory.NewStoreWithOptions(limiter.StoreOptions{
+		Prefix: limiter.DefaultPrefix,
+		Clock:  clock,
+	})
+
+	// Requests are admitted every 10 seconds, the burst being ignored.
+	instance := limiter.New(store, limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(6),
+	}, limiter.WithClock(clock), limiter.WithStoreMode(limiter.StoreModeLeakyBucket), limiter.WithBurst(10))
+
+	lctx, err := instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.False(lctx.Reached)
+	is.Equal(int64(1), lctx.Limit)
+	is.Equal(int64(0), lctx.Remaining)
+
+	// The second request within the interval is rejected, until the next admission.
+	clock.Advance(4 * time.Second)
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.True(lctx.Reached)
+	is.Equal(now.Add(10*time.Second).Unix(), lctx.Reset)
+	is.Equal("6", lctx.Headers(limiter.HeaderStyleLegacy, clock.Now())["Retry-After"])
+
+	// Rejected requests don't delay the next admission.
+	clock.Advance(6 * time.Second)
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.False(lctx.Reached)
+
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.True(lctx.Reached)
+
+	// Requests aren't accumulated while idle.
+	clock.Advance(1 * time.Minute)
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.False(lctx.Reached)
+
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.True(lctx.Reached)
+}
+
+func TestLimiterWarmup(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	clock := limitertest.NewFakeClock(time.Now())
+	store := memory.NewStoreWithOptions(limiter.StoreOptions{Prefix: "limiter", Clock: clock})
+	instance := limiter.New(store, limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(3),
+	}, limiter.WithClock(clock), limiter.WithWarmup(4, 0.5))
+
+	// allowed returns the number of requests of given key allowed until its limit is reached.
+	allowed := func(key string) int64 {
+		count := int64(0)
+		for {
+			lctx, err := instance.Get(ctx, key)
+			is.NoError(err)
+			if lctx.Reached {
+				return count
+			}
+			count++
+		}
+	}
+
+	// Scenario #1 : a new key is allowed its warm-up requests in addition to the limit.
+	is.Equal(int64(7), allowed("foo"))
+
+	// Scenario #2 : the warm-up allowance decays over the following windows.
+	clock.Advance(1*time.Minute + time.Second)
+	is.Equal(int64(5), allowed("foo"))
+
+	clock.Advance(1*time.Minute + time.Second)
+	is.Equal(int64(4), allowed("foo"))
+
+	// Scenario #3 : the limit then settles to the base rate, and stays there while the key is active.
+	for i := 0; i < 10; i++ {
+		clock.Advance(1*time.Minute + time.Second)
+		is.Equal(int64(3), allowed("foo"), "window #%d", i+3)
+	}
+
+	// Scenario #4 : other keys have their own warm-up.
+	is.Equal(int64(7), allowed("bar"))
+
+	// Scenario #5 : a key unseen for twice the ramp is new again.
+	clock.Advance(7 * time.Minute)
+	is.Equal(int64(7), allowed("foo"))
+
+	// Scenario #6 : a peek applies the warm-up allowance, without recording the creation of a key.
+	lctx, err := instance.Peek(ctx, "foo")
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Limit)
+	is.Equal(int64(0), lctx.Remaining)
+
+	lctx, err = instance.Peek(ctx, "baz")
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Limit)
+	is.Equal(int64(7), lctx.Remaining)
+
+	clock.Advance(1*time.Minute + time.Second)
+	is.Equal(int64(7), allowed("baz"))
+}
+
+func TestLimiterWarmupCountStatusCodes(t *testing.T) {
+	is := require.New(t)
+
+	clock := limitertest.NewFakeClock(time.Now())
+	store := memory.NewStoreWithOptions(limiter.StoreOptions{Prefix: "limiter", Clock: clock})
+	instance := limiter.New(store, limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(1),
+	}, limiter.WithClock(clock), limiter.WithWarmup(3, 0.5), limiter.WithCountStatusCodes(http.StatusUnauthorized))
+
+	request, err := http.NewRequest("POST", "/login", nil)
+	is.NoError(err)
+
+	// A new key is allowed its warm-up requests when checked before counting responses.
+	count := 0
+	for ; count < 10; count++ {
+		lctx, err := instance.CheckWithRequest(request, "foo")
+		is.NoError(err)
+		if lctx.Reached {
+			break
+		}
+
+		_, err = instance.CountWithRequest(request, "foo", http.StatusUnauthorized)
+		is.NoError(err)
+	}
+	is.Equal(4, count)
+}
+
+func TestLimiterMaxKeyLength(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	rate := limiter.Rate{
+		Period: 1 * time.Minute,
+		Limit:  int64(10),
+	}
+	store := memory.NewStore()
+	instance := limiter.New(store, rate, limiter.WithPrefix("app:"), limiter.WithMaxKeyLength(64))
+
+	key := "https://example.com/search?q=" + strings.Repeat("a", 200)
+	hash := sha256.Sum256([]byte(key))
+	hashedKey := "app:sha256:" + hex.EncodeToString(hash[:16])
+
+	// Scenario #1 : a long key is hashed consistently.
+	for i := 1; i <= 3; i++ {
+		lctx, err := instance.Get(ctx, key)
+		is.NoError(err)
+		is.Equal(int64(10-i), lctx.Remaining)
+	}
+
+	lctx, err := store.Peek(ctx, hashedKey, rate)
+	is.NoError(err)
+	is.Equal(int64(7), lctx.Remaining)
+
+	lctx, err = store.Peek(ctx, "app:"+key, rate)
+	is.NoError(err)
+	is.Equal(int64(10), lctx.Remaining)
+
+	// Scenario #2 : long keys don't share their counters.
+	lctx, err = instance.Get(ctx, key+"b")
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+
+	// Scenario #3 : a key up to the maximum length is stored as is.
+	key = strings.Repeat("a", 64)
+
+	_, err = instance.Get(ctx, key)
+	is.NoError(err)
+
+	lctx, err = store.Peek(ctx, "app:"+key, rate)
+	is.NoError(err)
+	is.Equal(int64(9), lctx.Remaining)
+}
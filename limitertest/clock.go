@@ -0,0 +1,39 @@
+package limitertest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock only moving when told to, for testing windows and expirations deterministically.
+// It implements limiter.Clock.
+type FakeClock struct {
+	mutex sync.RWMutex
+	now   time.Time
+}
+
+// NewFakeClock returns a fake clock set to given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of this clock.
+func (clock *FakeClock) Now() time.Time {
+	clock.mutex.RLock()
+	defer clock.mutex.RUnlock()
+	return clock.now
+}
+
+// Set sets the current time of this clock.
+func (clock *FakeClock) Set(now time.Time) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = now
+}
+
+// Advance moves the current time of this clock by given duration.
+func (clock *FakeClock) Advance(duration time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = clock.now.Add(duration)
+}
@@ -0,0 +1,28 @@
+package limitertest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/limitertest"
+)
+
+func TestFakeClock(t *testing.T) {
+	is := require.New(t)
+
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var clock limiter.Clock = limitertest.NewFakeClock(now)
+	is.Equal(now, clock.Now())
+	is.Equal(now, clock.Now())
+
+	fake := clock.(*limitertest.FakeClock)
+	fake.Advance(90 * time.Second)
+	is.Equal(now.Add(90*time.Second), clock.Now())
+
+	fake.Set(now)
+	is.Equal(now, clock.Now())
+}
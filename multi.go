@@ -0,0 +1,110 @@
+package limiter
+
+import (
+	"context"
+)
+
+// MultiLimiter applies several rates on the same key, such as a burst and a sustained rate.
+// A request is denied if any of the rates is exceeded.
+type MultiLimiter struct {
+	Limiters []*Limiter
+	// OnDecision is called once per decision on every rates, instead of the one of each limiter.
+	OnDecision func(key string, allowed bool, context Context)
+}
+
+// NewMultiLimiter returns an instance of MultiLimiter, using a limiter with given store and options for each rate.
+func NewMultiLimiter(store Store, rates []Rate, options ...Option) *MultiLimiter {
+	multi := &MultiLimiter{
+		Limiters: make([]*Limiter, 0, len(rates)),
+	}
+
+	for _, rate := range rates {
+		limiter := New(store, rate, options...)
+		multi.OnDecision = limiter.Options.OnDecision
+		limiter.Options.OnDecision = nil
+		multi.Limiters = append(multi.Limiters, limiter)
+	}
+
+	return multi
+}
+
+// Get increments the limit of every rate for given identifier, and returns the most restrictive one.
+// If a rate is exceeded, the context of the first one exceeded is returned.
+func (multi *MultiLimiter) Get(ctx context.Context, key string) (Context, error) {
+	lctx, err := multi.apply(ctx, key, func(limiter *Limiter, key string) (Context, error) {
+		return limiter.Get(ctx, key)
+	})
+	multi.decide(key, lctx)
+	return lctx, err
+}
+
+// Peek returns the most restrictive limit for given identifier, without modification on current values.
+func (multi *MultiLimiter) Peek(ctx context.Context, key string) (Context, error) {
+	return multi.apply(ctx, key, func(limiter *Limiter, key string) (Context, error) {
+		return limiter.Peek(ctx, key)
+	})
+}
+
+// Reset sets the limit of every rate for given identifier to zero.
+func (multi *MultiLimiter) Reset(ctx context.Context, key string) (Context, error) {
+	return multi.apply(ctx, key, func(limiter *Limiter, key string) (Context, error) {
+		return limiter.Reset(ctx, key)
+	})
+}
+
+// Increment increments the limit of every rate by given count for given identifier,
+// and returns the most restrictive one.
+func (multi *MultiLimiter) Increment(ctx context.Context, key string, count int64) (Context, error) {
+	lctx, err := multi.apply(ctx, key, func(limiter *Limiter, key string) (Context, error) {
+		return limiter.Increment(ctx, key, count)
+	})
+	multi.decide(key, lctx)
+	return lctx, err
+}
+
+// decide notifies OnDecision, if defined, of the decision on given key.
+func (multi *MultiLimiter) decide(key string, lctx Context) {
+	if multi.OnDecision != nil {
+		multi.OnDecision(key, !lctx.Reached, lctx)
+	}
+}
+
+// apply calls given handler with every limiter, and returns the most restrictive context.
+// Since limiters may share a store, each one uses its own key derived from its rate.
+func (multi *MultiLimiter) apply(ctx context.Context, key string,
+	handler func(limiter *Limiter, key string) (Context, error)) (Context, error) {
+
+	var (
+		result Context
+		found  bool
+	)
+
+	for _, limiter := range multi.Limiters {
+		lctx, err := handler(limiter, getRateKey(key, limiter.Rate))
+		if err != nil {
+			return lctx, err
+		}
+
+		if !found || isMoreRestrictive(lctx, result) {
+			result = lctx
+			found = true
+		}
+	}
+
+	return result, nil
+}
+
+// isMoreRestrictive returns if given context is more restrictive than the current one.
+func isMoreRestrictive(lctx Context, current Context) bool {
+	if lctx.Reached != current.Reached {
+		return lctx.Reached
+	}
+	if current.Reached {
+		// Report the first rate exceeded.
+		return false
+	}
+	if lctx.Remaining != current.Remaining {
+		return lctx.Remaining < current.Remaining
+	}
+	return lctx.Reset > current.Reset
+}
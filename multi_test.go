@@ -0,0 +1,71 @@
+package limiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+)
+
+func TestMultiLimiter(t *testing.T) {
+	is := require.New(t)
+	ctx := context.Background()
+
+	second := limiter.Rate{
+		Period: 1 * time.Second,
+		Limit:  int64(10),
+	}
+	hour := limiter.Rate{
+		Period: 1 * time.Hour,
+		Limit:  int64(15),
+	}
+
+	instance := limiter.NewMultiLimiter(memory.NewStore(), []limiter.Rate{second, hour})
+
+	for i := 1; i <= 10; i++ {
+		lctx, err := instance.Get(ctx, "foo")
+		is.NoError(err)
+		is.False(lctx.Reached)
+		is.Equal(int64(10), lctx.Limit)
+		is.Equal(int64(10-i), lctx.Remaining)
+	}
+
+	lctx, err := instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.True(lctx.Reached)
+	is.Equal(int64(10), lctx.Limit)
+
+	// The per-second rate passes again, but the per-hour one trips.
+	time.Sleep(1100 * time.Millisecond)
+
+	for i := 1; i <= 4; i++ {
+		lctx, err = instance.Get(ctx, "foo")
+		is.NoError(err)
+		is.False(lctx.Reached)
+		is.Equal(int64(15), lctx.Limit)
+		is.Equal(int64(4-i), lctx.Remaining)
+	}
+
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.True(lctx.Reached)
+	is.Equal(int64(15), lctx.Limit)
+
+	lctx, err = instance.Peek(ctx, "foo")
+	is.NoError(err)
+	is.True(lctx.Reached)
+	is.Equal(int64(15), lctx.Limit)
+
+	lctx, err = instance.Reset(ctx, "foo")
+	is.NoError(err)
+	is.False(lctx.Reached)
+
+	lctx, err = instance.Get(ctx, "foo")
+	is.NoError(err)
+	is.False(lctx.Reached)
+	is.Equal(int64(9), lctx.Remaining)
+}
@@ -1,108 +1,521 @@
 package limiter
 
 import (
+	"context"
+	"crypto"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"github.com/golang-jwt/jwt"
-	"github.com/pkg/errors"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/golang-jwt/jwt"
 )
 
 var (
 	// DefaultIPv4Mask defines the default IPv4 mask used to obtain user IP.
 	DefaultIPv4Mask = net.CIDRMask(32, 32)
-	// DefaultIPv6Mask defines the default IPv6 mask used to obtain user IP.
+	// DefaultIPv6Mask defines the default IPv6 mask used to obtain user IP, keeping the whole address.
+	// See DefaultIPv6MaskPrivacy for the recommended mask.
 	DefaultIPv6Mask = net.CIDRMask(128, 128)
-	// ErrInvalidJWT defines an error returned when JWT is invalid.
+	// DefaultIPv6MaskPrivacy defines the recommended IPv6 mask used to obtain user IP, keeping the /64 prefix which
+	// is commonly assigned to a single subscriber: a client can't evade limits by rotating its interface identifier,
+	// and the store keeps a key per subnet instead of per address.
+	DefaultIPv6MaskPrivacy = net.CIDRMask(64, 128)
+	// DefaultJWTAlgorithms defines the default signing algorithms allowed to verify JWT.
+	DefaultJWTAlgorithms = []string{"HS256"}
+	// DefaultJWTPublicKeyAlgorithms defines the default signing algorithms allowed to verify JWT with a public key.
+	DefaultJWTPublicKeyAlgorithms = []string{"RS256"}
+	// DefaultAuthScheme defines the default scheme of the Authorization header used to obtain JWT.
+	DefaultAuthScheme = "Bearer"
+	// ErrInvalidJWT defines an error returned when JWT is invalid. The errors below wrap it, to distinguish the most
+	// common causes: use errors.Is to match them.
 	ErrInvalidJWT = fmt.Errorf("invalid JWT token")
+	// ErrJWTMissing defines an error returned when the request has no JWT.
+	ErrJWTMissing = fmt.Errorf("%w: missing", ErrInvalidJWT)
+	// ErrJWTMalformed defines an error returned when JWT can't be parsed, such as a garbage token.
+	ErrJWTMalformed = fmt.Errorf("%w: malformed", ErrInvalidJWT)
+	// ErrJWTSignatureInvalid defines an error returned when the signature of JWT doesn't match its key.
+	ErrJWTSignatureInvalid = fmt.Errorf("%w: signature is invalid", ErrInvalidJWT)
+	// ErrJWTExpired defines an error returned when JWT has expired, with JWTLeeway.
+	ErrJWTExpired = fmt.Errorf("%w: expired", ErrInvalidJWT)
+	// ErrInvalidIPPrefix defines an error returned when an IPv4 or IPv6 prefix length is out of range.
+	ErrInvalidIPPrefix = fmt.Errorf("invalid IP prefix length")
+	// ErrInvalidIPv4Mask defines an error returned when IPv4Mask is not a 4-byte mask, such as an IPv6 mask.
+	ErrInvalidIPv4Mask = fmt.Errorf("invalid IPv4 mask")
+	// ErrInvalidIPv6Mask defines an error returned when IPv6Mask is not a 16-byte mask, such as an IPv4 mask.
+	ErrInvalidIPv6Mask = fmt.Errorf("invalid IPv6 mask")
+)
+
+// ASNResolver defines a lookup of the autonomous system number of an IP, such as a MaxMind database.
+type ASNResolver interface {
+	// Lookup returns the autonomous system number of given IP, and if it has been found.
+	Lookup(ip net.IP) (uint32, bool)
+}
+
+// CountryResolver defines a lookup of the country of an IP, such as a GeoIP database.
+type CountryResolver interface {
+	// Country returns the country code of given IP, such as "FR", and if it has been found.
+	Country(ip net.IP) (string, bool)
+}
+
+const (
+	// userAgentHashSize is the number of bytes of the User-Agent hash kept by GetIPUAKey.
+	userAgentHashSize = 8
+	// keySeparator is the separator of the components of the key built by GetKey.
+	keySeparator = "|"
 )
 
+// IPSource defines from where the user IP has been obtained.
+type IPSource int
+
+const (
+	// SourceRemoteAddr means that user IP has been obtained from the request RemoteAddr.
+	SourceRemoteAddr IPSource = iota
+	// SourceXFF means that user IP has been obtained from the X-Forwarded-For header.
+	SourceXFF
+	// SourceXRealIP means that user IP has been obtained from the X-Real-IP header.
+	SourceXRealIP
+	// SourceCustomHeader means that user IP has been obtained from a custom header (see ClientIPHeader).
+	SourceCustomHeader
+	// SourceForwarded means that user IP has been obtained from the Forwarded header (RFC 7239).
+	SourceForwarded
+	// SourceTrueClientIP means that user IP has been obtained from the True-Client-IP header.
+	SourceTrueClientIP
+)
+
+// String returns a human readable representation of IPSource.
+func (source IPSource) String() string {
+	switch source {
+	case SourceRemoteAddr:
+		return "RemoteAddr"
+	case SourceXFF:
+		return "X-Forwarded-For"
+	case SourceXRealIP:
+		return "X-Real-IP"
+	case SourceCustomHeader:
+		return "CustomHeader"
+	case SourceForwarded:
+		return "Forwarded"
+	case SourceTrueClientIP:
+		return "True-Client-IP"
+	default:
+		return "Unknown"
+	}
+}
+
 // GetIP returns IP address from request.
-// If options is defined and either TrustForwardHeader is true or ClientIPHeader is defined,
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
 // it will lookup IP in HTTP headers.
 // Please be advised that using this option could be insecure (ie: spoofed) if your reverse
 // proxy is not configured properly to forward a trustworthy client IP.
 // Please read the section "Limiter behind a reverse proxy" in the README for further information.
 func (limiter *Limiter) GetIP(r *http.Request) net.IP {
+	value, ok := r.Context().Value(ipContextKey{}).(ipContextValue)
+	if ok && value.options == &limiter.Options {
+		return value.ip
+	}
 	return GetIP(r, limiter.Options)
 }
 
+// WithIPContext returns a shallow copy of given request with its user IP stashed on the request context,
+// so it's resolved only once in a middleware chain: following calls of GetIP (and GetIPKey) will reuse it.
+// The stashed IP is only reused by this limiter, since it depends on its options.
+func (limiter *Limiter) WithIPContext(r *http.Request) *http.Request {
+	value, ok := r.Context().Value(ipContextKey{}).(ipContextValue)
+	if ok && value.options == &limiter.Options {
+		return r
+	}
+
+	value = ipContextValue{
+		options: &limiter.Options,
+		ip:      GetIP(r, limiter.Options),
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), ipContextKey{}, value))
+}
+
+// GetIPSource returns IP address from request, and from where it has been obtained.
+// It's useful for audit logging, in order to flag requests where a header has been honored unexpectedly.
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
+// it will lookup IP in HTTP headers.
+// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
+// proxy is not configured properly to forward a trustworthy client IP.
+// Please read the section "Limiter behind a reverse proxy" in the README for further information.
+func (limiter *Limiter) GetIPSource(r *http.Request) (net.IP, IPSource) {
+	return GetIPSource(r, limiter.Options)
+}
+
+// GetIPStrict returns IP address from request RemoteAddr (or RemoteAddrFunc if defined), ignoring HTTP headers
+// regardless of options, such as for a public route served by a limiter trusting forwarded headers elsewhere.
+func (limiter *Limiter) GetIPStrict(r *http.Request) net.IP {
+	return getIPFromRemoteAddr(limiter.Options.remoteAddr(r))
+}
+
+// GetIPStrictKey returns the user IP key (see GetIPKey) of the IP obtained with GetIPStrict, ignoring HTTP headers.
+func (limiter *Limiter) GetIPStrictKey(r *http.Request) string {
+	remoteAddr := limiter.Options.remoteAddr(r)
+
+	ip := getIPFromRemoteAddr(remoteAddr)
+	if ip == nil {
+		return getUnknownIPKey(remoteAddr)
+	}
+	return limiter.getIPKey(ip)
+}
+
+// IsAllowlisted returns if the user IP of given request is contained in one of the allowlisted networks.
+// The unmasked user IP is used for this comparison.
+func (limiter *Limiter) IsAllowlisted(r *http.Request) bool {
+	return limiter.IsIPAllowlisted(limiter.GetIP(r))
+}
+
+// IsIPAllowlisted returns if given IP is contained in one of the allowlisted networks.
+func (limiter *Limiter) IsIPAllowlisted(ip net.IP) bool {
+	return ip != nil && containsIP(limiter.Options.Allowlist, ip)
+}
+
+// IsBypassed returns if given request bypasses limiting, using BypassFunc if defined.
+func (limiter *Limiter) IsBypassed(r *http.Request) bool {
+	return limiter.Options.BypassFunc != nil && limiter.Options.BypassFunc(r)
+}
+
+// IsDenylisted returns if the user IP of given request is contained in one of the denylisted networks.
+// The unmasked user IP is used for this comparison.
+func (limiter *Limiter) IsDenylisted(r *http.Request) bool {
+	return limiter.IsIPDenylisted(limiter.GetIP(r))
+}
+
+// IsIPDenylisted returns if given IP is contained in one of the denylisted networks.
+func (limiter *Limiter) IsIPDenylisted(ip net.IP) bool {
+	return ip != nil && containsIP(limiter.Options.Denylist, ip)
+}
+
+// GetAllIPs returns every IP of given request, for proxy-aware abuse detection: the X-Forwarded-For chain
+// in order, from the client to the last proxy, followed by the RemoteAddr IP. Unparseable addresses are skipped,
+// and each IP is only returned once, at its first position.
+// Please be advised that the chain is forged by the client, unless your reverse proxy overwrites it: limiting
+// every IP of an untrusted chain allows a client to exhaust the limit of any IP.
+func GetAllIPs(r *http.Request) []net.IP {
+	return getAllIPs(r, r.RemoteAddr, true)
+}
+
+// getAllIPs returns the X-Forwarded-For chain of given request if chain is true, followed by the IP of given
+// RemoteAddr, without unparseable and duplicate addresses.
+func getAllIPs(r *http.Request, remoteAddr string, chain bool) []net.IP {
+	parts := []string{}
+	if chain {
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			parts = append(parts, strings.Split(header, ",")...)
+		}
+	}
+	parts = append(parts, remoteAddr)
+
+	ips := make([]net.IP, 0, len(parts))
+	seen := make(map[string]bool, len(parts))
+	for _, part := range parts {
+		ip := parseAddr(strings.TrimSpace(part))
+		if ip == nil || seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		ips = append(ips, ip)
+	}
+
+	return ips
+}
+
 // GetJWTSub returns sub from request JWT.
 // it will lookup sub in jwt token.
-func (limiter *Limiter) GetJWTSub(r *http.Request) string {
-	sub, err := GetJWTSub(r, limiter.Options.JWTSecret)
-	limiter.ErrValidation = err
-	return sub
+func (limiter *Limiter) GetJWTSub(r *http.Request) (string, error) {
+	return getJWTSub(r, limiter.Options)
+}
+
+// GetJWTClaim returns given claim from request JWT.
+// it will lookup claim in jwt token, and coerce it to a string.
+func (limiter *Limiter) GetJWTClaim(r *http.Request, claim string) (string, error) {
+	return getJWTClaim(r, limiter.Options, claim)
 }
 
 // GetIPWithMask returns IP address from request by applying a mask.
-// If options is defined and either TrustForwardHeader is true or ClientIPHeader is defined,
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
 // it will lookup IP in HTTP headers.
 // Please be advised that using this option could be insecure (ie: spoofed) if your reverse
 // proxy is not configured properly to forward a trustworthy client IP.
 // Please read the section "Limiter behind a reverse proxy" in the README for further information.
 func (limiter *Limiter) GetIPWithMask(r *http.Request) net.IP {
-	return GetIPWithMask(r, limiter.Options)
+	return maskIP(limiter.GetIP(r), limiter.Options)
+}
+
+// GetIPNet returns the network of the user IP from request, using the IPv4 or IPv6 mask: for example,
+// "203.0.113.0/24" with a /24 IPv4 mask. It's useful to log aggregated offenders.
+// It returns nil if the user IP can't be parsed.
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
+// it will lookup IP in HTTP headers.
+// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
+// proxy is not configured properly to forward a trustworthy client IP.
+// Please read the section "Limiter behind a reverse proxy" in the README for further information.
+func (limiter *Limiter) GetIPNet(r *http.Request) *net.IPNet {
+	ip := limiter.GetIP(r)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	mask := getIPMask(ip, limiter.Options)
+	if mask == nil {
+		return nil
+	}
+
+	return &net.IPNet{
+		IP:   ip.Mask(mask),
+		Mask: mask,
+	}
+}
+
+// GetMaskedIP extracts IP from request and returns the masked IP, without stringifying it.
+// It's useful to build composite keys (ie: IP with route or method) in your own middleware.
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
+// it will lookup IP in HTTP headers.
+// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
+// proxy is not configured properly to forward a trustworthy client IP.
+// Please read the section "Limiter behind a reverse proxy" in the README for further information.
+func (limiter *Limiter) GetMaskedIP(r *http.Request) net.IP {
+	return maskIP(limiter.GetIP(r), limiter.Options)
 }
 
 // GetIPKey extracts IP from request and returns hashed IP to use as store key.
-// If options is defined and either TrustForwardHeader is true or ClientIPHeader is defined,
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
 // it will lookup IP in HTTP headers.
 // Please be advised that using this option could be insecure (ie: spoofed) if your reverse
 // proxy is not configured properly to forward a trustworthy client IP.
 // Please read the section "Limiter behind a reverse proxy" in the README for further information.
+// If an ASNResolver is defined in options, the autonomous system number of the IP is used instead, such as "asn:15169".
+// It fallbacks on the masked IP if the lookup fails.
+// If the user IP can't be parsed, the raw RemoteAddr is used instead, such as "unknown:foo", so unparseable clients
+// don't share the same key.
 func (limiter *Limiter) GetIPKey(r *http.Request) string {
-	return limiter.GetIPWithMask(r).String()
+	ip := limiter.GetIP(r)
+	if ip == nil {
+		return getUnknownIPKey(limiter.Options.remoteAddr(r))
+	}
+	return limiter.getIPKey(ip)
+}
+
+// getIPKey returns the store key of given IP: its autonomous system number if found by ASNResolver,
+// or the masked IP otherwise.
+func (limiter *Limiter) getIPKey(ip net.IP) string {
+	if limiter.Options.ASNResolver != nil {
+		asn, ok := limiter.Options.ASNResolver.Lookup(ip)
+		if ok {
+			return fmt.Sprintf("asn:%d", asn)
+		}
+	}
+	return maskIP(ip, limiter.Options).String()
+}
+
+// GetAllIPs returns every IP of given request (see GetAllIPs), such as to limit every proxy of the chain.
+// The X-Forwarded-For chain is only used if TrustForwardHeader is true: otherwise, only the RemoteAddr IP
+// is returned.
+func (limiter *Limiter) GetAllIPs(r *http.Request) []net.IP {
+	return getAllIPs(r, limiter.Options.remoteAddr(r), limiter.Options.TrustForwardHeader)
+}
+
+// GetIPUAKey returns the user IP key (see GetIPKey) combined with a short hash of the normalized User-Agent header,
+// such as "8.8.8.8:3f1a2b4c5d6e7f80", to limit clients rotating their IP but keeping the same fingerprint.
+// The User-Agent is trimmed and lowercased before being hashed, and the hash is truncated to keep keys short.
+func (limiter *Limiter) GetIPUAKey(r *http.Request) string {
+	userAgent := strings.ToLower(strings.TrimSpace(r.UserAgent()))
+	hash := sha1.Sum([]byte(userAgent))
+	return limiter.GetIPKey(r) + ":" + hex.EncodeToString(hash[:userAgentHashSize])
+}
+
+// GetKey returns the store key for given request.
+// If a KeyFunc is defined in options, its returned string is used verbatim as store key.
+// Otherwise, it fallbacks on GetIPKey, followed by the request method if KeyIncludeMethod is enabled, then by the
+// path template of the request (see GetPathTemplate) if KeyIncludePath is enabled or PathTemplateFunc is defined,
+// such as "8.8.8.8|POST|/users/{id}". Components are separated by "|", and escaped like in a URL (see
+// escapeKeyComponent) so they can't contain it: the key is unambiguous, and valid on every store (ie: memcached).
+func (limiter *Limiter) GetKey(r *http.Request) string {
+	if limiter.Options.KeyFunc != nil {
+		return limiter.Options.KeyFunc(r)
+	}
+
+	includePath := limiter.Options.KeyIncludePath || limiter.Options.PathTemplateFunc != nil
+	if !limiter.Options.KeyIncludeMethod && !includePath {
+		return limiter.GetIPKey(r)
+	}
+
+	key := escapeKeyComponent(limiter.GetIPKey(r))
+	if limiter.Options.KeyIncludeMethod {
+		key += keySeparator + escapeKeyComponent(getKeyMethod(r))
+	}
+	if includePath {
+		key += keySeparator + escapeKeyComponent(limiter.GetPathTemplate(r))
+	}
+	return key
+}
+
+// getKeyMethod returns the method of given request, uppercased since it's matched case-insensitively elsewhere
+// (see SkipMethods). An empty method means GET.
+func getKeyMethod(r *http.Request) string {
+	if r.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(r.Method)
+}
+
+// escapeKeyComponent returns given component of a key built by GetKey, with "%", the key separator, whitespace and
+// control characters percent-encoded, such as "/foo%20bar" for "/foo bar".
+func escapeKeyComponent(component string) string {
+	escaped := strings.Builder{}
+	for i := 0; i < len(component); i++ {
+		c := component[i]
+		if c <= ' ' || c == 0x7f || c == '%' || c == keySeparator[0] {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+			continue
+		}
+		escaped.WriteByte(c)
+	}
+	return escaped.String()
+}
+
+// GetPathTemplate returns the route template matching the path of given request, obtained with PathTemplateFunc,
+// such as "/users/{id}" for "/users/123", so concrete paths of a route share their key.
+// The path is returned as is if PathTemplateFunc isn't defined, or if it returns an empty template.
+func (limiter *Limiter) GetPathTemplate(r *http.Request) string {
+	if limiter.Options.PathTemplateFunc != nil {
+		template := limiter.Options.PathTemplateFunc(r)
+		if template != "" {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// GetIPKeyWithPrefix extracts IP from request and returns IP masked with given prefix lengths to use as store key.
+// If a prefix length is out of range (0-32 for IPv4 and 0-128 for IPv6), the unmasked IP is used instead.
+// If the user IP can't be parsed, the raw RemoteAddr is used instead, like GetIPKey.
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
+// it will lookup IP in HTTP headers.
+// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
+// proxy is not configured properly to forward a trustworthy client IP.
+// Please read the section "Limiter behind a reverse proxy" in the README for further information.
+func (limiter *Limiter) GetIPKeyWithPrefix(r *http.Request, v4bits, v6bits int) string {
+	ip, _ := GetIPWithPrefix(r, v4bits, v6bits, limiter.Options)
+	if ip == nil {
+		return getUnknownIPKey(limiter.Options.remoteAddr(r))
+	}
+	return ip.String()
 }
 
 // GetIP returns IP address from request.
-// If options is defined and either TrustForwardHeader is true or ClientIPHeader is defined,
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
 // it will lookup IP in HTTP headers.
 // Please be advised that using this option could be insecure (ie: spoofed) if your reverse
 // proxy is not configured properly to forward a trustworthy client IP.
 // Please read the section "Limiter behind a reverse proxy" in the README for further information.
 func GetIP(r *http.Request, options ...Options) net.IP {
+	ip, _ := GetIPSource(r, options...)
+	return ip
+}
+
+// GetIPSource returns IP address from request, and from where it has been obtained.
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
+// it will lookup IP in HTTP headers.
+// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
+// proxy is not configured properly to forward a trustworthy client IP.
+// Please read the section "Limiter behind a reverse proxy" in the README for further information.
+func GetIPSource(r *http.Request, options ...Options) (net.IP, IPSource) {
 	if len(options) >= 1 {
-		if options[0].ClientIPHeader != "" {
-			ip := getIPFromHeader(r, options[0].ClientIPHeader)
-			if ip != nil {
-				return ip
-			}
+		remoteAddr := options[0].remoteAddr(r)
+
+		ip := getIPFromHeaders(r, options[0].clientIPHeaders(), options[0].OnHeaderIgnored)
+		if ip != nil {
+			return ip, SourceCustomHeader
 		}
 		if options[0].TrustForwardHeader {
-			ip := getIPFromXFFHeader(r)
+			if options[0].TrustTrueClientIP {
+				ip = getIPFromHeaders(r, []string{"True-Client-IP"}, options[0].OnHeaderIgnored)
+				if ip != nil {
+					return ip, SourceTrueClientIP
+				}
+			}
+
+			if options[0].TrustRFC7239 {
+				ip, source := getIPFromForwardedHeader(r, remoteAddr, options[0].TrustedProxies)
+				if ip != nil {
+					return ip, source
+				}
+			}
+
+			ip, source := getIPFromXFFHeader(r, remoteAddr, options[0].TrustedProxies, options[0].ForwardedHops)
 			if ip != nil {
-				return ip
+				return ip, source
 			}
 
-			ip = getIPFromHeader(r, "X-Real-IP")
+			ip = getIPFromXRealIPHeader(r, options[0].OnHeaderIgnored)
 			if ip != nil {
-				return ip
+				return ip, SourceXRealIP
 			}
 		}
-	}
 
-	remoteAddr := strings.TrimSpace(r.RemoteAddr)
-	host, _, err := net.SplitHostPort(remoteAddr)
-	if err != nil {
-		return net.ParseIP(remoteAddr)
+		return getIPFromRemoteAddr(remoteAddr), SourceRemoteAddr
 	}
 
-	return net.ParseIP(host)
+	return getIPFromRemoteAddr(r.RemoteAddr), SourceRemoteAddr
 }
 
 // GetJWTSub returns sub from request JWT.
+// Only JWT signed with one of DefaultJWTAlgorithms are considered valid.
 func GetJWTSub(r *http.Request, secret string) (string, error) {
-	if token, valid := getAuthorizationToken(r); valid {
-		sub, err := extractSubFromJWT(token, secret)
-		return sub, err
+	return getJWTSub(r, Options{JWTSecret: secret})
+}
+
+// GetJWTSubWithKeyFunc returns sub from request JWT, verified with the key returned by given function,
+// such as a key looked up by the "kid" header of the JWT.
+// Only JWT signed with one of DefaultJWTAlgorithms are considered valid.
+func GetJWTSubWithKeyFunc(r *http.Request, keyFunc jwt.Keyfunc) (string, error) {
+	return getJWTSub(r, Options{JWTKeyFunc: keyFunc})
+}
+
+// GetJWTSubWithPublicKey returns sub from request JWT, verified with given public key (ie: a *rsa.PublicKey).
+// Only JWT signed with one of given algorithms, or DefaultJWTPublicKeyAlgorithms if none, are considered valid.
+func GetJWTSubWithPublicKey(r *http.Request, key crypto.PublicKey, algorithms ...string) (string, error) {
+	if len(algorithms) == 0 {
+		algorithms = DefaultJWTPublicKeyAlgorithms
+	}
+	return getJWTSub(r, Options{JWTPublicKey: key, JWTAlgorithms: algorithms})
+}
+
+// GetJWTClaim returns given claim from request JWT, coerced to a string.
+// Only JWT signed with one of DefaultJWTAlgorithms are considered valid.
+// It returns ErrInvalidJWT if the claim is missing or is neither a string nor a number.
+func GetJWTClaim(r *http.Request, secret string, claim string) (string, error) {
+	return getJWTClaim(r, Options{JWTSecret: secret}, claim)
+}
+
+func getJWTSub(r *http.Request, options Options) (string, error) {
+	sub, err := getJWTClaim(r, options, "sub")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint([]byte(sub)), nil
+}
+
+func getJWTClaim(r *http.Request, options Options, claim string) (string, error) {
+	if token, valid := getAuthorizationToken(r, options); valid {
+		value, err := extractClaimFromJWT(token, options, claim)
+		return value, err
 	}
-	return "", ErrInvalidJWT
+	return "", ErrJWTMissing
 }
 
 // GetIPWithMask returns IP address from request by applying a mask.
-// If options is defined and either TrustForwardHeader is true or ClientIPHeader is defined,
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
 // it will lookup IP in HTTP headers.
 // Please be advised that using this option could be insecure (ie: spoofed) if your reverse
 // proxy is not configured properly to forward a trustworthy client IP.
@@ -112,20 +525,98 @@ func GetIPWithMask(r *http.Request, options ...Options) net.IP {
 		return GetIP(r)
 	}
 
-	ip := GetIP(r, options[0])
+	return maskIP(GetIP(r, options[0]), options[0])
+}
+
+// maskIP applies the IPv4 or IPv6 mask from given options on given IP.
+// If a mask is not defined, the default one is used.
+// IPv4-mapped IPv6 addresses (ie: "::ffff:192.0.2.1") are normalized to their IPv4 form first, so a client
+// obtains the same key over IPv4 and over a dual-stack listener.
+func maskIP(ip net.IP, options Options) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	mask := getIPMask(ip, options)
+	if mask == nil {
+		return ip
+	}
+	return ip.Mask(mask)
+}
+
+// getIPMask returns the IPv4 or IPv6 mask from given options for given IP, with the length of this IP.
+// If a mask is not defined, the default one is used. It returns nil if given IP is invalid.
+func getIPMask(ip net.IP, options Options) net.IPMask {
 	if ip.To4() != nil {
-		return ip.Mask(options[0].IPv4Mask)
+		mask := options.IPv4Mask
+		if len(mask) == 0 {
+			mask = DefaultIPv4Mask
+		}
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+		return mask
 	}
 	if ip.To16() != nil {
-		return ip.Mask(options[0].IPv6Mask)
+		mask := options.IPv6Mask
+		if len(mask) == 0 {
+			mask = DefaultIPv6Mask
+		}
+		return mask
 	}
-	return ip
+	return nil
+}
+
+// GetIPFromContext returns the user IP stashed on given context by WithIPContext, if any.
+func GetIPFromContext(ctx context.Context) (net.IP, bool) {
+	value, ok := ctx.Value(ipContextKey{}).(ipContextValue)
+	if !ok {
+		return nil, false
+	}
+	return value.ip, true
+}
+
+// ipContextKey is the context key used to stash the user IP.
+type ipContextKey struct{}
+
+// ipContextValue is the user IP stashed on a context, with the options that produced it.
+type ipContextValue struct {
+	options *Options
+	ip      net.IP
+}
+
+// GetIPWithPrefix returns IP address from request by applying a mask built from given prefix lengths.
+// If a prefix length is out of range (0-32 for IPv4 and 0-128 for IPv6), it returns the unmasked IP
+// with ErrInvalidIPPrefix.
+// If options is defined and either TrustForwardHeader is true or ClientIPHeader(s) is defined,
+// it will lookup IP in HTTP headers.
+// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
+// proxy is not configured properly to forward a trustworthy client IP.
+// Please read the section "Limiter behind a reverse proxy" in the README for further information.
+func GetIPWithPrefix(r *http.Request, v4bits, v6bits int, options ...Options) (net.IP, error) {
+	opt := Options{}
+	if len(options) >= 1 {
+		opt = options[0]
+	}
+
+	opt.IPv4Mask = net.CIDRMask(v4bits, 8*net.IPv4len)
+	opt.IPv6Mask = net.CIDRMask(v6bits, 8*net.IPv6len)
+	if opt.IPv4Mask == nil || opt.IPv6Mask == nil {
+		return GetIP(r, opt), ErrInvalidIPPrefix
+	}
+
+	return GetIPWithMask(r, opt), nil
 }
 
-func getIPFromXFFHeader(r *http.Request) net.IP {
+// getIPFromXFFHeader returns the client IP from X-Forwarded-For headers.
+// If no trusted proxies are given, it returns the first parseable IP of the chain.
+// Otherwise, it walks the chain from right to left, skipping trusted proxies, and returns the first untrusted IP.
+// If the entire chain is trusted, it returns the IP from given RemoteAddr.
+// If hops is positive, it returns the IP at this position from the end of the chain instead (see ForwardedHops).
+func getIPFromXFFHeader(r *http.Request, remoteAddr string, trustedProxies []net.IPNet, hops int) (net.IP, IPSource) {
 	headers := r.Header.Values("X-Forwarded-For")
 	if len(headers) == 0 {
-		return nil
+		return nil, SourceXFF
 	}
 
 	parts := []string{}
@@ -133,12 +624,171 @@ func getIPFromXFFHeader(r *http.Request) net.IP {
 		parts = append(parts, strings.Split(header, ",")...)
 	}
 
-	for i := range parts {
+	if hops > 0 {
+		return getIPFromHop(remoteAddr, parts, hops, SourceXFF)
+	}
+
+	return getIPFromChain(remoteAddr, parts, trustedProxies, SourceXFF)
+}
+
+// getIPFromForwardedHeader returns the client IP from the "for" directive of Forwarded headers (RFC 7239).
+// The chain is handled like the X-Forwarded-For one, regarding trusted proxies.
+func getIPFromForwardedHeader(r *http.Request, remoteAddr string, trustedProxies []net.IPNet) (net.IP, IPSource) {
+	headers := r.Header.Values("Forwarded")
+	if len(headers) == 0 {
+		return nil, SourceForwarded
+	}
+
+	parts := []string{}
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+					parts = append(parts, strings.Trim(pair[4:], `"`))
+				}
+			}
+		}
+	}
+
+	return getIPFromChain(remoteAddr, parts, trustedProxies, SourceForwarded)
+}
+
+// getIPFromChain returns the client IP from given chain of addresses, obtained from given source.
+// If no trusted proxies are given, it returns the first parseable IP of the chain.
+// Otherwise, it walks the chain from right to left, skipping trusted proxies, and returns the first untrusted IP.
+// If the entire chain is trusted, it returns the IP from given RemoteAddr.
+func getIPFromChain(remoteAddr string, parts []string, trustedProxies []net.IPNet, source IPSource) (net.IP, IPSource) {
+	if len(trustedProxies) == 0 {
+		for i := range parts {
+			part := strings.TrimSpace(parts[i])
+			ip := parseAddr(part)
+			if ip != nil {
+				return ip, source
+			}
+		}
+
+		return nil, source
+	}
+
+	for i := len(parts) - 1; i >= 0; i-- {
 		part := strings.TrimSpace(parts[i])
-		ip := net.ParseIP(part)
+		ip := parseAddr(part)
+		if ip != nil && !isTrustedProxy(ip, trustedProxies) {
+			return ip, source
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil, source
+	}
+
+	return getIPFromRemoteAddr(remoteAddr), SourceRemoteAddr
+}
+
+// getIPFromHop returns the client IP at given position from the end of given chain of addresses,
+// obtained from given source. If the chain is too short, or the address unparseable, it returns the IP from
+// given RemoteAddr.
+func getIPFromHop(remoteAddr string, parts []string, hops int, source IPSource) (net.IP, IPSource) {
+	if len(parts) < hops {
+		return getIPFromRemoteAddr(remoteAddr), SourceRemoteAddr
+	}
+
+	ip := parseAddr(strings.TrimSpace(parts[len(parts)-hops]))
+	if ip == nil {
+		return getIPFromRemoteAddr(remoteAddr), SourceRemoteAddr
+	}
+
+	return ip, source
+}
+
+// isTrustedProxy returns if given IP is contained in one of the trusted proxies networks.
+func isTrustedProxy(ip net.IP, trustedProxies []net.IPNet) bool {
+	return containsIP(trustedProxies, ip)
+}
+
+// containsIP returns if given IP is contained in one of given networks.
+func containsIP(networks []net.IPNet, ip net.IP) bool {
+	for i := range networks {
+		if networks[i].Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getUnknownIPKey returns the store key of a request whose user IP can't be parsed, from its raw RemoteAddr.
+func getUnknownIPKey(remoteAddr string) string {
+	return "unknown:" + remoteAddr
+}
+
+func getIPFromRemoteAddr(remoteAddr string) net.IP {
+	return parseAddr(strings.TrimSpace(remoteAddr))
+}
+
+// parseAddr parses given address, with or without a port (ie: "203.0.113.7:54321" or "[2001:db8::1]:443").
+func parseAddr(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return parseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+	}
+
+	return parseIP(host)
+}
+
+// parseIP parses given IP address, after stripping its IPv6 zone identifier (ie: "fe80::1%eth0") if any.
+func parseIP(ip string) net.IP {
+	if i := strings.LastIndexByte(ip, '%'); i >= 0 {
+		ip = ip[:i]
+	}
+
+	return net.ParseIP(ip)
+}
+
+// getIPFromHeaders returns the first parseable IP found in given headers, in order.
+// Empty header names are skipped, and an unparseable value falls through to the next candidate,
+// after notifying onIgnored if defined.
+func getIPFromHeaders(r *http.Request, names []string, onIgnored func(header, rawValue string)) net.IP {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+
+		ip := getIPFromHeader(r, name)
 		if ip != nil {
 			return ip
 		}
+
+		if onIgnored != nil {
+			if value := r.Header.Get(name); strings.TrimSpace(value) != "" {
+				onIgnored(name, value)
+			}
+		}
+	}
+
+	return nil
+}
+
+// getIPFromXRealIPHeader returns the client IP from the X-Real-IP header.
+// It should be a single IP, but some misconfigured proxies set a list of addresses separated by spaces or commas:
+// like for the X-Forwarded-For chain without trusted proxies, the first parseable address is used.
+// An unparseable value is ignored, after notifying onIgnored if defined.
+func getIPFromXRealIPHeader(r *http.Request, onIgnored func(header, rawValue string)) net.IP {
+	value := r.Header.Get("X-Real-IP")
+	parts := strings.FieldsFunc(value, func(c rune) bool {
+		return c == ',' || unicode.IsSpace(c)
+	})
+
+	for _, part := range parts {
+		ip := parseAddr(part)
+		if ip != nil {
+			return ip
+		}
+	}
+
+	if onIgnored != nil && len(parts) > 0 {
+		onIgnored("X-Real-IP", value)
 	}
 
 	return nil
@@ -158,33 +808,142 @@ func getIPFromHeader(r *http.Request, name string) net.IP {
 	return nil
 }
 
-func extractSubFromJWT(jwtString string, secret string) (string, error) {
-	claims := &jwt.StandardClaims{}
-	token, err := jwt.ParseWithClaims(jwtString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
+func extractClaimFromJWT(jwtString string, options Options, claim string) (string, error) {
+	algorithms := options.JWTAlgorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultJWTAlgorithms
+	}
+
+	// Claims are validated below, in order to take leeway into account.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	token, err := parser.ParseWithClaims(jwtString, claims, func(token *jwt.Token) (interface{}, error) {
+		// Verify the signing algorithm to prevent "none" or unexpected algorithms from being used.
+		if !isJWTAlgorithmAllowed(token.Method.Alg(), algorithms) {
+			return nil, ErrInvalidJWT
+		}
+		if options.JWTKeyFunc != nil {
+			return options.JWTKeyFunc(token)
+		}
+		return getJWTKey(token, options)
 	})
+	if verr, ok := err.(*jwt.ValidationError); ok {
+		switch {
+		case verr.Inner == ErrInvalidJWT:
+			return "", ErrInvalidJWT
+		case verr.Errors&jwt.ValidationErrorMalformed != 0:
+			return "", ErrJWTMalformed
+		case verr.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+			return "", ErrJWTSignatureInvalid
+		}
+	}
 	if err != nil {
-		return "", err
+		// Other errors, such as of JWTKeyFunc for an unknown "kid", don't unwrap to their cause.
+		return "", fmt.Errorf("%w: %v", ErrInvalidJWT, err)
 	}
 	if !token.Valid {
 		return "", ErrInvalidJWT
 	}
-	return fmt.Sprint([]byte(claims.Subject)), nil
+	if err := verifyJWTTime(claims, options.now(), options.JWTLeeway); err != nil {
+		return "", err
+	}
+
+	switch value := claims[claim].(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case json.Number:
+		return value.String(), nil
+	default:
+		return "", ErrInvalidJWT
+	}
+}
+
+// getJWTKey returns the key used to verify given JWT, selected by its signing method: the JWT secret for HMAC,
+// or the JWT public key for RSA and ECDSA.
+func getJWTKey(token *jwt.Token, options Options) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		// Without secret, an attacker could sign JWT with an empty secret.
+		if options.JWTSecret == "" && options.JWTPublicKey != nil {
+			return nil, ErrInvalidJWT
+		}
+		return []byte(options.JWTSecret), nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		if options.JWTPublicKey == nil {
+			return nil, ErrInvalidJWT
+		}
+		return options.JWTPublicKey, nil
+	default:
+		return nil, ErrInvalidJWT
+	}
+}
+
+// verifyJWTTime returns an error if "exp", "iat" or "nbf" claims are invalid at given time, with given leeway:
+// ErrJWTExpired for an expired JWT, and ErrInvalidJWT if it's not valid yet.
+func verifyJWTTime(claims jwt.MapClaims, now time.Time, leeway time.Duration) error {
+	if !claims.VerifyExpiresAt(now.Add(-leeway).Unix(), false) {
+		return ErrJWTExpired
+	}
+	if !claims.VerifyIssuedAt(now.Add(leeway).Unix(), false) || !claims.VerifyNotBefore(now.Add(leeway).Unix(), false) {
+		return ErrInvalidJWT
+	}
+	return nil
 }
 
-func getAuthorizationToken(r *http.Request) (string, bool) {
-	bearer := "bearer "
+// isJWTAlgorithmAllowed returns if given signing algorithm is in the allowed algorithms.
+func isJWTAlgorithmAllowed(algorithm string, algorithms []string) bool {
+	for i := range algorithms {
+		if algorithms[i] == algorithm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getAuthorizationToken returns the JWT of given request, from the first source yielding a token, in order:
+// the Authorization header, the JWTCookieName cookie and the JWTQueryParam query parameter.
+func getAuthorizationToken(r *http.Request, options Options) (string, bool) {
+	if token, ok := getAuthorizationHeaderToken(r, options); ok {
+		return token, true
+	}
+
+	if options.JWTCookieName != "" {
+		cookie, err := r.Cookie(options.JWTCookieName)
+		if err == nil && cookie.Value != "" {
+			return cookie.Value, true
+		}
+	}
+
+	if options.JWTQueryParam != "" && r.URL != nil {
+		token := r.URL.Query().Get(options.JWTQueryParam)
+		if token != "" {
+			return token, true
+		}
+	}
+
+	return "", false
+}
+
+// getAuthorizationHeaderToken returns the JWT of given request from its Authorization header, using the AuthScheme.
+func getAuthorizationHeaderToken(r *http.Request, options Options) (string, bool) {
 	headerToken := r.Header.Get("Authorization")
 	if headerToken == "" {
 		return "", false
 	}
 
-	// Verify the token format (Bearer <token>)
-	lowerToken := strings.ToLower(headerToken[:len(bearer)])
-	if len(headerToken) <= len(bearer) || lowerToken != bearer {
+	scheme := options.AuthScheme
+	if scheme == "" {
+		scheme = DefaultAuthScheme
+	}
+
+	// Verify the token format (<scheme> <token>), with any case and any run of whitespace.
+	fields := strings.Fields(headerToken)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], scheme) {
 		return "", false
 	}
-	tokenString := headerToken[len(bearer):]
 
-	return tokenString, true
+	return fields[1], true
 }
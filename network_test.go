@@ -1,12 +1,19 @@
 package limiter_test
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/require"
 
 	"github.com/ulule/limiter/v3"
@@ -235,3 +242,2651 @@ func TestGetIPKey(t *testing.T) {
 		is.Equal(scenario.expected, key, message)
 	}
 }
+
+func TestGetIPWithClientIPHeaders(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New(limiter.WithClientIPHeader("CF-Connecting-IP"))
+	limiter2 := New(limiter.WithClientIPHeaders("", "CF-Connecting-IP", "X-Internal-Client-IP"))
+	limiter3 := New(
+		limiter.WithClientIPHeader("Fastly-Client-IP"),
+		limiter.WithClientIPHeaders("CF-Connecting-IP"),
+	)
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request1.Header.Add("CF-Connecting-IP", "9.9.9.9")
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request2.Header.Add("CF-Connecting-IP", "unknown")
+	request2.Header.Add("X-Internal-Client-IP", "7.7.7.7")
+
+	request3 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request3.Header.Add("Fastly-Client-IP", "6.6.6.6")
+	request3.Header.Add("CF-Connecting-IP", "9.9.9.9")
+
+	request4 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request4.Header.Add("CF-Connecting-IP", "unknown")
+
+	scenarios := []struct {
+		request  *http.Request
+		limiter  *limiter.Limiter
+		expected net.IP
+	}{
+		{
+			//
+			// Scenario #1 : Single custom header.
+			//
+			request:  request1,
+			limiter:  limiter1,
+			expected: net.ParseIP("9.9.9.9").To4(),
+		},
+		{
+			//
+			// Scenario #2 : Multiple custom headers, first one matching.
+			//
+			request:  request1,
+			limiter:  limiter2,
+			expected: net.ParseIP("9.9.9.9").To4(),
+		},
+		{
+			//
+			// Scenario #3 : Multiple custom headers, unparseable value falls through.
+			//
+			request:  request2,
+			limiter:  limiter2,
+			expected: net.ParseIP("7.7.7.7").To4(),
+		},
+		{
+			//
+			// Scenario #4 : ClientIPHeader is tried before ClientIPHeaders.
+			//
+			request:  request3,
+			limiter:  limiter3,
+			expected: net.ParseIP("6.6.6.6").To4(),
+		},
+		{
+			//
+			// Scenario #5 : No parseable custom header, fallback on RemoteAddr.
+			//
+			request:  request4,
+			limiter:  limiter2,
+			expected: net.ParseIP("8.8.8.8").To4(),
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		ip := scenario.limiter.GetIPWithMask(scenario.request)
+		is.Equal(scenario.expected, ip, message)
+	}
+}
+
+func TestGetIPWithRemoteAddrFunc(t *testing.T) {
+	is := require.New(t)
+
+	type proxyAddrKey struct{}
+	remoteAddrFunc := limiter.WithRemoteAddrFunc(func(r *http.Request) string {
+		addr, ok := r.Context().Value(proxyAddrKey{}).(string)
+		if !ok {
+			return r.RemoteAddr
+		}
+		return addr
+	})
+
+	_, network1, err := net.ParseCIDR("10.0.0.0/8")
+	is.NoError(err)
+
+	limiter1 := New(remoteAddrFunc)
+	limiter2 := New(remoteAddrFunc, limiter.WithTrustForwardHeader(true), limiter.WithTrustedProxies(*network1))
+
+	newRequest := func(addr string) *http.Request {
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "10.0.0.1:8888",
+		}
+		if addr != "" {
+			request = request.WithContext(context.WithValue(request.Context(), proxyAddrKey{}, addr))
+		}
+		return request
+	}
+
+	// Scenario #1 : Address from the PROXY protocol.
+	request1 := newRequest("9.9.9.9:4444")
+	ip, source := limiter1.GetIPSource(request1)
+	is.Equal(net.ParseIP("9.9.9.9").To4(), ip.To4())
+	is.Equal(limiter.SourceRemoteAddr, source)
+	is.Equal("9.9.9.9", limiter1.GetIPKey(request1))
+
+	// Scenario #2 : Fallback on RemoteAddr.
+	request2 := newRequest("")
+	is.Equal("10.0.0.1", limiter1.GetIPKey(request2))
+
+	// Scenario #3 : Unparseable address.
+	request3 := newRequest("foo")
+	is.Nil(limiter1.GetIP(request3))
+	is.Equal("unknown:foo", limiter1.GetIPKey(request3))
+	is.Equal("unknown:foo", limiter1.GetIPKeyWithPrefix(request3, 24, 64))
+
+	// Scenario #4 : Fallback of an entirely trusted X-Forwarded-For chain.
+	request4 := newRequest("9.9.9.9:4444")
+	request4.Header.Add("X-Forwarded-For", "10.0.0.3, 10.0.0.2")
+	is.Equal("9.9.9.9", limiter2.GetIPKey(request4))
+
+	// Scenario #5 : Untrusted X-Forwarded-For entry.
+	request5 := newRequest("9.9.9.9:4444")
+	request5.Header.Add("X-Forwarded-For", "7.7.7.7, 10.0.0.2")
+	is.Equal("7.7.7.7", limiter2.GetIPKey(request5))
+}
+
+func TestGetIPWithOnHeaderIgnored(t *testing.T) {
+	is := require.New(t)
+
+	type ignored struct {
+		header   string
+		rawValue string
+	}
+
+	var calls []ignored
+	onHeaderIgnored := limiter.WithOnHeaderIgnored(func(header, rawValue string) {
+		calls = append(calls, ignored{header: header, rawValue: rawValue})
+	})
+
+	limiter1 := New(limiter.WithClientIPHeader("CF-Connecting-IP"), onHeaderIgnored)
+	limiter2 := New(limiter.WithClientIPHeaders("CF-Connecting-IP", "X-Internal-Client-IP"), onHeaderIgnored)
+	limiter3 := New(limiter.WithTrustForwardHeader(true), onHeaderIgnored)
+
+	newRequest := func(headers map[string]string) *http.Request {
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		for name, value := range headers {
+			request.Header.Add(name, value)
+		}
+		return request
+	}
+
+	scenarios := []struct {
+		request  *http.Request
+		limiter  *limiter.Limiter
+		expected net.IP
+		ignored  []ignored
+	}{
+		{
+			//
+			// Scenario #1 : Custom header present but garbage, fallback on RemoteAddr.
+			//
+			request:  newRequest(map[string]string{"CF-Connecting-IP": "unknown"}),
+			limiter:  limiter1,
+			expected: net.ParseIP("8.8.8.8").To4(),
+			ignored:  []ignored{{header: "CF-Connecting-IP", rawValue: "unknown"}},
+		},
+		{
+			//
+			// Scenario #2 : Custom header absent, fallback on RemoteAddr without notification.
+			//
+			request:  newRequest(nil),
+			limiter:  limiter1,
+			expected: net.ParseIP("8.8.8.8").To4(),
+		},
+		{
+			//
+			// Scenario #3 : Valid custom header.
+			//
+			request:  newRequest(map[string]string{"CF-Connecting-IP": "9.9.9.9"}),
+			limiter:  limiter1,
+			expected: net.ParseIP("9.9.9.9").To4(),
+		},
+		{
+			//
+			// Scenario #4 : First custom header garbage, second one used.
+			//
+			request:  newRequest(map[string]string{"CF-Connecting-IP": " 1.2.3 ", "X-Internal-Client-IP": "7.7.7.7"}),
+			limiter:  limiter2,
+			expected: net.ParseIP("7.7.7.7").To4(),
+			ignored:  []ignored{{header: "CF-Connecting-IP", rawValue: " 1.2.3 "}},
+		},
+		{
+			//
+			// Scenario #5 : X-Real-IP present but garbage, fallback on RemoteAddr.
+			//
+			request:  newRequest(map[string]string{"X-Real-IP": "unknown"}),
+			limiter:  limiter3,
+			expected: net.ParseIP("8.8.8.8").To4(),
+			ignored:  []ignored{{header: "X-Real-IP", rawValue: "unknown"}},
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		calls = nil
+
+		ip := scenario.limiter.GetIPWithMask(scenario.request)
+		is.Equal(scenario.expected, ip, message)
+		is.Equal(scenario.ignored, calls, message)
+	}
+}
+
+func TestGetIPWithTrustedProxies(t *testing.T) {
+	is := require.New(t)
+
+	_, network1, err := net.ParseCIDR("10.0.0.0/8")
+	is.NoError(err)
+	_, network2, err := net.ParseCIDR("7.7.7.0/24")
+	is.NoError(err)
+
+	limiter1 := New(limiter.WithTrustForwardHeader(true))
+	limiter2 := New(limiter.WithTrustForwardHeader(true), limiter.WithTrustedProxies(*network1, *network2))
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "10.0.0.2:8888",
+	}
+	request1.Header.Add("X-Forwarded-For", "1.2.3.4, 9.9.9.9, 7.7.7.7, 10.0.0.1")
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "10.0.0.2:8888",
+	}
+	request2.Header.Add("X-Forwarded-For", "1.2.3.4")
+	request2.Header.Add("X-Forwarded-For", "9.9.9.9, 10.0.0.1")
+
+	request3 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "10.0.0.2:8888",
+	}
+	request3.Header.Add("X-Forwarded-For", "7.7.7.7, 10.0.0.1")
+
+	scenarios := []struct {
+		request  *http.Request
+		limiter  *limiter.Limiter
+		expected net.IP
+	}{
+		{
+			//
+			// Scenario #1 : No trusted proxies, first IP of the chain.
+			//
+			request:  request1,
+			limiter:  limiter1,
+			expected: net.ParseIP("1.2.3.4").To4(),
+		},
+		{
+			//
+			// Scenario #2 : Trusted proxies, first untrusted IP from the right.
+			//
+			request:  request1,
+			limiter:  limiter2,
+			expected: net.ParseIP("9.9.9.9").To4(),
+		},
+		{
+			//
+			// Scenario #3 : Trusted proxies with multiple headers.
+			//
+			request:  request2,
+			limiter:  limiter2,
+			expected: net.ParseIP("9.9.9.9").To4(),
+		},
+		{
+			//
+			// Scenario #4 : Trusted proxies, entire chain is trusted.
+			//
+			request:  request3,
+			limiter:  limiter2,
+			expected: net.ParseIP("10.0.0.2").To4(),
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		ip := scenario.limiter.GetIPWithMask(scenario.request)
+		is.Equal(scenario.expected, ip, message)
+	}
+}
+
+func TestGetIPWithForwardedHops(t *testing.T) {
+	is := require.New(t)
+
+	_, network, err := net.ParseCIDR("10.0.0.0/8")
+	is.NoError(err)
+
+	limiter1 := New(limiter.WithTrustForwardHeader(true), limiter.WithForwardedHops(3))
+	limiter2 := New(limiter.WithTrustForwardHeader(true), limiter.WithForwardedHops(3),
+		limiter.WithTrustedProxies(*network))
+	limiter3 := New(limiter.WithForwardedHops(3))
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "10.0.0.2:8888",
+	}
+	request1.Header.Add("X-Forwarded-For", "6.6.6.6, 1.2.3.4, 10.0.0.3, 10.0.0.1")
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "10.0.0.2:8888",
+	}
+	request2.Header.Add("X-Forwarded-For", "6.6.6.6, 1.2.3.4")
+	request2.Header.Add("X-Forwarded-For", "10.0.0.3, 10.0.0.1")
+
+	request3 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "10.0.0.2:8888",
+	}
+	request3.Header.Add("X-Forwarded-For", "10.0.0.3, 10.0.0.1")
+
+	request4 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "10.0.0.2:8888",
+	}
+	request4.Header.Add("X-Forwarded-For", "foo, 10.0.0.3, 10.0.0.1")
+
+	scenarios := []struct {
+		request  *http.Request
+		limiter  *limiter.Limiter
+		expected net.IP
+	}{
+		{
+			//
+			// Scenario #1 : Third IP from the right.
+			//
+			request:  request1,
+			limiter:  limiter1,
+			expected: net.ParseIP("1.2.3.4").To4(),
+		},
+		{
+			//
+			// Scenario #2 : Third IP from the right, ignoring trusted proxies.
+			//
+			request:  request1,
+			limiter:  limiter2,
+			expected: net.ParseIP("1.2.3.4").To4(),
+		},
+		{
+			//
+			// Scenario #3 : Third IP from the right with multiple headers.
+			//
+			request:  request2,
+			limiter:  limiter1,
+			expected: net.ParseIP("1.2.3.4").To4(),
+		},
+		{
+			//
+			// Scenario #4 : Shorter chain, fallback on RemoteAddr.
+			//
+			request:  request3,
+			limiter:  limiter1,
+			expected: net.ParseIP("10.0.0.2").To4(),
+		},
+		{
+			//
+			// Scenario #5 : Unparseable entry, fallback on RemoteAddr.
+			//
+			request:  request4,
+			limiter:  limiter1,
+			expected: net.ParseIP("10.0.0.2").To4(),
+		},
+		{
+			//
+			// Scenario #6 : Forward headers not trusted.
+			//
+			request:  request1,
+			limiter:  limiter3,
+			expected: net.ParseIP("10.0.0.2").To4(),
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		ip := scenario.limiter.GetIPWithMask(scenario.request)
+		is.Equal(scenario.expected, ip, message)
+	}
+}
+
+func TestGetMaskedIP(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New(limiter.WithIPv4Mask(net.CIDRMask(24, 32)), limiter.WithIPv6Mask(net.CIDRMask(48, 128)))
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/foo"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/bar"},
+		Header:     http.Header{},
+		RemoteAddr: "[2001:db8:cafe:1234:beef::fafa]:8888",
+	}
+
+	ip := limiter1.GetMaskedIP(request1)
+	is.Equal(net.ParseIP("8.8.8.0").To4(), ip)
+	is.Len(ip, net.IPv4len)
+	is.Equal("8.8.8.0:/foo", fmt.Sprintf("%s:%s", ip, request1.URL.Path))
+	is.Equal(limiter1.GetIPKey(request1), ip.String())
+
+	ip = limiter1.GetMaskedIP(request2)
+	is.Equal(net.ParseIP("2001:db8:cafe::").To16(), ip)
+	is.Len(ip, net.IPv6len)
+	is.Equal("2001:db8:cafe:::/bar", fmt.Sprintf("%s:%s", ip, request2.URL.Path))
+	is.Equal(limiter1.GetIPKey(request2), ip.String())
+}
+
+func TestGetIPWithMaskDefaults(t *testing.T) {
+	is := require.New(t)
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "[2001:db8:cafe:1234:beef::fafa]:8888",
+	}
+
+	ip := limiter.GetIPWithMask(request1, limiter.Options{})
+	is.Equal(net.ParseIP("8.8.8.8").To4(), ip)
+	is.Equal(net.ParseIP("8.8.8.8").Mask(limiter.DefaultIPv4Mask), ip)
+
+	ip = limiter.GetIPWithMask(request2, limiter.Options{})
+	is.Equal(net.ParseIP("2001:db8:cafe:1234:beef::fafa").To16(), ip)
+
+	instance := New(limiter.WithIPv4Mask(nil), limiter.WithIPv6Mask(net.IPMask{}))
+	is.Equal("8.8.8.8", instance.GetIPKey(request1))
+	is.Equal("2001:db8:cafe:1234:beef::fafa", instance.GetIPKey(request2))
+}
+
+func TestGetIPKeyWithIPv6PrivacyMask(t *testing.T) {
+	is := require.New(t)
+
+	newRequest := func(remoteAddr string) *http.Request {
+		return &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: remoteAddr,
+		}
+	}
+
+	request1 := newRequest("[2001:db8:cafe:1234:beef::fafa]:8888")
+	request2 := newRequest("[2001:db8:cafe:1234:dead:beef:cafe:1]:8888")
+	request3 := newRequest("[2001:db8:cafe:1235:beef::fafa]:8888")
+	request4 := newRequest("8.8.8.8:8888")
+
+	limiter1 := New(limiter.WithIPv6PrivacyMask(true))
+
+	// Addresses of the same /64 share their key.
+	is.Equal("2001:db8:cafe:1234::", limiter1.GetIPKey(request1))
+	is.Equal(limiter1.GetIPKey(request1), limiter1.GetIPKey(request2))
+	is.NotEqual(limiter1.GetIPKey(request1), limiter1.GetIPKey(request3))
+	is.Equal("8.8.8.8", limiter1.GetIPKey(request4))
+
+	// The default mask is unchanged.
+	limiter2 := New()
+	is.NotEqual(limiter2.GetIPKey(request1), limiter2.GetIPKey(request2))
+
+	limiter3 := New(limiter.WithIPv6PrivacyMask(true), limiter.WithIPv6PrivacyMask(false))
+	is.Equal("2001:db8:cafe:1234:beef::fafa", limiter3.GetIPKey(request1))
+}
+
+func TestGetAllIPs(t *testing.T) {
+	is := require.New(t)
+
+	request := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "5.5.5.5:8888",
+	}
+	request.Header.Add("X-Forwarded-For", "9.9.9.9, 7.7.7.7, foo")
+	request.Header.Add("X-Forwarded-For", "9.9.9.9, [2001:db8::1]:443, 5.5.5.5")
+
+	expected := []net.IP{
+		net.ParseIP("9.9.9.9"),
+		net.ParseIP("7.7.7.7"),
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("5.5.5.5"),
+	}
+
+	// Scenario #1 : every IP of the chain, in order, without duplicates.
+	is.Equal(expected, limiter.GetAllIPs(request))
+
+	limiter1 := New(limiter.WithTrustForwardHeader(true))
+	is.Equal(expected, limiter1.GetAllIPs(request))
+
+	// Scenario #2 : the chain isn't trusted.
+	limiter2 := New(limiter.WithTrustForwardHeader(false))
+	is.Equal([]net.IP{net.ParseIP("5.5.5.5")}, limiter2.GetAllIPs(request))
+
+	// Scenario #3 : no chain.
+	request.Header.Del("X-Forwarded-For")
+	is.Equal([]net.IP{net.ParseIP("5.5.5.5")}, limiter.GetAllIPs(request))
+
+	request.RemoteAddr = "foo"
+	is.Empty(limiter.GetAllIPs(request))
+}
+
+func TestGetIPStrict(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New(
+		limiter.WithTrustForwardHeader(true),
+		limiter.WithClientIPHeader("CF-Connecting-IP"),
+		limiter.WithIPv4Mask(net.CIDRMask(24, 32)),
+	)
+
+	request := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request.Header.Add("X-Forwarded-For", "9.9.9.9, 7.7.7.7")
+	request.Header.Add("X-Real-IP", "6.6.6.6")
+	request.Header.Add("CF-Connecting-IP", "5.5.5.5")
+
+	// Scenario #1 : headers are honored by GetIP.
+	is.Equal(net.ParseIP("5.5.5.5").To4(), limiter1.GetIP(request).To4())
+
+	// Scenario #2 : headers are ignored, even if trusted.
+	is.Equal(net.ParseIP("8.8.8.8").To4(), limiter1.GetIPStrict(request).To4())
+	is.Equal("8.8.8.0", limiter1.GetIPStrictKey(request))
+
+	request.Header.Del("CF-Connecting-IP")
+	is.Equal(net.ParseIP("9.9.9.9").To4(), limiter1.GetIP(request).To4())
+	is.Equal(net.ParseIP("8.8.8.8").To4(), limiter1.GetIPStrict(request).To4())
+
+	// Scenario #3 : RemoteAddrFunc is still used.
+	limiter2 := New(
+		limiter.WithTrustForwardHeader(true),
+		limiter.WithRemoteAddrFunc(func(r *http.Request) string {
+			return "10.0.0.1:8888"
+		}),
+	)
+	is.Equal(net.ParseIP("10.0.0.1").To4(), limiter2.GetIPStrict(request).To4())
+
+	// Scenario #4 : unparseable RemoteAddr.
+	request.RemoteAddr = "foo"
+	is.Nil(limiter1.GetIPStrict(request))
+	is.Equal("unknown:foo", limiter1.GetIPStrictKey(request))
+}
+
+func TestGetIPKeyWithMalformedRemoteAddr(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New()
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "foo:8888",
+	}
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "bar",
+	}
+
+	is.Nil(limiter1.GetIP(request1))
+	is.Equal("unknown:foo:8888", limiter1.GetIPKey(request1))
+	is.Equal("unknown:bar", limiter1.GetIPKey(request2))
+	is.Equal("unknown:bar", limiter1.GetKey(request2))
+	is.Equal("unknown:bar", limiter1.GetIPKeyWithPrefix(request2, 24, 64))
+	is.Equal("unknown:bar", New(limiter.WithASNResolver(fakeASNResolver{})).GetIPKey(request2))
+}
+
+func TestGetIPUAKey(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New(limiter.WithIPv4Mask(net.CIDRMask(24, 32)))
+
+	newRequest := func(remoteAddr string, userAgent string) *http.Request {
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: remoteAddr,
+		}
+		if userAgent != "" {
+			request.Header.Set("User-Agent", userAgent)
+		}
+		return request
+	}
+
+	chrome := limiter1.GetIPUAKey(newRequest("8.8.8.8:8888", "Mozilla/5.0 Chrome/96.0"))
+	firefox := limiter1.GetIPUAKey(newRequest("8.8.8.8:8888", "Mozilla/5.0 Firefox/95.0"))
+	empty := limiter1.GetIPUAKey(newRequest("8.8.8.8:8888", ""))
+
+	// Scenario #1 : Different User-Agents from the same IP have distinct keys.
+	is.NotEqual(chrome, firefox)
+	is.NotEqual(chrome, empty)
+	is.NotEqual(firefox, empty)
+
+	// Scenario #2 : Keys are the masked IP and a short hash.
+	for _, key := range []string{chrome, firefox, empty} {
+		is.True(strings.HasPrefix(key, "8.8.8.0:"), key)
+		is.Len(strings.TrimPrefix(key, "8.8.8.0:"), 16, key)
+	}
+
+	// Scenario #3 : User-Agents are normalized before being hashed.
+	is.Equal(chrome, limiter1.GetIPUAKey(newRequest("8.8.8.8:8888", "  mozilla/5.0 CHROME/96.0 ")))
+
+	// Scenario #4 : The same User-Agent from IPs of the same network share their key, but not from other networks.
+	is.Equal(chrome, limiter1.GetIPUAKey(newRequest("8.8.8.9:8888", "Mozilla/5.0 Chrome/96.0")))
+	is.NotEqual(chrome, limiter1.GetIPUAKey(newRequest("8.8.4.4:8888", "Mozilla/5.0 Chrome/96.0")))
+}
+
+func TestGetIPNet(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New()
+	limiter2 := New(limiter.WithIPv4Mask(net.CIDRMask(24, 32)), limiter.WithIPv6Mask(net.CIDRMask(48, 128)))
+	limiter3 := New(limiter.WithIPv4Mask(net.CIDRMask(120, 128)))
+
+	scenarios := []struct {
+		remoteAddr string
+		limiter    *limiter.Limiter
+		expected   string
+	}{
+		{
+			// Scenario #1 : IPv4 with default mask.
+			remoteAddr: "203.0.113.7:8888",
+			limiter:    limiter1,
+			expected:   "203.0.113.7/32",
+		},
+		{
+			// Scenario #2 : IPv6 with default mask.
+			remoteAddr: "[2001:db8:cafe:1234:beef::fafa]:8888",
+			limiter:    limiter1,
+			expected:   "2001:db8:cafe:1234:beef::fafa/128",
+		},
+		{
+			// Scenario #3 : IPv4 with a /24 mask.
+			remoteAddr: "203.0.113.7:8888",
+			limiter:    limiter2,
+			expected:   "203.0.113.0/24",
+		},
+		{
+			// Scenario #4 : IPv6 with a /48 mask.
+			remoteAddr: "[2001:db8:cafe:1234:beef::fafa]:8888",
+			limiter:    limiter2,
+			expected:   "2001:db8:cafe::/48",
+		},
+		{
+			// Scenario #5 : IPv4 with a 16-byte mask.
+			remoteAddr: "203.0.113.7:8888",
+			limiter:    limiter3,
+			expected:   "203.0.113.0/24",
+		},
+	}
+
+	for i, scenario := range scenarios {
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: scenario.remoteAddr,
+		}
+
+		network := scenario.limiter.GetIPNet(request)
+		is.NotNil(network, "scenario #%d", i+1)
+		is.Equal(scenario.expected, network.String(), "scenario #%d", i+1)
+		is.True(network.Contains(scenario.limiter.GetIP(request)), "scenario #%d", i+1)
+		is.Equal(scenario.limiter.GetMaskedIP(request).String(), network.IP.String(), "scenario #%d", i+1)
+	}
+
+	request := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "foo",
+	}
+	is.Nil(limiter1.GetIPNet(request))
+}
+
+func TestGetIPKeyWithIPv4MappedAddress(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New()
+	limiter2 := New(limiter.WithIPv4Mask(net.CIDRMask(24, 32)), limiter.WithIPv6Mask(net.CIDRMask(64, 128)))
+	limiter3 := New(limiter.WithTrustForwardHeader(true))
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "192.0.2.1:8888",
+	}
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "[::ffff:192.0.2.1]:8888",
+	}
+
+	request3 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "10.0.0.1:8888",
+	}
+	request3.Header.Add("X-Forwarded-For", "::ffff:192.0.2.1")
+
+	is.Equal("192.0.2.1", limiter1.GetIPKey(request1))
+	is.Equal(limiter1.GetIPKey(request1), limiter1.GetIPKey(request2))
+	is.Equal(limiter1.GetMaskedIP(request1), limiter1.GetMaskedIP(request2))
+	is.Len(limiter1.GetMaskedIP(request2), net.IPv4len)
+
+	is.Equal("192.0.2.0", limiter2.GetIPKey(request1))
+	is.Equal(limiter2.GetIPKey(request1), limiter2.GetIPKey(request2))
+	is.Equal(limiter2.GetIPNet(request1).String(), limiter2.GetIPNet(request2).String())
+
+	is.Equal(limiter1.GetIPKey(request1), limiter3.GetIPKey(request3))
+}
+
+func TestGetJWTSubWithAlgorithms(t *testing.T) {
+	is := require.New(t)
+
+	secret := "javad"
+	claims := jwt.StandardClaims{Subject: "mohammad"}
+
+	token1, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	is.NoError(err)
+	token2, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte(secret))
+	is.NoError(err)
+	token3, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	is.NoError(err)
+
+	limiter1 := New(limiter.WithJWTSecret(secret))
+	limiter2 := New(limiter.WithJWTSecret(secret), limiter.WithJWTAlgorithms("HS512"))
+
+	scenarios := []struct {
+		token   string
+		limiter *limiter.Limiter
+		valid   bool
+	}{
+		{
+			//
+			// Scenario #1 : HS256 with default algorithms.
+			//
+			token:   token1,
+			limiter: limiter1,
+			valid:   true,
+		},
+		{
+			//
+			// Scenario #2 : HS512 with default algorithms.
+			//
+			token:   token2,
+			limiter: limiter1,
+			valid:   false,
+		},
+		{
+			//
+			// Scenario #3 : HS512 with HS512 allowed.
+			//
+			token:   token2,
+			limiter: limiter2,
+			valid:   true,
+		},
+		{
+			//
+			// Scenario #4 : HS256 with only HS512 allowed.
+			//
+			token:   token1,
+			limiter: limiter2,
+			valid:   false,
+		},
+		{
+			//
+			// Scenario #5 : "none" algorithm.
+			//
+			token:   token3,
+			limiter: limiter1,
+			valid:   false,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		request.Header.Add("Authorization", "Bearer "+scenario.token)
+
+		sub, err := scenario.limiter.GetJWTSub(request)
+		if scenario.valid {
+			is.NoError(err, message)
+			is.Equal(fmt.Sprint([]byte("mohammad")), sub, message)
+		} else {
+			is.Equal(limiter.ErrInvalidJWT, err, message)
+			is.Empty(sub, message)
+		}
+	}
+}
+
+func TestGetJWTSubWithKeyFunc(t *testing.T) {
+	is := require.New(t)
+
+	secrets := map[string]string{
+		"k1": "javad",
+		"k2": "mohammad",
+	}
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		secret, ok := secrets[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return []byte(secret), nil
+	}
+
+	sign := func(kid string, secret string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "mohammad"})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString([]byte(secret))
+		is.NoError(err)
+		return signed
+	}
+
+	scenarios := []struct {
+		token string
+		valid bool
+	}{
+		{
+			//
+			// Scenario #1 : First kid, signed with its secret.
+			//
+			token: sign("k1", "javad"),
+			valid: true,
+		},
+		{
+			//
+			// Scenario #2 : Second kid, signed with its secret.
+			//
+			token: sign("k2", "mohammad"),
+			valid: true,
+		},
+		{
+			//
+			// Scenario #3 : First kid, signed with the secret of the second kid.
+			//
+			token: sign("k1", "mohammad"),
+			valid: false,
+		},
+		{
+			//
+			// Scenario #4 : Unknown kid.
+			//
+			token: sign("k3", "javad"),
+			valid: false,
+		},
+	}
+
+	instance := New(limiter.WithJWTSecret("ignored"), limiter.WithJWTKeyFunc(keyFunc))
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		request.Header.Add("Authorization", "Bearer "+scenario.token)
+
+		sub, err := limiter.GetJWTSubWithKeyFunc(request, keyFunc)
+		if scenario.valid {
+			is.NoError(err, message)
+			is.Equal(fmt.Sprint([]byte("mohammad")), sub, message)
+		} else {
+			is.Error(err, message)
+			is.Empty(sub, message)
+		}
+
+		sub, err = instance.GetJWTSub(request)
+		if scenario.valid {
+			is.NoError(err, message)
+			is.Equal(fmt.Sprint([]byte("mohammad")), sub, message)
+		} else {
+			is.Error(err, message)
+			is.Empty(sub, message)
+		}
+	}
+
+	// The signing algorithm is verified before looking up the key.
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.StandardClaims{Subject: "mohammad"}).
+		SignedString([]byte("javad"))
+	is.NoError(err)
+	request := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{"Authorization": []string{"Bearer " + token}},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	_, err = limiter.GetJWTSubWithKeyFunc(request, func(token *jwt.Token) (interface{}, error) {
+		is.Fail("key function should not be called")
+		return nil, nil
+	})
+	is.Equal(limiter.ErrInvalidJWT, err)
+}
+
+func TestGetJWTSubWithPublicKey(t *testing.T) {
+	is := require.New(t)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	is.NoError(err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	is.NoError(err)
+
+	sign := func(method jwt.SigningMethod, key interface{}) string {
+		signed, err := jwt.NewWithClaims(method, jwt.StandardClaims{Subject: "mohammad"}).SignedString(key)
+		is.NoError(err)
+		return signed
+	}
+
+	scenarios := []struct {
+		token string
+		valid bool
+	}{
+		{
+			//
+			// Scenario #1 : Signed with RS256 and the matching private key.
+			//
+			token: sign(jwt.SigningMethodRS256, privateKey),
+			valid: true,
+		},
+		{
+			//
+			// Scenario #2 : Signed with RS256 and another private key.
+			//
+			token: sign(jwt.SigningMethodRS256, otherKey),
+			valid: false,
+		},
+		{
+			//
+			// Scenario #3 : Signed with a disallowed algorithm.
+			//
+			token: sign(jwt.SigningMethodRS512, privateKey),
+			valid: false,
+		},
+		{
+			//
+			// Scenario #4 : Signed with HMAC, using an empty secret.
+			//
+			token: sign(jwt.SigningMethodHS256, []byte("")),
+			valid: false,
+		},
+	}
+
+	instance := New(limiter.WithJWTPublicKey(&privateKey.PublicKey), limiter.WithJWTAlgorithms("RS256", "HS256"))
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		request.Header.Add("Authorization", "Bearer "+scenario.token)
+
+		sub, err := limiter.GetJWTSubWithPublicKey(request, &privateKey.PublicKey)
+		if scenario.valid {
+			is.NoError(err, message)
+			is.Equal(fmt.Sprint([]byte("mohammad")), sub, message)
+		} else {
+			is.Error(err, message)
+			is.Empty(sub, message)
+		}
+
+		sub, err = instance.GetJWTSub(request)
+		if scenario.valid {
+			is.NoError(err, message)
+			is.Equal(fmt.Sprint([]byte("mohammad")), sub, message)
+		} else {
+			is.Error(err, message)
+			is.Empty(sub, message)
+		}
+	}
+
+	// HMAC secret is still supported, in addition to the public key.
+	instance = New(limiter.WithJWTSecret("javad"), limiter.WithJWTPublicKey(&privateKey.PublicKey),
+		limiter.WithJWTAlgorithms("RS256", "HS256"))
+
+	for _, token := range []string{sign(jwt.SigningMethodHS256, []byte("javad")), scenarios[0].token} {
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{"Authorization": []string{"Bearer " + token}},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+
+		sub, err := instance.GetJWTSub(request)
+		is.NoError(err)
+		is.Equal(fmt.Sprint([]byte("mohammad")), sub)
+	}
+
+	// RS256 is rejected without public key.
+	request := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{"Authorization": []string{"Bearer " + scenarios[0].token}},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	instance = New(limiter.WithJWTSecret("javad"), limiter.WithJWTAlgorithms("RS256", "HS256"))
+
+	_, err = instance.GetJWTSub(request)
+	is.Equal(limiter.ErrInvalidJWT, err)
+}
+
+func TestGetJWTClaim(t *testing.T) {
+	is := require.New(t)
+
+	secret := "javad"
+	limiter1 := New(limiter.WithJWTSecret(secret))
+
+	scenarios := []struct {
+		claims   jwt.MapClaims
+		claim    string
+		expected string
+		err      error
+	}{
+		{
+			//
+			// Scenario #1 : String claim.
+			//
+			claims:   jwt.MapClaims{"sub": "mohammad", "tid": "ulule"},
+			claim:    "tid",
+			expected: "ulule",
+		},
+		{
+			//
+			// Scenario #2 : Number claim.
+			//
+			claims:   jwt.MapClaims{"sub": "mohammad", "tid": 42},
+			claim:    "tid",
+			expected: "42",
+		},
+		{
+			//
+			// Scenario #3 : Missing claim.
+			//
+			claims: jwt.MapClaims{"sub": "mohammad"},
+			claim:  "tid",
+			err:    limiter.ErrInvalidJWT,
+		},
+		{
+			//
+			// Scenario #4 : Claim with an unsupported type.
+			//
+			claims: jwt.MapClaims{"sub": "mohammad", "tid": []string{"ulule"}},
+			claim:  "tid",
+			err:    limiter.ErrInvalidJWT,
+		},
+		{
+			//
+			// Scenario #5 : Expired token.
+			//
+			claims: jwt.MapClaims{"sub": "mohammad", "tid": "ulule", "exp": time.Now().Add(-time.Minute).Unix()},
+			claim:  "tid",
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, scenario.claims).SignedString([]byte(secret))
+		is.NoError(err, message)
+
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		request.Header.Add("Authorization", "Bearer "+token)
+
+		value, err := limiter1.GetJWTClaim(request, scenario.claim)
+		switch {
+		case scenario.expected != "":
+			is.NoError(err, message)
+			is.Equal(scenario.expected, value, message)
+		case scenario.err != nil:
+			is.Equal(scenario.err, err, message)
+			is.Empty(value, message)
+		default:
+			is.Error(err, message)
+			is.Empty(value, message)
+		}
+	}
+}
+
+func TestGetIPWithZone(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New()
+
+	scenarios := []struct {
+		remoteAddr string
+		expected   net.IP
+	}{
+		{
+			//
+			// Scenario #1 : IPv6 with zone and port.
+			//
+			remoteAddr: "[fe80::1%eth0]:443",
+			expected:   net.ParseIP("fe80::1"),
+		},
+		{
+			//
+			// Scenario #2 : IPv6 with zone and without port.
+			//
+			remoteAddr: "fe80::1%eth0",
+			expected:   net.ParseIP("fe80::1"),
+		},
+		{
+			//
+			// Scenario #3 : IPv6 without zone.
+			//
+			remoteAddr: "[2001:db8:cafe:1234:beef::fafa]:8888",
+			expected:   net.ParseIP("2001:db8:cafe:1234:beef::fafa"),
+		},
+		{
+			//
+			// Scenario #4 : IPv4 with port.
+			//
+			remoteAddr: "8.8.8.8:8888",
+			expected:   net.ParseIP("8.8.8.8"),
+		},
+		{
+			//
+			// Scenario #5 : IPv4 without port.
+			//
+			remoteAddr: "8.8.8.8",
+			expected:   net.ParseIP("8.8.8.8"),
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: scenario.remoteAddr,
+		}
+
+		ip := limiter1.GetIP(request)
+		is.NotNil(ip, message)
+		is.True(scenario.expected.Equal(ip), message)
+		is.Equal(scenario.expected.String(), limiter1.GetIPKey(request), message)
+	}
+}
+
+func TestGetIPKeyWithPrefix(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New()
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "[2001:db8:cafe:1234:beef::fafa]:8888",
+	}
+
+	scenarios := []struct {
+		request  *http.Request
+		v4bits   int
+		v6bits   int
+		expected string
+		err      error
+	}{
+		{
+			//
+			// Scenario #1 : IPv4 with a /24 prefix.
+			//
+			request:  request1,
+			v4bits:   24,
+			v6bits:   64,
+			expected: "8.8.8.0",
+		},
+		{
+			//
+			// Scenario #2 : IPv4 with a /32 prefix.
+			//
+			request:  request1,
+			v4bits:   32,
+			v6bits:   128,
+			expected: "8.8.8.8",
+		},
+		{
+			//
+			// Scenario #3 : IPv6 with a /48 prefix.
+			//
+			request:  request2,
+			v4bits:   24,
+			v6bits:   48,
+			expected: "2001:db8:cafe::",
+		},
+		{
+			//
+			// Scenario #4 : Out of range IPv4 prefix.
+			//
+			request:  request1,
+			v4bits:   33,
+			v6bits:   64,
+			expected: "8.8.8.8",
+			err:      limiter.ErrInvalidIPPrefix,
+		},
+		{
+			//
+			// Scenario #5 : Out of range IPv6 prefix.
+			//
+			request:  request2,
+			v4bits:   24,
+			v6bits:   -1,
+			expected: "2001:db8:cafe:1234:beef::fafa",
+			err:      limiter.ErrInvalidIPPrefix,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+
+		key := limiter1.GetIPKeyWithPrefix(scenario.request, scenario.v4bits, scenario.v6bits)
+		is.Equal(scenario.expected, key, message)
+
+		ip, err := limiter.GetIPWithPrefix(scenario.request, scenario.v4bits, scenario.v6bits, limiter1.Options)
+		is.Equal(scenario.err, err, message)
+		is.Equal(scenario.expected, ip.String(), message)
+	}
+}
+
+func TestGetIPFromXFFHeaderWithPort(t *testing.T) {
+	is := require.New(t)
+
+	_, network1, err := net.ParseCIDR("10.0.0.0/8")
+	is.NoError(err)
+
+	limiter1 := New(limiter.WithTrustForwardHeader(true))
+	limiter2 := New(limiter.WithTrustForwardHeader(true), limiter.WithTrustedProxies(*network1))
+
+	scenarios := []struct {
+		header   string
+		limiter  *limiter.Limiter
+		expected net.IP
+	}{
+		{
+			//
+			// Scenario #1 : IPv4 with port.
+			//
+			header:   "203.0.113.7:54321, 10.0.0.1",
+			limiter:  limiter1,
+			expected: net.ParseIP("203.0.113.7"),
+		},
+		{
+			//
+			// Scenario #2 : Bracketed IPv6 with port.
+			//
+			header:   "[2001:db8::1]:443 ,10.0.0.1",
+			limiter:  limiter1,
+			expected: net.ParseIP("2001:db8::1"),
+		},
+		{
+			//
+			// Scenario #3 : Bare IPv6, followed by addresses with port.
+			//
+			header:   "2001:db8::2, [2001:db8::1]:443, 203.0.113.7:54321",
+			limiter:  limiter1,
+			expected: net.ParseIP("2001:db8::2"),
+		},
+		{
+			//
+			// Scenario #4 : Mixed addresses with trusted proxies.
+			//
+			header:   "2001:db8::2, [2001:db8::1]:443, 10.0.0.3:8080, 10.0.0.1",
+			limiter:  limiter2,
+			expected: net.ParseIP("2001:db8::1"),
+		},
+		{
+			//
+			// Scenario #5 : Mixed addresses with trusted proxies and IPv4 with port.
+			//
+			header:   "[2001:db8::1]:443, 203.0.113.7:54321 , 10.0.0.1",
+			limiter:  limiter2,
+			expected: net.ParseIP("203.0.113.7"),
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "10.0.0.2:8888",
+		}
+		request.Header.Add("X-Forwarded-For", scenario.header)
+
+		ip := scenario.limiter.GetIP(request)
+		is.True(scenario.expected.Equal(ip), message)
+	}
+}
+
+func TestGetKey(t *testing.T) {
+	is := require.New(t)
+
+	keyFunc := func(r *http.Request) string {
+		sub := r.Header.Get("X-User")
+		if sub != "" {
+			return "sub:" + sub
+		}
+		return "ip:" + limiter.GetIP(r).String()
+	}
+
+	limiter1 := New()
+	limiter2 := New(limiter.WithKeyFunc(keyFunc))
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request2.Header.Add("X-User", "mohammad")
+
+	is.Equal("8.8.8.8", limiter1.GetKey(request1))
+	is.Equal("8.8.8.8", limiter1.GetKey(request2))
+	is.Equal("ip:8.8.8.8", limiter2.GetKey(request1))
+	is.Equal("sub:mohammad", limiter2.GetKey(request2))
+}
+
+func TestGetKeyWithPathTemplate(t *testing.T) {
+	is := require.New(t)
+
+	// A matcher of registered route templates, like a router would do.
+	templates := []string{"/users/{id}", "/users/{id}/posts/{post}"}
+	pathTemplateFunc := func(r *http.Request) string {
+		parts := strings.Split(r.URL.Path, "/")
+		for _, template := range templates {
+			candidate := strings.Split(template, "/")
+			if len(candidate) != len(parts) {
+				continue
+			}
+
+			matched := true
+			for i := range candidate {
+				if !strings.HasPrefix(candidate[i], "{") && candidate[i] != parts[i] {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				return template
+			}
+		}
+		return ""
+	}
+
+	limiter1 := New(limiter.WithPathTemplateFunc(pathTemplateFunc))
+	limiter2 := New(limiter.WithPathTemplateFunc(pathTemplateFunc), limiter.WithKeyFunc(func(r *http.Request) string {
+		return "custom"
+	}))
+
+	scenarios := []struct {
+		path     string
+		template string
+	}{
+		{
+			// Scenario #1 : concrete paths of a route share its template.
+			path:     "/users/123",
+			template: "/users/{id}",
+		},
+		{
+			// Scenario #2 : concrete paths of a route share its template.
+			path:     "/users/456",
+			template: "/users/{id}",
+		},
+		{
+			// Scenario #3 : nested route.
+			path:     "/users/456/posts/789",
+			template: "/users/{id}/posts/{post}",
+		},
+		{
+			// Scenario #4 : unmatched path is used as is.
+			path:     "/health",
+			template: "/health",
+		},
+	}
+
+	for i, scenario := range scenarios {
+		request := &http.Request{
+			URL:        &url.URL{Path: scenario.path},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+
+		is.Equal(scenario.template, limiter1.GetPathTemplate(request), "scenario #%d", i+1)
+		is.Equal("8.8.8.8|"+scenario.template, limiter1.GetKey(request), "scenario #%d", i+1)
+		is.Equal("custom", limiter2.GetKey(request), "scenario #%d", i+1)
+	}
+
+	request := &http.Request{
+		URL:        &url.URL{Path: "/users/123"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	is.Equal("/users/123", New().GetPathTemplate(request))
+}
+
+func TestGetKeyWithMethodAndPath(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New(limiter.WithKeyIncludeMethod(true))
+	limiter2 := New(limiter.WithKeyIncludePath(true))
+	limiter3 := New(limiter.WithKeyIncludeMethod(true), limiter.WithKeyIncludePath(true))
+	limiter4 := New(limiter.WithKeyIncludeMethod(true), limiter.WithKeyIncludePath(true),
+		limiter.WithKeyFunc(func(r *http.Request) string {
+			return "custom"
+		}))
+
+	scenarios := []struct {
+		limiter  *limiter.Limiter
+		method   string
+		path     string
+		expected string
+	}{
+		{
+			//
+			// Scenario #1 : Method.
+			//
+			limiter:  limiter1,
+			method:   "GET",
+			path:     "/users",
+			expected: "2001:db8::1|GET",
+		},
+		{
+			//
+			// Scenario #2 : Another method.
+			//
+			limiter:  limiter1,
+			method:   "post",
+			path:     "/users",
+			expected: "2001:db8::1|POST",
+		},
+		{
+			//
+			// Scenario #3 : Path.
+			//
+			limiter:  limiter2,
+			method:   "GET",
+			path:     "/users",
+			expected: "2001:db8::1|/users",
+		},
+		{
+			//
+			// Scenario #4 : Path with a space, escaped.
+			//
+			limiter:  limiter2,
+			method:   "GET",
+			path:     "/users/foo bar",
+			expected: "2001:db8::1|/users/foo%20bar",
+		},
+		{
+			//
+			// Scenario #5 : Method then path.
+			//
+			limiter:  limiter3,
+			method:   "GET",
+			path:     "/users",
+			expected: "2001:db8::1|GET|/users",
+		},
+		{
+			//
+			// Scenario #6 : Same path with another method.
+			//
+			limiter:  limiter3,
+			method:   "POST",
+			path:     "/users",
+			expected: "2001:db8::1|POST|/users",
+		},
+		{
+			//
+			// Scenario #7 : Same method with another path.
+			//
+			limiter:  limiter3,
+			method:   "POST",
+			path:     "/posts",
+			expected: "2001:db8::1|POST|/posts",
+		},
+		{
+			//
+			// Scenario #8 : Separator and escape character in the path are escaped.
+			//
+			limiter:  limiter3,
+			method:   "POST",
+			path:     "/a|b%c",
+			expected: "2001:db8::1|POST|/a%7Cb%25c",
+		},
+		{
+			//
+			// Scenario #9 : KeyFunc is used verbatim.
+			//
+			limiter:  limiter4,
+			method:   "POST",
+			path:     "/posts",
+			expected: "custom",
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			Method:     scenario.method,
+			URL:        &url.URL{Path: scenario.path},
+			Header:     http.Header{},
+			RemoteAddr: "[2001:db8::1]:8888",
+		}
+
+		is.Equal(scenario.expected, scenario.limiter.GetKey(request), message)
+	}
+
+	// An unparseable RemoteAddr is escaped too.
+	request := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Path: "/users"},
+		Header:     http.Header{},
+		RemoteAddr: "foo bar|baz",
+	}
+	is.Equal("unknown:foo%20bar%7Cbaz|GET|/users", limiter3.GetKey(request))
+	is.Equal("unknown:foo bar|baz", New().GetKey(request))
+}
+
+func TestGetIPSource(t *testing.T) {
+	is := require.New(t)
+
+	_, network1, err := net.ParseCIDR("10.0.0.0/8")
+	is.NoError(err)
+
+	limiter1 := New(limiter.WithTrustForwardHeader(false))
+	limiter2 := New(limiter.WithTrustForwardHeader(true))
+	limiter3 := New(limiter.WithClientIPHeader("CF-Connecting-IP"))
+	limiter4 := New(limiter.WithTrustForwardHeader(true), limiter.WithTrustedProxies(*network1))
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request1.Header.Add("X-Forwarded-For", "9.9.9.9, 10.0.0.1")
+	request1.Header.Add("X-Real-IP", "6.6.6.6")
+	request1.Header.Add("CF-Connecting-IP", "7.7.7.7")
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request2.Header.Add("X-Real-IP", "6.6.6.6")
+
+	request3 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request3.Header.Add("X-Forwarded-For", "10.0.0.2, 10.0.0.1")
+
+	scenarios := []struct {
+		request  *http.Request
+		limiter  *limiter.Limiter
+		expected net.IP
+		source   limiter.IPSource
+	}{
+		{
+			//
+			// Scenario #1 : RemoteAddr without proxy.
+			//
+			request:  request1,
+			limiter:  limiter1,
+			expected: net.ParseIP("8.8.8.8"),
+			source:   limiter.SourceRemoteAddr,
+		},
+		{
+			//
+			// Scenario #2 : X-Forwarded-For with proxy.
+			//
+			request:  request1,
+			limiter:  limiter2,
+			expected: net.ParseIP("9.9.9.9"),
+			source:   limiter.SourceXFF,
+		},
+		{
+			//
+			// Scenario #3 : X-Real-IP with proxy.
+			//
+			request:  request2,
+			limiter:  limiter2,
+			expected: net.ParseIP("6.6.6.6"),
+			source:   limiter.SourceXRealIP,
+		},
+		{
+			//
+			// Scenario #4 : Custom header.
+			//
+			request:  request1,
+			limiter:  limiter3,
+			expected: net.ParseIP("7.7.7.7"),
+			source:   limiter.SourceCustomHeader,
+		},
+		{
+			//
+			// Scenario #5 : X-Forwarded-For with only trusted proxies.
+			//
+			request:  request3,
+			limiter:  limiter4,
+			expected: net.ParseIP("8.8.8.8"),
+			source:   limiter.SourceRemoteAddr,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		ip, source := scenario.limiter.GetIPSource(scenario.request)
+		is.True(scenario.expected.Equal(ip), message)
+		is.Equal(scenario.source, source, message)
+		is.True(scenario.expected.Equal(scenario.limiter.GetIP(scenario.request)), message)
+	}
+
+	is.Equal("X-Forwarded-For", limiter.SourceXFF.String())
+}
+
+func TestGetIPWithTrueClientIP(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New(limiter.WithTrustForwardHeader(true), limiter.WithTrustTrueClientIP(true))
+	limiter2 := New(limiter.WithTrustForwardHeader(true))
+	limiter3 := New(limiter.WithTrustTrueClientIP(true))
+	limiter4 := New(
+		limiter.WithTrustForwardHeader(true),
+		limiter.WithTrustTrueClientIP(true),
+		limiter.WithClientIPHeader("CF-Connecting-IP"),
+	)
+
+	newRequest := func(headers map[string]string) *http.Request {
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		for name, value := range headers {
+			request.Header.Add(name, value)
+		}
+		return request
+	}
+
+	request1 := newRequest(map[string]string{
+		"True-Client-IP":   "9.9.9.9",
+		"Forwarded":        "for=5.5.5.5",
+		"X-Forwarded-For":  "6.6.6.6",
+		"X-Real-IP":        "4.4.4.4",
+		"CF-Connecting-IP": "7.7.7.7",
+	})
+	request2 := newRequest(map[string]string{
+		"True-Client-IP":  "unknown",
+		"X-Forwarded-For": "6.6.6.6",
+	})
+
+	scenarios := []struct {
+		request  *http.Request
+		limiter  *limiter.Limiter
+		expected net.IP
+		source   limiter.IPSource
+	}{
+		{
+			//
+			// Scenario #1 : True-Client-IP is used before Forwarded, X-Forwarded-For and X-Real-IP.
+			//
+			request:  request1,
+			limiter:  limiter1,
+			expected: net.ParseIP("9.9.9.9"),
+			source:   limiter.SourceTrueClientIP,
+		},
+		{
+			//
+			// Scenario #2 : Malformed True-Client-IP falls through.
+			//
+			request:  request2,
+			limiter:  limiter1,
+			expected: net.ParseIP("6.6.6.6"),
+			source:   limiter.SourceXFF,
+		},
+		{
+			//
+			// Scenario #3 : True-Client-IP isn't trusted by default.
+			//
+			request:  request1,
+			limiter:  limiter2,
+			expected: net.ParseIP("5.5.5.5"),
+			source:   limiter.SourceForwarded,
+		},
+		{
+			//
+			// Scenario #4 : True-Client-IP requires TrustForwardHeader.
+			//
+			request:  request1,
+			limiter:  limiter3,
+			expected: net.ParseIP("8.8.8.8"),
+			source:   limiter.SourceRemoteAddr,
+		},
+		{
+			//
+			// Scenario #5 : Custom header is used before True-Client-IP.
+			//
+			request:  request1,
+			limiter:  limiter4,
+			expected: net.ParseIP("7.7.7.7"),
+			source:   limiter.SourceCustomHeader,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		ip, source := scenario.limiter.GetIPSource(scenario.request)
+		is.True(scenario.expected.Equal(ip), message)
+		is.Equal(scenario.source, source, message)
+	}
+
+	is.Equal("True-Client-IP", limiter.SourceTrueClientIP.String())
+}
+
+func TestGetIPWithMultiValueXRealIP(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New(limiter.WithTrustForwardHeader(true))
+
+	scenarios := []struct {
+		header   string
+		expected net.IP
+		source   limiter.IPSource
+	}{
+		{
+			//
+			// Scenario #1 : Single value.
+			//
+			header:   "6.6.6.6",
+			expected: net.ParseIP("6.6.6.6"),
+			source:   limiter.SourceXRealIP,
+		},
+		{
+			//
+			// Scenario #2 : Space-separated values, the first one is used.
+			//
+			header:   "6.6.6.6 7.7.7.7",
+			expected: net.ParseIP("6.6.6.6"),
+			source:   limiter.SourceXRealIP,
+		},
+		{
+			//
+			// Scenario #3 : Comma-separated values, the first one is used.
+			//
+			header:   "6.6.6.6,7.7.7.7, 9.9.9.9",
+			expected: net.ParseIP("6.6.6.6"),
+			source:   limiter.SourceXRealIP,
+		},
+		{
+			//
+			// Scenario #4 : Unparseable entries are skipped.
+			//
+			header:   "unknown, 2001:db8::1 7.7.7.7",
+			expected: net.ParseIP("2001:db8::1"),
+			source:   limiter.SourceXRealIP,
+		},
+		{
+			//
+			// Scenario #5 : No parseable entry, fallback on RemoteAddr.
+			//
+			header:   "unknown, ,",
+			expected: net.ParseIP("8.8.8.8"),
+			source:   limiter.SourceRemoteAddr,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		request.Header.Set("X-Real-IP", scenario.header)
+
+		ip, source := limiter1.GetIPSource(request)
+		is.True(scenario.expected.Equal(ip), message)
+		is.Equal(scenario.source, source, message)
+	}
+}
+
+func TestGetJWTSubWithAuthorizationHeader(t *testing.T) {
+	is := require.New(t)
+
+	secret := "javad"
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "mohammad"}).
+		SignedString([]byte(secret))
+	is.NoError(err)
+
+	limiter1 := New(limiter.WithJWTSecret(secret))
+
+	scenarios := []struct {
+		header string
+		valid  bool
+	}{
+		{
+			//
+			// Scenario #1 : Bearer token.
+			//
+			header: "Bearer " + token,
+			valid:  true,
+		},
+		{
+			//
+			// Scenario #2 : Bearer token with multiple spaces.
+			//
+			header: "Bearer   " + token,
+			valid:  true,
+		},
+		{
+			//
+			// Scenario #3 : Bearer token with another case and tab.
+			//
+			header: "bEaReR\t" + token,
+			valid:  true,
+		},
+		{
+			//
+			// Scenario #4 : Header shorter than scheme.
+			//
+			header: "Bear",
+			valid:  false,
+		},
+		{
+			//
+			// Scenario #5 : Scheme without token.
+			//
+			header: "Bearer ",
+			valid:  false,
+		},
+		{
+			//
+			// Scenario #6 : Another scheme.
+			//
+			header: "Basic " + token,
+			valid:  false,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		request.Header.Add("Authorization", scenario.header)
+
+		is.NotPanics(func() {
+			sub, err := limiter1.GetJWTSub(request)
+			if scenario.valid {
+				is.NoError(err, message)
+				is.Equal(fmt.Sprint([]byte("mohammad")), sub, message)
+			} else {
+				is.Equal(limiter.ErrJWTMissing, err, message)
+			}
+		}, message)
+	}
+}
+
+func TestGetJWTSubWithAuthScheme(t *testing.T) {
+	is := require.New(t)
+
+	secret := "javad"
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "mohammad"}).
+		SignedString([]byte(secret))
+	is.NoError(err)
+
+	limiter1 := New(limiter.WithJWTSecret(secret), limiter.WithAuthScheme("Token"))
+	limiter2 := New(limiter.WithJWTSecret(secret), limiter.WithAuthScheme("JWT"))
+
+	scenarios := []struct {
+		limiter *limiter.Limiter
+		header  string
+		valid   bool
+	}{
+		{
+			//
+			// Scenario #1 : Token scheme.
+			//
+			limiter: limiter1,
+			header:  "Token " + token,
+			valid:   true,
+		},
+		{
+			//
+			// Scenario #2 : Token scheme with another case.
+			//
+			limiter: limiter1,
+			header:  "token " + token,
+			valid:   true,
+		},
+		{
+			//
+			// Scenario #3 : JWT scheme.
+			//
+			limiter: limiter2,
+			header:  "JWT " + token,
+			valid:   true,
+		},
+		{
+			//
+			// Scenario #4 : Mismatched scheme.
+			//
+			limiter: limiter1,
+			header:  "JWT " + token,
+			valid:   false,
+		},
+		{
+			//
+			// Scenario #5 : Default scheme, when another one is configured.
+			//
+			limiter: limiter2,
+			header:  "Bearer " + token,
+			valid:   false,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		request.Header.Add("Authorization", scenario.header)
+
+		sub, err := scenario.limiter.GetJWTSub(request)
+		if scenario.valid {
+			is.NoError(err, message)
+			is.Equal(fmt.Sprint([]byte("mohammad")), sub, message)
+		} else {
+			is.Equal(limiter.ErrJWTMissing, err, message)
+		}
+	}
+
+	// The default scheme is used without options.
+	request := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request.Header.Add("Authorization", "Bearer "+token)
+
+	sub, err := limiter.GetJWTSub(request, secret)
+	is.NoError(err)
+	is.Equal(fmt.Sprint([]byte("mohammad")), sub)
+}
+
+func TestGetJWTSubWithCookieAndQueryParam(t *testing.T) {
+	is := require.New(t)
+
+	secret := "javad"
+	token1, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "header"}).
+		SignedString([]byte(secret))
+	is.NoError(err)
+	token2, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "cookie"}).
+		SignedString([]byte(secret))
+	is.NoError(err)
+	token3, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "query"}).
+		SignedString([]byte(secret))
+	is.NoError(err)
+
+	limiter1 := New(limiter.WithJWTSecret(secret), limiter.WithJWTCookieName("jwt"), limiter.WithJWTQueryParam("token"))
+	limiter2 := New(limiter.WithJWTSecret(secret))
+
+	scenarios := []struct {
+		limiter  *limiter.Limiter
+		header   string
+		cookie   string
+		query    string
+		expected string
+	}{
+		{
+			//
+			// Scenario #1 : Authorization header.
+			//
+			limiter:  limiter1,
+			header:   "Bearer " + token1,
+			expected: "header",
+		},
+		{
+			//
+			// Scenario #2 : Cookie.
+			//
+			limiter:  limiter1,
+			cookie:   token2,
+			expected: "cookie",
+		},
+		{
+			//
+			// Scenario #3 : Query parameter.
+			//
+			limiter:  limiter1,
+			query:    token3,
+			expected: "query",
+		},
+		{
+			//
+			// Scenario #4 : Authorization header before cookie and query parameter.
+			//
+			limiter:  limiter1,
+			header:   "Bearer " + token1,
+			cookie:   token2,
+			query:    token3,
+			expected: "header",
+		},
+		{
+			//
+			// Scenario #5 : Cookie before query parameter.
+			//
+			limiter:  limiter1,
+			cookie:   token2,
+			query:    token3,
+			expected: "cookie",
+		},
+		{
+			//
+			// Scenario #6 : Cookie after a mismatched Authorization header.
+			//
+			limiter:  limiter1,
+			header:   "Basic " + token1,
+			cookie:   token2,
+			expected: "cookie",
+		},
+		{
+			//
+			// Scenario #7 : No source yields a token.
+			//
+			limiter: limiter1,
+		},
+		{
+			//
+			// Scenario #8 : Sources not configured.
+			//
+			limiter: limiter2,
+			cookie:  token2,
+			query:   token3,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/", RawQuery: url.Values{"token": {scenario.query}}.Encode()},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		if scenario.header != "" {
+			request.Header.Add("Authorization", scenario.header)
+		}
+		if scenario.cookie != "" {
+			request.AddCookie(&http.Cookie{Name: "jwt", Value: scenario.cookie})
+		}
+
+		sub, err := scenario.limiter.GetJWTClaim(request, "sub")
+		if scenario.expected != "" {
+			is.NoError(err, message)
+			is.Equal(scenario.expected, sub, message)
+		} else {
+			is.Equal(limiter.ErrJWTMissing, err, message)
+		}
+	}
+}
+
+func TestGetJWTSubWithLeeway(t *testing.T) {
+	is := require.New(t)
+
+	secret := "javad"
+	limiter1 := New(limiter.WithJWTSecret(secret))
+	limiter2 := New(limiter.WithJWTSecret(secret), limiter.WithJWTLeeway(10*time.Second))
+
+	now := time.Now()
+
+	scenarios := []struct {
+		claims  jwt.MapClaims
+		limiter *limiter.Limiter
+		valid   bool
+	}{
+		{
+			//
+			// Scenario #1 : Expired token without leeway.
+			//
+			claims:  jwt.MapClaims{"sub": "mohammad", "exp": now.Add(-5 * time.Second).Unix()},
+			limiter: limiter1,
+			valid:   false,
+		},
+		{
+			//
+			// Scenario #2 : Expired token within leeway.
+			//
+			claims:  jwt.MapClaims{"sub": "mohammad", "exp": now.Add(-5 * time.Second).Unix()},
+			limiter: limiter2,
+			valid:   true,
+		},
+		{
+			//
+			// Scenario #3 : Expired token outside leeway.
+			//
+			claims:  jwt.MapClaims{"sub": "mohammad", "exp": now.Add(-20 * time.Second).Unix()},
+			limiter: limiter2,
+			valid:   false,
+		},
+		{
+			//
+			// Scenario #4 : Not yet valid token without leeway.
+			//
+			claims:  jwt.MapClaims{"sub": "mohammad", "nbf": now.Add(5 * time.Second).Unix()},
+			limiter: limiter1,
+			valid:   false,
+		},
+		{
+			//
+			// Scenario #5 : Not yet valid token within leeway.
+			//
+			claims:  jwt.MapClaims{"sub": "mohammad", "nbf": now.Add(5 * time.Second).Unix()},
+			limiter: limiter2,
+			valid:   true,
+		},
+		{
+			//
+			// Scenario #6 : Not yet valid token outside leeway.
+			//
+			claims:  jwt.MapClaims{"sub": "mohammad", "nbf": now.Add(20 * time.Second).Unix()},
+			limiter: limiter2,
+			valid:   false,
+		},
+		{
+			//
+			// Scenario #7 : Valid token without leeway.
+			//
+			claims:  jwt.MapClaims{"sub": "mohammad", "exp": now.Add(time.Minute).Unix()},
+			limiter: limiter1,
+			valid:   true,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, scenario.claims).SignedString([]byte(secret))
+		is.NoError(err, message)
+
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		request.Header.Add("Authorization", "Bearer "+token)
+
+		sub, err := scenario.limiter.GetJWTSub(request)
+		if scenario.valid {
+			is.NoError(err, message)
+			is.Equal(fmt.Sprint([]byte("mohammad")), sub, message)
+		} else {
+			is.True(errors.Is(err, limiter.ErrInvalidJWT), message)
+		}
+	}
+}
+
+func TestGetJWTSubErrors(t *testing.T) {
+	is := require.New(t)
+
+	secret := "javad"
+	instance := New(limiter.WithJWTSecret(secret))
+
+	now := time.Now()
+	sign := func(method jwt.SigningMethod, claims jwt.MapClaims, secret string) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString([]byte(secret))
+		is.NoError(err)
+		return "Bearer " + token
+	}
+
+	scenarios := []struct {
+		header string
+		err    error
+	}{
+		{
+			//
+			// Scenario #1 : Missing token.
+			//
+			header: "",
+			err:    limiter.ErrJWTMissing,
+		},
+		{
+			//
+			// Scenario #2 : Garbage token.
+			//
+			header: "Bearer foo.bar",
+			err:    limiter.ErrJWTMalformed,
+		},
+		{
+			//
+			// Scenario #3 : Token signed with another secret.
+			//
+			header: sign(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "mohammad"}, "foo"),
+			err:    limiter.ErrJWTSignatureInvalid,
+		},
+		{
+			//
+			// Scenario #4 : Expired token.
+			//
+			header: sign(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "mohammad", "exp": now.Add(-time.Minute).Unix()},
+				secret),
+			err: limiter.ErrJWTExpired,
+		},
+		{
+			//
+			// Scenario #5 : Not yet valid token.
+			//
+			header: sign(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "mohammad", "nbf": now.Add(time.Minute).Unix()},
+				secret),
+			err: limiter.ErrInvalidJWT,
+		},
+		{
+			//
+			// Scenario #6 : Token signed with a disallowed algorithm.
+			//
+			header: sign(jwt.SigningMethodHS512, jwt.MapClaims{"sub": "mohammad"}, secret),
+			err:    limiter.ErrInvalidJWT,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		if scenario.header != "" {
+			request.Header.Add("Authorization", scenario.header)
+		}
+
+		_, err := instance.GetJWTSub(request)
+		is.Equal(scenario.err, err, message)
+		is.True(errors.Is(err, limiter.ErrInvalidJWT), message)
+	}
+
+	// A JWTKeyFunc error, such as for an unknown "kid", is reported as an invalid JWT too.
+	errUnknownKey := errors.New("unknown kid")
+	instance = New(limiter.WithJWTKeyFunc(func(token *jwt.Token) (interface{}, error) {
+		return nil, errUnknownKey
+	}))
+
+	request := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request.Header.Add("Authorization", sign(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "mohammad"}, secret))
+
+	_, err := instance.GetJWTSub(request)
+	is.True(errors.Is(err, limiter.ErrInvalidJWT))
+	is.Contains(err.Error(), errUnknownKey.Error())
+}
+
+func TestGetIPFromContext(t *testing.T) {
+	is := require.New(t)
+
+	limiter1 := New(limiter.WithTrustForwardHeader(true), limiter.WithIPv4Mask(net.CIDRMask(24, 32)))
+	limiter2 := New(limiter.WithTrustForwardHeader(false))
+
+	request := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request.Header.Add("X-Forwarded-For", "9.9.9.9, 7.7.7.7")
+
+	ip, ok := limiter.GetIPFromContext(request.Context())
+	is.False(ok)
+	is.Nil(ip)
+
+	request = limiter1.WithIPContext(request)
+
+	ip, ok = limiter.GetIPFromContext(request.Context())
+	is.True(ok)
+	is.True(net.ParseIP("9.9.9.9").Equal(ip))
+
+	// Stashed IP is reused by the limiter that produced it, even if headers have changed...
+	request.Header.Set("X-Forwarded-For", "6.6.6.6")
+	is.True(net.ParseIP("9.9.9.9").Equal(limiter1.GetIP(request)))
+	is.Equal("9.9.9.0", limiter1.GetIPKey(request))
+	is.Equal(request, limiter1.WithIPContext(request))
+
+	// ...but not by another limiter using other options.
+	is.True(net.ParseIP("8.8.8.8").Equal(limiter2.GetIP(request)))
+	is.Equal("8.8.8.8", limiter2.GetIPKey(request))
+}
+
+func TestGetIPFromForwardedHeader(t *testing.T) {
+	is := require.New(t)
+
+	_, network1, err := net.ParseCIDR("203.0.113.0/24")
+	is.NoError(err)
+
+	limiter1 := New(limiter.WithTrustForwardHeader(true))
+	limiter2 := New(limiter.WithTrustForwardHeader(true), limiter.WithTrustRFC7239(false))
+	limiter3 := New(limiter.WithTrustForwardHeader(true), limiter.WithTrustedProxies(*network1))
+	limiter4 := New(limiter.WithTrustForwardHeader(false))
+
+	scenarios := []struct {
+		forwarded string
+		limiter   *limiter.Limiter
+		expected  net.IP
+		source    limiter.IPSource
+	}{
+		{
+			//
+			// Scenario #1 : IPv4 "for" directive.
+			//
+			forwarded: "for=192.0.2.60;proto=http;by=203.0.113.43",
+			limiter:   limiter1,
+			expected:  net.ParseIP("192.0.2.60"),
+			source:    limiter.SourceForwarded,
+		},
+		{
+			//
+			// Scenario #2 : Quoted IPv6 "for" directive with port.
+			//
+			forwarded: `For="[2001:db8:cafe::17]:4711"`,
+			limiter:   limiter1,
+			expected:  net.ParseIP("2001:db8:cafe::17"),
+			source:    limiter.SourceForwarded,
+		},
+		{
+			//
+			// Scenario #3 : Quoted IPv6 "for" directive without port.
+			//
+			forwarded: `for="[2001:db8:cafe::17]", for=192.0.2.60`,
+			limiter:   limiter1,
+			expected:  net.ParseIP("2001:db8:cafe::17"),
+			source:    limiter.SourceForwarded,
+		},
+		{
+			//
+			// Scenario #4 : Obfuscated identifier is skipped.
+			//
+			forwarded: "for=_hidden, for=192.0.2.60",
+			limiter:   limiter1,
+			expected:  net.ParseIP("192.0.2.60"),
+			source:    limiter.SourceForwarded,
+		},
+		{
+			//
+			// Scenario #5 : No "for" directive, fallback on X-Forwarded-For.
+			//
+			forwarded: "proto=https;by=203.0.113.43",
+			limiter:   limiter1,
+			expected:  net.ParseIP("9.9.9.9"),
+			source:    limiter.SourceXFF,
+		},
+		{
+			//
+			// Scenario #6 : Forwarded header disabled.
+			//
+			forwarded: "for=192.0.2.60",
+			limiter:   limiter2,
+			expected:  net.ParseIP("9.9.9.9"),
+			source:    limiter.SourceXFF,
+		},
+		{
+			//
+			// Scenario #7 : Forwarded header with trusted proxies.
+			//
+			forwarded: "for=192.0.2.60, for=198.51.100.17, for=203.0.113.43",
+			limiter:   limiter3,
+			expected:  net.ParseIP("198.51.100.17"),
+			source:    limiter.SourceForwarded,
+		},
+		{
+			//
+			// Scenario #8 : Forwarded header without proxy.
+			//
+			forwarded: "for=192.0.2.60",
+			limiter:   limiter4,
+			expected:  net.ParseIP("8.8.8.8"),
+			source:    limiter.SourceRemoteAddr,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: "8.8.8.8:8888",
+		}
+		request.Header.Add("Forwarded", scenario.forwarded)
+		request.Header.Add("X-Forwarded-For", "9.9.9.9")
+
+		ip, source := scenario.limiter.GetIPSource(request)
+		is.True(scenario.expected.Equal(ip), message)
+		is.Equal(scenario.source, source, message)
+	}
+}
+
+func TestIsAllowlisted(t *testing.T) {
+	is := require.New(t)
+
+	allowlist := []net.IPNet{}
+	for _, cidr := range []string{"10.0.0.0/8", "8.8.8.8/32", "2001:db8:cafe::/48", "2001:db8:beef::1/128"} {
+		_, network, err := net.ParseCIDR(cidr)
+		is.NoError(err)
+		allowlist = append(allowlist, *network)
+	}
+
+	limiter1 := New(limiter.WithAllowlist(allowlist...), limiter.WithIPv4Mask(net.CIDRMask(24, 32)))
+	limiter2 := New()
+
+	scenarios := []struct {
+		remoteAddr string
+		expected   bool
+	}{
+		{
+			//
+			// Scenario #1 : IPv4 in allowlisted range.
+			//
+			remoteAddr: "10.1.2.3:8888",
+			expected:   true,
+		},
+		{
+			//
+			// Scenario #2 : IPv4 in allowlisted /32.
+			//
+			remoteAddr: "8.8.8.8:8888",
+			expected:   true,
+		},
+		{
+			//
+			// Scenario #3 : IPv4 in the same masked network than an allowlisted /32.
+			//
+			remoteAddr: "8.8.8.9:8888",
+			expected:   false,
+		},
+		{
+			//
+			// Scenario #4 : IPv6 in allowlisted range.
+			//
+			remoteAddr: "[2001:db8:cafe:1234:beef::fafa]:8888",
+			expected:   true,
+		},
+		{
+			//
+			// Scenario #5 : IPv6 in allowlisted /128.
+			//
+			remoteAddr: "[2001:db8:beef::1]:8888",
+			expected:   true,
+		},
+		{
+			//
+			// Scenario #6 : IPv6 outside allowlisted /128.
+			//
+			remoteAddr: "[2001:db8:beef::2]:8888",
+			expected:   false,
+		},
+		{
+			//
+			// Scenario #7 : Malformed address.
+			//
+			remoteAddr: "invalid",
+			expected:   false,
+		},
+	}
+
+	for i, scenario := range scenarios {
+		message := fmt.Sprintf("Scenario #%d", (i + 1))
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: scenario.remoteAddr,
+		}
+
+		is.Equal(scenario.expected, limiter1.IsAllowlisted(request), message)
+		is.False(limiter2.IsAllowlisted(request), message)
+	}
+}
+
+func TestIsDenylisted(t *testing.T) {
+	is := require.New(t)
+
+	_, network1, err := net.ParseCIDR("192.0.2.0/24")
+	is.NoError(err)
+	_, network2, err := net.ParseCIDR("2001:db8:bad::/48")
+	is.NoError(err)
+
+	limiter1 := New(limiter.WithDenylist(*network1, *network2))
+	limiter2 := New(limiter.WithDenylist(*network1, *network2), limiter.WithTrustForwardHeader(true))
+
+	request1 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "192.0.2.1:8888",
+	}
+
+	request2 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "[2001:db8:bad::1]:8888",
+	}
+
+	request3 := &http.Request{
+		URL:        &url.URL{Path: "/"},
+		Header:     http.Header{},
+		RemoteAddr: "8.8.8.8:8888",
+	}
+	request3.Header.Add("X-Forwarded-For", "192.0.2.7")
+
+	is.True(limiter1.IsDenylisted(request1))
+	is.True(limiter1.IsDenylisted(request2))
+	is.False(limiter1.IsDenylisted(request3))
+	is.True(limiter2.IsDenylisted(request3))
+	is.False(New().IsDenylisted(request1))
+}
+
+type fakeASNResolver map[string]uint32
+
+func (resolver fakeASNResolver) Lookup(ip net.IP) (uint32, bool) {
+	asn, ok := resolver[ip.String()]
+	return asn, ok
+}
+
+func TestGetIPKeyWithASNResolver(t *testing.T) {
+	is := require.New(t)
+
+	resolver := fakeASNResolver{
+		"8.8.8.8":     15169,
+		"8.8.4.4":     15169,
+		"2001:db8::1": 64496,
+	}
+
+	limiter1 := New(limiter.WithASNResolver(resolver))
+	limiter2 := New(limiter.WithASNResolver(resolver), limiter.WithIPv4Mask(net.CIDRMask(24, 32)))
+
+	scenarios := []struct {
+		remoteAddr string
+		expected1  string
+		expected2  string
+	}{
+		{
+			// Scenario #1 : IPv4 in an autonomous system.
+			remoteAddr: "8.8.8.8:8888",
+			expected1:  "asn:15169",
+			expected2:  "asn:15169",
+		},
+		{
+			// Scenario #2 : another IPv4 in the same autonomous system.
+			remoteAddr: "8.8.4.4:8888",
+			expected1:  "asn:15169",
+			expected2:  "asn:15169",
+		},
+		{
+			// Scenario #3 : IPv6 in an autonomous system.
+			remoteAddr: "[2001:db8::1]:8888",
+			expected1:  "asn:64496",
+			expected2:  "asn:64496",
+		},
+		{
+			// Scenario #4 : lookup failure, fallback on masked IP.
+			remoteAddr: "178.1.2.3:8888",
+			expected1:  "178.1.2.3",
+			expected2:  "178.1.2.0",
+		},
+	}
+
+	for i, scenario := range scenarios {
+		request := &http.Request{
+			URL:        &url.URL{Path: "/"},
+			Header:     http.Header{},
+			RemoteAddr: scenario.remoteAddr,
+		}
+
+		is.Equal(scenario.expected1, limiter1.GetIPKey(request), "scenario #%d", i+1)
+		is.Equal(scenario.expected2, limiter2.GetIPKey(request), "scenario #%d", i+1)
+		is.Equal(scenario.expected1, limiter1.GetKey(request), "scenario #%d", i+1)
+	}
+}
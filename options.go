@@ -1,7 +1,12 @@
 package limiter
 
 import (
+	"crypto"
 	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt"
 )
 
 // Option is a functional option.
@@ -9,22 +14,266 @@ type Option func(*Options)
 
 // Options are limiter options.
 type Options struct {
-	// IPv4Mask defines the mask used to obtain a IPv4 address.
+	// IPv4Mask defines the mask used to obtain a IPv4 address. Default is DefaultIPv4Mask, even if omitted.
 	IPv4Mask net.IPMask
-	// IPv6Mask defines the mask used to obtain a IPv6 address.
+	// IPv6Mask defines the mask used to obtain a IPv6 address. Default is DefaultIPv6Mask, even if omitted.
 	IPv6Mask net.IPMask
 	// TrustForwardHeader enable parsing of X-Real-IP and X-Forwarded-For headers to obtain user IP.
 	// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
 	// proxy is not configured properly to forward a trustworthy client IP.
 	// Please read the section "Limiter behind a reverse proxy" in the README for further information.
 	TrustForwardHeader bool
+	// TrustRFC7239 enable parsing of Forwarded header (RFC 7239) to obtain user IP, if "TrustForwardHeader" is enabled.
+	// If enabled, Forwarded header is used before X-Forwarded-For and X-Real-IP headers.
+	// Default is true: please disable it if your reverse proxy doesn't set (nor remove) it.
+	TrustRFC7239 bool
+	// TrustTrueClientIP enable parsing of True-Client-IP header (ie: set by Akamai or Cloudflare Enterprise) to obtain
+	// user IP, if "TrustForwardHeader" is enabled. If enabled, it's used before Forwarded, X-Forwarded-For and
+	// X-Real-IP headers. Default is false: only enable it if your CDN always sets (or overrides) it.
+	TrustTrueClientIP bool
+	// TrustedProxies defines networks of proxies (ie: your load balancers) that can be trusted
+	// while parsing X-Forwarded-For header, if "TrustForwardHeader" is enabled.
+	// If configured, the chain is walked from right to left, skipping trusted proxies,
+	// and the first untrusted IP is used as user IP.
+	TrustedProxies []net.IPNet
+	// ForwardedHops defines the position, from the end, of the user IP in the X-Forwarded-For chain,
+	// if "TrustForwardHeader" is enabled: for example, 3 if your edge always appends exactly two proxies.
+	// If configured, it takes precedence over "TrustedProxies" for this header, and a shorter chain (or an
+	// unparseable entry) fallbacks on RemoteAddr. It's the most robust approach against spoofing for a fixed topology.
+	ForwardedHops int
+	// RemoteAddrFunc defines a function to obtain the network address of the client, instead of the request
+	// RemoteAddr, such as the source address parsed by a PROXY protocol aware listener and stored in the request
+	// context. It's used wherever RemoteAddr would be, including as a fallback of forwarded headers.
+	RemoteAddrFunc func(r *http.Request) string
 	// ClientIPHeader defines a custom header (likely defined by your CDN or Cloud provider) to obtain user IP.
 	// If configured, this option will override "TrustForwardHeader" option.
 	// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
 	// proxy is not configured properly to forward a trustworthy client IP.
 	// Please read the section "Limiter behind a reverse proxy" in the README for further information.
 	ClientIPHeader string
-	JWTSecret      string
+	// ClientIPHeaders defines an ordered list of custom headers to obtain user IP.
+	// Each header is tried in turn, after "ClientIPHeader", and the first parseable IP is used.
+	// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
+	// proxy is not configured properly to forward a trustworthy client IP.
+	// Please read the section "Limiter behind a reverse proxy" in the README for further information.
+	ClientIPHeaders []string
+	// OnHeaderIgnored defines a debug function called when a custom header (see ClientIPHeader and ClientIPHeaders),
+	// the True-Client-IP header or the X-Real-IP header is present but its value isn't a valid IP (ie: "unknown"), with the header name and
+	// its raw value. The next candidate is then tried as usual: it can be used to detect a misconfigured proxy.
+	OnHeaderIgnored func(header, rawValue string)
+	// Allowlist defines networks (ie: your health-checkers or monitoring) that bypass limiting entirely.
+	// Requests whose user IP is contained in one of these networks are allowed without touching the store.
+	Allowlist []net.IPNet
+	// Denylist defines networks (ie: known abusers) that are always rejected, without touching the store.
+	// Allowlist is evaluated first: an IP contained in both lists is allowed.
+	// Please be advised that the user IP could be spoofed if "TrustForwardHeader" or "ClientIPHeader" are enabled
+	// and your reverse proxy is not configured properly: an attacker could then evade the denylist, or forge an
+	// allowlisted IP.
+	Denylist []net.IPNet
+	// StoreMode defines the algorithm used to limit requests. Default is StoreModeFixed.
+	StoreMode StoreMode
+	// Burst defines the maximum number of tokens of a bucket, when using StoreModeTokenBucket.
+	// Default is the rate limit.
+	Burst int64
+	// WarmupRequests defines the requests allowed in addition to the limit in the first window of a new key, so
+	// brand-new clients aren't penalized, such as while loading an application. The allowance decays by WarmupFactor
+	// for each subsequent window, until the limit settles to the base rate. The creation of a key is recorded in the
+	// store, costing a store operation per request: a key unseen for twice the ramp is new again. Peeks apply the
+	// allowance without recording the creation of a key.
+	// Default is zero, meaning disabled.
+	WarmupRequests int
+	// WarmupFactor defines the fraction of the warm-up allowance kept for each window after the first one of a key,
+	// within [0, 1), such as 0.5 to halve it. Default is zero, meaning only the first window is elevated.
+	WarmupFactor float64
+	// ResetJitter defines the maximum duration added to the period of each key, when using StoreModeFixed,
+	// so that keys created at the same time don't all reset at the same instant (ie: a thundering herd of
+	// clients retrying at once). The added duration is derived from a hash of the key, within [0, ResetJitter):
+	// it's the same on every request of a key. Default is zero, meaning disabled.
+	ResetJitter time.Duration
+	// ByteQuota defines if requests are charged their body size by GetWithRequest, such as in middlewares, instead
+	// of one: the rate is then a quota of bytes per period (ie: "10485760-H" for 10 MB of uploads per hour).
+	// The size is the request ContentLength, or UnknownContentLengthCost if it's unknown (ie: a chunked request).
+	// A request is charged at least one byte, so it's rejected once the quota is exhausted.
+	ByteQuota bool
+	// UnknownContentLengthCost defines the size charged with ByteQuota for a request whose ContentLength is unknown.
+	UnknownContentLengthCost int64
+	// FailOpen defines if requests are allowed when the store fails. Default is true.
+	// Failing open keeps your service available when the store is down, but disables rate limiting meanwhile:
+	// an attacker able to make the store fail, or to wait for an outage, could then flood your service.
+	// Failing closed protects your service, but denies every request until the store is back.
+	FailOpen bool
+	// CoalescePeeks defines if concurrent peeks of the same identifier and rate are coalesced into a single store
+	// operation (ie: a single redis round trip), whose result is shared by every caller, such as under a burst of
+	// CheckWithRequest. Only reads are coalesced: coalescing increments would count a single request for many.
+	// The store operation isn't canceled with the context of the first caller: each caller stops waiting for it once
+	// its own context is done, and the store timeout applies.
+	CoalescePeeks bool
+	// RateResolver defines a function to obtain the rate of a request, such as a stricter rate for a given route.
+	// If it returns a zero Rate, the RateByClaim rate, the RateByCountry rate or the limiter rate is used.
+	RateResolver func(r *http.Request) Rate
+	// RateAdjuster defines an adjustment of the rate, consulted for every store interaction with the base rate of
+	// the limiter, or of the request (see GetRate), to obtain the effective one. If it returns a zero Rate, the base
+	// rate is used. Adjusted rates share the counters of their base rate, so the limit change applies immediately.
+	// However, a fixed window keeps the period it was created with: frequent changes, especially of the period,
+	// may interact oddly with fixed windows, like a counter above a tightened limit until its window ends.
+	RateAdjuster RateAdjuster
+	// RateClaim defines the JWT claim used to obtain the rate of a request from RateByClaim, such as "plan".
+	// The JWT is validated like for GetJWTClaim.
+	RateClaim string
+	// RateByClaim defines the rates of requests by value of the RateClaim claim, such as a rate per plan.
+	// If the JWT is invalid, or its claim absent or unmapped, the RateByCountry rate or the limiter rate is used.
+	RateByClaim map[string]Rate
+	// CountryResolver defines a lookup of the country of the user IP (see GetIP), used to obtain the rate of a
+	// request from RateByCountry.
+	CountryResolver CountryResolver
+	// RateByCountry defines the rates of requests by country code of the user IP, as returned by CountryResolver,
+	// such as a stricter rate for regions you don't serve. If the country isn't found or unmapped, the limiter rate
+	// is used.
+	RateByCountry map[string]Rate
+	// WebSocketRate defines the rate of WebSocket upgrade handshakes (see IsWebSocketUpgrade), such as a few per
+	// minute by IP, counted separately from other requests of the same key. A handshake is counted once by Handler
+	// and the net/http and Gin middlewares, regardless of CountStatusCodes: the connection isn't counted once
+	// upgraded. Default is a zero Rate, meaning handshakes are limited like other requests.
+	WebSocketRate Rate
+	// BypassFunc defines a function called before any store interaction, such as to recognize a premium API key
+	// or a JWT scope. If it returns true, the request is allowed without being counted.
+	// It's used by Handler and the net/http, Gin and Echo middlewares: bypassed requests are still served through
+	// them, so your own middlewares (ie: logging) see them, but OnDecision and OnLimitReached aren't called.
+	BypassFunc func(r *http.Request) bool
+	// SkipMethods defines the HTTP methods which are not limited by Handler and middlewares, checked before
+	// obtaining the key of the request, so the store is never involved. Default is DefaultSkipMethods ("OPTIONS").
+	SkipMethods []string
+	// SkipPaths defines the request paths which are not limited by Handler, such as health checks.
+	SkipPaths []string
+	// DryRun defines if the limiter only observes requests: counters are incremented and the context Reached flag
+	// is populated, but middlewares never reject requests, nor set rate limit headers.
+	DryRun bool
+	// CountStatusCodes defines the response status codes counted against the limit, such as 401 and 403 to only
+	// count failed logins. When defined, Handler and the net/http and Gin middlewares check the limit before calling
+	// the handler, but only increment it once the response status is known. Default is empty, meaning every request
+	// is counted before calling the handler.
+	// Since the limit is checked and incremented in two steps, concurrent requests may exceed it slightly.
+	CountStatusCodes []int
+	// WarnThreshold defines the consumed fraction of the limit (ie: 0.8) from which middlewares set a
+	// RateLimit-Warning header, to warn clients approaching their limit. Default is zero, meaning disabled.
+	WarnThreshold float64
+	// OnDecision defines a function called once per decision of the limiter, such as Get or Increment,
+	// with the key, if the request is allowed and the limit context. It can be used to collect metrics.
+	// With DryRun, a request is reported as not allowed once its limit is reached, even if it's not rejected.
+	// With CountStatusCodes, a request is decided by CheckWithRequest before calling the handler: it's not
+	// reported again once its response is counted.
+	OnDecision func(key string, allowed bool, context Context)
+	// OnLimitReached defines a function called with the details of each request rejected by GetWithRequest or
+	// CheckWithRequest, such as in middlewares. It's never called for allowed requests, and can be used to log abusers.
+	OnLimitReached func(event LimitEvent)
+	// Clock defines the source of the current time used by windows and expirations. Default is SystemClock.
+	// The store has its own clock (see StoreOptions), which should be the same one.
+	Clock Clock
+	// KeyFunc defines a custom function to obtain the store key from a request, instead of the user IP.
+	// Its returned string is used verbatim as store key: you are responsible of its cardinality.
+	KeyFunc func(r *http.Request) string
+	// KeyIncludeMethod defines if the default key of a request (see GetKey) includes its method after the user IP,
+	// so GET and POST requests are counted separately. It's ignored if KeyFunc is defined.
+	KeyIncludeMethod bool
+	// KeyIncludePath defines if the default key of a request (see GetKey) includes its path after the user IP and
+	// the method, so each path is counted separately. It's ignored if KeyFunc is defined.
+	// Use PathTemplateFunc to include the route template instead, so the key cardinality doesn't explode.
+	KeyIncludePath bool
+	// PathTemplateFunc defines a function to obtain the route template matching the path of a request, such as
+	// "/users/{id}" for "/users/123" from your router, so the default key is per route instead of per path, which
+	// would explode its cardinality. If it returns an empty template, the path is used as is.
+	// It's ignored if KeyFunc is defined: use GetPathTemplate to build your own key.
+	PathTemplateFunc func(r *http.Request) string
+	// Prefix defines a prefix prepended to every identifier of the limiter in the store, such as "myapp:", so
+	// applications sharing a store (ie: a Redis database) don't share their counters for the same identifier.
+	// It's applied after the key is obtained (see KeyFunc), and before the store prefix (see StoreOptions).
+	// Default is empty, meaning identifiers are stored as is.
+	Prefix string
+	// MaxKeyLength defines the maximum length of an identifier stored as is, such as 128. A longer identifier
+	// (ie: a full URL or a User-Agent from KeyFunc) is stored as the first 16 bytes of its SHA-256 hash, hex encoded
+	// like "sha256:9f86d081884c7d659a2feaa0c55ad015", so store keys don't balloon: shorter identifiers stay readable.
+	// With 128 bits kept, the probability of a collision among a billion hashed identifiers is about 1.5e-21.
+	// Default is zero, meaning identifiers are never hashed.
+	MaxKeyLength int
+	// ASNResolver defines a lookup of the autonomous system number of the user IP, used by GetIPKey instead of the
+	// masked IP, to group the IP ranges of a network (ie: a hosting provider) for bot mitigation.
+	ASNResolver ASNResolver
+	JWTSecret   string
+	// JWTPublicKey defines the public key used to verify JWT signed with an asymmetric algorithm, such as a
+	// *rsa.PublicKey for "RS256" (see jwt.ParseRSAPublicKeyFromPEM). The key is selected by the signing algorithm
+	// of the JWT, which must be in JWTAlgorithms: JWTSecret is still used for HMAC algorithms.
+	JWTPublicKey crypto.PublicKey
+	// JWTKeyFunc defines a function to obtain the key used to verify JWT, such as by its "kid" header when rotating
+	// secrets or using a JWKS. If configured, it's used instead of JWTSecret.
+	// The signing algorithm is still verified against JWTAlgorithms before calling it.
+	JWTKeyFunc jwt.Keyfunc
+	// JWTAlgorithms defines the signing algorithms allowed to verify JWT. Default is "HS256".
+	// Any JWT signed with another algorithm (ie: "none") will be rejected.
+	JWTAlgorithms []string
+	// AuthScheme defines the scheme of the Authorization header used to obtain JWT, matched case-insensitively.
+	// Default is "Bearer": for example, use "Token" for an "Authorization: Token <jwt>" header.
+	AuthScheme string
+	// JWTCookieName defines a cookie used to obtain JWT, if the Authorization header doesn't yield a token,
+	// such as for browser clients.
+	JWTCookieName string
+	// JWTQueryParam defines a query parameter used to obtain JWT, if neither the Authorization header nor
+	// the JWTCookieName cookie yield a token.
+	// Please be advised that query parameters are likely logged by your servers and proxies.
+	JWTQueryParam string
+	// JWTLeeway defines a tolerance for clock skew while validating "exp" and "nbf" claims of JWT.
+	// Default is zero, meaning that JWT are rejected the instant they expire.
+	JWTLeeway time.Duration
+}
+
+// Validate returns an error if options are inconsistent: ErrInvalidIPv4Mask if IPv4Mask isn't a canonical 4-byte
+// mask, or ErrInvalidIPv6Mask if IPv6Mask isn't a canonical 16-byte mask, such as when they are swapped.
+// An undefined mask is valid, since the default one is used instead, and so is a 16-byte IPv4 mask of at least
+// 96 bits, since it's applied to the IPv4-mapped form of the address.
+func (o Options) Validate() error {
+	if len(o.IPv4Mask) > 0 && !isValidMask(o.IPv4Mask, net.IPv4len) {
+		ones, bits := o.IPv4Mask.Size()
+		if bits != 8*net.IPv6len || ones < 8*(net.IPv6len-net.IPv4len) {
+			return ErrInvalidIPv4Mask
+		}
+	}
+	if len(o.IPv6Mask) > 0 && !isValidMask(o.IPv6Mask, net.IPv6len) {
+		return ErrInvalidIPv6Mask
+	}
+	return nil
+}
+
+// isValidMask returns if given mask is a canonical mask (ones followed by zeros) of given length in bytes.
+func isValidMask(mask net.IPMask, length int) bool {
+	_, bits := mask.Size()
+	return bits == 8*length
+}
+
+// now returns the current time of the configured clock, or the wall clock otherwise.
+func (o Options) now() time.Time {
+	if o.Clock == nil {
+		return time.Now()
+	}
+	return o.Clock.Now()
+}
+
+// remoteAddr returns the RemoteAddr of given request, using RemoteAddrFunc if defined.
+func (o Options) remoteAddr(r *http.Request) string {
+	if o.RemoteAddrFunc != nil {
+		return o.RemoteAddrFunc(r)
+	}
+	return r.RemoteAddr
+}
+
+// clientIPHeaders returns the ordered list of custom headers used to obtain user IP.
+func (o Options) clientIPHeaders() []string {
+	if o.ClientIPHeader == "" {
+		return o.ClientIPHeaders
+	}
+
+	headers := make([]string, 0, len(o.ClientIPHeaders)+1)
+	headers = append(headers, o.ClientIPHeader)
+	headers = append(headers, o.ClientIPHeaders...)
+	return headers
 }
 
 // WithIPv4Mask will configure the limiter to use given mask for IPv4 address.
@@ -34,6 +283,239 @@ func WithIPv4Mask(mask net.IPMask) Option {
 	}
 }
 
+// WithAllowlist will configure the limiter to allow requests from given networks without limiting them.
+func WithAllowlist(networks ...net.IPNet) Option {
+	return func(o *Options) {
+		o.Allowlist = networks
+	}
+}
+
+// WithDenylist will configure the limiter to always reject requests from given networks.
+// Please be advised that the user IP could be spoofed if your reverse proxy is not configured properly.
+// Please read the section "Limiter behind a reverse proxy" in the README for further information.
+func WithDenylist(networks ...net.IPNet) Option {
+	return func(o *Options) {
+		o.Denylist = networks
+	}
+}
+
+// WithStoreMode will configure the limiter to use given algorithm to limit requests.
+func WithStoreMode(mode StoreMode) Option {
+	return func(o *Options) {
+		o.StoreMode = mode
+	}
+}
+
+// WithBurst will configure the limiter to use given maximum number of tokens per bucket,
+// when using StoreModeTokenBucket.
+func WithBurst(burst int64) Option {
+	return func(o *Options) {
+		o.Burst = burst
+	}
+}
+
+// WithWarmup will configure the limiter to allow given requests in addition to the limit in the first window of
+// a new key, decaying by given factor for each subsequent window.
+func WithWarmup(requests int, factor float64) Option {
+	return func(o *Options) {
+		o.WarmupRequests = requests
+		o.WarmupFactor = factor
+	}
+}
+
+// WithResetJitter will configure the limiter to extend the period of each key by a duration within
+// [0, jitter), derived from the key, when using StoreModeFixed.
+func WithResetJitter(jitter time.Duration) Option {
+	return func(o *Options) {
+		o.ResetJitter = jitter
+	}
+}
+
+// WithByteQuota will configure the limiter to charge requests their body size instead of one, using given cost
+// for requests of unknown size.
+func WithByteQuota(unknownContentLengthCost int64) Option {
+	return func(o *Options) {
+		o.ByteQuota = true
+		o.UnknownContentLengthCost = unknownContentLengthCost
+	}
+}
+
+// WithFailOpen will configure the limiter to allow requests when the store fails if true,
+// or to deny them otherwise.
+func WithFailOpen(failOpen bool) Option {
+	return func(o *Options) {
+		o.FailOpen = failOpen
+	}
+}
+
+// WithCoalescePeeks will configure the limiter to coalesce concurrent peeks of the same identifier if true.
+func WithCoalescePeeks(coalesce bool) Option {
+	return func(o *Options) {
+		o.CoalescePeeks = coalesce
+	}
+}
+
+// WithRateResolver will configure the limiter to use given function to obtain the rate of a request.
+// Requests resolving to different rates don't share their counters, but requests resolving to the same rate do:
+// use WithKeyFunc to include the route in the key if required.
+func WithRateResolver(resolver func(r *http.Request) Rate) Option {
+	return func(o *Options) {
+		o.RateResolver = resolver
+	}
+}
+
+// WithRateAdjuster will configure the limiter to adjust the rate of every request with given adjuster.
+func WithRateAdjuster(adjuster RateAdjuster) Option {
+	return func(o *Options) {
+		o.RateAdjuster = adjuster
+	}
+}
+
+// WithBypassFunc will configure the limiter to allow requests without limiting them if given function returns true.
+func WithBypassFunc(fn func(r *http.Request) bool) Option {
+	return func(o *Options) {
+		o.BypassFunc = fn
+	}
+}
+
+// WithRateByClaim will configure the limiter to obtain the rate of a request from given JWT claim,
+// using given rates by claim value.
+// Like with WithRateResolver, requests resolving to different rates don't share their counters.
+func WithRateByClaim(claim string, rates map[string]Rate) Option {
+	return func(o *Options) {
+		o.RateClaim = claim
+		o.RateByClaim = rates
+	}
+}
+
+// WithRateByCountry will configure the limiter to obtain the rate of a request from the country of its user IP,
+// found with given resolver, using given rates by country code.
+// Like with WithRateResolver, requests resolving to different rates don't share their counters.
+func WithRateByCountry(resolver CountryResolver, rates map[string]Rate) Option {
+	return func(o *Options) {
+		o.CountryResolver = resolver
+		o.RateByCountry = rates
+	}
+}
+
+// WithWebSocketRate will configure the limiter to limit WebSocket upgrade handshakes with given rate, instead of
+// the rate of other requests.
+func WithWebSocketRate(rate Rate) Option {
+	return func(o *Options) {
+		o.WebSocketRate = rate
+	}
+}
+
+// WithSkipMethods will configure Handler and middlewares to not limit requests with given HTTP methods,
+// instead of DefaultSkipMethods. Without methods, every request is limited, including CORS preflight requests.
+func WithSkipMethods(methods ...string) Option {
+	return func(o *Options) {
+		o.SkipMethods = methods
+	}
+}
+
+// WithSkipPaths will configure Handler to not limit requests with given paths.
+func WithSkipPaths(paths ...string) Option {
+	return func(o *Options) {
+		o.SkipPaths = paths
+	}
+}
+
+// WithDryRun will configure the limiter to only observe requests, without rejecting them in middlewares.
+func WithDryRun(dryRun bool) Option {
+	return func(o *Options) {
+		o.DryRun = dryRun
+	}
+}
+
+// WithCountStatusCodes will configure middlewares to only count responses with given status codes against the limit.
+func WithCountStatusCodes(codes ...int) Option {
+	return func(o *Options) {
+		o.CountStatusCodes = codes
+	}
+}
+
+// WithWarnThreshold will configure middlewares to set a RateLimit-Warning header once the consumed fraction of the
+// limit reaches given threshold.
+func WithWarnThreshold(threshold float64) Option {
+	return func(o *Options) {
+		o.WarnThreshold = threshold
+	}
+}
+
+// WithOnDecision will configure the limiter to call given function once per decision.
+func WithOnDecision(handler func(key string, allowed bool, context Context)) Option {
+	return func(o *Options) {
+		o.OnDecision = handler
+	}
+}
+
+// WithOnLimitReached will configure the limiter to call given function for each rejected request.
+func WithOnLimitReached(handler func(event LimitEvent)) Option {
+	return func(o *Options) {
+		o.OnLimitReached = handler
+	}
+}
+
+// WithClock will configure the limiter to use given clock to obtain the current time, such as a fake one in tests.
+func WithClock(clock Clock) Option {
+	return func(o *Options) {
+		o.Clock = clock
+	}
+}
+
+// WithKeyFunc will configure the limiter to use given function to obtain the store key from a request.
+// Its returned string is used verbatim as store key: you are responsible of its cardinality.
+func WithKeyFunc(fn func(r *http.Request) string) Option {
+	return func(o *Options) {
+		o.KeyFunc = fn
+	}
+}
+
+// WithKeyIncludeMethod will configure the limiter to include the request method in the default key of a request.
+func WithKeyIncludeMethod(enable bool) Option {
+	return func(o *Options) {
+		o.KeyIncludeMethod = enable
+	}
+}
+
+// WithKeyIncludePath will configure the limiter to include the request path in the default key of a request.
+func WithKeyIncludePath(enable bool) Option {
+	return func(o *Options) {
+		o.KeyIncludePath = enable
+	}
+}
+
+// WithPathTemplateFunc will configure the limiter to include the route template of the request path, obtained
+// with given function, in the default key of a request.
+func WithPathTemplateFunc(fn func(r *http.Request) string) Option {
+	return func(o *Options) {
+		o.PathTemplateFunc = fn
+	}
+}
+
+// WithPrefix will configure the limiter to prepend given prefix to every identifier in the store, such as "myapp:".
+func WithPrefix(prefix string) Option {
+	return func(o *Options) {
+		o.Prefix = prefix
+	}
+}
+
+// WithMaxKeyLength will configure the limiter to store identifiers longer than given length as their hash.
+func WithMaxKeyLength(length int) Option {
+	return func(o *Options) {
+		o.MaxKeyLength = length
+	}
+}
+
+// WithASNResolver will configure the limiter to use given resolver to obtain the store key from the user IP's
+// autonomous system number.
+func WithASNResolver(resolver ASNResolver) Option {
+	return func(o *Options) {
+		o.ASNResolver = resolver
+	}
+}
+
 // WithJWTSecret will configure the limiter to use given mask with JWT secret.
 func WithJWTSecret(secret string) Option {
 	return func(o *Options) {
@@ -41,6 +523,58 @@ func WithJWTSecret(secret string) Option {
 	}
 }
 
+// WithJWTPublicKey will configure the limiter to use given public key to verify JWT signed with an asymmetric
+// algorithm. Such algorithms must be allowed with WithJWTAlgorithms, ie: "RS256".
+func WithJWTPublicKey(key crypto.PublicKey) Option {
+	return func(o *Options) {
+		o.JWTPublicKey = key
+	}
+}
+
+// WithJWTKeyFunc will configure the limiter to use given function to obtain the key used to verify JWT,
+// instead of the JWT secret.
+func WithJWTKeyFunc(keyFunc jwt.Keyfunc) Option {
+	return func(o *Options) {
+		o.JWTKeyFunc = keyFunc
+	}
+}
+
+// WithJWTAlgorithms will configure the limiter to only allow given signing algorithms to verify JWT.
+func WithJWTAlgorithms(algorithms ...string) Option {
+	return func(o *Options) {
+		o.JWTAlgorithms = algorithms
+	}
+}
+
+// WithAuthScheme will configure the limiter to use given scheme of the Authorization header to obtain JWT.
+func WithAuthScheme(scheme string) Option {
+	return func(o *Options) {
+		o.AuthScheme = scheme
+	}
+}
+
+// WithJWTCookieName will configure the limiter to obtain JWT from given cookie, after the Authorization header.
+func WithJWTCookieName(name string) Option {
+	return func(o *Options) {
+		o.JWTCookieName = name
+	}
+}
+
+// WithJWTQueryParam will configure the limiter to obtain JWT from given query parameter,
+// after the Authorization header and the JWTCookieName cookie.
+func WithJWTQueryParam(name string) Option {
+	return func(o *Options) {
+		o.JWTQueryParam = name
+	}
+}
+
+// WithJWTLeeway will configure the limiter to tolerate given clock skew while validating JWT.
+func WithJWTLeeway(leeway time.Duration) Option {
+	return func(o *Options) {
+		o.JWTLeeway = leeway
+	}
+}
+
 // WithIPv6Mask will configure the limiter to use given mask for IPv6 address.
 func WithIPv6Mask(mask net.IPMask) Option {
 	return func(o *Options) {
@@ -48,6 +582,18 @@ func WithIPv6Mask(mask net.IPMask) Option {
 	}
 }
 
+// WithIPv6PrivacyMask will configure the limiter to use DefaultIPv6MaskPrivacy (/64) for IPv6 address if true,
+// or DefaultIPv6Mask (/128) otherwise. It's recommended, so clients of a given subnet share their limit.
+func WithIPv6PrivacyMask(enable bool) Option {
+	return func(o *Options) {
+		if enable {
+			o.IPv6Mask = DefaultIPv6MaskPrivacy
+		} else {
+			o.IPv6Mask = DefaultIPv6Mask
+		}
+	}
+}
+
 // WithTrustForwardHeader will configure the limiter to trust X-Real-IP and X-Forwarded-For headers.
 // Please be advised that using this option could be insecure (ie: spoofed) if your reverse
 // proxy is not configured properly to forward a trustworthy client IP.
@@ -58,6 +604,47 @@ func WithTrustForwardHeader(enable bool) Option {
 	}
 }
 
+// WithTrustRFC7239 will configure the limiter to trust Forwarded header (RFC 7239), if "TrustForwardHeader" is enabled.
+// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
+// proxy is not configured properly to forward a trustworthy client IP.
+// Please read the section "Limiter behind a reverse proxy" in the README for further information.
+func WithTrustRFC7239(enable bool) Option {
+	return func(o *Options) {
+		o.TrustRFC7239 = enable
+	}
+}
+
+// WithTrustTrueClientIP will configure the limiter to trust the True-Client-IP header to obtain user IP,
+// if TrustForwardHeader is enabled.
+func WithTrustTrueClientIP(enable bool) Option {
+	return func(o *Options) {
+		o.TrustTrueClientIP = enable
+	}
+}
+
+// WithTrustedProxies will configure the limiter to skip given proxies networks while parsing X-Forwarded-For header.
+func WithTrustedProxies(proxies ...net.IPNet) Option {
+	return func(o *Options) {
+		o.TrustedProxies = proxies
+	}
+}
+
+// WithForwardedHops will configure the limiter to use the IP at given position from the end of the
+// X-Forwarded-For chain, if "TrustForwardHeader" is enabled.
+func WithForwardedHops(hops int) Option {
+	return func(o *Options) {
+		o.ForwardedHops = hops
+	}
+}
+
+// WithRemoteAddrFunc will configure the limiter to use given function to obtain the network address of the client,
+// instead of the request RemoteAddr.
+func WithRemoteAddrFunc(fn func(r *http.Request) string) Option {
+	return func(o *Options) {
+		o.RemoteAddrFunc = fn
+	}
+}
+
 // WithClientIPHeader will configure the limiter to use a custom header to obtain user IP.
 // Please be advised that using this option could be insecure (ie: spoofed) if your reverse
 // proxy is not configured properly to forward a trustworthy client IP.
@@ -67,3 +654,21 @@ func WithClientIPHeader(header string) Option {
 		o.ClientIPHeader = header
 	}
 }
+
+// WithClientIPHeaders will configure the limiter to use an ordered list of custom headers to obtain user IP.
+// Please be advised that using this option could be insecure (ie: spoofed) if your reverse
+// proxy is not configured properly to forward a trustworthy client IP.
+// Please read the section "Limiter behind a reverse proxy" in the README for further information.
+func WithClientIPHeaders(headers ...string) Option {
+	return func(o *Options) {
+		o.ClientIPHeaders = headers
+	}
+}
+
+// WithOnHeaderIgnored will configure the limiter to call given function when a header used to obtain user IP
+// is present but unparseable.
+func WithOnHeaderIgnored(handler func(header, rawValue string)) Option {
+	return func(o *Options) {
+		o.OnHeaderIgnored = handler
+	}
+}
@@ -1,6 +1,7 @@
 package limiter
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -15,12 +16,39 @@ type Rate struct {
 	Limit     int64
 }
 
+// RateAdjuster defines an adjustment of the rate of every request, such as by an external health signal of the
+// backend: the limit can be raised when healthy, and tightened when error rates rise.
+type RateAdjuster interface {
+	// Adjust returns the effective rate of a request, from its base rate.
+	Adjust(base Rate) Rate
+}
+
+// RateAdjusterFunc is an adapter to use an ordinary function as a RateAdjuster.
+type RateAdjusterFunc func(base Rate) Rate
+
+// Adjust returns fn(base).
+func (fn RateAdjusterFunc) Adjust(base Rate) Rate {
+	return fn(base)
+}
+
+// getRateKey returns the key of given identifier for given rate, so counters of different rates are not shared.
+func getRateKey(key string, rate Rate) string {
+	return fmt.Sprintf("%s:%d-%s", key, rate.Limit, rate.Period)
+}
+
 // NewRateFromFormatted returns the rate from the formatted version.
+// See ParseRate for the supported formats.
 func NewRateFromFormatted(formatted string) (Rate, error) {
+	return ParseRate(formatted)
+}
+
+// ParseRate returns the rate from the formatted version "<limit>-<period>".
+// The period is either "S" (second), "M" (minute), "H" (hour), "D" (day), or a duration such as "1m30s".
+func ParseRate(formatted string) (Rate, error) {
 	rate := Rate{}
 
-	values := strings.Split(formatted, "-")
-	if len(values) != 2 {
+	index := strings.LastIndex(formatted, "-")
+	if index < 0 {
 		return rate, errors.Errorf("incorrect format '%s'", formatted)
 	}
 
@@ -31,17 +59,27 @@ func NewRateFromFormatted(formatted string) (Rate, error) {
 		"D": time.Hour * 24, // Day
 	}
 
-	limit, period := values[0], strings.ToUpper(values[1])
+	limit, period := formatted[:index], formatted[index+1:]
 
-	p, ok := periods[period]
+	p, ok := periods[strings.ToUpper(period)]
 	if !ok {
-		return rate, errors.Errorf("incorrect period '%s'", period)
+		duration, err := time.ParseDuration(period)
+		if err != nil {
+			return rate, errors.Errorf("incorrect period '%s'", period)
+		}
+		if duration <= 0 {
+			return rate, errors.Errorf("period must be positive '%s'", period)
+		}
+		p = duration
 	}
 
 	l, err := strconv.ParseInt(limit, 10, 64)
 	if err != nil {
 		return rate, errors.Errorf("incorrect limit '%s'", limit)
 	}
+	if l < 0 {
+		return rate, errors.Errorf("limit must not be negative '%s'", limit)
+	}
 
 	rate = Rate{
 		Formatted: formatted,
@@ -57,3 +57,62 @@ func TestRate(t *testing.T) {
 	}
 
 }
+
+func TestParseRate(t *testing.T) {
+	is := require.New(t)
+
+	scenarios := []struct {
+		formatted string
+		period    time.Duration
+		limit     int64
+	}{
+		{
+			// Scenario #1 : period unit.
+			formatted: "100-H",
+			period:    1 * time.Hour,
+			limit:     int64(100),
+		},
+		{
+			// Scenario #2 : lowercase period unit.
+			formatted: "10-s",
+			period:    1 * time.Second,
+			limit:     int64(10),
+		},
+		{
+			// Scenario #3 : period duration.
+			formatted: "100-1m30s",
+			period:    90 * time.Second,
+			limit:     int64(100),
+		},
+		{
+			// Scenario #4 : sub-second period duration.
+			formatted: "5-500ms",
+			period:    500 * time.Millisecond,
+			limit:     int64(5),
+		},
+	}
+
+	for i, scenario := range scenarios {
+		rate, err := limiter.ParseRate(scenario.formatted)
+		is.NoError(err, "scenario #%d", i+1)
+		is.Equal(scenario.formatted, rate.Formatted, "scenario #%d", i+1)
+		is.Equal(scenario.period, rate.Period, "scenario #%d", i+1)
+		is.Equal(scenario.limit, rate.Limit, "scenario #%d", i+1)
+	}
+
+	wrongs := []string{
+		"abc-H",
+		"-5-H",
+		"10-X",
+		"10-0s",
+		"10--1s",
+		"10-",
+		"-H",
+		"10",
+	}
+
+	for _, w := range wrongs {
+		_, err := limiter.ParseRate(w)
+		is.Error(err, w)
+	}
+}
@@ -0,0 +1,126 @@
+package limiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// slidingWindow is a window of a sliding window counter, aligned on a multiple of the rate period.
+type slidingWindow struct {
+	// start is the beginning of the current window.
+	start time.Time
+	// weight is the ratio of the previous window that overlaps with the sliding window.
+	weight float64
+	// currentKey is the store key of the current window.
+	currentKey string
+	// previousKey is the store key of the previous window.
+	previousKey string
+}
+
+// newSlidingWindow returns the sliding window of given key at given time.
+func newSlidingWindow(key string, period time.Duration, now time.Time) slidingWindow {
+	index := now.UnixNano() / int64(period)
+	start := time.Unix(0, index*int64(period))
+	elapsed := now.Sub(start)
+
+	return slidingWindow{
+		start:       start,
+		weight:      1 - float64(elapsed)/float64(period),
+		currentKey:  key + ":" + strconv.FormatInt(index, 10),
+		previousKey: key + ":" + strconv.FormatInt(index-1, 10),
+	}
+}
+
+// windowRate returns the rate used to store the counter of a window.
+// The counter must outlive its own window, since it's used as previous window by the next one.
+func windowRate(rate Rate) Rate {
+	rate.Period = 2 * rate.Period
+	return rate
+}
+
+// incrementSliding increments the current window of given key by given count & gives back the
+// sliding window limit.
+func (limiter *Limiter) incrementSliding(ctx context.Context, key string, count int64, rate Rate) (Context, error) {
+	window := newSlidingWindow(key, rate.Period, limiter.Now())
+
+	previous, err := limiter.Store.Peek(ctx, window.previousKey, windowRate(rate))
+	if err != nil {
+		return Context{}, err
+	}
+
+	current, err := limiter.Store.Increment(ctx, window.currentKey, count, windowRate(rate))
+	if err != nil {
+		return Context{}, err
+	}
+
+	return slidingContext(rate, window, previous, current), nil
+}
+
+// peekSliding returns the sliding window limit for given key, without modification on current values.
+func (limiter *Limiter) peekSliding(ctx context.Context, key string, rate Rate) (Context, error) {
+	window := newSlidingWindow(key, rate.Period, limiter.Now())
+
+	previous, err := limiter.Store.Peek(ctx, window.previousKey, windowRate(rate))
+	if err != nil {
+		return Context{}, err
+	}
+
+	current, err := limiter.Store.Peek(ctx, window.currentKey, windowRate(rate))
+	if err != nil {
+		return Context{}, err
+	}
+
+	return slidingContext(rate, window, previous, current), nil
+}
+
+// resetSliding resets both previous and current windows of given key.
+func (limiter *Limiter) resetSliding(ctx context.Context, key string, rate Rate) (Context, error) {
+	window := newSlidingWindow(key, rate.Period, limiter.Now())
+
+	previous, err := limiter.Store.Reset(ctx, window.previousKey, windowRate(rate))
+	if err != nil {
+		return Context{}, err
+	}
+
+	current, err := limiter.Store.Reset(ctx, window.currentKey, windowRate(rate))
+	if err != nil {
+		return Context{}, err
+	}
+
+	return slidingContext(rate, window, previous, current), nil
+}
+
+// refundSliding decrements the current window of given key by given count, without going below zero.
+func (limiter *Limiter) refundSliding(ctx context.Context, store RefundStore, key string, count int64,
+	rate Rate) error {
+
+	window := newSlidingWindow(key, rate.Period, limiter.Now())
+
+	_, err := store.Refund(ctx, window.currentKey, count, windowRate(rate))
+	return err
+}
+
+// slidingContext returns the limit context of given rate from previous and current windows.
+// The count of the previous window is weighted by its overlap with the sliding window.
+func slidingContext(rate Rate, window slidingWindow, previous Context, current Context) Context {
+	limit := rate.Limit
+	count := int64(float64(limit-previous.Remaining)*window.weight) + (limit - current.Remaining)
+	if current.Reached {
+		count = limit + 1
+	}
+
+	remaining := int64(0)
+	reached := true
+	if count <= limit {
+		remaining = limit - count
+		reached = false
+	}
+
+	return Context{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     window.start.Add(rate.Period).Unix(),
+		Reached:   reached,
+	}
+}
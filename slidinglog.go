@@ -0,0 +1,24 @@
+package limiter
+
+import (
+	"context"
+)
+
+// logRequests logs given count of requests in the sliding log of given key.
+func (limiter *Limiter) logRequests(ctx context.Context, key string, count int64, rate Rate) (Context, error) {
+	store, ok := limiter.Store.(SlidingLogStore)
+	if !ok {
+		return Context{}, ErrStoreModeNotSupported
+	}
+
+	return store.LogRequests(ctx, key, count, rate)
+}
+
+// resetLog deletes the sliding log of given key.
+func (limiter *Limiter) resetLog(ctx context.Context, key string, rate Rate) (Context, error) {
+	if _, ok := limiter.Store.(SlidingLogStore); !ok {
+		return Context{}, ErrStoreModeNotSupported
+	}
+
+	return limiter.Store.Reset(ctx, key, rate)
+}
@@ -2,9 +2,55 @@ package limiter
 
 import (
 	"context"
+	"fmt"
 	"time"
 )
 
+var (
+	// ErrStoreModeNotSupported is returned when the store doesn't support the configured store mode.
+	ErrStoreModeNotSupported = fmt.Errorf("store mode not supported by store")
+	// ErrNamespaceNotSupported is returned when the store doesn't support resetting a namespace.
+	ErrNamespaceNotSupported = fmt.Errorf("namespace reset not supported by store")
+	// ErrLabelNotSupported is returned when the store or the store mode doesn't support labels.
+	ErrLabelNotSupported = fmt.Errorf("labels not supported by store")
+	// ErrRefundNotSupported is returned when the store or the store mode doesn't support refunds.
+	ErrRefundNotSupported = fmt.Errorf("refund not supported by store")
+	// ErrStoreUnavailable is matched by store errors caused by an unreachable backend, such as a refused connection.
+	ErrStoreUnavailable = fmt.Errorf("store is unavailable")
+	// ErrStoreTimeout is matched by store errors caused by a backend taking too long to respond.
+	ErrStoreTimeout = fmt.Errorf("store timed out")
+)
+
+// StoreError is an error of a store operation, classified by kind so callers can decide what to do with it,
+// such as failing open when the store is unavailable:
+//
+//	if errors.Is(err, limiter.ErrStoreUnavailable) {
+//	    ...
+//	}
+//
+// Use errors.As to obtain the underlying driver error.
+type StoreError struct {
+	// Kind is the class of the error, such as ErrStoreUnavailable or ErrStoreTimeout.
+	Kind error
+	// Err is the underlying error of the driver.
+	Err error
+}
+
+// Error returns the kind and the underlying error.
+func (e *StoreError) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error of the driver.
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns if given target is the kind of the error.
+func (e *StoreError) Is(target error) bool {
+	return target == e.Kind
+}
+
 // Store is the common interface for limiter stores.
 type Store interface {
 	// Get returns the limit for given identifier.
@@ -17,6 +63,92 @@ type Store interface {
 	Increment(ctx context.Context, key string, count int64, rate Rate) (Context, error)
 }
 
+// NamespaceStore is the interface implemented by stores supporting the reset of a namespace.
+type NamespaceStore interface {
+	// ResetNamespace deletes the counters of every identifier starting with given prefix.
+	// It iterates over the keys of the store: it's an O(n) operation, intended for administration.
+	ResetNamespace(ctx context.Context, prefix string) error
+}
+
+// LabelStore is the interface implemented by stores supporting a label attached to the counter of an identifier,
+// such as an account id, for administration tooling.
+type LabelStore interface {
+	// GetWithLabel returns the limit for given identifier like Get, and attaches given label to its counter.
+	// The label expires with the counter.
+	GetWithLabel(ctx context.Context, key string, rate Rate, label string) (Context, error)
+	// GetLabel returns the label attached to the counter of given identifier, or an empty string if none.
+	GetLabel(ctx context.Context, key string) (string, error)
+}
+
+// RefundStore is the interface implemented by stores supporting refunds.
+type RefundStore interface {
+	// Refund decrements the counter of given identifier by given count, without going below zero, & gives back
+	// the new limit. A missing or expired counter is left untouched, and its expiration is unchanged.
+	Refund(ctx context.Context, key string, count int64, rate Rate) (Context, error)
+}
+
+// TokenBucketStore is the interface implemented by stores supporting the token bucket algorithm.
+type TokenBucketStore interface {
+	// TakeTokens refills the bucket of given identifier according to the time elapsed since its last refill,
+	// then takes given count of tokens from it if available.
+	// The bucket is refilled with rate.Limit tokens per rate.Period, and holds at most burst tokens.
+	// The context reset is the time when the bucket is full, or when given count of tokens will be available
+	// if they were not taken.
+	TakeTokens(ctx context.Context, key string, count int64, rate Rate, burst int64) (Context, error)
+}
+
+// SlidingLogStore is the interface implemented by stores supporting the sliding log algorithm.
+type SlidingLogStore interface {
+	// LogRequests removes the requests of given identifier older than rate.Period from its log, then logs given
+	// count of requests at the current time, and returns the limit from the requests remaining in the log.
+	// Rejected requests are logged too, like with a fixed window, but only the rate.Limit + 1 most recent ones
+	// are kept, since older ones don't change the result. With a count of zero, the log is only read.
+	// The context reset is the time when the next request will be allowed, if the limit is reached, or when
+	// the oldest request leaves the log otherwise.
+	LogRequests(ctx context.Context, key string, count int64, rate Rate) (Context, error)
+}
+
+// StoreMode defines the algorithm used to limit requests on top of a store.
+type StoreMode int
+
+const (
+	// StoreModeFixed uses a fixed window, starting on the first request of a key.
+	// It allows bursts of up to twice the limit at window boundaries.
+	StoreModeFixed StoreMode = iota
+	// StoreModeSliding uses a sliding window counter, weighting the count of the previous window
+	// by its overlap with the sliding window.
+	StoreModeSliding
+	// StoreModeTokenBucket uses a token bucket, refilled at a steady rate and holding up to a burst of tokens.
+	// It requires a store implementing TokenBucketStore.
+	StoreModeTokenBucket
+	// StoreModeLeakyBucket uses a leaky bucket, admitting requests at a fixed interval (rate.Period / rate.Limit)
+	// without any burst: a request is rejected until the interval has elapsed since the last admitted one.
+	// It requires a store implementing TokenBucketStore, used as a bucket holding a single token.
+	StoreModeLeakyBucket
+	// StoreModeSlidingLog uses a sliding log, storing the time of each request to count exactly those within
+	// the last rate.Period. It's more precise than windows, but costs up to rate.Limit + 1 entries per key.
+	// It requires a store implementing SlidingLogStore.
+	StoreModeSlidingLog
+)
+
+// String returns the name of the store mode, such as "fixed".
+func (mode StoreMode) String() string {
+	switch mode {
+	case StoreModeFixed:
+		return "fixed"
+	case StoreModeSliding:
+		return "sliding"
+	case StoreModeTokenBucket:
+		return "token-bucket"
+	case StoreModeLeakyBucket:
+		return "leaky-bucket"
+	case StoreModeSlidingLog:
+		return "sliding-log"
+	default:
+		return fmt.Sprintf("StoreMode(%d)", int(mode))
+	}
+}
+
 // StoreOptions are options for store.
 type StoreOptions struct {
 	// Prefix is the prefix to use for the key.
@@ -26,9 +158,19 @@ type StoreOptions struct {
 	// Deprecated: this option is no longer required since all operations are atomic now.
 	MaxRetry int
 
-	// CleanUpInterval is the interval for cleanup (run garbage collection) on stale entries on memory store.
+	// CleanUpInterval is the interval for cleanup (run garbage collection) on stale entries on memory and SQL stores.
 	// Setting this to a low value will optimize memory consumption, but will likely
 	// reduce performance and increase lock contention.
 	// Setting this to a high value will maximum throughput, but will increase the memory footprint.
 	CleanUpInterval time.Duration
+
+	// ShardCount is the number of shards of the memory store, each one with its own lock.
+	// Setting this to a high value will reduce lock contention with many distinct keys.
+	// Default is DefaultShardCount.
+	ShardCount int
+
+	// Clock is the source of the current time used by windows and expirations of the store, such as a fake
+	// clock in tests. Default is SystemClock.
+	// On redis store, expirations are still handled by the redis server, using its own clock.
+	Clock Clock
 }
@@ -0,0 +1,46 @@
+package limiter
+
+import (
+	"context"
+)
+
+// burst returns the maximum number of tokens of a bucket for given rate.
+// A leaky bucket holds a single token, so requests are admitted one interval apart.
+func (limiter *Limiter) burst(rate Rate) int64 {
+	if limiter.Options.StoreMode == StoreModeLeakyBucket {
+		return 1
+	}
+	if limiter.Options.Burst > 0 {
+		return limiter.Options.Burst
+	}
+	return rate.Limit
+}
+
+// takeTokens takes given count of tokens from the bucket of given key.
+func (limiter *Limiter) takeTokens(ctx context.Context, key string, count int64, rate Rate) (Context, error) {
+	store, ok := limiter.Store.(TokenBucketStore)
+	if !ok {
+		return Context{}, ErrStoreModeNotSupported
+	}
+
+	return store.TakeTokens(ctx, key, count, rate, limiter.burst(rate))
+}
+
+// resetTokens refills the bucket of given key.
+func (limiter *Limiter) resetTokens(ctx context.Context, key string, rate Rate) (Context, error) {
+	if _, ok := limiter.Store.(TokenBucketStore); !ok {
+		return Context{}, ErrStoreModeNotSupported
+	}
+
+	_, err := limiter.Store.Reset(ctx, key, rate)
+	if err != nil {
+		return Context{}, err
+	}
+
+	return Context{
+		Limit:     limiter.burst(rate),
+		Remaining: limiter.burst(rate),
+		Reset:     limiter.Now().Unix(),
+		Reached:   false,
+	}, nil
+}
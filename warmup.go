@@ -0,0 +1,113 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+const (
+	// warmupKeySuffix is the suffix of the store key recording the creation of a key, for WarmupRequests.
+	warmupKeySuffix = "#warmup"
+	// warmupSettled is the count of the creation record of a key whose warm-up has ended.
+	warmupSettled = math.MaxInt32
+)
+
+// warmupRate returns given rate with its limit extended by the warm-up allowance of given key, with
+// WarmupRequests: the allowance decays with the number of windows elapsed since the key was created.
+//
+// The creation of a key is recorded in the store, on a fixed window counter of twice the duration of the ramp
+// incremented by its first request: its creation time is derived from the counter expiration, so concurrent first
+// requests agree on it. Once the ramp has ended, the counter is set to warmupSettled, and renewed while the key is
+// active.
+// If the record can't be obtained, the given rate is returned.
+func (limiter *Limiter) warmupRate(ctx context.Context, key string, rate Rate) Rate {
+	return limiter.applyWarmup(ctx, key, rate, true)
+}
+
+// peekWarmupRate returns given rate with its limit extended by the warm-up allowance of given key, like warmupRate,
+// without modification on its creation record: a key not created yet is allowed the allowance of its first window.
+func (limiter *Limiter) peekWarmupRate(ctx context.Context, key string, rate Rate) Rate {
+	return limiter.applyWarmup(ctx, key, rate, false)
+}
+
+// applyWarmup returns given rate with its limit extended by the warm-up allowance of given key, updating its
+// creation record if record is true.
+func (limiter *Limiter) applyWarmup(ctx context.Context, key string, rate Rate, record bool) Rate {
+	windows := limiter.warmupWindows()
+	if windows == 0 || rate.Period <= 0 {
+		return rate
+	}
+
+	horizon := time.Duration(windows) * rate.Period
+	recordKey := key + warmupKeySuffix
+	recordRate := Rate{
+		Period: 2 * horizon,
+		Limit:  math.MaxInt64,
+	}
+
+	creation, err := limiter.Store.Peek(ctx, recordKey, recordRate)
+	if err != nil {
+		return rate
+	}
+
+	now := limiter.Now()
+	count := creation.Limit - creation.Remaining
+
+	switch {
+	case count == 0:
+		if record {
+			_, err = limiter.Store.Increment(ctx, recordKey, 1, recordRate)
+			if err != nil {
+				return rate
+			}
+		}
+		rate.Limit += limiter.warmupAllowance(0)
+	case count < warmupSettled:
+		created := time.Unix(creation.Reset, 0).Add(-recordRate.Period)
+		window := int(now.Sub(created) / rate.Period)
+		if window < windows {
+			rate.Limit += limiter.warmupAllowance(window)
+		} else if record {
+			limiter.settleWarmup(ctx, recordKey, recordRate)
+		}
+	default:
+		if record && time.Unix(creation.Reset, 0).Sub(now) < horizon {
+			limiter.settleWarmup(ctx, recordKey, recordRate)
+		}
+	}
+
+	return rate
+}
+
+// settleWarmup records the end of the warm-up of given creation record key, renewing its expiration.
+func (limiter *Limiter) settleWarmup(ctx context.Context, recordKey string, recordRate Rate) {
+	_, err := limiter.Store.Reset(ctx, recordKey, recordRate)
+	if err == nil {
+		_, _ = limiter.Store.Increment(ctx, recordKey, warmupSettled, recordRate)
+	}
+}
+
+// warmupAllowance returns the requests allowed in addition to the limit in given window of a key, starting at zero
+// for its first window: WarmupRequests, multiplied by WarmupFactor for each window elapsed.
+func (limiter *Limiter) warmupAllowance(window int) int64 {
+	return int64(float64(limiter.Options.WarmupRequests) * math.Pow(limiter.warmupFactor(), float64(window)))
+}
+
+// warmupWindows returns the number of windows of the warm-up ramp: until the allowance is lower than a request.
+func (limiter *Limiter) warmupWindows() int {
+	windows := 0
+	for allowance := float64(limiter.Options.WarmupRequests); allowance >= 1; windows++ {
+		allowance *= limiter.warmupFactor()
+	}
+	return windows
+}
+
+// warmupFactor returns WarmupFactor, or zero if it's not within [0, 1) so the allowance can only decay.
+func (limiter *Limiter) warmupFactor() float64 {
+	factor := limiter.Options.WarmupFactor
+	if factor < 0 || factor >= 1 {
+		return 0
+	}
+	return factor
+}